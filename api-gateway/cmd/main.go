@@ -11,7 +11,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/virhanali/filmnesia/api-gateway/internal/auth"
 	"github.com/virhanali/filmnesia/api-gateway/internal/config"
+	"github.com/virhanali/filmnesia/api-gateway/internal/handler"
 	"github.com/virhanali/filmnesia/api-gateway/internal/router"
 )
 
@@ -21,21 +23,64 @@ func main() {
 		log.Fatalf("FATAL: Failed to load API Gateway configuration: %v", err)
 	}
 
-	if cfg.UserServiceURL == "" {
-		log.Fatal("FATAL: USER_SERVICE_URL must be configured.")
+	var keys *auth.KeySet
+	if cfg.JWKSURL != "" {
+		keys = auth.NewKeySet(cfg.JWKSURL, cfg.JWKSRefresh)
+		if err := keys.Refresh(); err != nil {
+			log.Printf("WARNING: Failed to fetch JWKS from %s: %v. Retrying as tokens arrive.", cfg.JWKSURL, err)
+		}
+	}
+	verifier, err := auth.NewVerifier(cfg.JWTSecretKey, keys)
+	if err != nil {
+		log.Fatalf("FATAL: Failed to set up token verification: %v", err)
+	}
+	if cfg.IdentitySecret == "" {
+		log.Println("WARNING: GATEWAY_IDENTITY_SECRET is not set; identity headers are forwarded unsigned, and services only trust signed ones.")
 	}
 
-	r := router.SetupRouter(cfg)
+	routes, err := config.LoadRoutes(cfg.RoutesFile, cfg)
+	if err != nil {
+		log.Fatalf("FATAL: Invalid route table: %v", err)
+	}
+
+	streams, closeStreams := context.WithCancel(context.Background())
+	defer closeStreams()
+	gateway := handler.NewGateway(verifier, cfg.AuthPolicies, []byte(cfg.IdentitySecret), streams)
+	gateway.Load(routes)
+	r := router.SetupRouter(gateway)
+
+	reload := func() {
+		log.Printf("INFO: Reloading routes from '%s'", cfg.RoutesFile)
+		if err := gateway.Reload(cfg.RoutesFile, cfg); err != nil {
+			log.Printf("ERROR: Keeping the current routes: %v", err)
+		}
+	}
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	if cfg.RoutesFile != "" {
+		if err := config.WatchRoutes(watchCtx, cfg.RoutesFile, reload); err != nil {
+			log.Printf("WARNING: Not watching '%s' for changes: %v. Send SIGHUP to reload it.", cfg.RoutesFile, err)
+		}
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			reload()
+		}
+	}()
 
 	serverAddr := ":" + cfg.APIGatewayPort
 	srv := &http.Server{
 		Addr:    serverAddr,
 		Handler: r,
 	}
+	// Shutdown waits for active requests, and streams never finish on
+	// their own.
+	srv.RegisterOnShutdown(closeStreams)
 
 	go func() {
 		log.Printf("INFO: API Gateway starting on port %s", cfg.APIGatewayPort)
-		log.Printf("INFO: Proxying /api/v1/users/* to %s", cfg.UserServiceURL)
 		if errSrv := srv.ListenAndServe(); errSrv != nil && !errors.Is(errSrv, http.ErrServerClosed) {
 			log.Fatalf("FATAL: API Gateway ListenAndServe error: %v", errSrv)
 		}
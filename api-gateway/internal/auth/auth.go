@@ -0,0 +1,77 @@
+// Package auth verifies the access tokens clients present to the gateway
+// and decides, per route, whether one is needed.
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/contracts"
+)
+
+var (
+	ErrMissingToken = errors.New("header authorization not found")
+	ErrInvalidToken = errors.New("token invalid or expired")
+	ErrForbidden    = errors.New("you are not authorized to access this resource")
+)
+
+// Claims are the claims of the access tokens user-service issues; the
+// subject is the user ID.
+type Claims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// Identity is the user a verified token belongs to. It is forwarded to
+// services in the identity headers (contracts.SetIdentity).
+type Identity = contracts.Identity
+
+// Verifier checks access tokens signed with a shared secret (HS256, as
+// user-service signs them), with the keys of a JWKS document (RSA and
+// ECDSA), or either when both are configured.
+type Verifier struct {
+	secret []byte
+	keys   *KeySet
+}
+
+func NewVerifier(secret string, keys *KeySet) (*Verifier, error) {
+	if secret == "" && keys == nil {
+		return nil, errors.New("a JWT secret or a JWKS URL is required")
+	}
+	return &Verifier{secret: []byte(secret), keys: keys}, nil
+}
+
+// Verify parses token and returns whose it is, or ErrInvalidToken.
+func (v *Verifier) Verify(token string) (Identity, error) {
+	claims := &Claims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, v.key)
+	if err != nil || !parsed.Valid {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: subject is not a user ID", ErrInvalidToken)
+	}
+	return Identity{UserID: userID, Username: claims.Username, Role: claims.Role}, nil
+}
+
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(v.secret) == 0 {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return v.secret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if v.keys == nil {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		kid, _ := token.Header["kid"].(string)
+		return v.keys.Key(kid)
+	}
+	return nil, jwt.ErrSignatureInvalid
+}
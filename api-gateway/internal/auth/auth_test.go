@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func claims(subject string, expires time.Time) Claims {
+	return Claims{
+		Username: "budi",
+		Role:     "user",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, c Claims, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// jwksServer serves a JWKS document with the public half of key as kid.
+func jwksServer(t *testing.T, kid string, key *ecdsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	doc := map[string]any{"keys": []map[string]string{{
+		"kid": kid, "kty": "EC", "use": "sig", "crv": "P-256",
+		"x": encode(key.X.FillBytes(make([]byte, 32))),
+		"y": encode(key.Y.FillBytes(make([]byte, 32))),
+	}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVerifier(t *testing.T) {
+	secret := []byte("secret")
+	userID := uuid.New()
+	hour := time.Now().Add(time.Hour)
+	v, err := NewVerifier(string(secret), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := v.Verify(sign(t, jwt.SigningMethodHS256, secret, claims(userID.String(), hour), ""))
+	if err != nil || id.UserID != userID || id.Username != "budi" || id.Role != "user" {
+		t.Fatalf("Verify = %+v, %v", id, err)
+	}
+
+	for name, token := range map[string]string{
+		"alg none":     sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(userID.String(), hour), ""),
+		"expired":      sign(t, jwt.SigningMethodHS256, secret, claims(userID.String(), time.Now().Add(-time.Minute)), ""),
+		"subject":      sign(t, jwt.SigningMethodHS256, secret, claims("budi", hour), ""),
+		"other secret": sign(t, jwt.SigningMethodHS256, []byte("other"), claims(userID.String(), hour), ""),
+	} {
+		if _, err := v.Verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestVerifierWithOnlyJWKSRejectsHMACTokens(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewKeySet(jwksServer(t, "k1", key).URL, time.Hour)
+	if err := keys.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier("", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.New()
+	hour := time.Now().Add(time.Hour)
+
+	if _, err := v.Verify(sign(t, jwt.SigningMethodES256, key, claims(userID.String(), hour), "k1")); err != nil {
+		t.Fatalf("JWKS-signed token: %v", err)
+	}
+	// Not even with an empty secret, which is all there is to guess.
+	for _, secret := range [][]byte{[]byte("secret"), {}} {
+		token := sign(t, jwt.SigningMethodHS256, secret, claims(userID.String(), hour), "k1")
+		if _, err := v.Verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("HS256 token with secret %q: %v", secret, err)
+		}
+	}
+}
+
+func TestPoliciesMatch(t *testing.T) {
+	ps := Policies{
+		Default: Policy{Mode: ModeOptional},
+		Routes: []Policy{
+			{Prefix: "/api/v1/users", Mode: ModeRequired},
+			// Listed first, and still loses to the rule for the method.
+			{Prefix: "/api/v1/users/login", Mode: ModeRequired},
+			{Method: "POST", Prefix: "/api/v1/users/login", Mode: ModePublic},
+			{Prefix: "/api/v1/notifications/webhooks", Mode: ModeRequired, Role: "admin"},
+		},
+	}
+	for _, tc := range []struct {
+		method, path string
+		want         Policy
+	}{
+		{"GET", "/api/v1/users/me", ps.Routes[0]},
+		{"POST", "/api/v1/users/login", ps.Routes[2]},
+		{"GET", "/api/v1/users/login", ps.Routes[1]},
+		{"GET", "/api/v1/notifications/webhooks/1", ps.Routes[3]},
+		{"GET", "/api/v1/usersx", ps.Default},
+		{"GET", "/health", ps.Default},
+	} {
+		if got := ps.Match(tc.method, tc.path); got != tc.want {
+			t.Errorf("Match(%s %s) = %s, want %s", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRefetch keeps tokens with unknown key IDs from making the gateway
+// fetch the JWKS document on every request.
+const minRefetch = 30 * time.Second
+
+// KeySet holds the public keys of a JWKS document. It fetches the document
+// again every refresh interval, and early when a token names a key it does
+// not know, so that rotated keys are picked up.
+type KeySet struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+func NewKeySet(url string, refresh time.Duration) *KeySet {
+	return &KeySet{url: url, refresh: refresh, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Key returns the key with ID kid. A document with a single key also
+// serves tokens that name none.
+func (s *KeySet) Key(kid string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.lookup(kid)
+	stale := time.Since(s.fetched) > s.refresh
+	if (!ok || stale) && time.Since(s.fetched) > minRefetch {
+		if err := s.fetch(); err != nil {
+			log.Printf("WARNING: Failed to refresh JWKS from %s: %v", s.url, err)
+		}
+		key, ok = s.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// Refresh fetches the document now.
+func (s *KeySet) Refresh() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetch()
+}
+
+func (s *KeySet) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetch replaces the keys with those of the document. The caller holds mu.
+func (s *KeySet) fetch() error {
+	// Failed attempts count too, so that an unreachable issuer is not
+	// asked again on every request.
+	s.fetched = time.Now()
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return err
+	}
+	keys := make(map[string]interface{}, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("WARNING: Skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("no usable signing keys")
+	}
+	s.keys = keys
+	return nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter %q", s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Mode is how a route treats access tokens.
+type Mode string
+
+const (
+	// ModePublic ignores tokens; nothing is verified or forwarded.
+	ModePublic Mode = "public"
+	// ModeOptional verifies a token when there is one and forwards who
+	// it belongs to, and lets requests without one through.
+	ModeOptional Mode = "optional"
+	// ModeRequired answers requests without a valid token with 401.
+	ModeRequired Mode = "required"
+)
+
+// Policy applies a mode, and for required routes optionally a role, to
+// the paths under Prefix, for one method or every method.
+type Policy struct {
+	Method string
+	Prefix string
+	Mode   Mode
+	Role   string
+}
+
+func (p Policy) String() string {
+	s := p.Prefix + "=" + string(p.Mode)
+	if p.Role != "" {
+		s = p.Prefix + "=role:" + p.Role
+	}
+	if p.Method != "" {
+		s = p.Method + " " + s
+	}
+	return s
+}
+
+func (p Policy) matches(method, path string) bool {
+	if p.Method != "" && p.Method != method {
+		return false
+	}
+	return path == p.Prefix || strings.HasPrefix(path, strings.TrimSuffix(p.Prefix, "/")+"/")
+}
+
+// Policies picks the policy of a request.
+type Policies struct {
+	Default Policy
+	Routes  []Policy
+}
+
+// Match returns the policy with the longest prefix that matches the
+// request, preferring one for its method, or the default.
+func (ps Policies) Match(method, path string) Policy {
+	best, found := ps.Default, false
+	for _, p := range ps.Routes {
+		if !p.matches(method, path) {
+			continue
+		}
+		if !found || len(p.Prefix) > len(best.Prefix) ||
+			(len(p.Prefix) == len(best.Prefix) && p.Method != "" && best.Method == "") {
+			best, found = p, true
+		}
+	}
+	return best
+}
+
+// ParseMode parses "public", "optional", "required" or "role:<name>",
+// which is required with that role.
+func ParseMode(raw string) (Mode, string, error) {
+	raw = strings.TrimSpace(raw)
+	if role, ok := strings.CutPrefix(raw, "role:"); ok && role != "" {
+		return ModeRequired, role, nil
+	}
+	switch mode := Mode(raw); mode {
+	case ModePublic, ModeOptional, ModeRequired:
+		return mode, "", nil
+	}
+	return "", "", fmt.Errorf("unknown auth mode %q", raw)
+}
+
+// ParsePolicies parses comma-separated "[METHOD ]prefix=mode" rules, e.g.
+// "POST /api/v1/users/login=public,/api/v1/notifications/webhooks=role:admin".
+func ParsePolicies(raw string) ([]Policy, error) {
+	var policies []Policy
+	for _, rule := range strings.Split(raw, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		route, mode, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("auth rule %q is not [METHOD ]prefix=mode", rule)
+		}
+		var p Policy
+		fields := strings.Fields(route)
+		switch len(fields) {
+		case 1:
+			p.Prefix = fields[0]
+		case 2:
+			p.Method, p.Prefix = strings.ToUpper(fields[0]), fields[1]
+		default:
+			return nil, fmt.Errorf("auth rule %q is not [METHOD ]prefix=mode", rule)
+		}
+		if !strings.HasPrefix(p.Prefix, "/") {
+			return nil, fmt.Errorf("auth rule %q: prefix must start with /", rule)
+		}
+		if p.Method != "" && !ValidMethod(p.Method) {
+			return nil, fmt.Errorf("auth rule %q: unknown method %q", rule, p.Method)
+		}
+		var err error
+		if p.Mode, p.Role, err = ParseMode(mode); err != nil {
+			return nil, fmt.Errorf("auth rule %q: %w", rule, err)
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// ValidMethod reports whether method is one the gateway routes.
+func ValidMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
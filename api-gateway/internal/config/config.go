@@ -6,15 +6,51 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/virhanali/filmnesia/api-gateway/internal/auth"
 )
 
 type Config struct {
 	APIGatewayPort string `mapstructure:"API_GATEWAY_PORT"`
 	UserServiceURL string `mapstructure:"USER_SERVICE_URL"`
+	// NotificationServiceURL is optional; without it /api/v1/notifications
+	// is not routed.
+	NotificationServiceURL string `mapstructure:"NOTIFICATION_SERVICE_URL"`
+	// RoutesFile is the route table; without one the two URLs above make
+	// up the default routes and USER_SERVICE_URL is required. Changes to
+	// the file, and SIGHUP, reload it.
+	RoutesFile string `mapstructure:"GATEWAY_ROUTES_FILE"`
+
+	// Access tokens are verified with JWTSecretKey, the secret user-service
+	// signs them with, with the keys published at JWKSURL, or both; one is
+	// required. The JWKS document is fetched again every JWKSRefresh.
+	JWTSecretKey string        `mapstructure:"JWT_SECRET_KEY"`
+	JWKSURL      string        `mapstructure:"JWT_JWKS_URL"`
+	JWKSRefresh  time.Duration `mapstructure:"-"`
+	// AuthPolicies say which routes need a token, from GATEWAY_AUTH_ROUTES
+	// ("[METHOD ]prefix=mode,...") and GATEWAY_AUTH_DEFAULT, the mode of
+	// routes no rule matches.
+	AuthPolicies auth.Policies `mapstructure:"-"`
+	// IdentitySecret, when set, signs the identity headers forwarded to
+	// services; those configured with the same secret trust them
+	// (contracts.IdentityVerifier).
+	IdentitySecret string `mapstructure:"GATEWAY_IDENTITY_SECRET"`
 }
 
+// defaultAuthRoutes mirror what the services themselves let through
+// without a token.
+const defaultAuthRoutes = "POST /api/v1/users/register=public," +
+	"POST /api/v1/users/login=public," +
+	"GET /api/v1/users/email=public," +
+	"GET /api/v1/users/username=public," +
+	"/api/v1/notifications/unsubscribe=public," +
+	"/api/v1/notifications/webhooks=role:admin," +
+	"/api/v1/notifications/deliveries=role:admin," +
+	"/api/v1=required"
+
 func LoadConfig(configDirHint string) (config Config, err error) {
 	wd, err := os.Getwd()
 	if err != nil {
@@ -66,12 +102,63 @@ func LoadConfig(configDirHint string) (config Config, err error) {
 	if config.UserServiceURL == "" {
 		config.UserServiceURL = os.Getenv("USER_SERVICE_URL")
 	}
+	if config.NotificationServiceURL == "" {
+		config.NotificationServiceURL = os.Getenv("NOTIFICATION_SERVICE_URL")
+	}
+	if config.RoutesFile == "" {
+		config.RoutesFile = os.Getenv("GATEWAY_ROUTES_FILE")
+	}
+	if config.JWTSecretKey == "" {
+		config.JWTSecretKey = os.Getenv("JWT_SECRET_KEY")
+	}
+	if config.JWKSURL == "" {
+		config.JWKSURL = os.Getenv("JWT_JWKS_URL")
+	}
+	if config.IdentitySecret == "" {
+		config.IdentitySecret = os.Getenv("GATEWAY_IDENTITY_SECRET")
+	}
+
+	config.JWKSRefresh = time.Hour
+	if raw := viper.GetString("JWT_JWKS_REFRESH"); raw != "" {
+		config.JWKSRefresh, err = time.ParseDuration(raw)
+		if err != nil || config.JWKSRefresh <= 0 {
+			log.Printf("Error parsing JWT_JWKS_REFRESH %q: must be a positive duration", raw)
+			return Config{}, errors.New("JWT_JWKS_REFRESH must be a positive duration")
+		}
+	}
+
+	routes := viper.GetString("GATEWAY_AUTH_ROUTES")
+	if routes == "" {
+		routes = defaultAuthRoutes
+	}
+	config.AuthPolicies.Routes, err = auth.ParsePolicies(routes)
+	if err != nil {
+		log.Printf("Error parsing GATEWAY_AUTH_ROUTES: %v", err)
+		return Config{}, err
+	}
+	defaultMode := viper.GetString("GATEWAY_AUTH_DEFAULT")
+	if defaultMode == "" {
+		defaultMode = string(auth.ModeOptional)
+	}
+	config.AuthPolicies.Default.Mode, config.AuthPolicies.Default.Role, err = auth.ParseMode(defaultMode)
+	if err != nil {
+		log.Printf("Error parsing GATEWAY_AUTH_DEFAULT: %v", err)
+		return Config{}, err
+	}
 
 	log.Printf("API Gateway Port loaded: [%s]", config.APIGatewayPort)
 	log.Printf("User Service URL loaded: [%s]", config.UserServiceURL)
+	log.Printf("Notification Service URL loaded: [%s]", config.NotificationServiceURL)
+	log.Printf("Routes file: [%s]", config.RoutesFile)
+	log.Printf("Auth: secret=%t jwks=[%s] refresh=%s identity signed=%t default=%s routes=%v",
+		config.JWTSecretKey != "", config.JWKSURL, config.JWKSRefresh, config.IdentitySecret != "",
+		config.AuthPolicies.Default.Mode, config.AuthPolicies.Routes)
 
-	if config.UserServiceURL == "" {
-		return Config{}, errors.New("USER_SERVICE_URL must be configured either in .env or as an environment variable")
+	if config.UserServiceURL == "" && config.RoutesFile == "" {
+		return Config{}, errors.New("USER_SERVICE_URL or GATEWAY_ROUTES_FILE must be configured either in .env or as an environment variable")
+	}
+	if config.JWTSecretKey == "" && config.JWKSURL == "" {
+		return Config{}, errors.New("JWT_SECRET_KEY or JWT_JWKS_URL must be configured to verify access tokens")
 	}
 
 	return config, nil
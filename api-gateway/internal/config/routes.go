@@ -0,0 +1,389 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+
+	"github.com/virhanali/filmnesia/api-gateway/internal/auth"
+)
+
+// RouteTable is what the gateway proxies where, read from the file named
+// by GATEWAY_ROUTES_FILE.
+type RouteTable struct {
+	Upstreams []UpstreamConfig `mapstructure:"upstreams"`
+	Routes    []RouteConfig    `mapstructure:"routes"`
+}
+
+// The strategies an upstream spreads requests over its instances with.
+const (
+	BalanceRoundRobin       = "round_robin"
+	BalanceLeastConnections = "least_connections"
+	// BalanceConsistentHash sends the requests with the same key to the
+	// same instance for as long as it is available.
+	BalanceConsistentHash = "consistent_hash"
+)
+
+// UpstreamConfig names a service and its instances. Environment variables
+// in URL and URLs are expanded, e.g. "${USER_SERVICE_URL}", and each may
+// hold several comma-separated addresses; Validate gathers them all in
+// URLs.
+type UpstreamConfig struct {
+	Name string   `mapstructure:"name"`
+	URL  string   `mapstructure:"url"`
+	URLs []string `mapstructure:"urls"`
+	// Optional drops the upstream, and the routes to it, when its URLs
+	// expand to nothing, e.g. with NOTIFICATION_SERVICE_URL unset.
+	Optional bool `mapstructure:"optional"`
+	// Balance is one of the Balance strategies; round_robin by default.
+	Balance string `mapstructure:"balance"`
+	// HashHeader is the header consistent_hash keys on. Requests without
+	// it are keyed on the client IP.
+	HashHeader  string            `mapstructure:"hash_header"`
+	HealthCheck HealthCheckConfig `mapstructure:"health_check"`
+	Outlier     OutlierConfig     `mapstructure:"outlier"`
+}
+
+// DefaultHealthCheckPath is the liveness endpoint the services serve. It
+// only fails when the process or its database is down, not its broker:
+// their /health fails while RabbitMQ is unreachable, and checking it would
+// take APIs that do not need the broker out of rotation.
+const DefaultHealthCheckPath = "/health/live"
+
+// HealthCheckConfig has every instance asked for Path each Interval. An
+// instance that fails UnhealthyThreshold checks in a row, by an error or a
+// status other than 2xx, gets no requests until it passes
+// HealthyThreshold in a row. Instances start out healthy.
+type HealthCheckConfig struct {
+	Disabled           bool          `mapstructure:"disabled"`
+	Path               string        `mapstructure:"path"`
+	Interval           time.Duration `mapstructure:"interval"`
+	Timeout            time.Duration `mapstructure:"timeout"`
+	HealthyThreshold   int           `mapstructure:"healthy_threshold"`
+	UnhealthyThreshold int           `mapstructure:"unhealthy_threshold"`
+}
+
+// OutlierConfig ejects an instance for Ejection once Failures requests in
+// a row were answered with 5xx or could not reach it.
+type OutlierConfig struct {
+	Disabled bool          `mapstructure:"disabled"`
+	Failures int           `mapstructure:"failures"`
+	Ejection time.Duration `mapstructure:"ejection"`
+}
+
+// RouteConfig sends the requests under Prefix to an upstream. The route
+// with the longest matching prefix wins.
+type RouteConfig struct {
+	Prefix string `mapstructure:"prefix"`
+	// Methods limits the route to these methods; empty allows every one.
+	Methods  []string `mapstructure:"methods"`
+	Upstream string   `mapstructure:"upstream"`
+	// StripPrefix removes Prefix from the path before forwarding, and
+	// Rewrite replaces it; at most one may be set.
+	StripPrefix bool   `mapstructure:"strip_prefix"`
+	Rewrite     string `mapstructure:"rewrite"`
+	// Auth is public, optional, required or role:<name>. Empty leaves the
+	// route to GATEWAY_AUTH_ROUTES, whose more specific rules also apply
+	// within a route that sets it.
+	Auth string `mapstructure:"auth"`
+	// Timeout bounds how long the upstream may take to answer. The stream
+	// endpoints, handler.StreamPaths, are exempt; zero waits as long as it
+	// takes.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Allows reports whether the route serves method.
+func (r RouteConfig) Allows(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether path is Prefix or below it.
+func (r RouteConfig) Matches(path string) bool {
+	return r.Prefix == "/" || path == r.Prefix || strings.HasPrefix(path, r.Prefix+"/")
+}
+
+// DefaultRoutes send /api/v1/users to USER_SERVICE_URL and, when it is
+// set, /api/v1/notifications to NOTIFICATION_SERVICE_URL, with the default
+// balancing and health checks.
+func DefaultRoutes(cfg Config) RouteTable {
+	table := RouteTable{
+		Upstreams: []UpstreamConfig{{Name: "users", URL: cfg.UserServiceURL}},
+		Routes:    []RouteConfig{{Prefix: "/api/v1/users", Upstream: "users"}},
+	}
+	if cfg.NotificationServiceURL != "" {
+		table.Upstreams = append(table.Upstreams, UpstreamConfig{Name: "notifications", URL: cfg.NotificationServiceURL})
+		table.Routes = append(table.Routes, RouteConfig{Prefix: "/api/v1/notifications", Upstream: "notifications"})
+	}
+	return table
+}
+
+// LoadRoutes reads a YAML or JSON route file and validates it. Without a
+// file the gateway serves DefaultRoutes.
+func LoadRoutes(path string, cfg Config) (RouteTable, error) {
+	table := DefaultRoutes(cfg)
+	if path == "" {
+		return table, table.Validate()
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		log.Printf("Routes file '%s' not found. Using the default routes.", path)
+		return table, table.Validate()
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return RouteTable{}, fmt.Errorf("read routes file '%s': %w", path, err)
+	}
+	table = RouteTable{}
+	if err := v.Unmarshal(&table); err != nil {
+		return RouteTable{}, fmt.Errorf("parse routes file '%s': %w", path, err)
+	}
+	if err := table.Validate(); err != nil {
+		return RouteTable{}, fmt.Errorf("routes file '%s': %w", path, err)
+	}
+	return table, nil
+}
+
+var errNoInstances = errors.New("no instance URLs")
+
+// Validate checks the table and normalises it: URLs are expanded,
+// optional upstreams without any are dropped with their routes, methods
+// upper-cased, prefixes lose their trailing slash and routes are sorted
+// longest prefix first.
+func (t *RouteTable) Validate() error {
+	upstreams := map[string]bool{}
+	dropped := map[string]bool{}
+	kept := t.Upstreams[:0]
+	for i := range t.Upstreams {
+		u := t.Upstreams[i]
+		if u.Name == "" {
+			return fmt.Errorf("upstream #%d has no name", i+1)
+		}
+		if upstreams[u.Name] || dropped[u.Name] {
+			return fmt.Errorf("upstream %q is defined twice", u.Name)
+		}
+		err := u.validate()
+		if errors.Is(err, errNoInstances) && u.Optional {
+			log.Printf("INFO: Upstream %q has no instance URLs; not routing to it.", u.Name)
+			dropped[u.Name] = true
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("upstream %q: %w", u.Name, err)
+		}
+		upstreams[u.Name] = true
+		kept = append(kept, u)
+	}
+	t.Upstreams = kept
+
+	routes := t.Routes[:0]
+	for _, r := range t.Routes {
+		if !dropped[r.Upstream] {
+			routes = append(routes, r)
+		}
+	}
+	t.Routes = routes
+	if len(t.Routes) == 0 {
+		return errors.New("no routes")
+	}
+
+	for i := range t.Routes {
+		r := &t.Routes[i]
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return fmt.Errorf("route #%d: prefix %q must start with /", i+1, r.Prefix)
+		}
+		if r.Prefix != "/" {
+			r.Prefix = strings.TrimSuffix(r.Prefix, "/")
+		}
+		if !upstreams[r.Upstream] {
+			return fmt.Errorf("route %s: unknown upstream %q", r.Prefix, r.Upstream)
+		}
+		if r.StripPrefix && r.Rewrite != "" {
+			return fmt.Errorf("route %s: strip_prefix and rewrite cannot both be set", r.Prefix)
+		}
+		if r.Rewrite != "" && !strings.HasPrefix(r.Rewrite, "/") {
+			return fmt.Errorf("route %s: rewrite %q must start with /", r.Prefix, r.Rewrite)
+		}
+		if r.Auth != "" {
+			if _, _, err := auth.ParseMode(r.Auth); err != nil {
+				return fmt.Errorf("route %s: %w", r.Prefix, err)
+			}
+		}
+		if r.Timeout < 0 {
+			return fmt.Errorf("route %s: timeout must not be negative", r.Prefix)
+		}
+		for j, m := range r.Methods {
+			r.Methods[j] = strings.ToUpper(strings.TrimSpace(m))
+			if !auth.ValidMethod(r.Methods[j]) {
+				return fmt.Errorf("route %s: unknown method %q", r.Prefix, m)
+			}
+		}
+		for _, other := range t.Routes[:i] {
+			if other.Prefix == r.Prefix && overlap(other.Methods, r.Methods) {
+				return fmt.Errorf("route %s is defined twice for the same methods", r.Prefix)
+			}
+		}
+	}
+	sort.SliceStable(t.Routes, func(i, j int) bool { return len(t.Routes[i].Prefix) > len(t.Routes[j].Prefix) })
+	return nil
+}
+
+// validate expands and checks the instances and fills in the defaults.
+func (u *UpstreamConfig) validate() error {
+	var urls []string
+	for _, raw := range append([]string{u.URL}, u.URLs...) {
+		for _, addr := range strings.Split(os.ExpandEnv(raw), ",") {
+			addr = strings.TrimSpace(addr)
+			if addr == "" {
+				continue
+			}
+			parsed, err := url.Parse(addr)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("url %q is not an http(s) URL", addr)
+			}
+			for _, seen := range urls {
+				if seen == addr {
+					return fmt.Errorf("instance %q is listed twice", addr)
+				}
+			}
+			urls = append(urls, addr)
+		}
+	}
+	if len(urls) == 0 {
+		return errNoInstances
+	}
+	u.URL, u.URLs = "", urls
+
+	switch u.Balance {
+	case "":
+		u.Balance = BalanceRoundRobin
+	case BalanceRoundRobin, BalanceLeastConnections, BalanceConsistentHash:
+	default:
+		return fmt.Errorf("unknown balance strategy %q", u.Balance)
+	}
+
+	hc := &u.HealthCheck
+	if hc.Path == "" {
+		hc.Path = DefaultHealthCheckPath
+	}
+	if !strings.HasPrefix(hc.Path, "/") {
+		return fmt.Errorf("health check path %q must start with /", hc.Path)
+	}
+	if hc.Interval == 0 {
+		hc.Interval = 10 * time.Second
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = 2 * time.Second
+	}
+	if hc.HealthyThreshold == 0 {
+		hc.HealthyThreshold = 2
+	}
+	if hc.UnhealthyThreshold == 0 {
+		hc.UnhealthyThreshold = 3
+	}
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 {
+		return errors.New("health check settings must not be negative")
+	}
+
+	if u.Outlier.Failures < 0 || u.Outlier.Ejection < 0 {
+		return errors.New("outlier settings must not be negative")
+	}
+	if u.Outlier.Failures == 0 {
+		u.Outlier.Failures = 5
+	}
+	if u.Outlier.Ejection == 0 {
+		u.Outlier.Ejection = 30 * time.Second
+	}
+	return nil
+}
+
+// overlap reports whether two method lists share a method; an empty list
+// has every method.
+func overlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, m := range a {
+		if (RouteConfig{Methods: b}).Allows(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthPolicies are the auth rules of the routes that set one.
+func (t RouteTable) AuthPolicies() []auth.Policy {
+	var policies []auth.Policy
+	for _, r := range t.Routes {
+		if r.Auth == "" {
+			continue
+		}
+		mode, role, _ := auth.ParseMode(r.Auth)
+		if len(r.Methods) == 0 {
+			policies = append(policies, auth.Policy{Prefix: r.Prefix, Mode: mode, Role: role})
+		}
+		for _, m := range r.Methods {
+			policies = append(policies, auth.Policy{Method: m, Prefix: r.Prefix, Mode: mode, Role: role})
+		}
+	}
+	return policies
+}
+
+// WatchRoutes calls reload when the routes file changes until ctx is
+// done. It watches the directory, as editors and Kubernetes replace files
+// rather than write them in place, and waits for changes to settle.
+func WatchRoutes(ctx context.Context, path string, reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		var settle <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == path || filepath.Base(event.Name) == "..data" {
+					settle = time.After(200 * time.Millisecond)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("WARNING: Watching routes file '%s': %v", path, err)
+			case <-settle:
+				settle = nil
+				reload()
+			}
+		}
+	}()
+	return nil
+}
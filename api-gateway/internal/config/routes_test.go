@@ -0,0 +1,173 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadRoutesLeavesOutOptionalUpstreamWithoutURLs(t *testing.T) {
+	t.Setenv("USER_SERVICE_URL", "http://users:8081")
+	t.Setenv("NOTIFICATION_SERVICE_URL", "")
+
+	table, err := LoadRoutes("../../routes.yaml", Config{})
+	if err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+	if len(table.Upstreams) != 1 || table.Upstreams[0].Name != "users" {
+		t.Errorf("upstreams = %+v, want users only", table.Upstreams)
+	}
+	if len(table.Routes) != 1 || table.Routes[0].Prefix != "/api/v1/users" {
+		t.Errorf("routes = %+v, want /api/v1/users only", table.Routes)
+	}
+
+	t.Setenv("NOTIFICATION_SERVICE_URL", "http://notifications:8082")
+	if table, err = LoadRoutes("../../routes.yaml", Config{}); err != nil || len(table.Routes) != 2 {
+		t.Errorf("with NOTIFICATION_SERVICE_URL: %+v, %v", table.Routes, err)
+	}
+
+	// Only optional upstreams may go without instances.
+	t.Setenv("USER_SERVICE_URL", "")
+	if _, err := LoadRoutes("../../routes.yaml", Config{}); err == nil {
+		t.Error("loaded without USER_SERVICE_URL")
+	}
+}
+
+func TestValidateNormalisesTheTable(t *testing.T) {
+	t.Setenv("USERS_A", "http://users-a:8081")
+	table := RouteTable{
+		Upstreams: []UpstreamConfig{{Name: "users", URL: "${USERS_A}, http://users-b:8081", URLs: []string{"http://users-c:8081"}}},
+		Routes: []RouteConfig{
+			{Prefix: "/", Upstream: "users"},
+			{Prefix: "/api/v1/users/", Upstream: "users", Methods: []string{" get", "post"}},
+			{Prefix: "/api", Upstream: "users"},
+		},
+	}
+	if err := table.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	u := table.Upstreams[0]
+	if want := []string{"http://users-a:8081", "http://users-b:8081", "http://users-c:8081"}; !slices.Equal(u.URLs, want) || u.URL != "" {
+		t.Errorf("instances = %q %v, want %v", u.URL, u.URLs, want)
+	}
+	if u.Balance != BalanceRoundRobin || u.HealthCheck.Path != DefaultHealthCheckPath || u.HealthCheck.Interval != 10*time.Second ||
+		u.HealthCheck.HealthyThreshold != 2 || u.HealthCheck.UnhealthyThreshold != 3 ||
+		u.Outlier.Failures != 5 || u.Outlier.Ejection != 30*time.Second {
+		t.Errorf("defaults not filled in: %+v", u)
+	}
+	var prefixes []string
+	for _, r := range table.Routes {
+		prefixes = append(prefixes, r.Prefix)
+	}
+	if want := []string{"/api/v1/users", "/api", "/"}; !slices.Equal(prefixes, want) {
+		t.Errorf("routes in order %v, want %v", prefixes, want)
+	}
+	if want := []string{"GET", "POST"}; !slices.Equal(table.Routes[0].Methods, want) {
+		t.Errorf("methods = %q, want %q", table.Routes[0].Methods, want)
+	}
+}
+
+func TestValidateRejectsInvalidTables(t *testing.T) {
+	upstream := func(mutate func(*UpstreamConfig)) *RouteTable {
+		u := UpstreamConfig{Name: "svc", URL: "http://svc:8080"}
+		mutate(&u)
+		return &RouteTable{Upstreams: []UpstreamConfig{u}, Routes: []RouteConfig{{Prefix: "/svc", Upstream: "svc"}}}
+	}
+	route := func(routes ...RouteConfig) *RouteTable {
+		return &RouteTable{Upstreams: []UpstreamConfig{{Name: "svc", URL: "http://svc:8080"}}, Routes: routes}
+	}
+
+	for name, table := range map[string]*RouteTable{
+		"upstream without a name":   upstream(func(u *UpstreamConfig) { u.Name = "" }),
+		"upstream without URLs":     upstream(func(u *UpstreamConfig) { u.URL = "" }),
+		"URL without a scheme":      upstream(func(u *UpstreamConfig) { u.URL = "svc:8080" }),
+		"instance listed twice":     upstream(func(u *UpstreamConfig) { u.URLs = []string{u.URL} }),
+		"unknown balance":           upstream(func(u *UpstreamConfig) { u.Balance = "random" }),
+		"relative health path":      upstream(func(u *UpstreamConfig) { u.HealthCheck.Path = "health" }),
+		"negative health interval":  upstream(func(u *UpstreamConfig) { u.HealthCheck.Interval = -time.Second }),
+		"negative outlier failures": upstream(func(u *UpstreamConfig) { u.Outlier.Failures = -1 }),
+		"upstream defined twice": {
+			Upstreams: []UpstreamConfig{{Name: "svc", URL: "http://a:1"}, {Name: "svc", URL: "http://b:1"}},
+			Routes:    []RouteConfig{{Prefix: "/svc", Upstream: "svc"}},
+		},
+		"no routes":                route(),
+		"relative prefix":          route(RouteConfig{Prefix: "svc", Upstream: "svc"}),
+		"unknown upstream":         route(RouteConfig{Prefix: "/svc", Upstream: "other"}),
+		"strip_prefix and rewrite": route(RouteConfig{Prefix: "/svc", Upstream: "svc", StripPrefix: true, Rewrite: "/v2"}),
+		"relative rewrite":         route(RouteConfig{Prefix: "/svc", Upstream: "svc", Rewrite: "v2"}),
+		"unknown auth mode":        route(RouteConfig{Prefix: "/svc", Upstream: "svc", Auth: "sometimes"}),
+		"negative timeout":         route(RouteConfig{Prefix: "/svc", Upstream: "svc", Timeout: -time.Second}),
+		"unknown method":           route(RouteConfig{Prefix: "/svc", Upstream: "svc", Methods: []string{"FETCH"}}),
+		"prefix defined twice": route(
+			RouteConfig{Prefix: "/svc", Upstream: "svc", Methods: []string{"GET", "POST"}},
+			RouteConfig{Prefix: "/svc/", Upstream: "svc", Methods: []string{"post"}},
+		),
+	} {
+		if err := table.Validate(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	byMethod := route(
+		RouteConfig{Prefix: "/svc", Upstream: "svc", Methods: []string{"GET"}},
+		RouteConfig{Prefix: "/svc", Upstream: "svc", Methods: []string{"POST"}},
+	)
+	if err := byMethod.Validate(); err != nil {
+		t.Errorf("same prefix for other methods: %v", err)
+	}
+}
+
+func TestWatchRoutesReloadsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "routes.yaml")
+	if err := os.WriteFile(path, []byte("routes: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan struct{}, 10)
+	if err := WatchRoutes(ctx, path, func() { reloads <- struct{}{} }); err != nil {
+		t.Fatalf("WatchRoutes: %v", err)
+	}
+	expect := func(what string, want bool) {
+		t.Helper()
+		select {
+		case <-reloads:
+			if !want {
+				t.Errorf("%s: reloaded", what)
+			}
+		case <-time.After(time.Second):
+			if want {
+				t.Errorf("%s: not reloaded", what)
+			}
+		}
+	}
+
+	// Writes in quick succession settle into one reload.
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(path, []byte("routes: []\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect("written in place", true)
+	expect("after settling", false)
+
+	// Editors replace the file instead.
+	replacement := filepath.Join(dir, "routes.yaml.tmp")
+	if err := os.WriteFile(replacement, []byte("routes: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(replacement, path); err != nil {
+		t.Fatal(err)
+	}
+	expect("replaced", true)
+
+	if err := os.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expect("another file", false)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/virhanali/filmnesia/api-gateway/internal/auth"
+	"github.com/virhanali/filmnesia/contracts"
+)
+
+// StreamPaths take the token in the access_token query parameter, as
+// browsers cannot set headers on EventSource and WebSocket requests. They
+// are kept out of the request log and are not bound by route timeouts.
+var StreamPaths = []string{"/api/v1/notifications/stream", "/api/v1/notifications/ws"}
+
+// authenticate removes any identity headers the client sent, applies
+// policy and, when the request carries a valid access token, forwards who
+// it belongs to in the identity headers. It answers requests the policy
+// turns away and reports whether to proxy the request. The Authorization
+// header itself is passed on untouched.
+func authenticate(c *gin.Context, verifier *auth.Verifier, policy auth.Policy, identitySecret []byte) bool {
+	contracts.StripIdentity(c.Request.Header)
+	if policy.Mode == auth.ModePublic {
+		return true
+	}
+
+	token, err := bearerToken(c)
+	if err == nil {
+		var id auth.Identity
+		id, err = verifier.Verify(token)
+		if err == nil {
+			if policy.Role != "" && id.Role != policy.Role {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": auth.ErrForbidden.Error()})
+				return false
+			}
+			contracts.SetIdentity(c.Request.Header, id, identitySecret, time.Now())
+			return true
+		}
+		log.Printf("API Gateway: Rejecting token for %s: %v", c.Request.URL.Path, err)
+	}
+	if errors.Is(err, auth.ErrMissingToken) && policy.Mode == auth.ModeOptional {
+		return true
+	}
+	if errors.Is(err, auth.ErrInvalidToken) {
+		// The details stay in the log.
+		err = auth.ErrInvalidToken
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	return false
+}
+
+var errInvalidAuthHeader = errors.New("format header authorization not valid (must be 'Bearer {token}')")
+
+// bearerToken returns the token of the Authorization header or, on
+// StreamPaths only, of the access_token query parameter: elsewhere a
+// token in the URL would only end up in logs and browser history.
+func bearerToken(c *gin.Context) (string, error) {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		if slices.Contains(StreamPaths, c.Request.URL.Path) {
+			if token := c.Query("access_token"); token != "" {
+				return token, nil
+			}
+		}
+		return "", auth.ErrMissingToken
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") || token == "" {
+		return "", errInvalidAuthHeader
+	}
+	return token, nil
+}
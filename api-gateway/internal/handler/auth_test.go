@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/api-gateway/internal/auth"
+	"github.com/virhanali/filmnesia/api-gateway/internal/config"
+	"github.com/virhanali/filmnesia/contracts"
+)
+
+const (
+	testJWTSecret      = "jwt-secret"
+	testIdentitySecret = "identity-secret"
+)
+
+// seen is what an echo upstream received.
+type seen struct {
+	Upstream string
+	Path     string
+	Query    string
+	Header   http.Header
+}
+
+// echoUpstream answers every request with its name and what it received,
+// and its health checks with 200.
+func echoUpstream(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(seen{Upstream: name, Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestGateway serves table, validated, behind the gateway's router
+// setup; requests need a token unless a route says otherwise.
+func newTestGateway(t *testing.T, table config.RouteTable) (*Gateway, *gin.Engine) {
+	t.Helper()
+	return newTestGatewayWithPolicies(t, table, auth.Policies{Default: auth.Policy{Mode: auth.ModeRequired}})
+}
+
+// newTestGatewayWithPolicies is newTestGateway with policies as the
+// GATEWAY_AUTH_ROUTES rules.
+func newTestGatewayWithPolicies(t *testing.T, table config.RouteTable, policies auth.Policies) (*Gateway, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if err := table.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := auth.NewVerifier(testJWTSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	g := NewGateway(verifier, policies, []byte(testIdentitySecret), streams)
+	g.Load(table)
+	router := gin.New()
+	router.NoRoute(g.Handle)
+	return g, router
+}
+
+func token(t *testing.T, userID uuid.UUID, role string) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		Username: "budi",
+		Role:     role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// recorder adds the CloseNotify the reverse proxy asks gin's writer for,
+// which httptest.ResponseRecorder lacks.
+type recorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (recorder) CloseNotify() <-chan bool { return make(chan bool) }
+
+// send serves a request and decodes what the upstream saw, if it got
+// that far.
+func send(t *testing.T, router http.Handler, method, target string, header http.Header) (int, seen) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := recorder{httptest.NewRecorder()}
+	router.ServeHTTP(rec, req)
+	var s seen
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+			t.Fatalf("%s %s: %v: %s", method, target, err, rec.Body)
+		}
+	}
+	return rec.Code, s
+}
+
+func TestAuthenticate(t *testing.T) {
+	upstream := echoUpstream(t, "svc")
+	_, router := newTestGateway(t, config.RouteTable{
+		Upstreams: []config.UpstreamConfig{{Name: "svc", URL: upstream.URL, HealthCheck: config.HealthCheckConfig{Disabled: true}}},
+		Routes: []config.RouteConfig{
+			{Prefix: "/public", Upstream: "svc", Auth: "public"},
+			{Prefix: "/optional", Upstream: "svc", Auth: "optional"},
+			{Prefix: "/admin", Upstream: "svc", Auth: "role:admin"},
+			{Prefix: "/api/v1/notifications", Upstream: "svc", Auth: "optional"},
+		},
+	})
+	userID := uuid.New()
+	bearer := func(token string) http.Header { return http.Header{"Authorization": {"Bearer " + token}} }
+	identity := contracts.NewIdentityVerifier([]byte(testIdentitySecret), 0)
+
+	// A client cannot pass off an identity of its own.
+	forged := bearer(token(t, userID, "user"))
+	forged.Set(contracts.UserIDHeader, uuid.NewString())
+	forged.Set(contracts.UserRoleHeader, "admin")
+	code, s := send(t, router, "GET", "/public/x", forged)
+	if code != http.StatusOK || s.Header.Get(contracts.UserIDHeader) != "" || s.Header.Get(contracts.UserRoleHeader) != "" {
+		t.Errorf("public route: %d %v", code, s.Header)
+	}
+
+	code, s = send(t, router, "GET", "/optional/x", nil)
+	if code != http.StatusOK || s.Header.Get(contracts.UserIDHeader) != "" {
+		t.Errorf("optional route without a token: %d %v", code, s.Header)
+	}
+	if code, _ := send(t, router, "GET", "/optional/x", bearer("not-a-token")); code != http.StatusUnauthorized {
+		t.Errorf("optional route with an invalid token: %d", code)
+	}
+	code, s = send(t, router, "GET", "/optional/x", forged)
+	if id, err := identity.Verify(s.Header); code != http.StatusOK || err != nil || id.UserID != userID || id.Role != "user" {
+		t.Errorf("optional route with a token: %d %+v %v", code, id, err)
+	}
+
+	if code, _ := send(t, router, "GET", "/admin/x", bearer(token(t, userID, "user"))); code != http.StatusForbidden {
+		t.Errorf("admin route as a user: %d", code)
+	}
+	if code, _ := send(t, router, "GET", "/admin/x", bearer(token(t, userID, "admin"))); code != http.StatusOK {
+		t.Errorf("admin route as an admin: %d", code)
+	}
+	if code, _ := send(t, router, "GET", "/elsewhere", nil); code != http.StatusNotFound {
+		t.Errorf("unrouted path: %d", code)
+	}
+}
+
+func TestQueryTokenOnlyOnStreamPaths(t *testing.T) {
+	upstream := echoUpstream(t, "svc")
+	_, router := newTestGateway(t, config.RouteTable{
+		Upstreams: []config.UpstreamConfig{{Name: "svc", URL: upstream.URL, HealthCheck: config.HealthCheckConfig{Disabled: true}}},
+		Routes:    []config.RouteConfig{{Prefix: "/api/v1/notifications", Upstream: "svc", Auth: "optional"}},
+	})
+	userID := uuid.New()
+	query := "?access_token=" + token(t, userID, "user")
+
+	code, s := send(t, router, "GET", "/api/v1/notifications/stream"+query, nil)
+	if code != http.StatusOK || s.Header.Get(contracts.UserIDHeader) != userID.String() {
+		t.Errorf("stream: %d %v", code, s.Header)
+	}
+	code, s = send(t, router, "GET", "/api/v1/notifications"+query, nil)
+	if code != http.StatusOK || s.Header.Get(contracts.UserIDHeader) != "" {
+		t.Errorf("query token accepted outside the streams: %d %v", code, s.Header)
+	}
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/virhanali/filmnesia/api-gateway/internal/auth"
+	"github.com/virhanali/filmnesia/api-gateway/internal/config"
+	"github.com/virhanali/filmnesia/api-gateway/internal/upstream"
+)
+
+// Gateway proxies requests by a route table that Load can replace while
+// it serves: requests in flight finish with the table they started with.
+type Gateway struct {
+	verifier       *auth.Verifier
+	policies       auth.Policies
+	identitySecret []byte
+	streams        context.Context
+
+	table atomic.Pointer[routeTable]
+}
+
+type routeTable struct {
+	routes    []route
+	policies  auth.Policies
+	upstreams []*balancer
+	// stopChecks ends the health checks of the table's upstreams.
+	stopChecks context.CancelFunc
+}
+
+type route struct {
+	config.RouteConfig
+	upstream *balancer
+}
+
+// balancer proxies to the instances of an upstream.
+type balancer struct {
+	pool       *upstream.Pool
+	hashHeader string
+	proxies    map[*upstream.Instance]gin.HandlerFunc
+}
+
+// NewGateway returns a gateway that authenticates requests with verifier
+// by policies, the GATEWAY_AUTH_ROUTES rules, together with the auth of
+// each route. Cancelling streams closes the long-lived connections it
+// proxies and stops the health checks.
+func NewGateway(verifier *auth.Verifier, policies auth.Policies, identitySecret []byte, streams context.Context) *Gateway {
+	return &Gateway{verifier: verifier, policies: policies, identitySecret: identitySecret, streams: streams}
+}
+
+// Load serves table, which must have been validated, from now on. The
+// instances of its upstreams start out healthy, and the health checks of
+// the previous table stop.
+func (g *Gateway) Load(table config.RouteTable) {
+	checks, stopChecks := context.WithCancel(g.streams)
+	balancers := map[string]*balancer{}
+	next := &routeTable{
+		stopChecks: stopChecks,
+		// Route rules come first so that they win ties with the
+		// GATEWAY_AUTH_ROUTES ones.
+		policies: auth.Policies{
+			Default: g.policies.Default,
+			Routes:  append(table.AuthPolicies(), g.policies.Routes...),
+		},
+	}
+	for _, uc := range table.Upstreams {
+		pool := upstream.NewPool(uc)
+		b := &balancer{pool: pool, hashHeader: uc.HashHeader, proxies: map[*upstream.Instance]gin.HandlerFunc{}}
+		for _, inst := range pool.Instances() {
+			b.proxies[inst] = NewReverseProxy(inst.URL.String(), g.streams, func(failed bool) { pool.Report(inst, failed) })
+		}
+		pool.StartHealthChecks(checks)
+		balancers[uc.Name] = b
+		next.upstreams = append(next.upstreams, b)
+	}
+	for _, rc := range table.Routes {
+		next.routes = append(next.routes, route{RouteConfig: rc, upstream: balancers[rc.Upstream]})
+		log.Printf("INFO: Proxying %s %s to %s", methodsLabel(rc.Methods), rc.Prefix, rc.Upstream)
+	}
+	if previous := g.table.Swap(next); previous != nil {
+		previous.stopChecks()
+	}
+}
+
+// Reload loads the route table at path, as config.LoadRoutes does for
+// cfg, and serves it. A table that fails to load leaves the current one
+// serving.
+func (g *Gateway) Reload(path string, cfg config.Config) error {
+	table, err := config.LoadRoutes(path, cfg)
+	if err != nil {
+		return err
+	}
+	g.Load(table)
+	return nil
+}
+
+// Upstreams shows administrators the instances of every upstream and
+// whether they receive requests.
+func (g *Gateway) Upstreams(c *gin.Context) {
+	if !authenticate(c, g.verifier, auth.Policy{Mode: auth.ModeRequired, Role: "admin"}, g.identitySecret) {
+		return
+	}
+	statuses := []upstream.Status{}
+	for _, b := range g.table.Load().upstreams {
+		statuses = append(statuses, b.pool.Status())
+	}
+	c.JSON(http.StatusOK, gin.H{"upstreams": statuses})
+}
+
+func methodsLabel(methods []string) string {
+	if len(methods) == 0 {
+		return "*"
+	}
+	return strings.Join(methods, ",")
+}
+
+// Handle proxies a request by the current route table, with the route of
+// the longest prefix that serves its method. A path some route matches
+// but none for the method is answered with 405.
+func (g *Gateway) Handle(c *gin.Context) {
+	table := g.table.Load()
+	path := c.Request.URL.Path
+	var allowed []string
+	for _, r := range table.routes {
+		if !r.Matches(path) {
+			continue
+		}
+		if !r.Allows(c.Request.Method) {
+			allowed = append(allowed, r.Methods...)
+			continue
+		}
+		g.serve(c, table, r)
+		return
+	}
+	if allowed != nil {
+		c.Header("Allow", strings.Join(allowed, ", "))
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+}
+
+// serve authenticates the request and proxies it to an instance of the
+// route's upstream, with the path rewritten and, unless it is one of the
+// StreamPaths, within the route's timeout. Requests are keyed for
+// consistent hashing on the upstream's hash header or else the client IP.
+// With no instance available the answer is 503.
+func (g *Gateway) serve(c *gin.Context, table *routeTable, r route) {
+	policy := table.policies.Match(c.Request.Method, c.Request.URL.Path)
+	if !authenticate(c, g.verifier, policy, g.identitySecret) {
+		return
+	}
+	// Client headers alone do not lift the timeout: any request could
+	// claim to be a stream.
+	stream := slices.Contains(StreamPaths, c.Request.URL.Path)
+	if r.StripPrefix || r.Rewrite != "" {
+		rest := strings.TrimPrefix(c.Request.URL.Path, r.Prefix)
+		if r.Prefix == "/" {
+			rest = c.Request.URL.Path
+		}
+		c.Request.URL.Path = strings.TrimSuffix(r.Rewrite, "/") + rest
+		if c.Request.URL.Path == "" {
+			c.Request.URL.Path = "/"
+		}
+		c.Request.URL.RawPath = ""
+	}
+	if r.Timeout > 0 && !stream {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), r.Timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+	}
+	key := c.ClientIP()
+	if r.upstream.hashHeader != "" {
+		if value := c.GetHeader(r.upstream.hashHeader); value != "" {
+			key = value
+		}
+	}
+	inst, done, err := r.upstream.pool.Pick(key)
+	if err != nil {
+		log.Printf("ERROR: No instance of %s for %s: %v", r.Upstream, c.Request.URL.Path, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service unavailable"})
+		return
+	}
+	defer done()
+	r.upstream.proxies[inst](c)
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/api-gateway/internal/auth"
+	"github.com/virhanali/filmnesia/api-gateway/internal/config"
+)
+
+// unchecked is an upstream of the instance at url without health checks.
+func unchecked(name, url string) config.UpstreamConfig {
+	return config.UpstreamConfig{Name: name, URL: url, HealthCheck: config.HealthCheckConfig{Disabled: true}}
+}
+
+func TestRouteMatching(t *testing.T) {
+	_, router := newTestGateway(t, config.RouteTable{
+		Upstreams: []config.UpstreamConfig{
+			unchecked("api", echoUpstream(t, "api").URL),
+			unchecked("users", echoUpstream(t, "users").URL),
+			unchecked("login", echoUpstream(t, "login").URL),
+			unchecked("admin", echoUpstream(t, "admin").URL),
+		},
+		// Listed shortest first: the longest prefix wins all the same.
+		Routes: []config.RouteConfig{
+			{Prefix: "/api", Upstream: "api", Auth: "public"},
+			{Prefix: "/api/v1/users", Upstream: "users", Auth: "public"},
+			{Prefix: "/api/v1/users/login", Methods: []string{"post"}, Upstream: "login", Auth: "public"},
+			{Prefix: "/admin", Methods: []string{"POST", "PUT"}, Upstream: "admin", Auth: "public"},
+		},
+	})
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		upstream     string
+	}{
+		{"GET", "/api/v1/users/me", http.StatusOK, "users"},
+		{"POST", "/api/v1/users/login", http.StatusOK, "login"},
+		{"GET", "/api/v1/users/login", http.StatusOK, "users"},
+		{"GET", "/api/v1/usersx", http.StatusOK, "api"},
+		{"GET", "/api", http.StatusOK, "api"},
+		{"PUT", "/admin/settings", http.StatusOK, "admin"},
+		{"GET", "/admin/settings", http.StatusMethodNotAllowed, ""},
+		{"GET", "/apix", http.StatusNotFound, ""},
+	} {
+		code, s := send(t, router, tc.method, tc.path, nil)
+		if code != tc.code || s.Upstream != tc.upstream {
+			t.Errorf("%s %s: %d from %q, want %d from %q", tc.method, tc.path, code, s.Upstream, tc.code, tc.upstream)
+		}
+	}
+
+	req := httptest.NewRequest("DELETE", "/admin", nil)
+	rec := recorder{httptest.NewRecorder()}
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "POST, PUT" {
+		t.Errorf("DELETE /admin: %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestPathRewriting(t *testing.T) {
+	upstream := echoUpstream(t, "svc")
+	_, router := newTestGateway(t, config.RouteTable{
+		Upstreams: []config.UpstreamConfig{unchecked("svc", upstream.URL)},
+		Routes: []config.RouteConfig{
+			{Prefix: "/kept", Upstream: "svc", Auth: "public"},
+			{Prefix: "/stripped/", Upstream: "svc", Auth: "public", StripPrefix: true},
+			{Prefix: "/old", Upstream: "svc", Auth: "public", Rewrite: "/new/"},
+		},
+	})
+
+	for _, tc := range []struct{ target, path, query string }{
+		{"/kept/a?x=1", "/kept/a", "x=1"},
+		{"/stripped/a/b?x=1", "/a/b", "x=1"},
+		{"/stripped", "/", ""},
+		{"/old/a", "/new/a", ""},
+		{"/old", "/new", ""},
+	} {
+		code, s := send(t, router, "GET", tc.target, nil)
+		if code != http.StatusOK || s.Path != tc.path || s.Query != tc.query {
+			t.Errorf("%s: %d forwarded as %s?%s, want %s?%s", tc.target, code, s.Path, s.Query, tc.path, tc.query)
+		}
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			w.Write([]byte(`{}`))
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	_, router := newTestGateway(t, config.RouteTable{
+		Upstreams: []config.UpstreamConfig{unchecked("slow", slow.URL)},
+		Routes: []config.RouteConfig{
+			{Prefix: "/hurried", Upstream: "slow", Auth: "public", Timeout: 20 * time.Millisecond},
+			{Prefix: "/patient", Upstream: "slow", Auth: "public"},
+			{Prefix: "/api/v1/notifications", Upstream: "slow", Auth: "public", Timeout: 20 * time.Millisecond},
+		},
+	})
+
+	if code, _ := send(t, router, "GET", "/hurried", nil); code != http.StatusGatewayTimeout {
+		t.Errorf("past the route timeout: %d", code)
+	}
+	if code, _ := send(t, router, "GET", "/patient", nil); code != http.StatusOK {
+		t.Errorf("without a route timeout: %d", code)
+	}
+
+	// Only the stream endpoints outlast the timeout, whatever the client
+	// says it is opening.
+	for _, header := range []http.Header{
+		{"Accept": {"text/event-stream"}},
+		{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}},
+	} {
+		if code, _ := send(t, router, "GET", "/hurried", header); code != http.StatusGatewayTimeout {
+			t.Errorf("plain route with %v: %d", header, code)
+		}
+	}
+	if code, _ := send(t, router, "GET", "/api/v1/notifications/stream", http.Header{"Accept": {"text/event-stream"}}); code != http.StatusOK {
+		t.Errorf("stream endpoint past the route timeout: %d", code)
+	}
+	if code, _ := send(t, router, "GET", "/api/v1/notifications/unread", nil); code != http.StatusGatewayTimeout {
+		t.Errorf("non-stream path under the stream prefix: %d", code)
+	}
+}
+
+func TestRouteAuthPolicies(t *testing.T) {
+	upstream := echoUpstream(t, "svc")
+	_, router := newTestGatewayWithPolicies(t, config.RouteTable{
+		Upstreams: []config.UpstreamConfig{unchecked("svc", upstream.URL)},
+		Routes: []config.RouteConfig{
+			{Prefix: "/open", Upstream: "svc", Auth: "public"},
+			{Prefix: "/closed", Upstream: "svc"},
+			{Prefix: "/by-method", Methods: []string{"POST"}, Upstream: "svc", Auth: "public"},
+			{Prefix: "/by-method", Methods: []string{"GET"}, Upstream: "svc"},
+		},
+	}, auth.Policies{
+		Default: auth.Policy{Mode: auth.ModeOptional},
+		Routes: []auth.Policy{
+			// Ties with the route's own rule, which wins.
+			{Prefix: "/open", Mode: auth.ModeRequired},
+			// More specific than the route's rule, so it applies.
+			{Prefix: "/open/admin", Mode: auth.ModeRequired, Role: "admin"},
+			{Prefix: "/closed", Mode: auth.ModeRequired},
+		},
+	})
+	user := http.Header{"Authorization": {"Bearer " + token(t, uuid.New(), "user")}}
+
+	for _, tc := range []struct {
+		method, path string
+		header       http.Header
+		code         int
+	}{
+		{"GET", "/open/x", nil, http.StatusOK},
+		{"GET", "/open/admin", nil, http.StatusUnauthorized},
+		{"GET", "/open/admin", user, http.StatusForbidden},
+		{"GET", "/closed/x", nil, http.StatusUnauthorized},
+		{"GET", "/closed/x", user, http.StatusOK},
+		{"POST", "/by-method", nil, http.StatusOK},
+		{"GET", "/by-method", nil, http.StatusOK},
+	} {
+		if code, _ := send(t, router, tc.method, tc.path, tc.header); code != tc.code {
+			t.Errorf("%s %s: %d, want %d", tc.method, tc.path, code, tc.code)
+		}
+	}
+}
+
+func TestReloadKeepsCurrentRoutesOnABadFile(t *testing.T) {
+	first, second := echoUpstream(t, "first"), echoUpstream(t, "second")
+	g, router := newTestGateway(t, config.RouteTable{
+		Upstreams: []config.UpstreamConfig{unchecked("first", first.URL)},
+		Routes:    []config.RouteConfig{{Prefix: "/first", Upstream: "first", Auth: "public"}},
+	})
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+upstreams:
+  - name: second
+    url: ` + second.URL + `
+    health_check: {disabled: true}
+routes:
+  - prefix: /second
+    upstream: second
+    auth: public
+`)
+	if err := g.Reload(path, config.Config{}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if code, s := send(t, router, "GET", "/second/x", nil); code != http.StatusOK || s.Upstream != "second" {
+		t.Errorf("new route: %d from %q", code, s.Upstream)
+	}
+	if code, _ := send(t, router, "GET", "/first/x", nil); code != http.StatusNotFound {
+		t.Errorf("route of the previous table: %d", code)
+	}
+
+	for name, content := range map[string]string{
+		"unknown upstream": "routes:\n  - prefix: /third\n    upstream: third\n",
+		"not YAML":         "routes: [",
+	} {
+		write(content)
+		if err := g.Reload(path, config.Config{}); err == nil {
+			t.Errorf("%s: reloaded", name)
+		}
+		if code, s := send(t, router, "GET", "/second/x", nil); code != http.StatusOK || s.Upstream != "second" {
+			t.Errorf("%s: current route no longer served: %d from %q", name, code, s.Upstream)
+		}
+	}
+}
@@ -1,23 +1,76 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func NewReverseProxy(targetHost string) gin.HandlerFunc {
+// NewReverseProxy forwards requests to targetHost, and answers with 504
+// when the request's deadline passes first. It tells report, when set,
+// whether each request failed: a 5xx response or an error reaching the
+// host, but not a client that went away. WebSocket upgrades and
+// Server-Sent Events pass through as they are; such long-lived requests
+// are cut when streams is cancelled, so that they do not hold up a
+// graceful shutdown. Clients reconnect and resume.
+func NewReverseProxy(targetHost string, streams context.Context, report func(failed bool)) gin.HandlerFunc {
 	target, err := url.Parse(targetHost)
 	if err != nil {
 		log.Fatalf("FATAL: Invalid target URL for reverse proxy: %s. Error: %v", targetHost, err)
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(target)
+	// Write every chunk as soon as the upstream sends it rather than
+	// buffering, so streamed responses arrive in real time.
+	proxy.FlushInterval = -1
+	if report == nil {
+		report = func(bool) {}
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		report(resp.StatusCode >= http.StatusInternalServerError)
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("API Gateway: Proxying %s to %s failed: %v", r.URL.Path, targetHost, err)
+		if !errors.Is(err, context.Canceled) {
+			report(true)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
 
 	return func(c *gin.Context) {
 		log.Printf("API Gateway: Proxying request for %s to %s", c.Request.URL.Path, targetHost)
+		if longLived(c.Request) {
+			ctx, cancel := context.WithCancel(c.Request.Context())
+			defer cancel()
+			stop := context.AfterFunc(streams, cancel)
+			defer stop()
+			c.Request = c.Request.WithContext(ctx)
+			// The proxy aborts a response it cannot finish copying, which
+			// is how every stream ends; that is not a crash to recover.
+			defer func() {
+				if r := recover(); r != nil && r != http.ErrAbortHandler {
+					panic(r)
+				}
+			}()
+		}
 		proxy.ServeHTTP(c.Writer, c.Request)
 	}
 }
+
+// longLived reports whether r opens a connection that stays open: a
+// WebSocket upgrade or an EventSource.
+func longLived(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
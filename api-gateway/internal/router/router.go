@@ -2,22 +2,21 @@ package router
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/virhanali/filmnesia/api-gateway/internal/config"
 	"github.com/virhanali/filmnesia/api-gateway/internal/handler"
 )
 
-func SetupRouter(cfg config.Config) *gin.Engine {
-	router := gin.Default()
-
-	userServiceProxy := handler.NewReverseProxy(cfg.UserServiceURL)
-	userRoutes := router.Group("/api/v1/users")
-	{
-		userRoutes.Any("/*proxyPath", userServiceProxy)
-	}
+// SetupRouter builds the gateway's own routes and sends every other
+// request through gateway.
+func SetupRouter(gateway *handler.Gateway) *gin.Engine {
+	router := gin.New()
+	// Stream requests carry the access token in the query string.
+	router.Use(gin.LoggerWithConfig(gin.LoggerConfig{SkipPaths: handler.StreamPaths}), gin.Recovery())
 
 	router.GET("/gateway/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "API Gateway is healthy"})
 	})
+	router.GET("/gateway/upstreams", gateway.Upstreams)
+	router.NoRoute(gateway.Handle)
 
 	return router
 }
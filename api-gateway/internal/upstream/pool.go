@@ -0,0 +1,263 @@
+// Package upstream spreads the gateway's requests over the instances of a
+// service and keeps track of which of them are fit to receive any.
+package upstream
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/virhanali/filmnesia/api-gateway/internal/config"
+)
+
+// ErrNoInstance is returned when every instance is unhealthy or ejected.
+var ErrNoInstance = errors.New("no healthy instance")
+
+// ringReplicas is how many points each instance has on the hash ring, so
+// that keys spread evenly and move little when an instance drops out.
+const ringReplicas = 100
+
+// Instance is one address of an upstream.
+type Instance struct {
+	URL *url.URL
+
+	active   atomic.Int64
+	requests atomic.Int64
+	failures atomic.Int64
+
+	mu sync.Mutex
+	// healthy is the verdict of the active checks, passes and fails the
+	// checks in a row since it last changed.
+	healthy      bool
+	passes       int
+	fails        int
+	lastCheck    time.Time
+	checkError   string
+	consecutive  int
+	ejectedUntil time.Time
+}
+
+func (i *Instance) available(now time.Time) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.healthy && !now.Before(i.ejectedUntil)
+}
+
+// Pool is an upstream's instances and how to pick one.
+type Pool struct {
+	cfg       config.UpstreamConfig
+	instances []*Instance
+	next      atomic.Uint64
+	// ring is the hash ring for consistent_hash, sorted by hash.
+	ring []ringPoint
+	now  func() time.Time
+}
+
+type ringPoint struct {
+	hash     uint32
+	instance *Instance
+}
+
+// NewPool returns the pool of a validated upstream.
+func NewPool(cfg config.UpstreamConfig) *Pool {
+	p := &Pool{cfg: cfg, now: time.Now}
+	for _, raw := range cfg.URLs {
+		u, _ := url.Parse(raw)
+		inst := &Instance{URL: u, healthy: true}
+		p.instances = append(p.instances, inst)
+		for r := 0; r < ringReplicas; r++ {
+			p.ring = append(p.ring, ringPoint{hash: crc32.ChecksumIEEE([]byte(raw + "#" + strconv.Itoa(r))), instance: inst})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	return p
+}
+
+func (p *Pool) Instances() []*Instance { return p.instances }
+
+// Pick chooses an available instance for a request with key, which only
+// consistent_hash uses. The caller must call done when the request ends.
+func (p *Pool) Pick(key string) (inst *Instance, done func(), err error) {
+	now := p.now()
+	switch p.cfg.Balance {
+	case config.BalanceLeastConnections:
+		for _, candidate := range p.instances {
+			if candidate.available(now) && (inst == nil || candidate.active.Load() < inst.active.Load()) {
+				inst = candidate
+			}
+		}
+	case config.BalanceConsistentHash:
+		h := crc32.ChecksumIEEE([]byte(key))
+		start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+		for n := range p.ring {
+			if candidate := p.ring[(start+n)%len(p.ring)].instance; candidate.available(now) {
+				inst = candidate
+				break
+			}
+		}
+	default:
+		start := p.next.Add(1)
+		for n := range p.instances {
+			if candidate := p.instances[(int(start)+n)%len(p.instances)]; candidate.available(now) {
+				inst = candidate
+				break
+			}
+		}
+	}
+	if inst == nil {
+		return nil, nil, ErrNoInstance
+	}
+	inst.active.Add(1)
+	inst.requests.Add(1)
+	return inst, func() { inst.active.Add(-1) }, nil
+}
+
+// Report records the outcome of a request to inst: failed is a 5xx
+// response or an error reaching it. Enough failures in a row eject it.
+func (p *Pool) Report(inst *Instance, failed bool) {
+	if !failed {
+		inst.mu.Lock()
+		inst.consecutive = 0
+		inst.mu.Unlock()
+		return
+	}
+	inst.failures.Add(1)
+	if p.cfg.Outlier.Disabled {
+		return
+	}
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	inst.consecutive++
+	if inst.consecutive >= p.cfg.Outlier.Failures {
+		inst.consecutive = 0
+		inst.ejectedUntil = p.now().Add(p.cfg.Outlier.Ejection)
+		log.Printf("WARNING: Ejecting %s instance %s for %s after %d failed requests in a row",
+			p.cfg.Name, inst.URL, p.cfg.Outlier.Ejection, p.cfg.Outlier.Failures)
+	}
+}
+
+// StartHealthChecks checks every instance each interval until ctx is done.
+func (p *Pool) StartHealthChecks(ctx context.Context) {
+	hc := p.cfg.HealthCheck
+	if hc.Disabled {
+		return
+	}
+	client := &http.Client{Timeout: hc.Timeout}
+	go func() {
+		ticker := time.NewTicker(hc.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				var wg sync.WaitGroup
+				for _, inst := range p.instances {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						p.check(ctx, client, inst)
+					}()
+				}
+				wg.Wait()
+			}
+		}
+	}()
+}
+
+func (p *Pool) check(ctx context.Context, client *http.Client, inst *Instance) {
+	hc := p.cfg.HealthCheck
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, inst.URL.JoinPath(hc.Path).String(), nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = errors.New(resp.Status)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	inst.lastCheck = p.now()
+	inst.checkError = ""
+	if err != nil {
+		inst.checkError = err.Error()
+		inst.passes = 0
+		inst.fails++
+		if inst.healthy && inst.fails >= hc.UnhealthyThreshold {
+			inst.healthy = false
+			log.Printf("WARNING: %s instance %s is unhealthy: %v", p.cfg.Name, inst.URL, err)
+		}
+		return
+	}
+	inst.fails = 0
+	inst.passes++
+	if !inst.healthy && inst.passes >= hc.HealthyThreshold {
+		inst.healthy = true
+		log.Printf("INFO: %s instance %s is healthy again", p.cfg.Name, inst.URL)
+	}
+}
+
+// InstanceStatus is what the admin endpoint shows of an instance.
+type InstanceStatus struct {
+	URL          string     `json:"url"`
+	Healthy      bool       `json:"healthy"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+	Active       int64      `json:"active"`
+	Requests     int64      `json:"requests"`
+	Failures     int64      `json:"failures"`
+	LastCheck    *time.Time `json:"last_check,omitempty"`
+	CheckError   string     `json:"check_error,omitempty"`
+}
+
+// Status is what the admin endpoint shows of an upstream.
+type Status struct {
+	Name      string           `json:"name"`
+	Balance   string           `json:"balance"`
+	Available int              `json:"available"`
+	Instances []InstanceStatus `json:"instances"`
+}
+
+func (p *Pool) Status() Status {
+	now := p.now()
+	status := Status{Name: p.cfg.Name, Balance: p.cfg.Balance}
+	for _, inst := range p.instances {
+		if inst.available(now) {
+			status.Available++
+		}
+		inst.mu.Lock()
+		s := InstanceStatus{
+			URL:        inst.URL.String(),
+			Healthy:    inst.healthy,
+			Active:     inst.active.Load(),
+			Requests:   inst.requests.Load(),
+			Failures:   inst.failures.Load(),
+			CheckError: inst.checkError,
+		}
+		if inst.ejectedUntil.After(now) {
+			until := inst.ejectedUntil
+			s.EjectedUntil = &until
+		}
+		if !inst.lastCheck.IsZero() {
+			at := inst.lastCheck
+			s.LastCheck = &at
+		}
+		inst.mu.Unlock()
+		status.Instances = append(status.Instances, s)
+	}
+	return status
+}
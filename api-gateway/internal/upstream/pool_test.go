@@ -0,0 +1,276 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/virhanali/filmnesia/api-gateway/internal/config"
+)
+
+// clock is a settable time for a pool.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+// newTestPool validates cfg, with n instances unless it lists some, and
+// returns its pool on a clock that only moves when the test says so.
+func newTestPool(t *testing.T, cfg config.UpstreamConfig, n int) (*Pool, *clock) {
+	t.Helper()
+	cfg.Name = "svc"
+	for i := 0; len(cfg.URLs) == 0 && i < n; i++ {
+		cfg.URL += fmt.Sprintf(",http://svc-%d:8080", i)
+	}
+	table := config.RouteTable{
+		Upstreams: []config.UpstreamConfig{cfg},
+		Routes:    []config.RouteConfig{{Prefix: "/", Upstream: "svc"}},
+	}
+	if err := table.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	p := NewPool(table.Upstreams[0])
+	c := &clock{now: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)}
+	p.now = c.Now
+	return p, c
+}
+
+// pick picks for key and ends the request at once.
+func pick(t *testing.T, p *Pool, key string) *Instance {
+	t.Helper()
+	inst, done, err := p.Pick(key)
+	if err != nil {
+		t.Fatalf("Pick(%q): %v", key, err)
+	}
+	done()
+	return inst
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	p, _ := newTestPool(t, config.UpstreamConfig{Balance: config.BalanceRoundRobin}, 3)
+	counts := map[*Instance]int{}
+	previous := pick(t, p, "")
+	for i := 0; i < 6; i++ {
+		inst := pick(t, p, "")
+		if inst == previous {
+			t.Fatalf("pick %d repeated %s", i, inst.URL)
+		}
+		counts[inst]++
+		previous = inst
+	}
+	for _, inst := range p.Instances() {
+		if counts[inst] != 2 {
+			t.Errorf("%s picked %d of 6 times, want 2", inst.URL, counts[inst])
+		}
+	}
+
+	// Unavailable instances are passed over.
+	p.Instances()[1].healthy = false
+	for i := 0; i < 4; i++ {
+		if inst := pick(t, p, ""); inst == p.Instances()[1] {
+			t.Fatalf("picked unhealthy %s", inst.URL)
+		}
+	}
+}
+
+func TestPickLeastConnections(t *testing.T) {
+	p, _ := newTestPool(t, config.UpstreamConfig{Balance: config.BalanceLeastConnections}, 3)
+	var held []*Instance
+	var release []func()
+	for i := 0; i < 3; i++ {
+		inst, done, err := p.Pick("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		held, release = append(held, inst), append(release, done)
+	}
+	if held[0] == held[1] || held[1] == held[2] || held[0] == held[2] {
+		t.Fatalf("busy instances picked again: %v", held)
+	}
+
+	release[1]()
+	for i := 0; i < 2; i++ {
+		if inst := pick(t, p, ""); inst != held[1] {
+			t.Errorf("picked %s, want the idle %s", inst.URL, held[1].URL)
+		}
+	}
+	if active := held[0].active.Load(); active != 1 {
+		t.Errorf("held instance has %d active requests, want 1", active)
+	}
+}
+
+func TestPickConsistentHash(t *testing.T) {
+	p, c := newTestPool(t, config.UpstreamConfig{Balance: config.BalanceConsistentHash}, 3)
+	owners := map[string]*Instance{}
+	used := map[*Instance]bool{}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		owners[key] = pick(t, p, key)
+		used[owners[key]] = true
+		if again := pick(t, p, key); again != owners[key] {
+			t.Fatalf("%s moved from %s to %s", key, owners[key].URL, again.URL)
+		}
+	}
+	if len(used) != 3 {
+		t.Errorf("50 keys spread over %d of 3 instances", len(used))
+	}
+
+	// Only the keys of an ejected instance move, and they move back.
+	gone := owners["user-0"]
+	gone.ejectedUntil = c.now.Add(time.Minute)
+	for key, owner := range owners {
+		got := pick(t, p, key)
+		if owner == gone && got == gone {
+			t.Errorf("%s still sent to the ejected instance", key)
+		}
+		if owner != gone && got != owner {
+			t.Errorf("%s moved off a healthy instance", key)
+		}
+	}
+	c.now = c.now.Add(time.Minute)
+	if got := pick(t, p, "user-0"); got != gone {
+		t.Errorf("user-0 not back on its instance after the ejection")
+	}
+}
+
+func TestPickWithEveryInstanceDown(t *testing.T) {
+	for _, balance := range []string{config.BalanceRoundRobin, config.BalanceLeastConnections, config.BalanceConsistentHash} {
+		p, c := newTestPool(t, config.UpstreamConfig{Balance: balance}, 2)
+		p.Instances()[0].healthy = false
+		p.Instances()[1].ejectedUntil = c.now.Add(time.Second)
+		if _, _, err := p.Pick("key"); !errors.Is(err, ErrNoInstance) {
+			t.Errorf("%s: Pick = %v, want ErrNoInstance", balance, err)
+		}
+		if s := p.Status(); s.Available != 0 {
+			t.Errorf("%s: %d available", balance, s.Available)
+		}
+	}
+}
+
+func TestHealthCheckThresholds(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	var paths atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths.Store(r.URL.Path)
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(backend.Close)
+	down := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	down.Close()
+
+	p, _ := newTestPool(t, config.UpstreamConfig{
+		URLs:        []string{backend.URL, down.URL},
+		HealthCheck: config.HealthCheckConfig{HealthyThreshold: 2, UnhealthyThreshold: 3},
+	}, 0)
+	up, unreachable := p.Instances()[0], p.Instances()[1]
+	client := &http.Client{Timeout: time.Second}
+	check := func(inst *Instance) { p.check(context.Background(), client, inst) }
+
+	status.Store(http.StatusServiceUnavailable)
+	for _, tc := range []struct {
+		name    string
+		status  int
+		healthy bool
+	}{
+		{"first failure", http.StatusServiceUnavailable, true},
+		{"second failure", http.StatusServiceUnavailable, true},
+		{"third failure", http.StatusServiceUnavailable, false},
+		{"first pass", http.StatusOK, false},
+		{"failure between passes", http.StatusInternalServerError, false},
+		{"pass after a failure", http.StatusOK, false},
+		{"second pass in a row", http.StatusNoContent, true},
+		{"failure once healthy", http.StatusBadGateway, true},
+	} {
+		status.Store(int32(tc.status))
+		check(up)
+		if got := up.available(p.now()); got != tc.healthy {
+			t.Errorf("after %s: available = %t, want %t", tc.name, got, tc.healthy)
+		}
+	}
+	if got, _ := paths.Load().(string); got != config.DefaultHealthCheckPath {
+		t.Errorf("checked %q, want %q", got, config.DefaultHealthCheckPath)
+	}
+
+	for i := 0; i < 3; i++ {
+		check(unreachable)
+	}
+	s := p.Status().Instances[1]
+	if s.Healthy || s.CheckError == "" || s.LastCheck == nil {
+		t.Errorf("unreachable instance: %+v", s)
+	}
+	for i := 0; i < 5; i++ {
+		if inst := pick(t, p, ""); inst != up {
+			t.Fatalf("picked the unreachable instance")
+		}
+	}
+}
+
+func TestOutlierEjection(t *testing.T) {
+	p, c := newTestPool(t, config.UpstreamConfig{
+		Outlier: config.OutlierConfig{Failures: 3, Ejection: 30 * time.Second},
+	}, 2)
+	bad := p.Instances()[0]
+
+	for i, failed := range []bool{true, true, false, true, true} {
+		p.Report(bad, failed)
+		if !bad.available(c.now) {
+			t.Fatalf("ejected after report %d; a success resets the count", i)
+		}
+	}
+	p.Report(bad, true)
+	if bad.available(c.now) {
+		t.Fatal("not ejected after 3 failures in a row")
+	}
+	s := p.Status()
+	if s.Available != 1 || s.Instances[0].EjectedUntil == nil || !s.Instances[0].EjectedUntil.Equal(c.now.Add(30*time.Second)) {
+		t.Errorf("status while ejected: %+v", s)
+	}
+	for i := 0; i < 4; i++ {
+		if pick(t, p, "") == bad {
+			t.Fatal("picked the ejected instance")
+		}
+	}
+
+	c.now = c.now.Add(29 * time.Second)
+	if bad.available(c.now) {
+		t.Error("re-admitted before the ejection ended")
+	}
+	c.now = c.now.Add(time.Second)
+	if !bad.available(c.now) || p.Status().Instances[0].EjectedUntil != nil {
+		t.Error("not re-admitted after the ejection")
+	}
+
+	// Without outlier detection failures are only counted.
+	p, c = newTestPool(t, config.UpstreamConfig{Outlier: config.OutlierConfig{Disabled: true, Failures: 1}}, 1)
+	for i := 0; i < 10; i++ {
+		p.Report(p.Instances()[0], true)
+	}
+	if !p.Instances()[0].available(c.now) {
+		t.Error("ejected with outlier detection disabled")
+	}
+}
+
+func TestReportAccounting(t *testing.T) {
+	p, _ := newTestPool(t, config.UpstreamConfig{}, 1)
+	inst, done, err := p.Pick("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := p.Status().Instances[0]; s.Active != 1 || s.Requests != 1 {
+		t.Errorf("during a request: %+v", s)
+	}
+	p.Report(inst, true)
+	done()
+	pick(t, p, "")
+	p.Report(inst, false)
+
+	s := p.Status().Instances[0]
+	if s.Active != 0 || s.Requests != 2 || s.Failures != 1 {
+		t.Errorf("after two requests, one failed: %+v", s)
+	}
+}
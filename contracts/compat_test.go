@@ -0,0 +1,239 @@
+package contracts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// latestPayloads has one sample of the current Go type for every event.
+// Adding an event type without adding it here fails TestEveryEventIsCovered.
+var latestPayloads = []Payload{
+	UserRegisteredEvent{UserID: uuid.New(), Email: "a@example.com", Username: "a", RegisteredAt: time.Now(), Locale: LocaleEnglish},
+	UserUpdatedEvent{UserID: uuid.New(), Username: "a", Email: "a@example.com", UpdatedAt: time.Now(), Locale: "en-US"},
+	UserEmailChangedEvent{UserID: uuid.New(), Username: "a", OldEmail: "a@example.com", NewEmail: "b@example.com", ChangedAt: time.Now()},
+	UserRoleChangedEvent{UserID: uuid.New(), Username: "a", OldRole: "user", NewRole: "admin", ChangedAt: time.Now()},
+	UserDeletedEvent{UserID: uuid.New(), Username: "a", Email: "a@example.com", DeletedAt: time.Now()},
+	UserLoggedInEvent{UserID: uuid.New(), Username: "a", LoggedInAt: time.Now()},
+}
+
+func routingKeys(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir("schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Name())
+	}
+	return keys
+}
+
+func TestEveryEventIsCovered(t *testing.T) {
+	covered := map[string]bool{}
+	for _, p := range latestPayloads {
+		covered[p.RoutingKey()] = true
+	}
+	for _, key := range routingKeys(t) {
+		if !covered[key] {
+			t.Errorf("%s has a schema but no sample in latestPayloads", key)
+		}
+		for _, v := range Versions(key) {
+			if _, err := os.Stat(filepath.Join("testdata", "fixtures", key, fileName(v))); err != nil {
+				t.Errorf("%s v%d has no recorded fixture in testdata/fixtures", key, v)
+			}
+		}
+	}
+}
+
+// A schema version is immutable once published: consumers in the field
+// validate against the copy they were built with. Change the schema by
+// adding a new version instead.
+func TestPublishedSchemasAreUnchanged(t *testing.T) {
+	for _, key := range routingKeys(t) {
+		for _, v := range Versions(key) {
+			current, err := os.ReadFile(filepath.Join("schemas", key, fileName(v)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			published, err := os.ReadFile(filepath.Join("testdata", "published", key, fileName(v)))
+			if err != nil {
+				t.Errorf("%s v%d: no published copy in testdata/published; add one when releasing a version", key, v)
+				continue
+			}
+			if !bytes.Equal(current, published) {
+				t.Errorf("%s v%d was edited after publication; add v%d instead", key, v, v+1)
+			}
+		}
+	}
+}
+
+// Producers upgrade before consumers, so a payload of version n must still
+// be accepted by consumers that only know versions below n. That means a
+// new version may only add optional fields.
+func TestNewVersionsAreReadableByOldConsumers(t *testing.T) {
+	for _, key := range routingKeys(t) {
+		versions := Versions(key)
+		for _, v := range versions {
+			fixture := readFixture(t, key, v)
+			for _, older := range versions {
+				if older > v {
+					break
+				}
+				if err := Validate(key, older, fixture); err != nil {
+					t.Errorf("%s v%d payload breaks v%d consumers: %v", key, v, older, err)
+				}
+			}
+		}
+	}
+}
+
+// Consumers decode every recorded version into the current Go type.
+func TestFixturesUpcastToLatest(t *testing.T) {
+	for _, p := range latestPayloads {
+		key := p.RoutingKey()
+		for _, v := range Versions(key) {
+			data, latest, err := Upcast(key, v, readFixture(t, key, v))
+			if err != nil {
+				t.Errorf("%s v%d: %v", key, v, err)
+				continue
+			}
+			if latest != p.SchemaVersion() {
+				t.Errorf("%s: upcast to v%d but the Go type is v%d", key, latest, p.SchemaVersion())
+			}
+			if err := json.Unmarshal(data, newOf(p)); err != nil {
+				t.Errorf("%s v%d does not decode into %T: %v", key, v, p, err)
+			}
+		}
+	}
+}
+
+func TestGoTypesMatchTheirSchema(t *testing.T) {
+	for _, p := range latestPayloads {
+		if _, err := NewEnvelope("/test", p); err != nil {
+			t.Errorf("%T: %v", p, err)
+		}
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	event := UserRegisteredEvent{UserID: uuid.New(), Email: "a@example.com", Username: "a", RegisteredAt: time.Now()}
+	env, err := NewEnvelope("/user-service", event)
+	if err != nil {
+		t.Fatalf("NewEnvelope: %v", err)
+	}
+	if env.Type != "com.filmnesia.user.registered" || env.DataSchema != "/schemas/user.registered/v2.json" || env.ID == "" {
+		t.Errorf("unexpected envelope: %+v", env)
+	}
+
+	body, _ := json.Marshal(env)
+	decoded, err := Decode(body, ContentType, "")
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	var got UserRegisteredEvent
+	if err := decoded.DecodeData(&got); err != nil || got.UserID != event.UserID {
+		t.Errorf("DecodeData = %+v, %v", got, err)
+	}
+
+	legacy := readFixture(t, EventUserRegistered, 1)
+	decoded, err = Decode(legacy, "application/json", EventUserRegistered)
+	if err != nil {
+		t.Fatalf("Decode(legacy): %v", err)
+	}
+	if decoded.RoutingKey() != EventUserRegistered || decoded.DataSchema != DataSchemaURI(EventUserRegistered, 2) {
+		t.Errorf("legacy body decoded as %s %s", decoded.Type, decoded.DataSchema)
+	}
+}
+
+func TestInvalidPayloadsAreRejected(t *testing.T) {
+	_, err := NewEnvelope("/test", UserRegisteredEvent{Email: "not-an-email", Username: "a"})
+	if !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("producer: want ErrInvalidPayload, got %v", err)
+	}
+
+	_, err = Decode([]byte(`{"user_id":"x"}`), "application/json", EventUserRegistered)
+	if !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("consumer: want ErrInvalidPayload, got %v", err)
+	}
+
+	_, err = Decode([]byte(`{}`), "application/json", "film.rated")
+	if !errors.Is(err, ErrUnknownEvent) {
+		t.Errorf("unknown type: want ErrUnknownEvent, got %v", err)
+	}
+}
+
+func TestUpcasting(t *testing.T) {
+	const key = "test.versioned"
+	v1 := []byte(`{"type":"object","required":["name"],"properties":{"name":{"type":"string"}}}`)
+	v2 := []byte(`{"type":"object","required":["name","locale"],"properties":{"name":{"type":"string"},"locale":{"type":"string"}}}`)
+	if err := RegisterSchema(key, 1, v1); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterSchema(key, 2, v2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Upcast(key, 1, []byte(`{"name":"a"}`)); err == nil {
+		t.Error("upcast without an upcaster succeeded")
+	}
+
+	RegisterUpcaster(key, 1, func(data json.RawMessage) (json.RawMessage, error) {
+		var m map[string]any
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		m["locale"] = "id"
+		return json.Marshal(m)
+	})
+
+	env := Envelope{SpecVersion: SpecVersion, Type: TypePrefix + key, DataSchema: DataSchemaURI(key, 1), Data: []byte(`{"name":"a"}`)}
+	body, _ := json.Marshal(env)
+	decoded, err := Decode(body, ContentType, "")
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if decoded.DataSchema != DataSchemaURI(key, 2) || !bytes.Contains(decoded.Data, []byte(`"locale":"id"`)) {
+		t.Errorf("not upcast: %s %s", decoded.DataSchema, decoded.Data)
+	}
+}
+
+func fileName(version int) string {
+	return "v" + strconv.Itoa(version) + ".json"
+}
+
+func readFixture(t *testing.T, key string, version int) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "fixtures", key, fileName(version)))
+	if err != nil {
+		t.Fatalf("fixture %s v%d: %v", key, version, err)
+	}
+	return data
+}
+
+// newOf returns a pointer to a zero value of p's concrete type.
+func newOf(p Payload) any {
+	switch p.(type) {
+	case UserRegisteredEvent:
+		return &UserRegisteredEvent{}
+	case UserUpdatedEvent:
+		return &UserUpdatedEvent{}
+	case UserEmailChangedEvent:
+		return &UserEmailChangedEvent{}
+	case UserRoleChangedEvent:
+		return &UserRoleChangedEvent{}
+	case UserDeletedEvent:
+		return &UserDeletedEvent{}
+	case UserLoggedInEvent:
+		return &UserLoggedInEvent{}
+	}
+	return &map[string]any{}
+}
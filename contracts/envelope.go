@@ -0,0 +1,154 @@
+// Package contracts holds the event contracts shared by Filmnesia services:
+// a CloudEvents 1.0 envelope, versioned payload types and the JSON Schemas
+// they are validated against.
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SpecVersion = "1.0"
+
+	// ContentType marks a message body as a structured-mode CloudEvent.
+	ContentType = "application/cloudevents+json"
+
+	// TypePrefix turns a routing key into a CloudEvents type:
+	// user.registered becomes com.filmnesia.user.registered.
+	TypePrefix = "com.filmnesia."
+)
+
+var (
+	ErrUnknownEvent   = errors.New("unknown event type")
+	ErrUnknownVersion = errors.New("unknown schema version")
+	ErrInvalidPayload = errors.New("payload does not match its schema")
+)
+
+// Payload is an event body with a registered schema.
+type Payload interface {
+	// RoutingKey is the AMQP routing key and the suffix of the CloudEvents
+	// type, e.g. "user.registered".
+	RoutingKey() string
+	SchemaVersion() int
+}
+
+// Envelope is a CloudEvents 1.0 event in structured JSON mode. The schema
+// version lives in DataSchema so that Type stays stable across versions.
+type Envelope struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// DataSchemaURI is the dataschema of a routing key at a version; it is also
+// the path of the schema file within this module.
+func DataSchemaURI(routingKey string, version int) string {
+	return fmt.Sprintf("/schemas/%s/v%d.json", routingKey, version)
+}
+
+// NewEnvelope validates p against its schema and wraps it. Producers call
+// this before publishing so that an invalid event never leaves the service.
+func NewEnvelope(source string, p Payload) (Envelope, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return Envelope{}, err
+	}
+	if err := Validate(p.RoutingKey(), p.SchemaVersion(), data); err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		SpecVersion:     SpecVersion,
+		ID:              uuid.NewString(),
+		Source:          source,
+		Type:            TypePrefix + p.RoutingKey(),
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		DataSchema:      DataSchemaURI(p.RoutingKey(), p.SchemaVersion()),
+		Data:            data,
+	}, nil
+}
+
+// RoutingKey strips TypePrefix from the CloudEvents type.
+func (e Envelope) RoutingKey() string {
+	return strings.TrimPrefix(e.Type, TypePrefix)
+}
+
+// SchemaVersion parses the version out of DataSchema.
+func (e Envelope) SchemaVersion() (int, error) {
+	rest, ok := strings.CutPrefix(e.DataSchema, "/schemas/"+e.RoutingKey()+"/v")
+	if !ok {
+		return 0, fmt.Errorf("%w: dataschema %q", ErrUnknownVersion, e.DataSchema)
+	}
+	version, err := strconv.Atoi(strings.TrimSuffix(rest, ".json"))
+	if err != nil {
+		return 0, fmt.Errorf("%w: dataschema %q", ErrUnknownVersion, e.DataSchema)
+	}
+	return version, nil
+}
+
+// DecodeData unmarshals the payload into v.
+func (e Envelope) DecodeData(v any) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Decode reads a message body, validates it and upcasts its data to the
+// latest schema version of its type, so consumers only handle the newest
+// payload shape. Bodies that are not CloudEvents are treated as version 1
+// payloads published before the envelope existed; routingKey names their
+// type.
+func Decode(body []byte, contentType, routingKey string) (Envelope, error) {
+	var env Envelope
+	if contentType == ContentType || isEnvelope(body) {
+		if err := json.Unmarshal(body, &env); err != nil {
+			return Envelope{}, fmt.Errorf("decode cloudevent: %w", err)
+		}
+		if env.SpecVersion != SpecVersion {
+			return Envelope{}, fmt.Errorf("unsupported cloudevents specversion %q", env.SpecVersion)
+		}
+	} else {
+		env = Envelope{
+			SpecVersion:     SpecVersion,
+			Type:            TypePrefix + routingKey,
+			DataContentType: "application/json",
+			DataSchema:      DataSchemaURI(routingKey, 1),
+			Data:            body,
+		}
+	}
+
+	version, err := env.SchemaVersion()
+	if err != nil {
+		return Envelope{}, err
+	}
+	if err := Validate(env.RoutingKey(), version, env.Data); err != nil {
+		return Envelope{}, err
+	}
+
+	data, latest, err := Upcast(env.RoutingKey(), version, env.Data)
+	if err != nil {
+		return Envelope{}, err
+	}
+	env.Data = data
+	env.DataSchema = DataSchemaURI(env.RoutingKey(), latest)
+	return env, nil
+}
+
+func isEnvelope(body []byte) bool {
+	var probe struct {
+		SpecVersion string `json:"specversion"`
+	}
+	return json.Unmarshal(body, &probe) == nil && probe.SpecVersion != ""
+}
@@ -0,0 +1,8 @@
+module github.com/virhanali/filmnesia/contracts
+
+go 1.24.3
+
+require (
+	github.com/google/uuid v1.6.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+)
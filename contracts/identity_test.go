@@ -0,0 +1,52 @@
+package contracts
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestIdentityVerifier(t *testing.T) {
+	secret := []byte("gateway secret")
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	v := NewIdentityVerifier(secret, 0)
+	v.now = func() time.Time { return now }
+	id := Identity{UserID: uuid.New(), Username: "budi", Role: "admin"}
+
+	signed := func(secret []byte, at time.Time) http.Header {
+		h := http.Header{}
+		SetIdentity(h, id, secret, at)
+		return h
+	}
+	if got, err := v.Verify(signed(secret, now.Add(-30*time.Second))); err != nil || got != id {
+		t.Fatalf("Verify = %+v, %v", got, err)
+	}
+
+	if _, err := v.Verify(http.Header{}); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("no headers: %v", err)
+	}
+	tampered := signed(secret, now)
+	tampered.Set(UserRoleHeader, "superadmin")
+	for name, h := range map[string]http.Header{
+		"unsigned":     signed(nil, now),
+		"other secret": signed([]byte("other"), now),
+		"tampered":     tampered,
+		"too old":      signed(secret, now.Add(-2*time.Minute)),
+		"from ahead":   signed(secret, now.Add(2*time.Minute)),
+	} {
+		if _, err := v.Verify(h); !errors.Is(err, ErrInvalidIdentity) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	StripIdentity(tampered)
+	if len(tampered) != 0 {
+		t.Errorf("left after StripIdentity: %v", tampered)
+	}
+	if NewIdentityVerifier(nil, 0) != nil {
+		t.Error("a verifier without a secret")
+	}
+}
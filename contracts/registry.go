@@ -0,0 +1,195 @@
+package contracts
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed schemas
+var schemaFiles embed.FS
+
+// Upcaster rewrites data from one schema version to the next.
+type Upcaster func(data json.RawMessage) (json.RawMessage, error)
+
+type eventSpec struct {
+	schemas   map[int]*jsonschema.Schema
+	upcasters map[int]Upcaster // keyed by the version they upgrade from
+}
+
+var (
+	registryOnce sync.Once
+	registryErr  error
+
+	mu       sync.RWMutex
+	registry = map[string]*eventSpec{}
+)
+
+// loadSchemas compiles every schemas/<routing key>/v<n>.json file.
+func loadSchemas() {
+	registryOnce.Do(func() {
+		registryErr = fs.WalkDir(schemaFiles, "schemas", func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			routingKey := path.Base(path.Dir(p))
+			version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path.Base(p), "v"), ".json"))
+			if err != nil {
+				return fmt.Errorf("schema file %s is not named v<n>.json", p)
+			}
+			raw, err := schemaFiles.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			schema, err := compileSchema(DataSchemaURI(routingKey, version), raw)
+			if err != nil {
+				return fmt.Errorf("compile %s: %w", p, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			spec := specFor(routingKey)
+			spec.schemas[version] = schema
+			return nil
+		})
+	})
+}
+
+func compileSchema(uri string, raw []byte) (*jsonschema.Schema, error) {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.AssertFormat = true
+	url := "mem://filmnesia" + uri
+	if err := c.AddResource(url, bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return c.Compile(url)
+}
+
+// specFor must be called with mu held.
+func specFor(routingKey string) *eventSpec {
+	spec, ok := registry[routingKey]
+	if !ok {
+		spec = &eventSpec{schemas: map[int]*jsonschema.Schema{}, upcasters: map[int]Upcaster{}}
+		registry[routingKey] = spec
+	}
+	return spec
+}
+
+func lookup(routingKey string) (*eventSpec, error) {
+	loadSchemas()
+	if registryErr != nil {
+		return nil, registryErr
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	spec, ok := registry[routingKey]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, routingKey)
+	}
+	return spec, nil
+}
+
+// RegisterUpcaster installs fn to turn version from of routingKey into
+// version from+1. Both versions must have a schema file.
+func RegisterUpcaster(routingKey string, from int, fn Upcaster) {
+	loadSchemas()
+	mu.Lock()
+	defer mu.Unlock()
+	specFor(routingKey).upcasters[from] = fn
+}
+
+// RegisterSchema adds a schema that is not shipped in schemas/. It exists
+// for tests that exercise versioning without a real second version.
+func RegisterSchema(routingKey string, version int, raw []byte) error {
+	loadSchemas()
+	schema, err := compileSchema(DataSchemaURI(routingKey, version), raw)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	specFor(routingKey).schemas[version] = schema
+	return nil
+}
+
+// Versions lists the schema versions known for routingKey, oldest first.
+func Versions(routingKey string) []int {
+	spec, err := lookup(routingKey)
+	if err != nil {
+		return nil
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	versions := make([]int, 0, len(spec.schemas))
+	for v := range spec.schemas {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// Validate checks data against the schema for routingKey at version.
+func Validate(routingKey string, version int, data []byte) error {
+	spec, err := lookup(routingKey)
+	if err != nil {
+		return err
+	}
+	mu.RLock()
+	schema, ok := spec.schemas[version]
+	mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s v%d", ErrUnknownVersion, routingKey, version)
+	}
+
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if err := schema.Validate(doc); err != nil {
+		return fmt.Errorf("%w: %s v%d: %v", ErrInvalidPayload, routingKey, version, err)
+	}
+	return nil
+}
+
+// Upcast applies upcasters until data is at the latest version of
+// routingKey, validating every intermediate result.
+func Upcast(routingKey string, version int, data json.RawMessage) (json.RawMessage, int, error) {
+	versions := Versions(routingKey)
+	if len(versions) == 0 {
+		return nil, 0, fmt.Errorf("%w: %s", ErrUnknownEvent, routingKey)
+	}
+	latest := versions[len(versions)-1]
+
+	spec, _ := lookup(routingKey)
+	for version < latest {
+		mu.RLock()
+		up, ok := spec.upcasters[version]
+		mu.RUnlock()
+		if !ok {
+			return nil, 0, fmt.Errorf("no upcaster for %s v%d -> v%d", routingKey, version, version+1)
+		}
+		next, err := up(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("upcast %s v%d: %w", routingKey, version, err)
+		}
+		version++
+		if err := Validate(routingKey, version, next); err != nil {
+			return nil, 0, err
+		}
+		data = next
+	}
+	return data, version, nil
+}
@@ -0,0 +1,142 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Routing keys on the user_events exchange, one per event type.
+const (
+	EventUserRegistered   = "user.registered"
+	EventUserUpdated      = "user.updated"
+	EventUserEmailChanged = "user.email_changed"
+	EventUserRoleChanged  = "user.role_changed"
+	EventUserDeleted      = "user.deleted"
+	EventUserLoggedIn     = "user.logged_in"
+)
+
+// Locales the product ships translations for. Events carry the user's
+// choice; consumers fall back to DefaultLocale for anything else.
+const (
+	LocaleIndonesian = "id"
+	LocaleEnglish    = "en"
+	DefaultLocale    = LocaleIndonesian
+)
+
+func init() {
+	// v2 of these events only adds the optional locale; v1 data is valid
+	// v2 data as it is.
+	RegisterUpcaster(EventUserRegistered, 1, unchanged)
+	RegisterUpcaster(EventUserUpdated, 1, unchanged)
+}
+
+func unchanged(data json.RawMessage) (json.RawMessage, error) { return data, nil }
+
+// The types below are the latest version of each user event. When a schema
+// gets a new version, the old struct is kept as <Name>V<n>, a schema file
+// is added under schemas/ and an upcaster to the new version is registered
+// with RegisterUpcaster.
+
+type UserRegisteredEvent struct {
+	UserID       uuid.UUID `json:"user_id"`
+	Email        string    `json:"email"`
+	Username     string    `json:"username"`
+	RegisteredAt time.Time `json:"registered_at"`
+	Locale       string    `json:"locale,omitempty"`
+}
+
+func (UserRegisteredEvent) RoutingKey() string { return EventUserRegistered }
+func (UserRegisteredEvent) SchemaVersion() int { return 2 }
+
+// IdempotencyKey is per user: a user registers at most once, so consumers
+// must not act on a second registered event for the same ID.
+func (e UserRegisteredEvent) IdempotencyKey() string {
+	return EventUserRegistered + ":" + e.UserID.String()
+}
+
+// UserUpdatedEvent is emitted for every successful profile update, in
+// addition to the more specific email event.
+type UserUpdatedEvent struct {
+	UserID           uuid.UUID `json:"user_id"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	PreviousUsername string    `json:"previous_username,omitempty"`
+	PreviousEmail    string    `json:"previous_email,omitempty"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Locale           string    `json:"locale,omitempty"`
+}
+
+func (UserUpdatedEvent) RoutingKey() string { return EventUserUpdated }
+func (UserUpdatedEvent) SchemaVersion() int { return 2 }
+
+func (e UserUpdatedEvent) IdempotencyKey() string {
+	return changeKey(EventUserUpdated, e.UserID, e.UpdatedAt)
+}
+
+type UserEmailChangedEvent struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	OldEmail  string    `json:"old_email"`
+	NewEmail  string    `json:"new_email"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+func (UserEmailChangedEvent) RoutingKey() string { return EventUserEmailChanged }
+func (UserEmailChangedEvent) SchemaVersion() int { return 1 }
+
+func (e UserEmailChangedEvent) IdempotencyKey() string {
+	return changeKey(EventUserEmailChanged, e.UserID, e.ChangedAt)
+}
+
+// UserRoleChangedEvent is reserved for a role-change flow; user-service
+// has no API that changes roles, so nothing publishes it yet.
+type UserRoleChangedEvent struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	OldRole   string    `json:"old_role"`
+	NewRole   string    `json:"new_role"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+func (UserRoleChangedEvent) RoutingKey() string { return EventUserRoleChanged }
+func (UserRoleChangedEvent) SchemaVersion() int { return 1 }
+
+func (e UserRoleChangedEvent) IdempotencyKey() string {
+	return changeKey(EventUserRoleChanged, e.UserID, e.ChangedAt)
+}
+
+type UserDeletedEvent struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func (UserDeletedEvent) RoutingKey() string { return EventUserDeleted }
+func (UserDeletedEvent) SchemaVersion() int { return 1 }
+
+func (e UserDeletedEvent) IdempotencyKey() string {
+	return changeKey(EventUserDeleted, e.UserID, e.DeletedAt)
+}
+
+type UserLoggedInEvent struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Username   string    `json:"username"`
+	LoggedInAt time.Time `json:"logged_in_at"`
+}
+
+func (UserLoggedInEvent) RoutingKey() string { return EventUserLoggedIn }
+func (UserLoggedInEvent) SchemaVersion() int { return 1 }
+
+func (e UserLoggedInEvent) IdempotencyKey() string {
+	return changeKey(EventUserLoggedIn, e.UserID, e.LoggedInAt)
+}
+
+// changeKey identifies one occurrence of a repeatable event by its user and
+// timestamp.
+func changeKey(eventType string, userID uuid.UUID, at time.Time) string {
+	return fmt.Sprintf("%s:%s:%d", eventType, userID, at.UnixNano())
+}
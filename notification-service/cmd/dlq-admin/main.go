@@ -0,0 +1,82 @@
+// Command dlq-admin inspects, requeues or purges the parking lot of a
+// notification work queue.
+//
+//	go run ./cmd/dlq-admin -queue user.registered.notifications.queue inspect
+//	go run ./cmd/dlq-admin -queue user.registered.notifications.queue -limit 10 requeue
+//	go run ./cmd/dlq-admin -queue user.registered.notifications.queue purge
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/config"
+	"github.com/virhanali/filmnesia/notification-service/internal/consumer"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func main() {
+	configPath := flag.String("config", ".", "directory containing the service .env file")
+	queue := flag.String("queue", "", "work queue whose parking lot to operate on")
+	limit := flag.Int("limit", 20, "maximum number of messages to inspect or requeue")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] inspect|requeue|purge\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *queue == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("FATAL: Failed to load configuration: %v", err)
+	}
+
+	conn, err := amqp.Dial(cfg.RabbitMQURL)
+	if err != nil {
+		log.Fatalf("FATAL: Failed to connect to RabbitMQ: %v", err)
+	}
+	defer conn.Close()
+
+	admin, err := consumer.NewDeadLetterAdmin(conn)
+	if err != nil {
+		log.Fatalf("FATAL: Failed to open a channel: %v", err)
+	}
+	defer admin.Close()
+
+	parkingLot := consumer.ParkingLotQueue(*queue)
+	switch flag.Arg(0) {
+	case "inspect":
+		msgs, err := admin.Inspect(*queue, *limit)
+		if err != nil {
+			log.Fatalf("FATAL: Failed to inspect '%s': %v", parkingLot, err)
+		}
+		for i, m := range msgs {
+			fmt.Printf("#%d routing_key=%s reason=%s retries=%d published=%s\n  %s\n",
+				i+1, m.RoutingKey, m.Reason, m.Retries, m.Timestamp.Format(time.RFC3339), m.Body)
+		}
+		fmt.Printf("%d message(s) shown from '%s'.\n", len(msgs), parkingLot)
+	case "requeue":
+		n, err := admin.Requeue(*queue, *limit)
+		if err != nil {
+			log.Fatalf("FATAL: Requeue from '%s' stopped after %d message(s): %v", parkingLot, n, err)
+		}
+		fmt.Printf("%d message(s) moved from '%s' back to '%s'.\n", n, parkingLot, *queue)
+	case "purge":
+		n, err := admin.Purge(*queue)
+		if err != nil {
+			log.Fatalf("FATAL: Failed to purge '%s': %v", parkingLot, err)
+		}
+		fmt.Printf("%d message(s) purged from '%s'.\n", n, parkingLot)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
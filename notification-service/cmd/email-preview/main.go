@@ -0,0 +1,85 @@
+// Command email-preview renders an email template with sample data, to
+// check a template change without sending anything.
+//
+//	go run ./cmd/email-preview -list
+//	go run ./cmd/email-preview -template user.registered -format html > welcome.html
+//	go run ./cmd/email-preview -template user.registered -locale en
+//	go run ./cmd/email-preview -template user.registered -data event.json -format eml
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/email"
+	"github.com/virhanali/filmnesia/notification-service/internal/handler"
+)
+
+func main() {
+	name := flag.String("template", "", "template to render, e.g. user.registered")
+	format := flag.String("format", "text", "output: text, html or eml (the full MIME message)")
+	dataFile := flag.String("data", "", "JSON file with the event to render instead of the built-in sample")
+	to := flag.String("to", "budi@example.com", "recipient the email is rendered for")
+	locale := flag.String("locale", contracts.DefaultLocale, "locale to render in, e.g. id or en")
+	list := flag.Bool("list", false, "list the available templates")
+	flag.Parse()
+
+	renderer, err := email.NewRenderer(contracts.DefaultLocale)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	if *list {
+		for _, n := range renderer.Names() {
+			fmt.Println(n)
+		}
+		return
+	}
+	if *name == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, ok := handler.Samples()[*name]
+	if !ok {
+		log.Fatalf("FATAL: no sample data for template %q", *name)
+	}
+	if *dataFile != "" {
+		raw, err := os.ReadFile(*dataFile)
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		// Decode into the sample's type so the template sees the same
+		// fields as in production.
+		ptr := reflect.New(reflect.TypeOf(data))
+		if err := json.Unmarshal(raw, ptr.Interface()); err != nil {
+			log.Fatalf("FATAL: decode %s: %v", *dataFile, err)
+		}
+		data = ptr.Elem().Interface()
+	}
+
+	mailer := email.NewMailer(renderer, email.LogSender{}, "Filmnesia <no-reply@filmnesia.com>")
+	msg, err := mailer.Compose(email.Mail{Template: *name, Locale: *locale, To: *to, Data: data})
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	switch *format {
+	case "text":
+		fmt.Printf("Subject: %s\n\n%s", msg.Subject, msg.Text)
+	case "html":
+		fmt.Print(msg.HTML)
+	case "eml":
+		raw, err := msg.Bytes()
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		os.Stdout.Write(raw)
+	default:
+		log.Fatalf("FATAL: unknown format %q", *format)
+	}
+}
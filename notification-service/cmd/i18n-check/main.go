@@ -0,0 +1,50 @@
+// Command i18n-check compares the email translation catalogs with the
+// keys the templates use and reports, per locale, missing translations,
+// unused keys and plural messages lacking a form the locale needs.
+//
+//	go run ./cmd/i18n-check
+//	go run ./cmd/i18n-check -strict
+//
+// It exits 1 when the default locale misses a key, since rendering would
+// fail (the service refuses to start in that case too), or with -strict on
+// any finding.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/email"
+)
+
+func main() {
+	defaultLocale := flag.String("default-locale", contracts.DefaultLocale, "locale every other locale falls back to")
+	strict := flag.Bool("strict", false, "fail on any finding, not only on keys missing from the default locale")
+	flag.Parse()
+
+	renderer, err := email.NewRenderer(*defaultLocale)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	catalog := renderer.Catalog()
+	report := catalog.Check(renderer.Usages())
+
+	if len(report.Problems) == 0 {
+		fmt.Printf("OK: %d templates, locales %v, no findings\n", len(renderer.Names()), catalog.Locales())
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LOCALE\tKIND\tKEY\tDETAIL")
+	for _, p := range report.Problems {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Locale, p.Kind, p.Key, p.Detail)
+	}
+	w.Flush()
+
+	if report.Fatal() || (*strict && len(report.Problems) > 0) {
+		os.Exit(1)
+	}
+}
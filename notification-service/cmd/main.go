@@ -2,19 +2,37 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/virhanali/filmnesia/contracts"
 	"github.com/virhanali/filmnesia/notification-service/internal/config"
 	"github.com/virhanali/filmnesia/notification-service/internal/consumer"
-)
-
-const (
-	UserEventsExchange       = "user_events"
-	UserRegisteredQueue      = "user.registered.notifications.queue"
-	UserRegisteredRoutingKey = "user.registered"
+	"github.com/virhanali/filmnesia/notification-service/internal/database"
+	deliveryhttp "github.com/virhanali/filmnesia/notification-service/internal/delivery/http"
+	"github.com/virhanali/filmnesia/notification-service/internal/deliverylog"
+	"github.com/virhanali/filmnesia/notification-service/internal/digest"
+	"github.com/virhanali/filmnesia/notification-service/internal/email"
+	"github.com/virhanali/filmnesia/notification-service/internal/handler"
+	"github.com/virhanali/filmnesia/notification-service/internal/idempotency"
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+	"github.com/virhanali/filmnesia/notification-service/internal/notify"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+	"github.com/virhanali/filmnesia/notification-service/internal/profile"
+	"github.com/virhanali/filmnesia/notification-service/internal/ratelimit"
+	"github.com/virhanali/filmnesia/notification-service/internal/schedule"
+	"github.com/virhanali/filmnesia/notification-service/internal/stream"
+	"github.com/virhanali/filmnesia/notification-service/internal/webhook"
 )
 
 func main() {
@@ -27,31 +45,187 @@ func main() {
 		log.Fatal("FATAL: RABBITMQ_URL is not set in configuration.")
 	}
 
-	mqConsumer, err := consumer.NewRabbitMQConsumer(cfg)
+	topology, err := config.LoadTopology(cfg.TopologyFile)
 	if err != nil {
-		log.Fatalf("FATAL: Failed to initialize RabbitMQ consumer: %v", err)
+		log.Fatalf("FATAL: Failed to load topology: %v", err)
 	}
+	mailer, err := newMailer(cfg)
+	if err != nil {
+		log.Fatalf("FATAL: Failed to set up email delivery: %v", err)
+	}
+	defer mailer.Close()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	err = mqConsumer.SetupAndConsumeUserRegisteredEvents(
-		ctx,
-		UserEventsExchange,
-		UserRegisteredQueue,
-		UserRegisteredRoutingKey,
-	)
-	if err != nil {
-		log.Fatalf("FATAL: Failed to setup or start consuming user registered events: %v", err)
-	}
-	err = mqConsumer.SetupAndConsumeUserRegisteredEvents(
-		ctx,
-		UserEventsExchange,
-		UserRegisteredQueue,
-		UserRegisteredRoutingKey,
-	)
+	var dedupStore idempotency.Store
+	var profiles profile.Store
+	var preferences preference.Store
+	var schedules preference.ScheduleStore
+	var jobs schedule.Store
+	var digests digest.Store
+	var notifications inbox.Store
+	var webhooks webhook.Store
+	var deliveries deliverylog.Store
+	var pool *pgxpool.Pool
+	if cfg.DatabaseURL != "" {
+		var errDB error
+		pool, errDB = database.NewPostgresPool(ctx, cfg.DatabaseURL)
+		if errDB != nil {
+			log.Fatalf("FATAL: Failed to connect to the notification database: %v", errDB)
+		}
+		defer pool.Close()
+		dedupStore = idempotency.NewPostgresStore(pool)
+		profiles = profile.NewPostgresStore(pool)
+		prefStore := preference.NewPostgresStore(pool)
+		preferences, schedules = prefStore, prefStore
+		jobs = schedule.NewPostgresStore(pool)
+		digests = digest.NewPostgresStore(pool)
+		notifications = inbox.NewPostgresStore(pool)
+		webhooks = webhook.NewPostgresStore(pool)
+		deliveries = deliverylog.NewPostgresStore(pool)
+	} else {
+		log.Println("WARNING: NOTIFICATION_DATABASE_URL is not set; processed events, user profiles, preferences, inboxes, webhooks, pending digests and the delivery log are only remembered until restart.")
+		dedupStore = idempotency.NewMemoryStore()
+		profiles = profile.NewMemoryStore()
+		prefStore := preference.NewMemoryStore()
+		preferences, schedules = prefStore, prefStore
+		jobs = schedule.NewMemoryStore()
+		digests = digest.NewMemoryStore()
+		notifications = inbox.NewMemoryStore()
+		webhooks = webhook.NewMemoryStore()
+		deliveries = deliverylog.NewMemoryStore()
+	}
+
+	hub := stream.NewHub(cfg.StreamMaxConnections)
+	relay := consumer.NewStreamRelay(cfg, hub)
+	if err := relay.Start(ctx); err != nil {
+		log.Fatalf("FATAL: Failed to start the notification stream relay: %v", err)
+	}
+	defer relay.Close()
+
+	secret := unsubscribeSecret(cfg)
+	signer := preference.NewSigner(secret)
+	scheduler := schedule.New(jobs, schedule.Config{
+		PollInterval: cfg.SchedulerPollInterval,
+		Concurrency:  cfg.SchedulerConcurrency,
+	})
+	dispatcher := notify.NewDispatcher(notify.Config{
+		Mailer:        mailer,
+		Inbox:         notifications,
+		Preferences:   preferences,
+		Signer:        signer,
+		Stream:        relay,
+		PublicURL:     cfg.PublicURL,
+		DeliveryLog:   deliveries,
+		RecipientKey:  recipientHashKey(cfg, secret),
+		Profiles:      profiles,
+		RateLimits:    rateLimits(cfg),
+		Scheduler:     scheduler,
+		Schedules:     schedules,
+		Digests:       digests,
+		TimeZone:      cfg.TimeZone,
+		DigestHour:    cfg.DigestHour,
+		DigestWeekday: cfg.DigestWeekday,
+	})
+	dispatcher.RegisterJobs()
+	deliverylog.StartCleanup(ctx, deliveries, cfg.DeliveryLogRetention, time.Hour)
+	schedulerCtx, stopScheduler := context.WithCancel(ctx)
+	defer stopScheduler()
+	scheduler.Start(schedulerCtx)
+
+	webhookWorker := webhook.NewWorker(webhooks, webhook.Config{
+		MaxAttempts:  cfg.WebhookMaxAttempts,
+		RetryMin:     cfg.WebhookRetryMin,
+		RetryMax:     cfg.WebhookRetryMax,
+		Timeout:      cfg.WebhookTimeout,
+		DisableAfter: cfg.WebhookDisableAfter,
+		AllowPrivate: cfg.WebhookAllowPrivate,
+	})
+	webhookCtx, stopWebhooks := context.WithCancel(ctx)
+	defer stopWebhooks()
+	webhookWorker.Start(webhookCtx)
+
+	registry := consumer.NewRegistry()
+	registry.MustRegister(handler.Routes(handler.Deps{Dispatcher: dispatcher, Profiles: profiles, Webhooks: webhookWorker})...)
+	if err := registry.ApplyTopology(topology); err != nil {
+		log.Fatalf("FATAL: Invalid topology: %v", err)
+	}
+	// The lease must outlive the slowest handler run, or a slow handler's
+	// message could be claimed a second time while it is still being
+	// processed.
+	dedup := idempotency.NewDeduplicator(dedupStore, 2*registry.LongestTimeout(cfg.HandlerTimeout))
+	dedup.StartCleanup(ctx, cfg.DedupTTL, cfg.DedupCleanupInterval)
+
+	mqConsumer, err := consumer.NewRabbitMQConsumer(cfg, dedup)
 	if err != nil {
-		log.Fatalf("FATAL: Failed to setup or start consuming user registered events: %v", err)
+		log.Fatalf("FATAL: Failed to initialize RabbitMQ consumer: %v", err)
+	}
+
+	if err := mqConsumer.Start(ctx, registry); err != nil {
+		log.Fatalf("FATAL: Failed to start notification handlers: %v", err)
+	}
+
+	// Stream requests carry the access token in the query string.
+	router := gin.New()
+	router.Use(gin.LoggerWithConfig(gin.LoggerConfig{SkipPaths: deliveryhttp.StreamPaths}), gin.Recovery())
+	// The gateway's health checks use /health/live: the API keeps working
+	// while the broker is down, and only the event pipeline is degraded.
+	router.GET("/health/live", func(c *gin.Context) {
+		if pool != nil {
+			if errDB := pool.Ping(c.Request.Context()); errDB != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "db_error": errDB.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
+	})
+	router.GET("/health", func(c *gin.Context) {
+		status := mqConsumer.Health()
+		code := http.StatusOK
+		if status.State != consumer.StateHealthy {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, gin.H{"status": status.State, "rabbitmq": status})
+	})
+	router.GET("/health/dedup/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"persistent": cfg.DatabaseURL != "", "stats": dedup.Stats()})
+	})
+	router.GET("/health/dispatch/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, dispatcher.Stats())
+	})
+	router.GET("/health/stream/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, hub.Stats())
+	})
+	router.GET("/health/webhooks/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, webhookWorker.Stats())
+	})
+	router.GET("/health/schedule/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, scheduler.Stats())
+	})
+	router.GET("/health/ratelimit/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, dispatcher.RateLimitStats())
+	})
+	if cfg.JWTSecretKey != "" {
+		identity := contracts.NewIdentityVerifier([]byte(cfg.GatewayIdentitySecret), contracts.DefaultIdentityMaxAge)
+		auth := deliveryhttp.AuthMiddleware(cfg.JWTSecretKey, identity)
+		deliveryhttp.NewPreferenceHandler(preferences, schedules, signer).RegisterRoutes(router, auth)
+		deliveryhttp.NewInboxHandler(notifications).RegisterRoutes(router, auth)
+		deliveryhttp.NewStreamHandler(hub, notifications, cfg.StreamHeartbeat).RegisterRoutes(router, auth)
+		deliveryhttp.NewWebhookHandler(webhooks, webhookWorker).RegisterRoutes(router, auth)
+		deliveryhttp.NewDeliveryLogHandler(deliveries, dispatcher).RegisterRoutes(router, auth)
+	}
+
+	srv := &http.Server{
+		Addr:    ":" + cfg.ServicePort,
+		Handler: router,
 	}
+	go func() {
+		log.Printf("INFO: Notification Service health endpoint listening on port %s", cfg.ServicePort)
+		if errSrv := srv.ListenAndServe(); errSrv != nil && !errors.Is(errSrv, http.ErrServerClosed) {
+			log.Fatalf("FATAL: Notification Service HTTP server error: %v", errSrv)
+		}
+	}()
 
 	log.Println("Notification Service is running and waiting for messages...")
 	log.Println("Press CTRL+C to exit.")
@@ -61,7 +235,100 @@ func main() {
 
 	log.Println("INFO: Notification Service shutting down...")
 
+	// Open streams would otherwise hold up the HTTP server until the
+	// timeout; clients reconnect to another replica and resume.
+	hub.Close()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if errShut := srv.Shutdown(shutdownCtx); errShut != nil {
+		log.Printf("ERROR: Notification Service HTTP server failed to shutdown gracefully: %v", errShut)
+	}
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer drainCancel()
+	if errDrain := mqConsumer.Shutdown(drainCtx); errDrain != nil {
+		log.Printf("ERROR: %v", errDrain)
+	}
+	// Attempts cut short here are not recorded and run again later.
+	stopWebhooks()
+	stopScheduler()
+	webhookWorker.Wait()
+	scheduler.Wait()
 	cancel()
 
 	log.Println("INFO: Notification Service shutdown complete.")
 }
+
+func newMailer(cfg config.Config) (*email.Mailer, error) {
+	renderer, err := email.NewRenderer(cfg.DefaultLocale)
+	if err != nil {
+		return nil, err
+	}
+
+	var sender email.Sender = email.LogSender{}
+	if cfg.SMTPHost != "" {
+		sender, err = email.NewSMTPSender(email.SMTPConfig{
+			Host:           cfg.SMTPHost,
+			Port:           cfg.SMTPPort,
+			Username:       cfg.SMTPUsername,
+			Password:       cfg.SMTPPassword,
+			TLS:            cfg.SMTPTLS,
+			Timeout:        cfg.SMTPTimeout,
+			MaxConnections: cfg.SMTPMaxConnections,
+			IdleTimeout:    cfg.SMTPIdleTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return email.NewMailer(renderer, sender, cfg.EmailFrom), nil
+}
+
+// rateLimits checks the channels the configuration limits, and gives
+// this replica its share of every limit.
+func rateLimits(cfg config.Config) notify.RateLimits {
+	n := cfg.RateLimitReplicas
+	limits := notify.RateLimits{
+		Recipient: cfg.RateLimitRecipient.Share(n),
+		Template:  cfg.RateLimitTemplate.Share(n),
+		Templates: map[string]ratelimit.Limit{},
+		Channels:  map[preference.Channel]ratelimit.Limit{},
+	}
+	for template, limit := range cfg.RateLimitTemplates {
+		limits.Templates[template] = limit.Share(n)
+	}
+	for name, limit := range cfg.RateLimitChannels {
+		channel := preference.Channel(name)
+		if !channel.Valid() {
+			log.Fatalf("FATAL: NOTIFICATION_RATE_LIMIT_CHANNELS: unknown channel %q", name)
+		}
+		limits.Channels[channel] = limit.Share(n)
+	}
+	return limits
+}
+
+// unsubscribeSecret returns the configured signing key, or a random one so
+// links still work until the next restart. config.LoadConfig only allows
+// the latter when emails are logged rather than sent.
+func unsubscribeSecret(cfg config.Config) []byte {
+	if cfg.UnsubscribeSecret != "" {
+		return []byte(cfg.UnsubscribeSecret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("FATAL: Failed to generate an unsubscribe secret: %v", err)
+	}
+	return secret
+}
+
+// recipientHashKey returns the configured delivery log hash key, or one
+// derived from the unsubscribe secret, so that the secret itself is only
+// ever used to sign links.
+func recipientHashKey(cfg config.Config, unsubscribeSecret []byte) []byte {
+	if cfg.RecipientHashKey != "" {
+		return []byte(cfg.RecipientHashKey)
+	}
+	mac := hmac.New(sha256.New, unsubscribeSecret)
+	mac.Write([]byte("delivery log recipient hash"))
+	return mac.Sum(nil)
+}
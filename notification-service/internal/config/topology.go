@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Topology is the broker layout the service consumes from, read from the
+// file named by NOTIFICATION_TOPOLOGY_FILE. Handlers declare their own
+// defaults in code; an entry under handlers overrides them by name.
+type Topology struct {
+	Exchanges []ExchangeConfig         `mapstructure:"exchanges"`
+	Handlers  map[string]HandlerConfig `mapstructure:"handlers"`
+}
+
+type ExchangeConfig struct {
+	Name string `mapstructure:"name"`
+	// Kind is the AMQP exchange type; handlers bind with routing-key
+	// patterns, so it defaults to "topic".
+	Kind string `mapstructure:"kind"`
+}
+
+// HandlerConfig overrides a handler's queue, bindings and worker settings.
+// Zero values keep the handler's defaults.
+type HandlerConfig struct {
+	Disabled    bool          `mapstructure:"disabled"`
+	Exchange    string        `mapstructure:"exchange"`
+	Queue       string        `mapstructure:"queue"`
+	Bindings    []string      `mapstructure:"bindings"`
+	Concurrency int           `mapstructure:"concurrency"`
+	Prefetch    int           `mapstructure:"prefetch"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
+// LoadTopology reads a YAML or JSON topology file. A missing file is not an
+// error: every handler then runs with its defaults.
+func LoadTopology(path string) (Topology, error) {
+	if path == "" {
+		return Topology{}, nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		log.Printf("Topology file '%s' not found. Using handler defaults.", path)
+		return Topology{}, nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return Topology{}, fmt.Errorf("read topology file '%s': %w", path, err)
+	}
+
+	var topology Topology
+	if err := v.Unmarshal(&topology); err != nil {
+		return Topology{}, fmt.Errorf("parse topology file '%s': %w", path, err)
+	}
+	for i, exchange := range topology.Exchanges {
+		if exchange.Name == "" {
+			return Topology{}, fmt.Errorf("topology file '%s': exchange #%d has no name", path, i+1)
+		}
+		if exchange.Kind == "" {
+			topology.Exchanges[i].Kind = "topic"
+		}
+	}
+
+	log.Printf("Successfully loaded topology from '%s' (%d exchange(s), %d handler override(s))",
+		path, len(topology.Exchanges), len(topology.Handlers))
+	return topology, nil
+}
@@ -0,0 +1,157 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeadLetteredMessage is a parking-lot message as shown by the admin tool.
+type DeadLetteredMessage struct {
+	RoutingKey string
+	Reason     string
+	Retries    int
+	Timestamp  time.Time
+	Headers    amqp.Table
+	Body       []byte
+}
+
+// DeadLetterAdmin inspects, requeues and purges the parking lot of a work
+// queue. It is meant for operators, not for the consumer itself.
+type DeadLetterAdmin struct {
+	ch *amqp.Channel
+}
+
+func NewDeadLetterAdmin(conn *amqp.Connection) (*DeadLetterAdmin, error) {
+	ch, err := openChannel(conn)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetterAdmin{ch: ch}, nil
+}
+
+// Inspect returns up to limit messages from the parking lot of queue
+// without removing them.
+func (a *DeadLetterAdmin) Inspect(queue string, limit int) ([]DeadLetteredMessage, error) {
+	var (
+		out     []DeadLetteredMessage
+		lastTag uint64
+	)
+	for len(out) < limit {
+		d, ok, err := a.ch.Get(ParkingLotQueue(queue), false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		lastTag = d.DeliveryTag
+		out = append(out, DeadLetteredMessage{
+			RoutingKey: d.RoutingKey,
+			Reason:     deathReason(d.Headers),
+			Retries:    retryCount(d.Headers),
+			Timestamp:  d.Timestamp,
+			Headers:    d.Headers,
+			Body:       d.Body,
+		})
+	}
+	if lastTag != 0 {
+		if err := a.ch.Nack(lastTag, true, true); err != nil {
+			return nil, fmt.Errorf("return inspected messages to the parking lot: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// Requeue moves up to limit messages from the parking lot back onto queue
+// with a fresh retry budget. Each message is acked only after the broker
+// confirms the copy.
+func (a *DeadLetterAdmin) Requeue(queue string, limit int) (int, error) {
+	moved := 0
+	for moved < limit {
+		d, ok, err := a.ch.Get(ParkingLotQueue(queue), false)
+		if err != nil {
+			return moved, err
+		}
+		if !ok {
+			break
+		}
+
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		delete(headers, RetryCountHeader)
+		delete(headers, "x-death")
+		delete(headers, "x-first-death-exchange")
+		delete(headers, "x-first-death-queue")
+		delete(headers, "x-first-death-reason")
+		delete(headers, "x-last-death-exchange")
+		delete(headers, "x-last-death-queue")
+		delete(headers, "x-last-death-reason")
+
+		if err := a.publish(queue, d, headers); err != nil {
+			a.ch.Nack(d.DeliveryTag, false, true)
+			return moved, err
+		}
+		if err := d.Ack(false); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+func (a *DeadLetterAdmin) publish(queue string, d amqp.Delivery, headers amqp.Table) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	confirm, err := a.ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		Type:          d.Type,
+		Body:          d.Body,
+	})
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("broker nacked requeue to '%s'", queue)
+	}
+	return nil
+}
+
+// Purge deletes every message in the parking lot of queue.
+func (a *DeadLetterAdmin) Purge(queue string) (int, error) {
+	return a.ch.QueuePurge(ParkingLotQueue(queue), false)
+}
+
+func (a *DeadLetterAdmin) Close() error {
+	return a.ch.Close()
+}
+
+// deathReason reports why the broker dead-lettered a message: "rejected"
+// for a nack, "expired" for a TTL, and so on.
+func deathReason(headers amqp.Table) string {
+	if reason, ok := headers["x-first-death-reason"].(string); ok {
+		return reason
+	}
+	if deaths, ok := headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			if reason, ok := death["reason"].(string); ok {
+				return reason
+			}
+		}
+	}
+	return "unknown"
+}
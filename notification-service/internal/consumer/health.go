@@ -0,0 +1,58 @@
+package consumer
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	StateStarting = "starting"
+	StateHealthy  = "healthy"
+	StateDegraded = "degraded"
+	StateDraining = "draining"
+	StateStopped  = "stopped"
+)
+
+// HealthStatus describes the consumer's broker connection. The state is
+// "degraded" from the moment the connection or channel drops until
+// consumption has been re-established on every queue.
+type HealthStatus struct {
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	LastError  string    `json:"last_error,omitempty"`
+	Reconnects int       `json:"reconnects"`
+}
+
+type health struct {
+	mu     sync.RWMutex
+	status HealthStatus
+}
+
+func newHealth() *health {
+	return &health{status: HealthStatus{State: StateStarting, Since: time.Now()}}
+}
+
+func (h *health) set(state string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.status.State != state {
+		h.status.State = state
+		h.status.Since = time.Now()
+	}
+	if err != nil {
+		h.status.LastError = err.Error()
+	}
+}
+
+func (h *health) reconnected() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.Reconnects++
+}
+
+func (h *health) get() HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status
+}
@@ -2,48 +2,148 @@ package consumer
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
 
+	"github.com/virhanali/filmnesia/contracts"
 	"github.com/virhanali/filmnesia/notification-service/internal/config"
-	"github.com/virhanali/filmnesia/notification-service/internal/domain"
+	"github.com/virhanali/filmnesia/notification-service/internal/idempotency"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// RabbitMQConsumer consumes on a single channel that a supervisor goroutine
+// watches. When the connection or channel closes it reconnects with
+// jittered backoff, re-declares every route's topology and resumes
+// consuming; Health reports "degraded" in between.
+//
+// Each route's queue is served by its own pool of workers with a matching
+// prefetch, and every delivery is handled under the route's timeout.
 type RabbitMQConsumer struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	cfg     config.Config
+	cfg    config.Config
+	health *health
+	dedup  *idempotency.Deduplicator
+	policy retryPolicy
+
+	mu        sync.Mutex
+	conn      *amqp.Connection
+	channel   *amqp.Channel
+	registry  *Registry
+	routes    []Route
+	consumers []string
+	tagSeq    int
+	draining  bool
+	closed    bool
+
+	workers       sync.WaitGroup
+	superviseOnce sync.Once
 }
 
-func NewRabbitMQConsumer(cfg config.Config) (*RabbitMQConsumer, error) {
+func NewRabbitMQConsumer(cfg config.Config, dedup *idempotency.Deduplicator) (*RabbitMQConsumer, error) {
 	log.Printf("Attempting to connect to RabbitMQ at %s", cfg.RabbitMQURL)
-	conn, err := amqp.Dial(cfg.RabbitMQURL)
+	conn, ch, err := dial(cfg.RabbitMQURL)
 	if err != nil {
-		log.Printf("Failed to connect to RabbitMQ: %v", err)
 		return nil, err
 	}
+	log.Println("Successfully connected to RabbitMQ and opened a channel.")
 
-	ch, err := conn.Channel()
+	c := &RabbitMQConsumer{
+		cfg:     cfg,
+		health:  newHealth(),
+		dedup:   dedup,
+		policy:  retryPolicy{maxRetries: cfg.MaxRetries, delays: cfg.RetryDelays},
+		conn:    conn,
+		channel: ch,
+	}
+	c.health.set(StateHealthy, nil)
+	return c, nil
+}
+
+func dial(url string) (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		log.Printf("Failed to connect to RabbitMQ: %v", err)
+		return nil, nil, err
+	}
+
+	ch, err := openChannel(conn)
 	if err != nil {
 		log.Printf("Failed to open a channel: %v", err)
 		conn.Close()
+		return nil, nil, err
+	}
+	return conn, ch, nil
+}
+
+// openChannel opens a channel in confirm mode, which retries need in order
+// to republish safely before acking.
+func openChannel(conn *amqp.Connection) (*amqp.Channel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
 		return nil, err
 	}
-	log.Println("Successfully connected to RabbitMQ and opened a channel.")
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	return ch, nil
+}
 
-	return &RabbitMQConsumer{
-		conn:    conn,
-		channel: ch,
-		cfg:     cfg,
-	}, nil
+func (c *RabbitMQConsumer) Health() HealthStatus {
+	return c.health.get()
+}
+
+// Start declares the topology of every route in registry, starts their
+// workers and remembers the routes so that they are restored after a
+// reconnect. It also starts the supervisor, which runs until ctx is
+// cancelled; in-flight work is not interrupted by ctx, use Shutdown to
+// drain it. Start may only be called once.
+func (c *RabbitMQConsumer) Start(ctx context.Context, registry *Registry) error {
+	routes := registry.Routes()
+	if len(routes) == 0 {
+		return errors.New("no handlers registered")
+	}
+
+	c.mu.Lock()
+	if c.registry != nil {
+		c.mu.Unlock()
+		return errors.New("rabbitmq consumer is already started")
+	}
+	ch := c.channel
+	c.registry = registry
+	c.mu.Unlock()
+	if ch == nil {
+		return errors.New("rabbitmq consumer is closed")
+	}
+
+	for _, route := range routes {
+		if err := c.subscribe(ctx, ch, route); err != nil {
+			return fmt.Errorf("handler %q: %w", route.Name, err)
+		}
+		c.mu.Lock()
+		c.routes = append(c.routes, route)
+		c.mu.Unlock()
+	}
+
+	c.superviseOnce.Do(func() {
+		s := &supervisor{
+			session: c,
+			health:  c.health,
+			backoff: jitterBackoff{min: c.cfg.RabbitMQReconnectMinBackoff, max: c.cfg.RabbitMQReconnectMaxBackoff},
+		}
+		go s.run(ctx)
+	})
+	return nil
 }
 
-func (c *RabbitMQConsumer) SetupAndConsumeUserRegisteredEvents(ctx context.Context, exchangeName, queueName, routingKey string) error {
-	err := c.channel.ExchangeDeclare(
-		exchangeName,
-		"direct",
+func (c *RabbitMQConsumer) subscribe(ctx context.Context, ch *amqp.Channel, route Route) error {
+	kind := c.registry.ExchangeKind(route.Exchange)
+	route.exchangeKind = kind
+	err := ch.ExchangeDeclare(
+		route.Exchange,
+		kind,
 		true,
 		false,
 		false,
@@ -51,41 +151,67 @@ func (c *RabbitMQConsumer) SetupAndConsumeUserRegisteredEvents(ctx context.Conte
 		nil,
 	)
 	if err != nil {
-		log.Printf("Failed to declare exchange '%s': %v", exchangeName, err)
+		log.Printf("Failed to declare %s exchange '%s': %v", kind, route.Exchange, err)
 		return err
 	}
-	log.Printf("Exchange '%s' declared successfully or already exists.", exchangeName)
+	log.Printf("Exchange '%s' (%s) declared successfully or already exists.", route.Exchange, kind)
 
-	q, err := c.channel.QueueDeclare(
-		queueName,
+	if err := declareDeadLetterTopology(ch, route.Queue, c.cfg.RetryDelays); err != nil {
+		log.Printf("Failed to declare dead-letter topology for queue '%s': %v", route.Queue, err)
+		return err
+	}
+
+	q, err := ch.QueueDeclare(
+		route.Queue,
 		true,
 		false,
 		false,
 		false,
-		nil,
+		workQueueArgs(route.Queue),
 	)
 	if err != nil {
-		log.Printf("Failed to declare queue '%s': %v", queueName, err)
+		log.Printf("Failed to declare queue '%s': %v", route.Queue, err)
 		return err
 	}
 	log.Printf("Queue '%s' declared successfully or already exists.", q.Name)
 
-	err = c.channel.QueueBind(
-		q.Name,
-		routingKey,
-		exchangeName,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Printf("Failed to bind queue '%s' to exchange '%s' with routing key '%s': %v", q.Name, exchangeName, routingKey, err)
+	for _, pattern := range route.Bindings {
+		if err := ch.QueueBind(q.Name, pattern, route.Exchange, false, nil); err != nil {
+			log.Printf("Failed to bind queue '%s' to exchange '%s' with pattern '%s': %v", q.Name, route.Exchange, pattern, err)
+			return err
+		}
+		log.Printf("Queue '%s' bound to exchange '%s' with pattern '%s'.", q.Name, route.Exchange, pattern)
+	}
+
+	workers := route.Concurrency
+	if workers <= 0 {
+		workers = c.cfg.Concurrency(q.Name)
+	}
+	prefetch := route.Prefetch
+	if prefetch <= 0 {
+		prefetch = c.cfg.Prefetch
+	}
+	if prefetch <= 0 {
+		prefetch = 2 * workers
+	}
+	if prefetch < workers {
+		log.Printf("WARNING: Prefetch %d for queue '%s' is below its %d workers; some will sit idle.", prefetch, q.Name, workers)
+	}
+	// Qos applies to consumers started after it on this channel, so each
+	// queue gets its own limit.
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		log.Printf("Failed to set prefetch for queue '%s': %v", q.Name, err)
 		return err
 	}
-	log.Printf("Queue '%s' bound to exchange '%s' with routing key '%s'.", q.Name, exchangeName, routingKey)
 
-	msgs, err := c.channel.Consume(
+	c.mu.Lock()
+	c.tagSeq++
+	tag := fmt.Sprintf("notification-service.%s.%d", route.Name, c.tagSeq)
+	c.mu.Unlock()
+
+	msgs, err := ch.Consume(
 		q.Name,
-		"",
+		tag,
 		false,
 		false,
 		false,
@@ -97,48 +223,220 @@ func (c *RabbitMQConsumer) SetupAndConsumeUserRegisteredEvents(ctx context.Conte
 		return err
 	}
 
-	log.Printf("Waiting for messages on queue '%s'. To exit press CTRL+C", q.Name)
+	c.mu.Lock()
+	c.consumers = append(c.consumers, tag)
+	c.mu.Unlock()
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("Consumer context cancelled. Shutting down message processing.")
-				c.Close()
-				return
-			case d, ok := <-msgs:
-				if !ok {
-					log.Println("RabbitMQ messages channel closed. Consumer stopping.")
-					return
-				}
-
-				log.Printf("Received a message from queue '%s': %s", q.Name, d.Body)
-
-				var event domain.UserRegisteredEvent
-				if err := json.Unmarshal(d.Body, &event); err != nil {
-					log.Printf("ERROR: Failed to unmarshal message body: %v. Body: %s", err, d.Body)
-					if errNack := d.Nack(false, false); errNack != nil {
-						log.Printf("ERROR: Failed to Nack unparseable message: %v", errNack)
-					}
-					continue
-				}
-
-				log.Printf("INFO: Processing UserRegisteredEvent - Sending welcome email to UserID: %s, Email: %s, Username: %s",
-					event.UserID, event.Email, event.Username)
-
-				if errAck := d.Ack(false); errAck != nil {
-					log.Printf("ERROR: Failed to acknowledge message: %v", errAck)
-				} else {
-					log.Printf("Message acknowledged for UserID: %s", event.UserID)
-				}
+	// Handlers keep ctx's values but not its cancellation, so that stopping
+	// the service lets in-flight messages finish instead of failing them.
+	base := context.WithoutCancel(ctx)
+	c.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go c.work(base, confirmChannel{ch}, route, msgs)
+	}
+
+	log.Printf("Handler '%s' waiting for %v on queue '%s' with %d workers (prefetch %d).", route.Name, route.Bindings, q.Name, workers, prefetch)
+	return nil
+}
+
+// work handles deliveries until the delivery channel closes, which happens
+// when the consumer is cancelled by Shutdown or the AMQP channel dies. A
+// dead channel is not fatal: the supervisor notices the same closure and
+// starts new workers once it has reconnected.
+func (c *RabbitMQConsumer) work(base context.Context, pub republisher, route Route, msgs <-chan amqp.Delivery) {
+	defer c.workers.Done()
+
+	timeout := route.Timeout
+	if timeout <= 0 {
+		timeout = c.cfg.HandlerTimeout
+	}
+	for d := range msgs {
+		ctx, cancel := context.WithTimeout(base, timeout)
+		err := c.dedup.Run(ctx, route.Queue, idempotencyKey(d), func(ctx context.Context) error {
+			if err := dispatch(ctx, route, d); err != nil {
+				return err
 			}
+			return ctx.Err()
+		})
+		cancel()
+		c.policy.settle(pub, route.Queue, d, err)
+	}
+}
+
+// dispatch decodes d and hands it to the route's handler. Decode validates
+// the body against its schema and upcasts older versions; a message that
+// fails either will never succeed on retry.
+func dispatch(ctx context.Context, route Route, d amqp.Delivery) error {
+	if !route.accepts(d.RoutingKey) {
+		// A durable queue keeps bindings that were removed from the
+		// topology file until someone unbinds them on the broker.
+		return Permanent(fmt.Errorf("handler %q has no binding for routing key '%s'; remove the stale binding from queue '%s'",
+			route.Name, d.RoutingKey, route.Queue))
+	}
+
+	envelope, err := contracts.Decode(d.Body, d.ContentType, d.RoutingKey)
+	if err != nil {
+		// Bodies carry personal data such as email addresses, so only
+		// what identifies the message is logged.
+		log.Printf("ERROR: Handler '%s' rejecting invalid message %s (routing key '%s', %d bytes): %v",
+			route.Name, d.MessageId, d.RoutingKey, len(d.Body), err)
+		return Permanent(err)
+	}
+	return route.Handler(ctx, envelope)
+}
+
+// IdempotencyKeyHeader is set by producers to the key that identifies a
+// business event across re-publishes.
+const IdempotencyKeyHeader = "x-idempotency-key"
+
+// idempotencyKey prefers the producer's idempotency key and falls back to
+// the message ID, which still collapses redeliveries of one publish.
+func idempotencyKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[IdempotencyKeyHeader].(string); ok && key != "" {
+		return key
+	}
+	return d.MessageId
+}
+
+// watch implements session. It reports the first close of the current
+// connection or channel.
+func (c *RabbitMQConsumer) watch() (<-chan error, bool) {
+	c.mu.Lock()
+	conn, ch, stopping := c.conn, c.channel, c.closed || c.draining
+	c.mu.Unlock()
+	if stopping || conn == nil || ch == nil {
+		return nil, false
+	}
+
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	closed := make(chan error, 1)
+	go func() {
+		// Both notify channels are closed by the library on shutdown, so
+		// this goroutine always ends.
+		select {
+		case amqpErr := <-connClosed:
+			closed <- closeCause("connection", amqpErr)
+		case amqpErr := <-chClosed:
+			closed <- closeCause("channel", amqpErr)
 		}
 	}()
+	return closed, true
+}
+
+func closeCause(what string, amqpErr *amqp.Error) error {
+	if amqpErr == nil {
+		return fmt.Errorf("%s (closed)", what)
+	}
+	return fmt.Errorf("%s (%v)", what, amqpErr)
+}
+
+func (c *RabbitMQConsumer) stopping() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed || c.draining
+}
+
+// restore implements session: it reopens the channel, redialling first if
+// the connection itself is gone, and replays every route.
+func (c *RabbitMQConsumer) restore(ctx context.Context) error {
+	c.mu.Lock()
+	conn, oldCh, routes := c.conn, c.channel, append([]Route(nil), c.routes...)
+	c.consumers = nil
+	c.mu.Unlock()
 
+	var ch *amqp.Channel
+	var err error
+	if conn != nil && !conn.IsClosed() {
+		if oldCh != nil {
+			oldCh.Close()
+		}
+		ch, err = openChannel(conn)
+	} else {
+		conn, ch, err = dial(c.cfg.RabbitMQURL)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		if err = c.subscribe(ctx, ch, route); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		ch.Close()
+		c.mu.Lock()
+		c.conn = conn
+		c.mu.Unlock()
+		return fmt.Errorf("restore topology: %w", err)
+	}
+
+	c.mu.Lock()
+	if c.closed || c.draining {
+		c.mu.Unlock()
+		ch.Close()
+		conn.Close()
+		return errors.New("rabbitmq consumer is stopping")
+	}
+	c.conn, c.channel = conn, ch
+	c.mu.Unlock()
+
+	log.Printf("RabbitMQ consumer restored %d handler(s).", len(routes))
 	return nil
 }
 
+// Shutdown stops taking new deliveries, waits for the workers to finish
+// what they already received (bounded by ctx) and then closes the
+// connection. Deliveries that were prefetched but not finished in time are
+// requeued by the broker when the channel closes.
+func (c *RabbitMQConsumer) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed || c.draining {
+		c.mu.Unlock()
+		return nil
+	}
+	c.draining = true
+	ch, tags := c.channel, append([]string(nil), c.consumers...)
+	c.mu.Unlock()
+
+	log.Printf("Stopping %d consumer(s) and draining in-flight messages...", len(tags))
+	c.health.set(StateDraining, nil)
+	if ch != nil {
+		for _, tag := range tags {
+			if err := ch.Cancel(tag, false); err != nil {
+				log.Printf("Error cancelling consumer '%s': %v", tag, err)
+			}
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		c.workers.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		log.Println("All in-flight messages finished.")
+	case <-ctx.Done():
+		err = fmt.Errorf("drain notification workers: %w", ctx.Err())
+		log.Printf("WARNING: Gave up waiting for in-flight messages: %v", ctx.Err())
+	}
+
+	c.Close()
+	return err
+}
+
 func (c *RabbitMQConsumer) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+
 	log.Println("Closing RabbitMQ consumer channel and connection...")
 	if c.channel != nil {
 		if err := c.channel.Close(); err != nil {
@@ -156,4 +454,5 @@ func (c *RabbitMQConsumer) Close() {
 		}
 		c.conn = nil
 	}
+	c.health.set(StateStopped, nil)
 }
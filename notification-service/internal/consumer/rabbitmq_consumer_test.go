@@ -0,0 +1,161 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/config"
+	"github.com/virhanali/filmnesia/notification-service/internal/idempotency"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestShutdownDrainsInFlightWorkers(t *testing.T) {
+	c := &RabbitMQConsumer{
+		health: newHealth(),
+		dedup:  idempotency.NewDeduplicator(idempotency.NewMemoryStore(), time.Minute),
+		policy: retryPolicy{maxRetries: 3, delays: testDelays},
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	route := testRoute("welcome_email", "user.registered")
+	route.Queue = "notification-service.welcome_email"
+	route.Timeout = time.Minute
+	route.Handler = func(context.Context, contracts.Envelope) error {
+		close(started)
+		<-release
+		return nil
+	}
+
+	env, err := contracts.NewEnvelope("/user-service", contracts.UserRegisteredEvent{
+		UserID: uuid.New(), Username: "budi", Email: "budi@example.com", RegisteredAt: time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(env)
+	acker := &fakeAcker{}
+	msgs := make(chan amqp.Delivery, 1)
+	msgs <- amqp.Delivery{Acknowledger: acker, RoutingKey: "user.registered", ContentType: contracts.ContentType, MessageId: env.ID, Body: body}
+
+	c.workers.Add(1)
+	go c.work(context.Background(), &fakeRepublisher{}, route, msgs)
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- c.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v while a message was in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := c.Health().State; got != StateDraining {
+		t.Errorf("state while draining = %q", got)
+	}
+
+	// Cancelling the consumer closes its delivery channel.
+	close(msgs)
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if !acker.acked {
+		t.Error("in-flight message was not acked before shutdown finished")
+	}
+	if got := c.Health().State; got != StateStopped {
+		t.Errorf("state after shutdown = %q", got)
+	}
+}
+
+func TestShutdownGivesUpAtTheDeadline(t *testing.T) {
+	c := &RabbitMQConsumer{health: newHealth()}
+	c.workers.Add(1)
+	defer c.workers.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown with a stuck worker = %v, want a deadline error", err)
+	}
+}
+
+func TestLeaseOutlivesTheSlowestRoute(t *testing.T) {
+	cfg := config.Config{HandlerTimeout: 20 * time.Millisecond}
+	r := NewRegistry()
+	r.MustRegister(testRoute("digest", "user.registered"))
+	err := r.ApplyTopology(config.Topology{
+		Handlers: map[string]config.HandlerConfig{"digest": {Timeout: time.Second}},
+	})
+	if err != nil {
+		t.Fatalf("ApplyTopology: %v", err)
+	}
+	if got := r.LongestTimeout(cfg.HandlerTimeout); got != time.Second {
+		t.Fatalf("LongestTimeout = %s, want the route's 1s", got)
+	}
+
+	c := &RabbitMQConsumer{
+		cfg:    cfg,
+		health: newHealth(),
+		dedup:  idempotency.NewDeduplicator(idempotency.NewMemoryStore(), 2*r.LongestTimeout(cfg.HandlerTimeout)),
+		policy: retryPolicy{maxRetries: 3, delays: testDelays},
+	}
+
+	// The handler runs well past twice the service-wide timeout but within
+	// its route's.
+	var calls atomic.Int32
+	started := make(chan struct{})
+	route := r.Routes()[0]
+	route.Handler = func(ctx context.Context, _ contracts.Envelope) error {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		select {
+		case <-time.After(150 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	env, err := contracts.NewEnvelope("/user-service", contracts.UserRegisteredEvent{
+		UserID: uuid.New(), Username: "budi", Email: "budi@example.com", RegisteredAt: time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(env)
+	deliver := func(acker *fakeAcker, pub *fakeRepublisher) {
+		msgs := make(chan amqp.Delivery, 1)
+		msgs <- amqp.Delivery{Acknowledger: acker, RoutingKey: "user.registered", ContentType: contracts.ContentType, MessageId: env.ID, Body: body}
+		close(msgs)
+		c.workers.Add(1)
+		go c.work(context.Background(), pub, route, msgs)
+	}
+
+	first := &fakeAcker{}
+	deliver(first, &fakeRepublisher{})
+	<-started
+	time.Sleep(3 * cfg.HandlerTimeout)
+
+	// A redelivery while the first run is still going must wait for it.
+	redelivered, retried := &fakeAcker{}, &fakeRepublisher{}
+	deliver(redelivered, retried)
+	c.workers.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+	if !first.acked || first.nacked {
+		t.Errorf("slow run was not acked: %+v", first)
+	}
+	if retried.queue == "" {
+		t.Error("redelivery was not deferred to a retry queue")
+	}
+}
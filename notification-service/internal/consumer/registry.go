@@ -0,0 +1,287 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/config"
+)
+
+// DefaultExchangeKind is used for exchanges the topology file does not
+// mention. Handlers bind with routing-key patterns, which need a topic
+// exchange.
+const DefaultExchangeKind = "topic"
+
+// HandlerFunc processes one event, already validated and upcast to the
+// latest schema version. Returning an error retries the message; wrap it
+// with Permanent to park it instead.
+type HandlerFunc func(ctx context.Context, event contracts.Envelope) error
+
+// Handle adapts a function that takes a payload type to a HandlerFunc. A
+// payload that does not decode into T is a permanent failure. The
+// function finds the envelope's ID with EventID.
+func Handle[T any](fn func(ctx context.Context, event T) error) HandlerFunc {
+	return func(ctx context.Context, envelope contracts.Envelope) error {
+		var event T
+		if err := envelope.DecodeData(&event); err != nil {
+			return Permanent(fmt.Errorf("decode %s data: %w", envelope.Type, err))
+		}
+		return fn(context.WithValue(ctx, eventIDKey{}, envelope.ID), event)
+	}
+}
+
+type eventIDKey struct{}
+
+// EventID returns the ID of the event a Handle function was called with,
+// or "" outside of one.
+func EventID(ctx context.Context) string {
+	id, _ := ctx.Value(eventIDKey{}).(string)
+	return id
+}
+
+// Route declares a handler: the events it wants, as routing-key patterns
+// on a topic exchange, and the queue and worker settings it runs with.
+// Every route has its own queue, and with it its own retry tiers and
+// parking lot.
+type Route struct {
+	// Name identifies the handler in the topology file and in logs.
+	Name     string
+	Exchange string
+	// Queue defaults to "notification-service.<Name>".
+	Queue string
+	// Bindings are topic patterns: "*" matches one dot-separated word and
+	// "#" zero or more, e.g. "user.registered" or "user.#".
+	Bindings []string
+
+	// Concurrency, Prefetch and Timeout default to the service-wide
+	// settings (NOTIFICATION_WORKERS, RABBITMQ_PREFETCH,
+	// NOTIFICATION_HANDLER_TIMEOUT) when zero.
+	Concurrency int
+	Prefetch    int
+	Timeout     time.Duration
+
+	Handler HandlerFunc
+
+	exchangeKind string
+}
+
+// Registry collects the routes the consumer serves. Adding an event type
+// means registering a route for it; the consumer declares its topology and
+// workers from here.
+type Registry struct {
+	routes    []Route
+	exchanges map[string]string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{exchanges: map[string]string{}}
+}
+
+// Register adds route, filling in its default queue name. Names and queues
+// must be unique: two routes on one queue would steal each other's
+// messages.
+func (r *Registry) Register(route Route) error {
+	if route.Name == "" {
+		return fmt.Errorf("handler route has no name")
+	}
+	if route.Queue == "" {
+		route.Queue = "notification-service." + route.Name
+	}
+	if err := validateRoute(route); err != nil {
+		return err
+	}
+	for _, existing := range r.routes {
+		if existing.Name == route.Name {
+			return fmt.Errorf("handler %q is already registered", route.Name)
+		}
+		if existing.Queue == route.Queue {
+			return fmt.Errorf("handlers %q and %q both consume queue '%s'", existing.Name, route.Name, route.Queue)
+		}
+	}
+	r.routes = append(r.routes, route)
+	return nil
+}
+
+// MustRegister is Register for routes wired at startup, where a mistake is
+// a programming error.
+func (r *Registry) MustRegister(routes ...Route) {
+	for _, route := range routes {
+		if err := r.Register(route); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// ApplyTopology overlays the topology file on the registered routes:
+// exchange kinds, and per-handler overrides by name. Overrides for unknown
+// handlers are rejected so that a typo does not silently do nothing.
+func (r *Registry) ApplyTopology(topology config.Topology) error {
+	for _, exchange := range topology.Exchanges {
+		r.exchanges[exchange.Name] = exchange.Kind
+	}
+
+	known := map[string]bool{}
+	routes := r.routes[:0]
+	for _, route := range r.routes {
+		known[route.Name] = true
+		override, ok := topology.Handlers[route.Name]
+		if !ok {
+			routes = append(routes, route)
+			continue
+		}
+		if override.Disabled {
+			log.Printf("Handler '%s' is disabled by the topology file.", route.Name)
+			continue
+		}
+		if override.Exchange != "" {
+			route.Exchange = override.Exchange
+		}
+		if override.Queue != "" {
+			route.Queue = override.Queue
+		}
+		if len(override.Bindings) > 0 {
+			route.Bindings = override.Bindings
+		}
+		if override.Concurrency > 0 {
+			route.Concurrency = override.Concurrency
+		}
+		if override.Prefetch > 0 {
+			route.Prefetch = override.Prefetch
+		}
+		if override.Timeout > 0 {
+			route.Timeout = override.Timeout
+		}
+		if err := validateRoute(route); err != nil {
+			return err
+		}
+		routes = append(routes, route)
+	}
+	r.routes = routes
+
+	var unknown []string
+	for name := range topology.Handlers {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("topology file configures unknown handler(s): %s", strings.Join(unknown, ", "))
+	}
+
+	queues := map[string]string{}
+	for _, route := range r.routes {
+		if other, ok := queues[route.Queue]; ok {
+			return fmt.Errorf("handlers %q and %q both consume queue '%s'", other, route.Name, route.Queue)
+		}
+		queues[route.Queue] = route.Name
+	}
+	return nil
+}
+
+// accepts reports whether one of the route's bindings matches routingKey.
+// Fanout and headers exchanges ignore routing keys, so everything matches.
+func (route Route) accepts(routingKey string) bool {
+	if route.exchangeKind != "topic" && route.exchangeKind != "direct" {
+		return true
+	}
+	for _, pattern := range route.Bindings {
+		if MatchesPattern(pattern, routingKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes returns the registered routes in registration order.
+func (r *Registry) Routes() []Route {
+	return append([]Route(nil), r.routes...)
+}
+
+// LongestTimeout returns the longest handler timeout of any route, with
+// fallback standing in for routes that leave Timeout zero.
+func (r *Registry) LongestTimeout(fallback time.Duration) time.Duration {
+	longest := fallback
+	for _, route := range r.routes {
+		longest = max(longest, route.Timeout)
+	}
+	return longest
+}
+
+// ExchangeKind returns the declared type of exchange.
+func (r *Registry) ExchangeKind(exchange string) string {
+	if kind, ok := r.exchanges[exchange]; ok {
+		return kind
+	}
+	return DefaultExchangeKind
+}
+
+func validateRoute(route Route) error {
+	if route.Exchange == "" {
+		return fmt.Errorf("handler %q has no exchange", route.Name)
+	}
+	if route.Handler == nil {
+		return fmt.Errorf("handler %q has no handler function", route.Name)
+	}
+	if len(route.Bindings) == 0 {
+		return fmt.Errorf("handler %q has no bindings", route.Name)
+	}
+	for _, pattern := range route.Bindings {
+		if err := validatePattern(pattern); err != nil {
+			return fmt.Errorf("handler %q: %w", route.Name, err)
+		}
+	}
+	return nil
+}
+
+// validatePattern rejects topic patterns that the broker would accept but
+// that cannot match what they look like they match, such as "user.*ed".
+func validatePattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty binding pattern")
+	}
+	for _, word := range strings.Split(pattern, ".") {
+		if word == "" {
+			return fmt.Errorf("binding pattern %q has an empty word", pattern)
+		}
+		if word != "*" && word != "#" && strings.ContainsAny(word, "*#") {
+			return fmt.Errorf("binding pattern %q: wildcards must be a whole word", pattern)
+		}
+	}
+	return nil
+}
+
+// MatchesPattern reports whether routingKey matches a topic pattern, with
+// the broker's semantics.
+func MatchesPattern(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			for skip := 0; skip <= len(key); skip++ {
+				if matchWords(pattern[1:], key[skip:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(key) == 0 {
+				return false
+			}
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
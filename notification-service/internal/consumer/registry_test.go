@@ -0,0 +1,119 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/config"
+)
+
+func testRoute(name string, bindings ...string) Route {
+	return Route{
+		Name:     name,
+		Exchange: "user_events",
+		Bindings: bindings,
+		Handler:  func(context.Context, contracts.Envelope) error { return nil },
+	}
+}
+
+func TestRegistryRejectsConflictingRoutes(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register(testRoute("welcome_email", "user.registered")); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if got := r.Routes()[0].Queue; got != "notification-service.welcome_email" {
+		t.Errorf("default queue = %q", got)
+	}
+
+	if err := r.Register(testRoute("welcome_email", "user.deleted")); err == nil {
+		t.Error("registered the same handler name twice")
+	}
+	dup := testRoute("audit", "user.#")
+	dup.Queue = "notification-service.welcome_email"
+	if err := r.Register(dup); err == nil {
+		t.Error("registered two handlers on one queue")
+	}
+	if err := r.Register(testRoute("bad", "user.*ed")); err == nil {
+		t.Error("accepted a partial-word wildcard")
+	}
+}
+
+func TestApplyTopologyOverridesByName(t *testing.T) {
+	r := NewRegistry()
+	r.MustRegister(testRoute("welcome_email", "user.registered"), testRoute("audit", "user.#"))
+
+	err := r.ApplyTopology(config.Topology{
+		Exchanges: []config.ExchangeConfig{{Name: "user_events", Kind: "topic"}},
+		Handlers: map[string]config.HandlerConfig{
+			"welcome_email": {Queue: "welcome.queue", Concurrency: 8},
+			"audit":         {Disabled: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ApplyTopology: %v", err)
+	}
+
+	routes := r.Routes()
+	if len(routes) != 1 || routes[0].Queue != "welcome.queue" || routes[0].Concurrency != 8 {
+		t.Errorf("unexpected routes after overrides: %+v", routes)
+	}
+
+	err = r.ApplyTopology(config.Topology{Handlers: map[string]config.HandlerConfig{"welcom_email": {}}})
+	if err == nil {
+		t.Error("accepted an override for an unknown handler")
+	}
+}
+
+func TestMatchesPattern(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"user.registered", "user.registered", true},
+		{"user.registered", "user.deleted", false},
+		{"user.*", "user.deleted", true},
+		{"user.*", "user.email.changed", false},
+		{"user.#", "user", true},
+		{"user.#", "user.email.changed", true},
+		{"#.changed", "user.email.changed", true},
+		{"*.registered", "user.registered", true},
+		{"#", "anything.at.all", true},
+	}
+	for _, tc := range cases {
+		if got := MatchesPattern(tc.pattern, tc.key); got != tc.want {
+			t.Errorf("MatchesPattern(%q, %q) = %t, want %t", tc.pattern, tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestHandleDecodesPayload(t *testing.T) {
+	type payload struct {
+		Name string `json:"name"`
+	}
+	var got payload
+	var eventID string
+	h := Handle(func(ctx context.Context, p payload) error { got, eventID = p, EventID(ctx); return nil })
+
+	if err := h(context.Background(), contracts.Envelope{ID: "evt-1", Data: json.RawMessage(`{"name":"a"}`)}); err != nil || got.Name != "a" || eventID != "evt-1" {
+		t.Fatalf("got %+v from %q, %v", got, eventID, err)
+	}
+	if err := h(context.Background(), contracts.Envelope{Data: json.RawMessage(`[]`)}); !IsPermanent(err) {
+		t.Errorf("undecodable payload: want a permanent error, got %v", err)
+	}
+}
+
+func TestThrottleDelayWaitsForTheLimit(t *testing.T) {
+	delays := []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute}
+	for wait, want := range map[time.Duration]time.Duration{
+		time.Second:      5 * time.Second,
+		10 * time.Second: 30 * time.Second,
+		time.Hour:        2 * time.Minute,
+	} {
+		if got := throttleDelay(delays, wait); got != want {
+			t.Errorf("throttleDelay(%s) = %s, want %s", wait, got, want)
+		}
+	}
+}
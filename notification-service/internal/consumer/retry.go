@@ -0,0 +1,174 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/idempotency"
+	"github.com/virhanali/filmnesia/notification-service/internal/ratelimit"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// permanentError marks a failure that retrying cannot fix, such as a body
+// that does not parse. The message goes straight to the parking lot.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// settleAction is what happens to a delivery once its handler returns.
+type settleAction int
+
+const (
+	settleAck settleAction = iota
+	settleRetry
+	settlePark
+)
+
+// settleDecision is a retryPolicy's verdict. For settleRetry, delay picks
+// the retry tier and retries is the RetryCountHeader value for the copy.
+type settleDecision struct {
+	action  settleAction
+	delay   time.Duration
+	retries int
+}
+
+// retryPolicy decides how a delivery is settled from the handler's error
+// and the number of retries it has already had.
+type retryPolicy struct {
+	maxRetries int
+	delays     []time.Duration
+}
+
+func (p retryPolicy) decide(handleErr error, attempts int) settleDecision {
+	if handleErr == nil {
+		return settleDecision{action: settleAck}
+	}
+
+	if errors.Is(handleErr, idempotency.ErrInProgress) {
+		// Not a failure of this message; wait for the other worker without
+		// spending the retry budget.
+		attempts = min(attempts, max(p.maxRetries-1, 0))
+	}
+	if IsPermanent(handleErr) || attempts >= p.maxRetries || len(p.delays) == 0 {
+		return settleDecision{action: settlePark, retries: attempts}
+	}
+
+	var limited *ratelimit.Error
+	if errors.As(handleErr, &limited) {
+		// Not a failure either: come back once the limit allows, again
+		// without spending the retry budget.
+		return settleDecision{action: settleRetry, delay: throttleDelay(p.delays, limited.RetryAfter), retries: attempts}
+	}
+	return settleDecision{
+		action:  settleRetry,
+		delay:   p.delays[min(attempts, len(p.delays)-1)],
+		retries: attempts + 1,
+	}
+}
+
+// republisher sends a copy of a delivery to a retry queue and returns once
+// the broker has confirmed it.
+type republisher interface {
+	republish(queue string, d amqp.Delivery, retries int) error
+}
+
+// settle acks, retries or dead-letters d as the policy decides. Retrying
+// republishes a copy to the retry queue for the next delay, with
+// RetryCountHeader incremented, and only acks the original once the broker
+// has confirmed the copy; if that fails the original is requeued instead so
+// nothing is lost.
+func (p retryPolicy) settle(pub republisher, queue string, d amqp.Delivery, handleErr error) {
+	decision := p.decide(handleErr, retryCount(d.Headers))
+	switch decision.action {
+	case settleAck:
+		if err := d.Ack(false); err != nil {
+			log.Printf("ERROR: Failed to acknowledge message: %v", err)
+		}
+		return
+	case settlePark:
+		log.Printf("ERROR: Giving up on message from queue '%s' after %d retries, moving it to '%s': %v",
+			queue, decision.retries, ParkingLotQueue(queue), handleErr)
+		if err := d.Nack(false, false); err != nil {
+			log.Printf("ERROR: Failed to dead-letter message: %v", err)
+		}
+		return
+	}
+
+	if err := pub.republish(RetryQueue(queue, decision.delay), d, decision.retries); err != nil {
+		log.Printf("ERROR: Failed to schedule retry for message from queue '%s', requeueing: %v", queue, err)
+		if errNack := d.Nack(false, true); errNack != nil {
+			log.Printf("ERROR: Failed to requeue message: %v", errNack)
+		}
+		return
+	}
+
+	log.Printf("WARNING: Message from queue '%s' failed (%v); retry %d/%d in %s.",
+		queue, handleErr, decision.retries, p.maxRetries, decision.delay)
+	if err := d.Ack(false); err != nil {
+		log.Printf("ERROR: Failed to acknowledge message after scheduling retry: %v", err)
+	}
+}
+
+// throttleDelay picks the shortest retry tier that waits at least wait,
+// or the longest tier.
+func throttleDelay(delays []time.Duration, wait time.Duration) time.Duration {
+	for _, delay := range delays {
+		if delay >= wait {
+			return delay
+		}
+	}
+	return delays[len(delays)-1]
+}
+
+// confirmChannel republishes on a channel in confirm mode.
+type confirmChannel struct{ ch *amqp.Channel }
+
+func (c confirmChannel) republish(queue string, d amqp.Delivery, retries int) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[RetryCountHeader] = int32(retries)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	confirm, err := c.ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		Type:          d.Type,
+		Body:          d.Body,
+	})
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("broker nacked republish to '%s'", queue)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/idempotency"
+	"github.com/virhanali/filmnesia/notification-service/internal/ratelimit"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var testDelays = []time.Duration{10 * time.Second, time.Minute, 10 * time.Minute}
+
+// fakeAcker records how a delivery was settled.
+type fakeAcker struct {
+	mu      sync.Mutex
+	acked   bool
+	nacked  bool
+	requeue bool
+}
+
+func (a *fakeAcker) Ack(uint64, bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked = true
+	return nil
+}
+
+func (a *fakeAcker) Nack(_ uint64, _ bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacked, a.requeue = true, requeue
+	return nil
+}
+
+func (a *fakeAcker) Reject(_ uint64, requeue bool) error { return a.Nack(0, false, requeue) }
+
+type fakeRepublisher struct {
+	queue   string
+	retries int
+	err     error
+}
+
+func (p *fakeRepublisher) republish(queue string, _ amqp.Delivery, retries int) error {
+	p.queue, p.retries = queue, retries
+	return p.err
+}
+
+func delivery(acker *fakeAcker, retries int) amqp.Delivery {
+	d := amqp.Delivery{Acknowledger: acker, Headers: amqp.Table{}}
+	if retries > 0 {
+		d.Headers[RetryCountHeader] = int32(retries)
+	}
+	return d
+}
+
+func TestRetryPolicyDecide(t *testing.T) {
+	policy := retryPolicy{maxRetries: 5, delays: testDelays}
+	failed := errors.New("smtp down")
+
+	cases := []struct {
+		name     string
+		err      error
+		attempts int
+		want     settleDecision
+	}{
+		{"success", nil, 3, settleDecision{action: settleAck}},
+		{"first failure", failed, 0, settleDecision{settleRetry, 10 * time.Second, 1}},
+		{"second failure", failed, 1, settleDecision{settleRetry, time.Minute, 2}},
+		{"past the last tier", failed, 4, settleDecision{settleRetry, 10 * time.Minute, 5}},
+		{"out of retries", failed, 5, settleDecision{action: settlePark, retries: 5}},
+		{"permanent", Permanent(failed), 0, settleDecision{action: settlePark}},
+		{"wrapped permanent", fmt.Errorf("handler: %w", Permanent(failed)), 1, settleDecision{action: settlePark, retries: 1}},
+		{"in progress keeps the budget", idempotency.ErrInProgress, 5, settleDecision{settleRetry, 10 * time.Minute, 5}},
+		{"throttled keeps the budget", &ratelimit.Error{Scope: "recipient", RetryAfter: 30 * time.Second}, 2, settleDecision{settleRetry, time.Minute, 2}},
+	}
+	for _, tc := range cases {
+		if got := policy.decide(tc.err, tc.attempts); got != tc.want {
+			t.Errorf("%s: decide = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	if got := (retryPolicy{maxRetries: 3}).decide(failed, 0); got.action != settlePark {
+		t.Errorf("no retry tiers: decide = %+v, want park", got)
+	}
+}
+
+func TestSettleUsesRetryCountHeader(t *testing.T) {
+	policy := retryPolicy{maxRetries: 3, delays: testDelays}
+	const queue = "notification-service.welcome_email"
+	failed := errors.New("smtp down")
+
+	t.Run("retry tier from header", func(t *testing.T) {
+		acker, pub := &fakeAcker{}, &fakeRepublisher{}
+		policy.settle(pub, queue, delivery(acker, 2), failed)
+		if pub.queue != RetryQueue(queue, 10*time.Minute) || pub.retries != 3 {
+			t.Errorf("republished to %q with %d retries", pub.queue, pub.retries)
+		}
+		if !acker.acked || acker.nacked {
+			t.Errorf("original not acked after the retry was confirmed: %+v", acker)
+		}
+	})
+
+	t.Run("parks after MaxRetries", func(t *testing.T) {
+		acker, pub := &fakeAcker{}, &fakeRepublisher{}
+		policy.settle(pub, queue, delivery(acker, 3), failed)
+		if pub.queue != "" || !acker.nacked || acker.requeue {
+			t.Errorf("want a dead-letter nack and no retry, got %+v and %q", acker, pub.queue)
+		}
+	})
+
+	t.Run("permanent goes straight to the parking lot", func(t *testing.T) {
+		acker, pub := &fakeAcker{}, &fakeRepublisher{}
+		policy.settle(pub, queue, delivery(acker, 0), Permanent(failed))
+		if pub.queue != "" || !acker.nacked || acker.requeue {
+			t.Errorf("want a dead-letter nack and no retry, got %+v and %q", acker, pub.queue)
+		}
+	})
+
+	t.Run("requeues when the retry is not confirmed", func(t *testing.T) {
+		acker, pub := &fakeAcker{}, &fakeRepublisher{err: errors.New("nacked")}
+		policy.settle(pub, queue, delivery(acker, 0), failed)
+		if acker.acked || !acker.nacked || !acker.requeue {
+			t.Errorf("want a requeue, got %+v", acker)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/config"
+	"github.com/virhanali/filmnesia/notification-service/internal/stream"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// StreamRelay carries new in-app notifications to every replica: Publish
+// sends them to a fanout exchange, and each replica consumes its own copy
+// from an exclusive, auto-deleted queue and hands it to its local hub.
+// Messages are transient; a client that misses one catches up from the
+// inbox when it reconnects.
+type StreamRelay struct {
+	cfg config.Config
+	hub *stream.Hub
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	closed  bool
+}
+
+func NewStreamRelay(cfg config.Config, hub *stream.Hub) *StreamRelay {
+	return &StreamRelay{cfg: cfg, hub: hub}
+}
+
+// Start connects and starts relaying until ctx is cancelled or Close is
+// called, reconnecting with backoff when the connection drops.
+func (r *StreamRelay) Start(ctx context.Context) error {
+	msgs, err := r.connect()
+	if err != nil {
+		return err
+	}
+	log.Printf("Relaying notification streams through fanout exchange '%s'.", r.cfg.StreamExchange)
+	go r.run(ctx, msgs)
+	return nil
+}
+
+func (r *StreamRelay) connect() (<-chan amqp.Delivery, error) {
+	conn, err := amqp.Dial(r.cfg.RabbitMQURL)
+	if err != nil {
+		return nil, fmt.Errorf("connect to RabbitMQ for notification streams: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("open a notification stream channel: %w", err)
+	}
+	msgs, err := r.declare(ch)
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		ch.Close()
+		conn.Close()
+		return nil, errors.New("stream relay is closed")
+	}
+	r.conn, r.channel = conn, ch
+	return msgs, nil
+}
+
+func (r *StreamRelay) declare(ch *amqp.Channel) (<-chan amqp.Delivery, error) {
+	exchange := r.cfg.StreamExchange
+	if err := ch.ExchangeDeclare(exchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("declare exchange '%s': %w", exchange, err)
+	}
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("declare notification stream queue: %w", err)
+	}
+	if err := ch.QueueBind(q.Name, "", exchange, false, nil); err != nil {
+		return nil, fmt.Errorf("bind queue '%s' to exchange '%s': %w", q.Name, exchange, err)
+	}
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("consume queue '%s': %w", q.Name, err)
+	}
+	return msgs, nil
+}
+
+func (r *StreamRelay) run(ctx context.Context, msgs <-chan amqp.Delivery) {
+	for {
+		if !r.relay(ctx, msgs) {
+			return
+		}
+		log.Println("WARNING: Notification stream relay lost its RabbitMQ channel. Reconnecting...")
+		for attempt := 0; ; attempt++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff(attempt, r.cfg.RabbitMQReconnectMinBackoff, r.cfg.RabbitMQReconnectMaxBackoff)):
+			}
+			var err error
+			if msgs, err = r.connect(); err == nil {
+				log.Printf("Notification stream relay reconnected after %d attempt(s).", attempt+1)
+				break
+			}
+			if r.isClosed() {
+				return
+			}
+			log.Printf("WARNING: Notification stream relay reconnect attempt %d failed: %v", attempt+1, err)
+		}
+	}
+}
+
+// relay hands deliveries to the hub until msgs closes, and reports
+// whether it should reconnect.
+func (r *StreamRelay) relay(ctx context.Context, msgs <-chan amqp.Delivery) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case d, ok := <-msgs:
+			if !ok {
+				return !r.isClosed()
+			}
+			var e stream.Event
+			if err := json.Unmarshal(d.Body, &e); err != nil {
+				log.Printf("ERROR: Failed to decode notification stream message: %v", err)
+				continue
+			}
+			r.hub.Deliver(e)
+		}
+	}
+}
+
+// Publish sends e to every replica. While the broker is unreachable e is
+// still delivered to this replica's connections, and the error is
+// returned for the caller to log.
+func (r *StreamRelay) Publish(ctx context.Context, e stream.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	ch := r.channel
+	r.mu.Unlock()
+	if ch == nil || ch.IsClosed() {
+		r.hub.Deliver(e)
+		return errors.New("notification stream relay is not connected")
+	}
+	err = ch.PublishWithContext(ctx, r.cfg.StreamExchange, "", false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Transient,
+		Timestamp:    time.Now().UTC(),
+		Body:         body,
+	})
+	if err != nil {
+		r.hub.Deliver(e)
+		return fmt.Errorf("publish notification stream event: %w", err)
+	}
+	return nil
+}
+
+func (r *StreamRelay) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+func (r *StreamRelay) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if r.channel != nil {
+		if err := r.channel.Close(); err != nil {
+			log.Printf("Error closing notification stream channel: %v", err)
+		}
+	}
+	if r.conn != nil {
+		if err := r.conn.Close(); err != nil {
+			log.Printf("Error closing notification stream connection: %v", err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package consumer
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// session is the broker connection as the supervisor sees it.
+type session interface {
+	// watch returns a channel that receives the cause once the current
+	// connection or channel dies, or false if there is nothing to watch
+	// because the consumer is stopping.
+	watch() (<-chan error, bool)
+	// restore makes one attempt to reconnect and resume every route.
+	restore(ctx context.Context) error
+	// stopping reports whether Shutdown or Close has been called, after
+	// which a lost connection is expected.
+	stopping() bool
+}
+
+// backoffPolicy returns how long to wait before reconnect attempt n,
+// counting from 0.
+type backoffPolicy interface {
+	delay(attempt int) time.Duration
+}
+
+// jitterBackoff grows exponentially from min to max, half of each delay
+// random so that instances do not reconnect in lockstep.
+type jitterBackoff struct {
+	min, max time.Duration
+}
+
+func (b jitterBackoff) delay(attempt int) time.Duration {
+	return backoff(attempt, b.min, b.max)
+}
+
+// backoff returns an exponentially growing delay capped at maxDelay, half
+// of it random so that instances do not reconnect in lockstep.
+func backoff(attempt int, minDelay, maxDelay time.Duration) time.Duration {
+	d := minDelay
+	for i := 0; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// supervisor waits for the session to drop and restores it, reporting
+// "degraded" on health in between, until ctx is cancelled or the session
+// is stopping.
+type supervisor struct {
+	session session
+	health  *health
+	backoff backoffPolicy
+}
+
+func (s *supervisor) run(ctx context.Context) {
+	for {
+		closed, ok := s.session.watch()
+		if !ok {
+			return
+		}
+
+		var cause error
+		select {
+		case <-ctx.Done():
+			return
+		case cause = <-closed:
+		}
+		if s.session.stopping() {
+			return
+		}
+
+		log.Printf("WARNING: RabbitMQ consumer lost its %v. Reconnecting...", cause)
+		s.health.set(StateDegraded, cause)
+		if !s.restore(ctx) {
+			return
+		}
+	}
+}
+
+// restore retries the session with backoff until it succeeds, ctx is
+// cancelled or the session starts stopping.
+func (s *supervisor) restore(ctx context.Context) bool {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(s.backoff.delay(attempt)):
+		}
+
+		err := s.session.restore(ctx)
+		if s.session.stopping() {
+			return false
+		}
+		if err != nil {
+			log.Printf("WARNING: RabbitMQ reconnect attempt %d failed: %v", attempt+1, err)
+			s.health.set(StateDegraded, err)
+			continue
+		}
+
+		s.health.reconnected()
+		s.health.set(StateHealthy, nil)
+		log.Printf("RabbitMQ consumer reconnected after %d attempt(s).", attempt+1)
+		return true
+	}
+}
@@ -0,0 +1,117 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSession hands out one close channel per watch and fails the first
+// restores it is asked for.
+type fakeSession struct {
+	mu             sync.Mutex
+	watches        chan chan error
+	failRestores   int
+	restores       int
+	stateOnRestore []string
+	health         *health
+}
+
+func (s *fakeSession) watch() (<-chan error, bool) {
+	closed := make(chan error, 1)
+	s.watches <- closed
+	return closed, true
+}
+
+func (s *fakeSession) restore(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restores++
+	s.stateOnRestore = append(s.stateOnRestore, s.health.get().State)
+	if s.restores <= s.failRestores {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (s *fakeSession) stopping() bool { return false }
+
+// recordingBackoff returns no delay and records the attempts it was asked
+// about.
+type recordingBackoff struct {
+	mu       sync.Mutex
+	attempts []int
+}
+
+func (b *recordingBackoff) delay(attempt int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.attempts = append(b.attempts, attempt)
+	return 0
+}
+
+func TestSupervisorReconnectsAfterAClosedChannel(t *testing.T) {
+	h := newHealth()
+	h.set(StateHealthy, nil)
+	session := &fakeSession{watches: make(chan chan error), failRestores: 2, health: h}
+	backoff := &recordingBackoff{}
+	s := &supervisor{session: session, health: h, backoff: backoff}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+
+	closed := <-session.watches
+	closed <- errors.New("channel (Exception (406) PRECONDITION_FAILED)")
+
+	// The supervisor watches again once it has restored the session.
+	select {
+	case <-session.watches:
+	case <-time.After(time.Second):
+		t.Fatal("supervisor did not reconnect")
+	}
+	cancel()
+	<-done
+
+	for i, state := range session.stateOnRestore {
+		if state != StateDegraded {
+			t.Errorf("restore %d ran while health was %q, want degraded", i+1, state)
+		}
+	}
+	if session.restores != 3 {
+		t.Errorf("restores = %d, want 3", session.restores)
+	}
+	if got := backoff.attempts; len(got) != 3 || got[0] != 0 || got[2] != 2 {
+		t.Errorf("backoff attempts = %v, want [0 1 2]", got)
+	}
+	status := h.get()
+	if status.State != StateHealthy || status.Reconnects != 1 || status.LastError != "connection refused" {
+		t.Errorf("health after reconnect = %+v", status)
+	}
+}
+
+func TestJitterBackoffStaysWithinBounds(t *testing.T) {
+	b := jitterBackoff{min: 100 * time.Millisecond, max: time.Second}
+	cases := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{30, time.Second},
+	}
+	for _, tc := range cases {
+		for i := 0; i < 50; i++ {
+			if got := b.delay(tc.attempt); got < tc.ceiling/2 || got > tc.ceiling {
+				t.Fatalf("delay(%d) = %s, want within [%s, %s]", tc.attempt, got, tc.ceiling/2, tc.ceiling)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package consumer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RetryCountHeader counts how many times a message has been sent through
+// a retry queue. It is set by the consumer, not the broker.
+const RetryCountHeader = "x-retry-count"
+
+// Every work queue Q gets:
+//
+//	Q.dlx          fanout exchange set as Q's x-dead-letter-exchange
+//	Q.parking-lot  durable queue bound to Q.dlx; rejected messages end up here
+//	Q.retry.<d>    one queue per retry delay, x-message-ttl=d, dead-lettering
+//	               back to Q through the default exchange
+//
+// Adding x-dead-letter-exchange to an existing Q changes its arguments, so a
+// queue declared by an older build must be deleted (or drained) once before
+// this topology can be declared.
+
+func DeadLetterExchange(queue string) string { return queue + ".dlx" }
+
+func ParkingLotQueue(queue string) string { return queue + ".parking-lot" }
+
+func RetryQueue(queue string, delay time.Duration) string {
+	return queue + ".retry." + durationLabel(delay)
+}
+
+// durationLabel renders 10s, 1m, 1h30m rather than time.Duration's 1m0s.
+func durationLabel(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// workQueueArgs are the arguments Q itself must be declared with.
+func workQueueArgs(queue string) amqp.Table {
+	return amqp.Table{"x-dead-letter-exchange": DeadLetterExchange(queue)}
+}
+
+// declareDeadLetterTopology declares Q's dead-letter exchange, parking lot
+// and retry tiers. It must run before Q is declared.
+func declareDeadLetterTopology(ch *amqp.Channel, queue string, delays []time.Duration) error {
+	dlx := DeadLetterExchange(queue)
+	if err := ch.ExchangeDeclare(dlx, "fanout", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare dead-letter exchange '%s': %w", dlx, err)
+	}
+
+	parking := ParkingLotQueue(queue)
+	if _, err := ch.QueueDeclare(parking, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare parking-lot queue '%s': %w", parking, err)
+	}
+	if err := ch.QueueBind(parking, "", dlx, false, nil); err != nil {
+		return fmt.Errorf("bind parking-lot queue '%s': %w", parking, err)
+	}
+
+	for _, delay := range delays {
+		name := RetryQueue(queue, delay)
+		args := amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		}
+		if _, err := ch.QueueDeclare(name, true, false, false, false, args); err != nil {
+			return fmt.Errorf("declare retry queue '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// retryCount reads RetryCountHeader, tolerating the integer types AMQP
+// tables decode to.
+func retryCount(headers amqp.Table) int {
+	switch v := headers[RetryCountHeader].(type) {
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint8:
+		return int(v)
+	default:
+		return 0
+	}
+}
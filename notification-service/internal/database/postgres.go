@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NewPostgresPool opens a pool on url and pings it, retrying a few times so
+// the service can start alongside its database container.
+func NewPostgresPool(ctx context.Context, url string) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("parse database URL: %w", err)
+	}
+
+	const attempts = 5
+	delay := time.Second
+	for i := 1; ; i++ {
+		err = pool.Ping(ctx)
+		if err == nil {
+			log.Println("Successfully connected to the notification database.")
+			return pool, nil
+		}
+		if i == attempts {
+			pool.Close()
+			return nil, fmt.Errorf("ping database after %d attempts: %w", attempts, err)
+		}
+		log.Printf("WARNING: Database not reachable (attempt %d/%d): %v", i, attempts, err)
+		select {
+		case <-ctx.Done():
+			pool.Close()
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
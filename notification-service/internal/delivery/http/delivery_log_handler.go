@@ -0,0 +1,119 @@
+package http
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/deliverylog"
+	"github.com/virhanali/filmnesia/notification-service/internal/notify"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+	"github.com/virhanali/filmnesia/notification-service/internal/ratelimit"
+)
+
+// DeliveryLogHandler is the API support staff look up what became of a
+// user's notifications with.
+type DeliveryLogHandler struct {
+	store      deliverylog.Store
+	dispatcher *notify.Dispatcher
+}
+
+func NewDeliveryLogHandler(store deliverylog.Store, dispatcher *notify.Dispatcher) *DeliveryLogHandler {
+	return &DeliveryLogHandler{store: store, dispatcher: dispatcher}
+}
+
+func (h *DeliveryLogHandler) RegisterRoutes(router *gin.Engine, auth gin.HandlerFunc) {
+	group := router.Group("/api/v1/notifications/deliveries", auth, RequireRole("admin"))
+	{
+		group.GET("", h.SearchDeliveries)
+		group.GET("/:id", h.GetDelivery)
+		group.POST("/:id/resend", h.ResendDelivery)
+	}
+}
+
+// SearchDeliveries returns the most recently updated entries, newest
+// first. Query parameters, all optional: user_id, event_id, email (matched
+// by its hash), channel, status and limit (default 50, at most 200).
+func (h *DeliveryLogHandler) SearchDeliveries(c *gin.Context) {
+	q := deliverylog.Query{
+		EventID:       c.Query("event_id"),
+		RecipientHash: h.dispatcher.HashRecipient(c.Query("email")),
+		Channel:       c.Query("channel"),
+		Status:        deliverylog.Status(c.Query("status")),
+		Limit:         50,
+	}
+	if raw := c.Query("user_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id format"})
+			return
+		}
+		q.UserID = id
+	}
+	if q.Channel != "" && !preference.Channel(q.Channel).Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown channel " + strconv.Quote(q.Channel)})
+		return
+	}
+	if q.Status != "" && !q.Status.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown status " + strconv.Quote(string(q.Status))})
+		return
+	}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+			return
+		}
+		q.Limit = n
+	}
+
+	entries, err := h.store.Search(c.Request.Context(), q)
+	if !handleStoreError(c, err, "search deliveries") {
+		return
+	}
+	if entries == nil {
+		entries = []deliverylog.Entry{}
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": entries})
+}
+
+func (h *DeliveryLogHandler) GetDelivery(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	entry, err := h.store.Get(c.Request.Context(), id)
+	if !handleStoreError(c, err, "load delivery") {
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// ResendDelivery sends the email of an entry again and answers with the
+// new entry. A failed send is recorded and reported as a bad gateway, and
+// one a rate limit holds back as too many requests.
+func (h *DeliveryLogHandler) ResendDelivery(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	entry, err := h.dispatcher.Resend(c.Request.Context(), id)
+	var limited *ratelimit.Error
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, entry)
+	case errors.Is(err, notify.ErrNotResendable), errors.Is(err, notify.ErrSuppressed), errors.Is(err, notify.ErrNoRecipient):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.As(err, &limited):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "delivery": entry})
+	case entry.Status == deliverylog.StatusFailed:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Resend failed: " + err.Error(), "delivery": entry})
+	default:
+		handleStoreError(c, err, "resend delivery")
+	}
+}
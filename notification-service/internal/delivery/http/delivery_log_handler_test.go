@@ -0,0 +1,104 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/deliverylog"
+	"github.com/virhanali/filmnesia/notification-service/internal/email"
+	"github.com/virhanali/filmnesia/notification-service/internal/notify"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+	"github.com/virhanali/filmnesia/notification-service/internal/profile"
+)
+
+func TestDeliveryLogAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	renderer, err := email.NewRenderer(contracts.DefaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := deliverylog.NewMemoryStore()
+	prefs := preference.NewMemoryStore()
+	profiles := profile.NewMemoryStore()
+	dispatcher := notify.NewDispatcher(notify.Config{
+		Mailer:       email.NewMailer(renderer, email.LogSender{}, "no-reply@filmnesia.com"),
+		Preferences:  prefs,
+		Signer:       preference.NewSigner([]byte("x")),
+		DeliveryLog:  store,
+		RecipientKey: []byte("k"),
+		Profiles:     profiles,
+	})
+	router := gin.New()
+	NewDeliveryLogHandler(store, dispatcher).RegisterRoutes(router, AuthMiddleware(testSecret, nil))
+	admin := bearerAs(t, uuid.New(), "admin")
+
+	userID := uuid.New()
+	for _, category := range []preference.Category{preference.CategoryAccount, preference.CategoryMarketing} {
+		dispatcher.SendEmail(context.Background(), notify.Email{
+			EventID:  "evt-" + string(category),
+			UserID:   userID,
+			Category: category,
+			Template: contracts.EventUserRegistered,
+			To:       "Budi@example.com",
+			Data:     contracts.UserRegisteredEvent{UserID: userID, Username: "budi", RegisteredAt: time.Now()},
+		})
+	}
+
+	if rec := do(router, "GET", "/api/v1/notifications/deliveries", bearer(t, userID), "", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin search: %d", rec.Code)
+	}
+	search := func(query string) []deliverylog.Entry {
+		t.Helper()
+		rec := do(router, "GET", "/api/v1/notifications/deliveries?"+query, admin, "", "")
+		var resp struct{ Deliveries []deliverylog.Entry }
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+			t.Fatalf("search %s: %d %s", query, rec.Code, rec.Body)
+		}
+		return resp.Deliveries
+	}
+	if got := search("email=budi@example.com"); len(got) != 2 {
+		t.Errorf("by email: %d entries", len(got))
+	}
+	sent := search("event_id=evt-account")
+	if len(sent) != 1 || sent[0].Status != deliverylog.StatusSent || sent[0].Attempts != 1 {
+		t.Fatalf("by event: %+v", sent)
+	}
+	suppressed := search("user_id=" + userID.String() + "&status=suppressed")
+	if len(suppressed) != 1 {
+		t.Fatalf("suppressed: %+v", suppressed)
+	}
+	for _, query := range []string{"user_id=budi", "status=lost", "channel=fax", "limit=0"} {
+		if rec := do(router, "GET", "/api/v1/notifications/deliveries?"+query, admin, "", ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d", query, rec.Code)
+		}
+	}
+
+	path := "/api/v1/notifications/deliveries/"
+	if rec := do(router, "GET", path+sent[0].ID.String(), admin, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("get: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(router, "GET", path+uuid.NewString(), admin, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown: %d", rec.Code)
+	}
+
+	if rec := do(router, "POST", path+sent[0].ID.String()+"/resend", admin, "", ""); rec.Code != http.StatusConflict {
+		t.Errorf("resend without a known address: %d %s", rec.Code, rec.Body)
+	}
+	profiles.Upsert(context.Background(), profile.Profile{UserID: userID, Email: "budi@example.com", UpdatedAt: time.Now()})
+	rec := do(router, "POST", path+sent[0].ID.String()+"/resend", admin, "", "")
+	var resent deliverylog.Entry
+	json.Unmarshal(rec.Body.Bytes(), &resent)
+	if rec.Code != http.StatusCreated || resent.ResendOf == nil || *resent.ResendOf != sent[0].ID {
+		t.Fatalf("resend: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(router, "POST", path+suppressed[0].ID.String()+"/resend", admin, "", ""); rec.Code != http.StatusConflict {
+		t.Errorf("resend of a suppressed email: %d %s", rec.Code, rec.Body)
+	}
+}
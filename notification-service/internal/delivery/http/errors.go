@@ -0,0 +1,34 @@
+package http
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/deliverylog"
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+	"github.com/virhanali/filmnesia/notification-service/internal/webhook"
+)
+
+// notFoundErrors are the stores' errors for a record that does not exist,
+// or belongs to another user.
+var notFoundErrors = []error{inbox.ErrNotFound, webhook.ErrNotFound, deliverylog.ErrNotFound}
+
+// handleStoreError answers for err and reports whether the caller may
+// continue. Another user's record is reported as not found.
+func handleStoreError(c *gin.Context, err error, action string) bool {
+	if err == nil {
+		return true
+	}
+	for _, notFound := range notFoundErrors {
+		if errors.Is(err, notFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": notFound.Error()})
+			return false
+		}
+	}
+	log.Printf("ERROR: %s: %v", action, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action})
+	return false
+}
@@ -0,0 +1,123 @@
+package http
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+)
+
+type InboxHandler struct {
+	store inbox.Store
+	now   func() time.Time
+}
+
+func NewInboxHandler(store inbox.Store) *InboxHandler {
+	return &InboxHandler{store: store, now: time.Now}
+}
+
+func (h *InboxHandler) RegisterRoutes(router *gin.Engine, auth gin.HandlerFunc) {
+	group := router.Group("/api/v1/notifications", auth)
+	{
+		group.GET("", h.ListNotifications)
+		group.GET("/unread-count", h.UnreadCount)
+		group.POST("/read-all", h.MarkAllRead)
+		group.POST("/:id/read", h.MarkRead)
+		group.DELETE("/:id", h.DeleteNotification)
+	}
+}
+
+// ListNotifications returns the user's notifications, newest first.
+// Query parameters: limit (default 20, at most 100), cursor (next_cursor
+// of the previous page) and unread=true for unread ones only.
+func (h *InboxHandler) ListNotifications(c *gin.Context) {
+	q := inbox.Query{Cursor: c.Query("cursor")}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		q.Limit = n
+	}
+	if unread := c.Query("unread"); unread != "" {
+		b, err := strconv.ParseBool(unread)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unread must be true or false"})
+			return
+		}
+		q.UnreadOnly = b
+	}
+
+	page, err := h.store.List(c.Request.Context(), authUserID(c), q)
+	if err != nil {
+		if errors.Is(err, inbox.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("ERROR: list notifications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load notifications"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *InboxHandler) UnreadCount(c *gin.Context) {
+	count, err := h.store.UnreadCount(c.Request.Context(), authUserID(c))
+	if err != nil {
+		log.Printf("ERROR: count unread notifications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count unread notifications"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": count})
+}
+
+func (h *InboxHandler) MarkRead(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	err := h.store.MarkRead(c.Request.Context(), authUserID(c), id, h.now().UTC())
+	if !handleStoreError(c, err, "mark notification read") {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *InboxHandler) MarkAllRead(c *gin.Context) {
+	now := h.now().UTC()
+	updated, err := h.store.MarkAllRead(c.Request.Context(), authUserID(c), now, now)
+	if err != nil {
+		log.Printf("ERROR: mark all notifications read: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications read"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
+
+func (h *InboxHandler) DeleteNotification(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	err := h.store.Delete(c.Request.Context(), authUserID(c), id)
+	if !handleStoreError(c, err, "delete notification") {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func pathID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
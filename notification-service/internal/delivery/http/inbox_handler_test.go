@@ -0,0 +1,106 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+)
+
+func TestInboxAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := inbox.NewMemoryStore()
+	router := gin.New()
+	auth := AuthMiddleware(testSecret, nil)
+	prefs := preference.NewMemoryStore()
+	NewPreferenceHandler(prefs, prefs, preference.NewSigner([]byte("x"))).RegisterRoutes(router, auth)
+	NewInboxHandler(store).RegisterRoutes(router, auth)
+
+	ctx := context.Background()
+	userID := uuid.New()
+	token := bearer(t, userID)
+	var created []inbox.Notification
+	for _, title := range []string{"one", "two", "three"} {
+		n := inbox.New(userID, "social", "test", title, "")
+		store.Create(ctx, n)
+		created = append(created, n)
+	}
+	other := inbox.New(uuid.New(), "social", "test", "not yours", "")
+	store.Create(ctx, other)
+
+	list := func(query string) inbox.Page {
+		t.Helper()
+		rec := do(router, http.MethodGet, "/api/v1/notifications"+query, token, "", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", query, rec.Code, rec.Body)
+		}
+		var page inbox.Page
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		return page
+	}
+	unread := func() int {
+		t.Helper()
+		rec := do(router, http.MethodGet, "/api/v1/notifications/unread-count", token, "", "")
+		var body struct{ Unread int }
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return body.Unread
+	}
+
+	if rec := do(router, http.MethodGet, "/api/v1/notifications", "", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: %d", rec.Code)
+	}
+
+	first := list("?limit=2")
+	if len(first.Items) != 2 || first.NextCursor == "" {
+		t.Fatalf("first page = %+v", first)
+	}
+	second := list("?limit=2&cursor=" + url.QueryEscape(first.NextCursor))
+	if len(second.Items) != 1 || second.NextCursor != "" {
+		t.Fatalf("second page = %+v", second)
+	}
+	for query, want := range map[string]int{"?limit=0": 400, "?unread=maybe": 400, "?cursor=bogus": 400} {
+		if rec := do(router, http.MethodGet, "/api/v1/notifications"+query, token, "", ""); rec.Code != want {
+			t.Errorf("GET %s: %d, want %d", query, rec.Code, want)
+		}
+	}
+
+	if rec := do(router, http.MethodPost, "/api/v1/notifications/"+created[0].ID.String()+"/read", token, "", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("mark read: %d %s", rec.Code, rec.Body)
+	}
+	if n := unread(); n != 2 {
+		t.Errorf("unread = %d, want 2", n)
+	}
+	if page := list("?unread=true"); len(page.Items) != 2 {
+		t.Errorf("unread filter returned %d items", len(page.Items))
+	}
+	for _, target := range []string{other.ID.String() + "/read", "not-a-uuid/read"} {
+		rec := do(router, http.MethodPost, "/api/v1/notifications/"+target, token, "", "")
+		if rec.Code != http.StatusNotFound && rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: %d", target, rec.Code)
+		}
+	}
+
+	if rec := do(router, http.MethodPost, "/api/v1/notifications/read-all", token, "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("read-all: %d", rec.Code)
+	}
+	if n := unread(); n != 0 {
+		t.Errorf("unread after read-all = %d", n)
+	}
+
+	if rec := do(router, http.MethodDelete, "/api/v1/notifications/"+created[1].ID.String(), token, "", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", rec.Code)
+	}
+	if rec := do(router, http.MethodDelete, "/api/v1/notifications/"+other.ID.String(), token, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted another user's notification: %d", rec.Code)
+	}
+	if page := list(""); len(page.Items) != 2 {
+		t.Errorf("%d notifications left, want 2", len(page.Items))
+	}
+}
@@ -0,0 +1,107 @@
+package http
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/domain"
+)
+
+const (
+	AuthUserIDKey   = "authUserID"
+	AuthUsernameKey = "authUsername"
+	AuthUserRoleKey = "authUserRole"
+)
+
+var (
+	ErrMissingAuthHeader = errors.New("header authorization not found")
+	ErrInvalidAuthHeader = errors.New("format header authorization not valid (must be 'Bearer {token}')")
+	ErrTokenInvalid      = errors.New("token invalid or expired")
+)
+
+// AuthMiddleware accepts the access tokens user-service issues, signed
+// with the shared JWT_SECRET_KEY, and puts the user into the context.
+// With identity set it first accepts the identity headers the gateway
+// signed; headers that do not verify are rejected rather than ignored.
+func AuthMiddleware(jwtSecretKey string, identity *contracts.IdentityVerifier) gin.HandlerFunc {
+	if jwtSecretKey == "" {
+		log.Fatal("FATAL: JWT_SECRET_KEY cannot be empty for AuthMiddleware")
+	}
+
+	return func(c *gin.Context) {
+		if identity != nil {
+			id, err := identity.Verify(c.Request.Header)
+			if err == nil {
+				c.Set(AuthUserIDKey, id.UserID)
+				c.Set(AuthUsernameKey, id.Username)
+				c.Set(AuthUserRoleKey, id.Role)
+				c.Next()
+				return
+			}
+			if !errors.Is(err, contracts.ErrNoIdentity) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrMissingAuthHeader.Error()})
+			return
+		}
+		scheme, tokenString, ok := strings.Cut(authHeader, " ")
+		if !ok || !strings.EqualFold(scheme, "bearer") || tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidAuthHeader.Error()})
+			return
+		}
+
+		claims := &domain.AppClaims{}
+		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, jwt.ErrSignatureInvalid
+			}
+			return []byte(jwtSecretKey), nil
+		})
+		if err != nil || !token.Valid {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrTokenInvalid.Error()})
+			return
+		}
+
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrTokenInvalid.Error()})
+			return
+		}
+
+		c.Set(AuthUserIDKey, userID)
+		c.Set(AuthUsernameKey, claims.Username)
+		c.Set(AuthUserRoleKey, claims.Role)
+		c.Next()
+	}
+}
+
+// authUserID returns the user AuthMiddleware authenticated.
+func authUserID(c *gin.Context) uuid.UUID {
+	id, _ := c.Get(AuthUserIDKey)
+	userID, _ := id.(uuid.UUID)
+	return userID
+}
+
+// RequireRole lets through users AuthMiddleware authenticated with role,
+// and answers everyone else with 403.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(AuthUserRoleKey) != role {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You are not authorized to access this resource"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,49 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/contracts"
+)
+
+func TestAuthMiddlewareTrustsSignedGatewayIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := []byte("gateway")
+	router := gin.New()
+	router.GET("/whoami", AuthMiddleware(testSecret, contracts.NewIdentityVerifier(secret, 0)), func(c *gin.Context) {
+		c.String(http.StatusOK, authUserID(c).String()+" "+c.GetString(AuthUserRoleKey))
+	})
+	userID := uuid.New()
+	request := func(secret []byte, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/whoami", nil)
+		if secret != nil {
+			contracts.SetIdentity(req.Header, contracts.Identity{UserID: userID, Username: "budi", Role: "admin"}, secret, time.Now())
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := request(secret, ""); rec.Code != http.StatusOK || rec.Body.String() != userID.String()+" admin" {
+		t.Errorf("signed identity: %d %s", rec.Code, rec.Body)
+	}
+	// Forged headers are not overridden by a valid token.
+	if rec := request([]byte("forged"), bearer(t, userID)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("forged identity: %d %s", rec.Code, rec.Body)
+	}
+	if rec := request(nil, bearer(t, userID)); rec.Code != http.StatusOK || rec.Body.String() != userID.String()+" user" {
+		t.Errorf("token only: %d %s", rec.Code, rec.Body)
+	}
+	if rec := request(nil, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: %d", rec.Code)
+	}
+}
@@ -0,0 +1,223 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/notify"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+)
+
+type PreferenceHandler struct {
+	store     preference.Store
+	schedules preference.ScheduleStore
+	signer    *preference.Signer
+	now       func() time.Time
+}
+
+func NewPreferenceHandler(store preference.Store, schedules preference.ScheduleStore, signer *preference.Signer) *PreferenceHandler {
+	return &PreferenceHandler{store: store, schedules: schedules, signer: signer, now: time.Now}
+}
+
+// RegisterRoutes mounts the preferences API behind auth, and the
+// unsubscribe endpoint without it: the signed token is the credential.
+func (h *PreferenceHandler) RegisterRoutes(router *gin.Engine, auth gin.HandlerFunc) {
+	group := router.Group("/api/v1/notifications/preferences", auth)
+	{
+		group.GET("", h.GetPreferences)
+		group.PATCH("", h.UpdatePreferences)
+		group.GET("/schedule", h.GetSchedule)
+		group.PUT("/schedule", h.UpdateSchedule)
+	}
+	router.GET(notify.UnsubscribePath, h.ConfirmUnsubscribe)
+	router.POST(notify.UnsubscribePath, h.Unsubscribe)
+}
+
+// CategoryPreferences is the state of one category on every channel.
+type CategoryPreferences struct {
+	Category      preference.Category         `json:"category"`
+	Transactional bool                        `json:"transactional"`
+	Channels      map[preference.Channel]bool `json:"channels"`
+}
+
+type PreferencesResponse struct {
+	Preferences []CategoryPreferences `json:"preferences"`
+}
+
+type UpdatePreferencesRequest struct {
+	Settings []struct {
+		Category preference.Category `json:"category" binding:"required"`
+		Channel  preference.Channel  `json:"channel" binding:"required"`
+		Enabled  *bool               `json:"enabled" binding:"required"`
+	} `json:"settings" binding:"required,min=1,dive"`
+}
+
+func (h *PreferenceHandler) GetPreferences(c *gin.Context) {
+	prefs, err := preference.Load(c.Request.Context(), h.store, authUserID(c))
+	if err != nil {
+		log.Printf("ERROR: load preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load notification preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, toPreferencesResponse(prefs))
+}
+
+// UpdatePreferences applies the given settings and leaves the others as
+// they are. Either all settings are valid and applied, or none is.
+func (h *PreferenceHandler) UpdatePreferences(c *gin.Context) {
+	var req UpdatePreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := h.now().UTC()
+	settings := make([]preference.Setting, 0, len(req.Settings))
+	for _, s := range req.Settings {
+		setting := preference.Setting{Category: s.Category, Channel: s.Channel, Enabled: *s.Enabled, UpdatedAt: now}
+		if err := setting.Validate(); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, preference.ErrTransactional) {
+				status = http.StatusUnprocessableEntity
+			}
+			c.JSON(status, gin.H{"error": fmt.Sprintf("%s/%s: %v", s.Category, s.Channel, err)})
+			return
+		}
+		settings = append(settings, setting)
+	}
+
+	userID := authUserID(c)
+	if err := h.store.Set(c.Request.Context(), userID, settings); err != nil {
+		log.Printf("ERROR: save preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save notification preferences"})
+		return
+	}
+	h.GetPreferences(c)
+}
+
+// GetSchedule returns the user's time zone, quiet hours and how often
+// each category that can wait is emailed, defaults filled in. An empty
+// time zone is the service's.
+func (h *PreferenceHandler) GetSchedule(c *gin.Context) {
+	s, err := h.schedules.GetSchedule(c.Request.Context(), authUserID(c))
+	if err != nil {
+		log.Printf("ERROR: load schedule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load notification schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, toScheduleResponse(s))
+}
+
+// UpdateSchedule replaces the user's schedule. Categories left out of
+// digests return to their default frequency.
+func (h *PreferenceHandler) UpdateSchedule(c *gin.Context) {
+	var req preference.Schedule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, preference.ErrNoSchedule) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	req.UpdatedAt = h.now().UTC()
+
+	if err := h.schedules.SetSchedule(c.Request.Context(), authUserID(c), req); err != nil {
+		log.Printf("ERROR: save schedule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save notification schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, toScheduleResponse(req))
+}
+
+var confirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Filmnesia</title></head>
+<body style="font-family:Helvetica,Arial,sans-serif;max-width:480px;margin:48px auto;">
+{{if .Done}}<p>You will no longer receive {{.Category}} emails. / Kamu tidak akan lagi menerima email {{.Category}}.</p>
+{{else}}<p>Stop receiving {{.Category}} emails from Filmnesia? / Berhenti menerima email {{.Category}} dari Filmnesia?</p>
+<form method="post"><input type="hidden" name="token" value="{{.Token}}"><button type="submit">Unsubscribe / Berhenti berlangganan</button></form>
+{{end}}</body></html>`))
+
+// ConfirmUnsubscribe shows a page asking to confirm. It does not change
+// anything itself: link scanners and prefetchers issue GETs.
+func (h *PreferenceHandler) ConfirmUnsubscribe(c *gin.Context) {
+	token := c.Query("token")
+	u, err := h.signer.Verify(token)
+	if err != nil {
+		c.String(http.StatusBadRequest, "This unsubscribe link is not valid.")
+		return
+	}
+	h.page(c, http.StatusOK, u, token, false)
+}
+
+// Unsubscribe redeems a token. It serves RFC 8058 one-click requests,
+// which POST "List-Unsubscribe=One-Click" to the link from the header, as
+// well as the confirmation form; the token comes from the query or the
+// form.
+func (h *PreferenceHandler) Unsubscribe(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		token = c.PostForm("token")
+	}
+	u, err := h.signer.Verify(token)
+	if err != nil {
+		c.String(http.StatusBadRequest, "This unsubscribe link is not valid.")
+		return
+	}
+
+	setting := preference.Setting{Category: u.Category, Channel: u.Channel, Enabled: false, UpdatedAt: h.now().UTC()}
+	if err := h.store.Set(c.Request.Context(), u.UserID, []preference.Setting{setting}); err != nil {
+		log.Printf("ERROR: unsubscribe UserID %s from %s/%s: %v", u.UserID, u.Category, u.Channel, err)
+		c.String(http.StatusInternalServerError, "Failed to unsubscribe, please try again later.")
+		return
+	}
+	log.Printf("INFO: UserID %s unsubscribed from %s/%s", u.UserID, u.Category, u.Channel)
+	h.page(c, http.StatusOK, u, token, true)
+}
+
+func (h *PreferenceHandler) page(c *gin.Context, status int, u preference.Unsubscribe, token string, done bool) {
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	confirmPage.Execute(c.Writer, struct {
+		Category preference.Category
+		Token    string
+		Done     bool
+	}{u.Category, token, done})
+}
+
+func toPreferencesResponse(prefs preference.Preferences) PreferencesResponse {
+	resp := PreferencesResponse{Preferences: make([]CategoryPreferences, 0, len(preference.Categories))}
+	for _, category := range preference.Categories {
+		cp := CategoryPreferences{
+			Category:      category,
+			Transactional: category.Transactional(),
+			Channels:      map[preference.Channel]bool{},
+		}
+		for _, channel := range preference.Channels {
+			cp.Channels[channel] = prefs.Allows(category, channel)
+		}
+		resp.Preferences = append(resp.Preferences, cp)
+	}
+	return resp
+}
+
+func toScheduleResponse(s preference.Schedule) preference.Schedule {
+	digests := map[preference.Category]preference.Frequency{}
+	for _, category := range preference.Categories {
+		if !category.Transactional() {
+			digests[category] = s.Frequency(category)
+		}
+	}
+	s.Digests = digests
+	return s
+}
@@ -0,0 +1,196 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/domain"
+	"github.com/virhanali/filmnesia/notification-service/internal/notify"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+)
+
+const testSecret = "test-secret"
+
+func testRouter(t *testing.T) (*gin.Engine, preference.Store, *preference.Signer) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store := preference.NewMemoryStore()
+	signer := preference.NewSigner([]byte("unsubscribe"))
+	router := gin.New()
+	NewPreferenceHandler(store, store, signer).RegisterRoutes(router, AuthMiddleware(testSecret, nil))
+	return router, store, signer
+}
+
+func bearer(t *testing.T, userID uuid.UUID) string {
+	t.Helper()
+	return bearerAs(t, userID, "user")
+}
+
+func bearerAs(t *testing.T, userID uuid.UUID, role string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, domain.AppClaims{
+		Username: "budi",
+		Role:     role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+func do(router http.Handler, method, target, auth, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func channels(t *testing.T, body []byte, category preference.Category) map[preference.Channel]bool {
+	t.Helper()
+	var resp PreferencesResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	for _, cp := range resp.Preferences {
+		if cp.Category == category {
+			return cp.Channels
+		}
+	}
+	t.Fatalf("no %s in %s", category, body)
+	return nil
+}
+
+func TestPreferencesAPI(t *testing.T) {
+	router, _, _ := testRouter(t)
+	userID := uuid.New()
+	auth := bearer(t, userID)
+
+	if rec := do(router, http.MethodGet, "/api/v1/notifications/preferences", "", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: %d", rec.Code)
+	}
+
+	rec := do(router, http.MethodGet, "/api/v1/notifications/preferences", auth, "", "")
+	if rec.Code != http.StatusOK || channels(t, rec.Body.Bytes(), preference.CategoryMarketing)[preference.ChannelEmail] {
+		t.Fatalf("defaults: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(router, http.MethodPatch, "/api/v1/notifications/preferences", auth, "application/json",
+		`{"settings":[{"category":"marketing","channel":"email","enabled":true},{"category":"social","channel":"push","enabled":false}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", rec.Code, rec.Body)
+	}
+	if !channels(t, rec.Body.Bytes(), preference.CategoryMarketing)[preference.ChannelEmail] || channels(t, rec.Body.Bytes(), preference.CategorySocial)[preference.ChannelPush] {
+		t.Errorf("update not applied: %s", rec.Body)
+	}
+
+	for body, want := range map[string]int{
+		`{"settings":[{"category":"security","channel":"email","enabled":false}]}`:  http.StatusUnprocessableEntity,
+		`{"settings":[{"category":"newsletter","channel":"email","enabled":true}]}`: http.StatusBadRequest,
+		`{"settings":[{"category":"social","channel":"fax","enabled":true}]}`:       http.StatusBadRequest,
+		`{"settings":[{"category":"social","channel":"email"}]}`:                    http.StatusBadRequest,
+		`{"settings":[]}`: http.StatusBadRequest,
+	} {
+		if rec := do(router, http.MethodPatch, "/api/v1/notifications/preferences", auth, "application/json", body); rec.Code != want {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, want)
+		}
+	}
+}
+
+func TestScheduleAPI(t *testing.T) {
+	router, _, _ := testRouter(t)
+	auth := bearer(t, uuid.New())
+	const target = "/api/v1/notifications/preferences/schedule"
+	decode := func(rec *httptest.ResponseRecorder) preference.Schedule {
+		t.Helper()
+		var s preference.Schedule
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &s) != nil {
+			t.Fatalf("%d %s", rec.Code, rec.Body)
+		}
+		return s
+	}
+
+	s := decode(do(router, http.MethodGet, target, auth, "", ""))
+	if s.QuietHours != nil || s.Digests[preference.CategorySocial] != preference.FrequencyDaily || s.Digests[preference.CategoryMarketing] != preference.FrequencyImmediate {
+		t.Errorf("defaults: %+v", s)
+	}
+	if _, ok := s.Digests[preference.CategorySecurity]; ok {
+		t.Error("transactional categories are listed as digestible")
+	}
+
+	do(router, http.MethodPut, target, auth, "application/json",
+		`{"time_zone":"Asia/Makassar","quiet_hours":{"start":"22:00","end":"07:00"},"digests":{"marketing":"weekly"}}`)
+	s = decode(do(router, http.MethodGet, target, auth, "", ""))
+	if s.TimeZone != "Asia/Makassar" || s.QuietHours == nil || s.QuietHours.End != "07:00" || s.Digests[preference.CategoryMarketing] != preference.FrequencyWeekly || s.UpdatedAt.IsZero() {
+		t.Errorf("after update: %+v", s)
+	}
+
+	for body, want := range map[string]int{
+		`{"digests":{"security":"daily"}}`:                http.StatusUnprocessableEntity,
+		`{"digests":{"social":"hourly"}}`:                 http.StatusBadRequest,
+		`{"time_zone":"Mars/Olympus"}`:                    http.StatusBadRequest,
+		`{"quiet_hours":{"start":"25:00","end":"07:00"}}`: http.StatusBadRequest,
+		`{"quiet_hours":{"start":"07:00","end":"07:00"}}`: http.StatusBadRequest,
+	} {
+		if rec := do(router, http.MethodPut, target, auth, "application/json", body); rec.Code != want {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, want)
+		}
+	}
+}
+
+func TestOneClickUnsubscribe(t *testing.T) {
+	router, store, signer := testRouter(t)
+	userID := uuid.New()
+	store.Set(context.Background(), userID, []preference.Setting{{Category: preference.CategoryMarketing, Channel: preference.ChannelEmail, Enabled: true}})
+	target := notify.UnsubscribePath + "?token=" + url.QueryEscape(signer.Sign(userID, preference.CategoryMarketing, preference.ChannelEmail))
+
+	allowed := func() bool {
+		prefs, _ := preference.Load(context.Background(), store, userID)
+		return prefs.Allows(preference.CategoryMarketing, preference.ChannelEmail)
+	}
+
+	// Opening the link only asks for confirmation.
+	if rec := do(router, http.MethodGet, target, "", "", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<form") {
+		t.Errorf("GET: %d %s", rec.Code, rec.Body)
+	}
+	if !allowed() {
+		t.Fatal("GET unsubscribed the user")
+	}
+
+	// RFC 8058: the mail client POSTs to the header URL without cookies
+	// or credentials.
+	rec := do(router, http.MethodPost, target, "", "application/x-www-form-urlencoded", "List-Unsubscribe=One-Click")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("one-click POST: %d %s", rec.Code, rec.Body)
+	}
+	if allowed() {
+		t.Error("still subscribed after one-click unsubscribe")
+	}
+	// Redeeming the token again is harmless.
+	if rec := do(router, http.MethodPost, target, "", "application/x-www-form-urlencoded", "List-Unsubscribe=One-Click"); rec.Code != http.StatusOK {
+		t.Errorf("second POST: %d", rec.Code)
+	}
+
+	if rec := do(router, http.MethodPost, notify.UnsubscribePath+"?token=forged.token", "", "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("forged token: %d", rec.Code)
+	}
+}
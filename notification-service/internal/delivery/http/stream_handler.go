@@ -0,0 +1,300 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+	"github.com/virhanali/filmnesia/notification-service/internal/stream"
+)
+
+// StreamPaths are the endpoints that hold a connection open. Browsers
+// cannot set headers on them, so they take the access token from the
+// access_token query parameter; keep them out of request logs.
+var StreamPaths = []string{"/api/v1/notifications/stream", "/api/v1/notifications/ws"}
+
+const (
+	// replayLimit is how many missed notifications a reconnecting client
+	// is sent. A client that missed more is told to resync: reload its
+	// inbox from the list endpoint.
+	replayLimit = 100
+	// sseRetry is the reconnection delay suggested to EventSource clients.
+	sseRetry = 3 * time.Second
+	// streamWriteTimeout bounds one WebSocket write to a stalled client.
+	streamWriteTimeout = 10 * time.Second
+)
+
+type StreamHandler struct {
+	hub       *stream.Hub
+	store     inbox.Store
+	heartbeat time.Duration
+}
+
+func NewStreamHandler(hub *stream.Hub, store inbox.Store, heartbeat time.Duration) *StreamHandler {
+	return &StreamHandler{hub: hub, store: store, heartbeat: heartbeat}
+}
+
+func (h *StreamHandler) RegisterRoutes(router *gin.Engine, auth gin.HandlerFunc) {
+	group := router.Group("/api/v1/notifications", QueryToken(), auth)
+	{
+		group.GET("/stream", h.ServeSSE)
+		group.GET("/ws", h.ServeWebSocket)
+	}
+}
+
+// QueryToken lets a request without an Authorization header authenticate
+// with the access_token query parameter instead, for EventSource and
+// WebSocket clients.
+func QueryToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("access_token"); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		c.Next()
+	}
+}
+
+// streamWriter writes the messages of a stream in one transport's format.
+type streamWriter interface {
+	notification(e stream.Event) error
+	// resync tells the client it missed too much to be replayed; id is
+	// the position to resume from afterwards.
+	resync(id string) error
+	heartbeat() error
+}
+
+// session is a subscribed connection and what it has to replay.
+type session struct {
+	userID uuid.UUID
+	sub    *stream.Subscription
+	replay []inbox.Notification
+	resync string
+}
+
+// open subscribes the user and looks up what they missed since the event
+// ID the client last saw. It answers the request itself when it fails.
+func (h *StreamHandler) open(c *gin.Context, lastEventID string) (*session, bool) {
+	userID := authUserID(c)
+	sub, err := h.hub.Subscribe(userID)
+	switch {
+	case errors.Is(err, stream.ErrTooManyConnections):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return nil, false
+	case err != nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	s := &session{userID: userID, sub: sub}
+	if lastEventID == "" {
+		return s, true
+	}
+	ctx := c.Request.Context()
+	missed, err := h.store.Since(ctx, userID, lastEventID, replayLimit+1)
+	if err == nil && len(missed) > replayLimit {
+		var latest inbox.Page
+		latest, err = h.store.List(ctx, userID, inbox.Query{Limit: 1})
+		if err == nil && len(latest.Items) > 0 {
+			s.resync = latest.Items[0].Position()
+		}
+		missed = nil
+	}
+	if err != nil {
+		sub.Close()
+		if errors.Is(err, inbox.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"})
+			return nil, false
+		}
+		log.Printf("ERROR: replay notifications of UserID %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load missed notifications"})
+		return nil, false
+	}
+	s.replay = missed
+	return s, true
+}
+
+// run replays what the client missed and then streams new notifications
+// until ctx ends, the subscription is dropped or a write fails.
+func (h *StreamHandler) run(ctx context.Context, s *session, w streamWriter) error {
+	defer s.sub.Close()
+
+	// Live events can overlap the replay: the subscription was opened
+	// before the inbox was read.
+	replayed := make(map[uuid.UUID]struct{}, len(s.replay))
+	if s.resync != "" {
+		if err := w.resync(s.resync); err != nil {
+			return err
+		}
+	}
+	for _, n := range s.replay {
+		replayed[n.ID] = struct{}{}
+		if err := w.notification(stream.Event{UserID: s.userID, Notification: n}); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.sub.Done():
+			return nil
+		case e := <-s.sub.Events():
+			if _, ok := replayed[e.Notification.ID]; ok {
+				continue
+			}
+			if err := w.notification(e); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := w.heartbeat(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ServeSSE streams notifications as Server-Sent Events. Event IDs are
+// inbox positions, so a reconnecting EventSource resumes through its
+// Last-Event-ID header; the last_event_id query parameter does the same
+// for clients that cannot set it.
+func (h *StreamHandler) ServeSSE(c *gin.Context) {
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	s, ok := h.open(c, lastEventID)
+	if !ok {
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// Tells nginx-style proxies not to buffer the stream.
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := &sseWriter{c: c}
+	if err := w.write(fmt.Sprintf("retry: %d\n\n", sseRetry.Milliseconds())); err != nil {
+		s.sub.Close()
+		return
+	}
+	log.Printf("INFO: UserID %s opened a notification stream (sse)", s.userID)
+	err := h.run(c.Request.Context(), s, w)
+	log.Printf("INFO: UserID %s closed a notification stream (sse): %v", s.userID, closeReason(err))
+}
+
+type sseWriter struct {
+	c *gin.Context
+}
+
+func (w *sseWriter) write(s string) error {
+	if _, err := w.c.Writer.WriteString(s); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+func (w *sseWriter) notification(e stream.Event) error {
+	data, err := json.Marshal(e.Notification)
+	if err != nil {
+		return err
+	}
+	return w.write("id: " + e.ID() + "\nevent: notification\ndata: " + string(data) + "\n\n")
+}
+
+func (w *sseWriter) resync(id string) error {
+	return w.write("id: " + id + "\nevent: resync\ndata: {}\n\n")
+}
+
+func (w *sseWriter) heartbeat() error {
+	return w.write(": heartbeat\n\n")
+}
+
+// WebSocketMessage is what the WebSocket endpoint sends: a notification,
+// a resync (see ServeSSE) or a heartbeat.
+type WebSocketMessage struct {
+	Type         string              `json:"type"`
+	ID           string              `json:"id,omitempty"`
+	Notification *inbox.Notification `json:"notification,omitempty"`
+}
+
+// ServeWebSocket streams the same messages as ServeSSE over a WebSocket,
+// as JSON text frames. Clients resume with the last_event_id query
+// parameter. Messages from the client are ignored.
+func (h *StreamHandler) ServeWebSocket(c *gin.Context) {
+	s, ok := h.open(c, c.Query("last_event_id"))
+	if !ok {
+		return
+	}
+
+	server := websocket.Server{
+		// The access token, not a cookie, authenticates the connection,
+		// so another site cannot open it on the user's behalf; any Origin
+		// is accepted.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			ctx, cancel := context.WithCancel(c.Request.Context())
+			defer cancel()
+			// Reading is how a closed connection is noticed.
+			go func() {
+				defer cancel()
+				var discard string
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			log.Printf("INFO: UserID %s opened a notification stream (websocket)", s.userID)
+			err := h.run(ctx, s, &wsWriter{ws: ws})
+			log.Printf("INFO: UserID %s closed a notification stream (websocket): %v", s.userID, closeReason(err))
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+	// A failed handshake never reaches Handler.
+	s.sub.Close()
+}
+
+type wsWriter struct {
+	ws *websocket.Conn
+}
+
+func (w *wsWriter) send(msg WebSocketMessage) error {
+	w.ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	return websocket.JSON.Send(w.ws, msg)
+}
+
+func (w *wsWriter) notification(e stream.Event) error {
+	return w.send(WebSocketMessage{Type: "notification", ID: e.ID(), Notification: &e.Notification})
+}
+
+func (w *wsWriter) resync(id string) error {
+	return w.send(WebSocketMessage{Type: "resync", ID: id})
+}
+
+func (w *wsWriter) heartbeat() error {
+	return w.send(WebSocketMessage{Type: "heartbeat"})
+}
+
+func closeReason(err error) string {
+	if err == nil {
+		return "done"
+	}
+	return err.Error()
+}
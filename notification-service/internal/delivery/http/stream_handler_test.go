@@ -0,0 +1,178 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+	"github.com/virhanali/filmnesia/notification-service/internal/stream"
+)
+
+func streamServer(t *testing.T, heartbeat time.Duration) (*httptest.Server, *stream.Hub, inbox.Store) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	hub := stream.NewHub(1)
+	store := inbox.NewMemoryStore()
+	router := gin.New()
+	NewStreamHandler(hub, store, heartbeat).RegisterRoutes(router, AuthMiddleware(testSecret, nil))
+	srv := httptest.NewServer(router)
+	t.Cleanup(func() {
+		hub.Close()
+		srv.Close()
+	})
+	return srv, hub, store
+}
+
+// sseEvent is one event of a stream; a comment reads as event "comment".
+type sseEvent struct {
+	id, event, data, retry string
+}
+
+func readSSE(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if e != (sseEvent{}) {
+				return e
+			}
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "retry: "):
+			e.retry = strings.TrimPrefix(line, "retry: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		case strings.HasPrefix(line, ": "):
+			e.event = "comment"
+		}
+	}
+}
+
+func openSSE(t *testing.T, srv *httptest.Server, token, lastEventID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/notifications/stream", nil)
+	req.Header.Set("Authorization", token)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func TestSSEStreamsAndResumes(t *testing.T) {
+	srv, hub, store := streamServer(t, time.Hour)
+	ctx := context.Background()
+	userID := uuid.New()
+	token := bearer(t, userID)
+
+	resp, body := openSSE(t, srv, token, "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("open stream: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if e := readSSE(t, body); e.retry == "" {
+		t.Fatalf("first event = %+v, want the retry hint", e)
+	}
+
+	first := inbox.New(userID, "social", "test", "first", "")
+	store.Create(ctx, first)
+	hub.Publish(ctx, stream.Event{UserID: userID, Notification: first})
+	e := readSSE(t, body)
+	if e.event != "notification" || e.id != first.Position() || !strings.Contains(e.data, `"title":"first"`) {
+		t.Fatalf("event = %+v", e)
+	}
+
+	if resp, _ := openSSE(t, srv, token, ""); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second stream with a limit of one: %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	// Missed while disconnected.
+	second := inbox.New(userID, "social", "test", "second", "")
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+	store.Create(ctx, second)
+
+	var resumed *bufio.Reader
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		resp, body := openSSE(t, srv, token, e.id)
+		if resp.StatusCode == http.StatusOK {
+			resumed = body
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reconnect: %d", resp.StatusCode)
+		}
+		// The server notices the closed stream asynchronously.
+		time.Sleep(10 * time.Millisecond)
+	}
+	readSSE(t, resumed)
+	if e := readSSE(t, resumed); e.id != second.Position() {
+		t.Errorf("resumed with %+v, want the missed notification", e)
+	}
+}
+
+func TestSSERejectsBadRequests(t *testing.T) {
+	srv, _, _ := streamServer(t, time.Hour)
+	if resp, _ := openSSE(t, srv, "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a token: %d", resp.StatusCode)
+	}
+	if resp, _ := openSSE(t, srv, bearer(t, uuid.New()), "bogus"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad Last-Event-ID: %d", resp.StatusCode)
+	}
+}
+
+func TestSSEHeartbeat(t *testing.T) {
+	srv, _, _ := streamServer(t, 10*time.Millisecond)
+	_, body := openSSE(t, srv, bearer(t, uuid.New()), "")
+	readSSE(t, body)
+	if e := readSSE(t, body); e.event != "comment" {
+		t.Errorf("idle stream sent %+v, want a heartbeat", e)
+	}
+}
+
+func TestWebSocketStream(t *testing.T) {
+	srv, hub, _ := streamServer(t, time.Hour)
+	userID := uuid.New()
+	token := strings.TrimPrefix(bearer(t, userID), "Bearer ")
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/notifications/ws?access_token=" + url.QueryEscape(token)
+	ws, err := websocket.Dial(wsURL, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	n := inbox.New(userID, "social", "test", "hello", "")
+	hub.Publish(context.Background(), stream.Event{UserID: userID, Notification: n})
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg WebSocketMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "notification" || msg.ID != n.Position() || msg.Notification == nil || msg.Notification.Title != "hello" {
+		t.Errorf("message = %+v", msg)
+	}
+}
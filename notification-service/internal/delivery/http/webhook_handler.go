@@ -0,0 +1,225 @@
+package http
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/webhook"
+)
+
+// WebhookHandler is the API administrators manage partner webhooks with.
+type WebhookHandler struct {
+	store  webhook.Store
+	worker *webhook.Worker
+	now    func() time.Time
+}
+
+func NewWebhookHandler(store webhook.Store, worker *webhook.Worker) *WebhookHandler {
+	return &WebhookHandler{store: store, worker: worker, now: time.Now}
+}
+
+func (h *WebhookHandler) RegisterRoutes(router *gin.Engine, auth gin.HandlerFunc) {
+	group := router.Group("/api/v1/notifications/webhooks", auth, RequireRole("admin"))
+	{
+		group.POST("", h.CreateWebhook)
+		group.GET("", h.ListWebhooks)
+		group.GET("/:id", h.GetWebhook)
+		group.PATCH("/:id", h.UpdateWebhook)
+		group.DELETE("/:id", h.DeleteWebhook)
+		group.POST("/:id/test", h.SendTestEvent)
+		group.GET("/:id/deliveries", h.ListDeliveries)
+	}
+}
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	EventTypes  []string `json:"event_types" binding:"required"`
+	Description string   `json:"description"`
+	// Secret is generated when empty.
+	Secret string `json:"secret"`
+}
+
+// CreateWebhookResponse is the only response that carries the secret.
+type CreateWebhookResponse struct {
+	webhook.Subscription
+	Secret string `json:"secret"`
+}
+
+// UpdateWebhookRequest changes the fields that are set. Setting active
+// to true re-enables a disabled subscription and clears its failures.
+type UpdateWebhookRequest struct {
+	URL         *string  `json:"url"`
+	EventTypes  []string `json:"event_types"`
+	Description *string  `json:"description"`
+	Active      *bool    `json:"active"`
+}
+
+type DeliveryWithAttempts struct {
+	webhook.Delivery
+	AttemptLog []webhook.Attempt `json:"attempt_log"`
+}
+
+type TestEventResponse struct {
+	Delivery webhook.Delivery `json:"delivery"`
+	Attempt  webhook.Attempt  `json:"attempt"`
+}
+
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sub, err := webhook.NewSubscription(req.URL, req.EventTypes, req.Description, req.Secret, authUserID(c), h.worker.AllowPrivate())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.store.CreateSubscription(c.Request.Context(), sub); err != nil {
+		log.Printf("ERROR: create webhook subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	c.JSON(http.StatusCreated, CreateWebhookResponse{Subscription: sub, Secret: sub.Secret})
+}
+
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	subs, err := h.store.ListSubscriptions(c.Request.Context())
+	if !handleStoreError(c, err, "list webhooks") {
+		return
+	}
+	if subs == nil {
+		subs = []webhook.Subscription{}
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": subs})
+}
+
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	sub, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	var req UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sub, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	if req.URL != nil {
+		if err := webhook.ValidateURL(*req.URL, h.worker.AllowPrivate()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sub.URL = *req.URL
+	}
+	if req.EventTypes != nil {
+		if err := webhook.ValidateEventTypes(req.EventTypes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sub.EventTypes = slices.Compact(slices.Sorted(slices.Values(req.EventTypes)))
+	}
+	if req.Description != nil {
+		sub.Description = *req.Description
+	}
+	if req.Active != nil {
+		if *req.Active && !sub.Active {
+			sub.ConsecutiveFailures = 0
+			sub.DisabledReason = ""
+		}
+		sub.Active = *req.Active
+	}
+	sub.UpdatedAt = h.now().UTC()
+
+	if !handleStoreError(c, h.store.UpdateSubscription(c.Request.Context(), sub), "update webhook") {
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	id, ok := pathID(c)
+	if !ok {
+		return
+	}
+	if !handleStoreError(c, h.store.DeleteSubscription(c.Request.Context(), id), "delete webhook") {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// SendTestEvent sends a webhook.test event right away and answers with
+// the attempt, so an integration can be checked end to end. The endpoint
+// failing is not an error of this request.
+func (h *WebhookHandler) SendTestEvent(c *gin.Context) {
+	sub, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+	d, a, err := h.worker.SendTest(c.Request.Context(), sub)
+	if !handleStoreError(c, err, "send test event") {
+		return
+	}
+	c.JSON(http.StatusOK, TestEventResponse{Delivery: d, Attempt: a})
+}
+
+// ListDeliveries returns the latest deliveries with their attempts,
+// newest first. Query parameter: limit (default 20, at most 100).
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	sub, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+	limit := 20
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+
+	ctx := c.Request.Context()
+	deliveries, err := h.store.ListDeliveries(ctx, sub.ID, limit)
+	if !handleStoreError(c, err, "list webhook deliveries") {
+		return
+	}
+	out := make([]DeliveryWithAttempts, 0, len(deliveries))
+	for _, d := range deliveries {
+		attempts, err := h.store.Attempts(ctx, d.ID)
+		if !handleStoreError(c, err, "list webhook deliveries") {
+			return
+		}
+		if attempts == nil {
+			attempts = []webhook.Attempt{}
+		}
+		out = append(out, DeliveryWithAttempts{Delivery: d, AttemptLog: attempts})
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": out})
+}
+
+func (h *WebhookHandler) subscription(c *gin.Context) (webhook.Subscription, bool) {
+	id, ok := pathID(c)
+	if !ok {
+		return webhook.Subscription{}, false
+	}
+	sub, err := h.store.GetSubscription(c.Request.Context(), id)
+	if !handleStoreError(c, err, "load webhook") {
+		return webhook.Subscription{}, false
+	}
+	return sub, true
+}
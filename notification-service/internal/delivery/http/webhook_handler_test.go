@@ -0,0 +1,102 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/webhook"
+)
+
+func TestWebhookAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var received http.Header
+	var body []byte
+	partner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer partner.Close()
+
+	store := webhook.NewMemoryStore()
+	worker := webhook.NewWorker(store, webhook.Config{AllowPrivate: true})
+	router := gin.New()
+	NewWebhookHandler(store, worker).RegisterRoutes(router, AuthMiddleware(testSecret, nil))
+	admin := bearerAs(t, uuid.New(), "admin")
+
+	if rec := do(router, "GET", "/api/v1/notifications/webhooks", bearer(t, uuid.New()), "", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin list: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(router, "POST", "/api/v1/notifications/webhooks", admin, "application/json",
+		`{"url":"`+partner.URL+`","event_types":["user.exploded"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown event type: %d %s", rec.Code, rec.Body)
+	}
+
+	rec := do(router, "POST", "/api/v1/notifications/webhooks", admin, "application/json",
+		`{"url":"`+partner.URL+`","event_types":["user.registered","user.deleted"],"description":"cinema"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	var created CreateWebhookResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.Secret == "" || !created.Active || len(created.EventTypes) != 2 {
+		t.Fatalf("created = %s", rec.Body)
+	}
+	path := "/api/v1/notifications/webhooks/" + created.ID.String()
+
+	rec = do(router, "GET", path, admin, "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get: %d %s", rec.Code, rec.Body)
+	}
+	var got map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if _, leaked := got["secret"]; leaked {
+		t.Errorf("the secret is shown after creation: %s", rec.Body)
+	}
+
+	rec = do(router, "POST", path+"/test", admin, "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("test event: %d %s", rec.Code, rec.Body)
+	}
+	if err := webhook.Verify(created.Secret, received, body, time.Minute, time.Now()); err != nil {
+		t.Errorf("test event signature: %v", err)
+	}
+	if received.Get(webhook.HeaderEvent) != webhook.EventTest {
+		t.Errorf("test event headers = %v", received)
+	}
+
+	rec = do(router, "GET", path+"/deliveries", admin, "", "")
+	var deliveries struct {
+		Deliveries []DeliveryWithAttempts `json:"deliveries"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &deliveries)
+	if len(deliveries.Deliveries) != 1 || len(deliveries.Deliveries[0].AttemptLog) != 1 ||
+		deliveries.Deliveries[0].AttemptLog[0].StatusCode != http.StatusOK {
+		t.Fatalf("deliveries = %s", rec.Body)
+	}
+
+	// Re-enabling a disabled subscription clears its failures.
+	sub, _ := store.GetSubscription(t.Context(), created.ID)
+	sub.Active, sub.ConsecutiveFailures, sub.DisabledReason = false, 5, "disabled after 5 consecutive failed attempts"
+	store.UpdateSubscription(t.Context(), sub)
+	rec = do(router, "PATCH", path, admin, "application/json", `{"active":true,"event_types":["user.deleted"]}`)
+	var updated webhook.Subscription
+	json.Unmarshal(rec.Body.Bytes(), &updated)
+	if rec.Code != http.StatusOK || !updated.Active || updated.ConsecutiveFailures != 0 || updated.DisabledReason != "" ||
+		len(updated.EventTypes) != 1 {
+		t.Fatalf("re-enable: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(router, "DELETE", path, admin, "", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(router, "GET", path, admin, "", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("get deleted: %d %s", rec.Code, rec.Body)
+	}
+}
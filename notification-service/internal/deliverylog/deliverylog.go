@@ -0,0 +1,145 @@
+// Package deliverylog records what became of every notification, so that
+// support can answer "I never got the email" without searching container
+// logs.
+package deliverylog
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNotFound = errors.New("delivery not found")
+
+type Status string
+
+const (
+	StatusSent       Status = "sent"
+	StatusFailed     Status = "failed"
+	StatusSuppressed Status = "suppressed"
+	StatusDeferred   Status = "deferred"
+	StatusDigested   Status = "digested"
+	// StatusThrottled is a notification held back by a rate limit; it is
+	// tried again once the limit allows.
+	StatusThrottled Status = "throttled"
+)
+
+func (s Status) Valid() bool {
+	switch s {
+	case StatusSent, StatusFailed, StatusSuppressed, StatusDeferred, StatusDigested, StatusThrottled:
+		return true
+	}
+	return false
+}
+
+// Entry is one notification to one user on one channel. Retries of the
+// same event share the entry: Key identifies it, Attempts counts the
+// delivery attempts and Status is the outcome of the latest.
+type Entry struct {
+	ID      uuid.UUID `json:"id"`
+	Key     string    `json:"-"`
+	UserID  uuid.UUID `json:"user_id"`
+	EventID string    `json:"event_id,omitempty"`
+	// Channel and Category are the preference names, e.g. "email" and
+	// "account".
+	Channel  string `json:"channel"`
+	Category string `json:"category"`
+	Template string `json:"template"`
+	// RecipientHash is HashRecipient of the address. The address itself
+	// is not kept: a resend goes to the user's current one.
+	RecipientHash string `json:"recipient_hash,omitempty"`
+	Status        Status `json:"status"`
+	// Response is what the provider answered, or why nothing was sent.
+	Response string `json:"response,omitempty"`
+	Attempts int    `json:"attempts"`
+	// ResendOf is the entry an administrator resent.
+	ResendOf *uuid.UUID `json:"resend_of,omitempty"`
+	// Locale and Data are what the email was rendered with, Data encoded
+	// as JSON, for rendering it again on resend. Data never holds the
+	// address.
+	Locale    string          `json:"locale,omitempty"`
+	Data      json.RawMessage `json:"-"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Resendable reports whether the entry holds the data to render its email
+// again.
+func (e Entry) Resendable() bool {
+	return len(e.Data) > 0
+}
+
+// HashRecipient returns the hex HMAC-SHA256 under key of an email address,
+// normalised, so that entries can be searched by address without storing
+// it. Keying it keeps the hashes of known addresses from being looked up
+// by anyone who reads the log but does not hold the key.
+func HashRecipient(key []byte, address string) string {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if address == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(address))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Query selects entries; empty fields match everything.
+type Query struct {
+	UserID        uuid.UUID
+	EventID       string
+	RecipientHash string
+	Channel       string
+	Status        Status
+	Limit         int
+}
+
+func (q Query) matches(e Entry) bool {
+	return (q.UserID == uuid.Nil || e.UserID == q.UserID) &&
+		(q.EventID == "" || e.EventID == q.EventID) &&
+		(q.RecipientHash == "" || e.RecipientHash == q.RecipientHash) &&
+		(q.Channel == "" || e.Channel == q.Channel) &&
+		(q.Status == "" || e.Status == q.Status)
+}
+
+type Store interface {
+	// Record stores e, or when an entry with e.Key exists updates its
+	// status, response, locale and data and adds e.Attempts to its
+	// attempts.
+	// It returns the entry as stored.
+	Record(ctx context.Context, e Entry) (Entry, error)
+	Get(ctx context.Context, id uuid.UUID) (Entry, error)
+	// Search returns the entries matching q, most recently updated first.
+	Search(ctx context.Context, q Query) ([]Entry, error)
+	// Purge deletes the entries last updated before cutoff.
+	Purge(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// StartCleanup deletes entries older than retention every interval until
+// ctx is done.
+func StartCleanup(ctx context.Context, store Store, retention, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := store.Purge(ctx, time.Now().Add(-retention))
+				if err != nil {
+					log.Printf("WARNING: Delivery log cleanup failed: %v", err)
+				} else if n > 0 {
+					log.Printf("INFO: Removed %d delivery log entries older than %s.", n, retention)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,86 @@
+package deliverylog
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps entries in process memory, for tests and local
+// development without a database.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[uuid.UUID]Entry
+	keys    map[string]uuid.UUID
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[uuid.UUID]Entry{}, keys: map[string]uuid.UUID{}}
+}
+
+func (s *MemoryStore) Record(ctx context.Context, e Entry) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.keys[e.Key]
+	if !ok {
+		s.keys[e.Key] = e.ID
+		s.entries[e.ID] = e
+		return e, nil
+	}
+	stored := s.entries[id]
+	stored.Status, stored.Response, stored.UpdatedAt = e.Status, e.Response, e.UpdatedAt
+	stored.Attempts += e.Attempts
+	if e.Data != nil {
+		stored.Locale, stored.Data = e.Locale, e.Data
+	}
+	s.entries[id] = stored
+	return stored, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id uuid.UUID) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return e, nil
+}
+
+func (s *MemoryStore) Search(ctx context.Context, q Query) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Entry
+	for _, e := range s.entries {
+		if q.matches(e) {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].UpdatedAt.Equal(out[j].UpdatedAt) {
+			return out[i].UpdatedAt.After(out[j].UpdatedAt)
+		}
+		return out[i].ID.String() > out[j].ID.String()
+	})
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, e := range s.entries {
+		if e.UpdatedAt.Before(cutoff) {
+			delete(s.entries, id)
+			delete(s.keys, e.Key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,64 @@
+package deliverylog
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRecordUpdatesTheEntryOfARetriedEvent(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	userID := uuid.New()
+	key := []byte("recipient key")
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	entry := func(status Status, at time.Time) Entry {
+		return Entry{
+			ID: uuid.New(), Key: "evt-1/email", UserID: userID, EventID: "evt-1", Channel: "email",
+			RecipientHash: HashRecipient(key, " Budi@Example.com "), Status: status, Attempts: 1,
+			CreatedAt: at, UpdatedAt: at,
+		}
+	}
+
+	first, _ := s.Record(ctx, entry(StatusFailed, start))
+	retry := entry(StatusSent, start.Add(time.Minute))
+	retry.Locale, retry.Data = "id", json.RawMessage(`{"username":"budi"}`)
+	stored, _ := s.Record(ctx, retry)
+	if stored.ID != first.ID || stored.Attempts != 2 || stored.Status != StatusSent || !stored.Resendable() || stored.Locale != "id" || !stored.CreatedAt.Equal(start) {
+		t.Fatalf("after the retry: %+v", stored)
+	}
+
+	other := entry(StatusSuppressed, start.Add(2*time.Minute))
+	other.Key, other.EventID, other.Attempts = "evt-2/email", "evt-2", 0
+	s.Record(ctx, other)
+
+	for name, tc := range map[string]struct {
+		q    Query
+		want int
+	}{
+		"user":      {Query{UserID: userID}, 2},
+		"event":     {Query{EventID: "evt-1"}, 1},
+		"recipient": {Query{RecipientHash: HashRecipient(key, "budi@example.com")}, 2},
+		"other key": {Query{RecipientHash: HashRecipient([]byte("other"), "budi@example.com")}, 0},
+		"status":    {Query{Status: StatusSuppressed}, 1},
+		"limit":     {Query{UserID: userID, Limit: 1}, 1},
+		"nobody":    {Query{UserID: uuid.New()}, 0},
+	} {
+		if got, _ := s.Search(ctx, tc.q); len(got) != tc.want {
+			t.Errorf("%s: %d entries, want %d", name, len(got), tc.want)
+		}
+	}
+	if got, _ := s.Search(ctx, Query{}); len(got) != 2 || got[0].EventID != "evt-2" {
+		t.Errorf("not newest first: %+v", got)
+	}
+
+	if n, _ := s.Purge(ctx, start.Add(90*time.Second)); n != 1 {
+		t.Errorf("purged %d entries, want 1", n)
+	}
+	if _, err := s.Get(ctx, first.ID); err != ErrNotFound {
+		t.Errorf("purged entry: %v", err)
+	}
+}
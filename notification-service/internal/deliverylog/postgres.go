@@ -0,0 +1,119 @@
+package deliverylog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps entries in the delivery_log table (see
+// migrations/000007_create_delivery_log and
+// 000008_delivery_log_template_data).
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+const selectEntryColumns = `id, key, user_id, event_id, channel, category, template, recipient_hash,
+	status, response, attempts, resend_of, locale, data, created_at, updated_at`
+
+func scanEntry(row pgx.Row) (Entry, error) {
+	var e Entry
+	var data []byte
+	err := row.Scan(&e.ID, &e.Key, &e.UserID, &e.EventID, &e.Channel, &e.Category, &e.Template, &e.RecipientHash,
+		&e.Status, &e.Response, &e.Attempts, &e.ResendOf, &e.Locale, &data, &e.CreatedAt, &e.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Entry{}, ErrNotFound
+	}
+	e.Data = data
+	return e, err
+}
+
+func (s *PostgresStore) Record(ctx context.Context, e Entry) (Entry, error) {
+	var data []byte
+	if e.Data != nil {
+		data = e.Data
+	}
+	return scanEntry(s.pool.QueryRow(ctx, `
+		INSERT INTO delivery_log (`+selectEntryColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (key) DO UPDATE SET
+			status = EXCLUDED.status,
+			response = EXCLUDED.response,
+			attempts = delivery_log.attempts + EXCLUDED.attempts,
+			locale = CASE WHEN EXCLUDED.data IS NULL THEN delivery_log.locale ELSE EXCLUDED.locale END,
+			data = COALESCE(EXCLUDED.data, delivery_log.data),
+			updated_at = EXCLUDED.updated_at
+		RETURNING `+selectEntryColumns,
+		e.ID, e.Key, e.UserID, e.EventID, e.Channel, e.Category, e.Template, e.RecipientHash,
+		e.Status, e.Response, e.Attempts, e.ResendOf, e.Locale, data, e.CreatedAt, e.UpdatedAt))
+}
+
+func (s *PostgresStore) Get(ctx context.Context, id uuid.UUID) (Entry, error) {
+	return scanEntry(s.pool.QueryRow(ctx, `SELECT `+selectEntryColumns+` FROM delivery_log WHERE id = $1`, id))
+}
+
+func (s *PostgresStore) Search(ctx context.Context, q Query) ([]Entry, error) {
+	var where []string
+	var args []any
+	add := func(column string, value any) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if q.UserID != uuid.Nil {
+		add("user_id", q.UserID)
+	}
+	if q.EventID != "" {
+		add("event_id", q.EventID)
+	}
+	if q.RecipientHash != "" {
+		add("recipient_hash", q.RecipientHash)
+	}
+	if q.Channel != "" {
+		add("channel", q.Channel)
+	}
+	if q.Status != "" {
+		add("status", q.Status)
+	}
+	sql := `SELECT ` + selectEntryColumns + ` FROM delivery_log`
+	if len(where) > 0 {
+		sql += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	sql += ` ORDER BY updated_at DESC, id DESC`
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		sql += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []Entry
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *PostgresStore) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM delivery_log WHERE updated_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,37 @@
+// Package digest collects the low-priority notifications a user receives
+// as a digest instead of one email each, until their digest is sent.
+package digest
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Item is one notification waiting for a digest. Summary is its one-line
+// rendering in the user's language; To and Locale address the digest.
+type Item struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Category  string
+	Template  string
+	Summary   string
+	To        string
+	Locale    string
+	CreatedAt time.Time
+}
+
+// Store keeps items. An item belongs to the first digest job that
+// collects it; a job that runs again after a failure collects the same
+// items, so a digest is never split or sent without them.
+type Store interface {
+	Add(ctx context.Context, item Item) error
+	// Collect assigns the user's uncollected items of category to jobID
+	// and returns every item of the job, oldest first.
+	Collect(ctx context.Context, jobID, userID uuid.UUID, category string) ([]Item, error)
+	// Pending counts the user's uncollected items of category.
+	Pending(ctx context.Context, userID uuid.UUID, category string) (int, error)
+	// Delete removes the items of a job once its digest is sent.
+	Delete(ctx context.Context, jobID uuid.UUID) error
+}
@@ -0,0 +1,69 @@
+package digest
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps items in process memory, for tests and local
+// development without a database.
+type MemoryStore struct {
+	mu    sync.Mutex
+	items map[uuid.UUID]Item
+	jobs  map[uuid.UUID]uuid.UUID
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: map[uuid.UUID]Item{}, jobs: map[uuid.UUID]uuid.UUID{}}
+}
+
+func (s *MemoryStore) Add(ctx context.Context, item Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[item.ID] = item
+	return nil
+}
+
+func (s *MemoryStore) Collect(ctx context.Context, jobID, userID uuid.UUID, category string) ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Item
+	for id, item := range s.items {
+		job, collected := s.jobs[id]
+		if !collected && item.UserID == userID && item.Category == category {
+			s.jobs[id], job, collected = jobID, jobID, true
+		}
+		if collected && job == jobID {
+			out = append(out, item)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *MemoryStore) Pending(ctx context.Context, userID uuid.UUID, category string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, item := range s.items {
+		if _, collected := s.jobs[id]; !collected && item.UserID == userID && item.Category == category {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, jobID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		if job == jobID {
+			delete(s.items, id)
+			delete(s.jobs, id)
+		}
+	}
+	return nil
+}
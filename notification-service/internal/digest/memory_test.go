@@ -0,0 +1,49 @@
+package digest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCollectKeepsItemsWithTheFirstJob(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	userID := uuid.New()
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	add := func(summary, category string, at time.Time) {
+		s.Add(ctx, Item{ID: uuid.New(), UserID: userID, Category: category, Summary: summary, CreatedAt: at})
+	}
+	add("second", "social", start.Add(time.Minute))
+	add("first", "social", start)
+	add("reminder", "release_reminders", start)
+
+	first, second := uuid.New(), uuid.New()
+	items, _ := s.Collect(ctx, first, userID, "social")
+	if len(items) != 2 || items[0].Summary != "first" || items[1].Summary != "second" {
+		t.Fatalf("collected %+v, want both social items oldest first", items)
+	}
+
+	// An item arriving after the collection waits for the next digest,
+	// while a retry of the first job still sees its own items.
+	add("third", "social", start.Add(2*time.Minute))
+	if items, _ := s.Collect(ctx, first, userID, "social"); len(items) != 3 {
+		t.Fatalf("retried job collected %d items", len(items))
+	}
+	if n, _ := s.Pending(ctx, userID, "social"); n != 0 {
+		t.Errorf("pending = %d after the retry took the late item", n)
+	}
+	if items, _ := s.Collect(ctx, second, userID, "social"); len(items) != 0 {
+		t.Errorf("second job collected %d items of the first", len(items))
+	}
+
+	s.Delete(ctx, first)
+	if items, _ := s.Collect(ctx, first, userID, "social"); len(items) != 0 {
+		t.Errorf("deleted job still has %d items", len(items))
+	}
+	if n, _ := s.Pending(ctx, userID, "release_reminders"); n != 1 {
+		t.Errorf("pending reminders = %d, want 1", n)
+	}
+}
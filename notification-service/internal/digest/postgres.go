@@ -0,0 +1,69 @@
+package digest
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps items in the digest_items table (see
+// migrations/000006_create_digests).
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+func (s *PostgresStore) Add(ctx context.Context, item Item) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO digest_items (id, user_id, category, template, summary, recipient, locale, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		item.ID, item.UserID, item.Category, item.Template, item.Summary, item.To, item.Locale, item.CreatedAt)
+	return err
+}
+
+// Collect claims and reads in one statement: the CTE's rows are not yet
+// visible to the outer SELECT, so they are added to it.
+func (s *PostgresStore) Collect(ctx context.Context, jobID, userID uuid.UUID, category string) ([]Item, error) {
+	rows, err := s.pool.Query(ctx, `
+		WITH collected AS (
+			UPDATE digest_items SET job_id = $1
+			WHERE job_id IS NULL AND user_id = $2 AND category = $3
+			RETURNING id, user_id, category, template, summary, recipient, locale, created_at
+		)
+		SELECT * FROM collected
+		UNION ALL
+		SELECT id, user_id, category, template, summary, recipient, locale, created_at
+		FROM digest_items WHERE job_id = $1
+		ORDER BY created_at`,
+		jobID, userID, category)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Item
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.UserID, &it.Category, &it.Template, &it.Summary, &it.To, &it.Locale, &it.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+func (s *PostgresStore) Pending(ctx context.Context, userID uuid.UUID, category string) (int, error) {
+	var n int
+	err := s.pool.QueryRow(ctx,
+		`SELECT count(*) FROM digest_items WHERE job_id IS NULL AND user_id = $1 AND category = $2`,
+		userID, category).Scan(&n)
+	return n, err
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, jobID uuid.UUID) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM digest_items WHERE job_id = $1`, jobID)
+	return err
+}
@@ -0,0 +1,11 @@
+package domain
+
+import "github.com/golang-jwt/jwt/v5"
+
+// AppClaims are the claims of the access tokens user-service issues; the
+// subject is the user ID.
+type AppClaims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	jwt.RegisteredClaims
+}
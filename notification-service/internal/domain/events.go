@@ -1,14 +1,8 @@
 package domain
 
-import (
-	"time"
+import "github.com/virhanali/filmnesia/contracts"
 
-	"github.com/google/uuid"
-)
-
-type UserRegisteredEvent struct {
-	UserID       uuid.UUID `json:"user_id"`
-	Email        string    `json:"email"`
-	Username     string    `json:"username"`
-	RegisteredAt time.Time `json:"registered_at"`
-}
+// Events consumed by this service are defined in the shared contracts
+// module; consumers always see the latest schema version after upcasting.
+type UserRegisteredEvent = contracts.UserRegisteredEvent
+type UserUpdatedEvent = contracts.UserUpdatedEvent
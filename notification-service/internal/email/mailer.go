@@ -0,0 +1,75 @@
+package email
+
+import (
+	"context"
+)
+
+// Mailer renders a template and sends the result from a fixed address.
+type Mailer struct {
+	renderer *Renderer
+	sender   Sender
+	from     string
+}
+
+func NewMailer(renderer *Renderer, sender Sender, from string) *Mailer {
+	return &Mailer{renderer: renderer, sender: sender, from: from}
+}
+
+// Mail is one templated email.
+type Mail struct {
+	Template string
+	// Locale is the recipient's language; see Renderer.Render for how it
+	// falls back.
+	Locale string
+	To     string
+	Data   any
+	// UnsubscribeURL, when set, is offered in the layouts and as an RFC
+	// 8058 one-click List-Unsubscribe header. It must accept a POST.
+	UnsubscribeURL string
+}
+
+// Send renders m and sends it. A template that does not render is a
+// permanent failure, like a 5xx reply.
+func (m *Mailer) Send(ctx context.Context, mail Mail) error {
+	msg, err := m.Compose(mail)
+	if err != nil {
+		return err
+	}
+	return m.Deliver(ctx, msg)
+}
+
+// Compose renders mail into the message Send would send, for sending it
+// later with Deliver.
+func (m *Mailer) Compose(mail Mail) (*Message, error) {
+	content, err := m.renderer.render(mail.Template, mail.Locale, mail.Data, mail.To, mail.UnsubscribeURL)
+	if err != nil {
+		return nil, Invalid(err)
+	}
+	msg := &Message{
+		From:    m.from,
+		To:      []string{mail.To},
+		Subject: content.Subject,
+		Text:    content.Text,
+		HTML:    content.HTML,
+	}
+	if mail.UnsubscribeURL != "" {
+		msg.Headers = map[string]string{
+			"List-Unsubscribe":      "<" + mail.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+	return msg, nil
+}
+
+// Deliver sends a composed message.
+func (m *Mailer) Deliver(ctx context.Context, msg *Message) error {
+	return m.sender.Send(ctx, msg)
+}
+
+func (m *Mailer) Renderer() *Renderer {
+	return m.renderer
+}
+
+func (m *Mailer) Close() error {
+	return m.sender.Close()
+}
@@ -0,0 +1,131 @@
+// Package email renders notification templates and delivers them over SMTP.
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrNoRecipients = errors.New("email has no recipients")
+
+// Message is an email with a plain-text and an HTML alternative. Either body
+// may be empty, but not both.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	// Headers are added to the message as-is, e.g. List-Unsubscribe.
+	Headers map[string]string
+}
+
+// Bytes encodes m as an RFC 5322 message. With both bodies present it is a
+// multipart/alternative with the text part first, so clients that can show
+// HTML pick the last part.
+func (m *Message) Bytes() ([]byte, error) {
+	if len(m.To) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if m.Text == "" && m.HTML == "" {
+		return nil, errors.New("email has no body")
+	}
+
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", m.From)
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(m.From))
+	header("MIME-Version", "1.0")
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header(textproto.CanonicalMIMEHeaderKey(name), m.Headers[name])
+	}
+
+	if m.Text == "" || m.HTML == "" {
+		contentType, body := "text/plain; charset=utf-8", m.Text
+		if m.HTML != "" {
+			contentType, body = "text/html; charset=utf-8", m.HTML
+		}
+		header("Content-Type", contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// envelopeAddress extracts the bare address for MAIL FROM / RCPT TO from a
+// header-style address such as "Filmnesia <no-reply@filmnesia.com>".
+func envelopeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	return parsed.Address, nil
+}
+
+func messageID(from string) string {
+	domain := "filmnesia.local"
+	if addr, err := envelopeAddress(from); err == nil {
+		if _, d, ok := strings.Cut(addr, "@"); ok {
+			domain = d
+		}
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%s.%d@%s>", hex.EncodeToString(b), time.Now().UnixNano(), domain)
+}
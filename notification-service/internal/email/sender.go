@@ -0,0 +1,382 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TLS modes for SMTPConfig.TLS.
+const (
+	// TLSModeStartTLS upgrades a plain connection and refuses servers that
+	// do not offer STARTTLS, so credentials never travel in the clear.
+	TLSModeStartTLS = "starttls"
+	// TLSModeImplicit connects over TLS from the start (port 465).
+	TLSModeImplicit = "tls"
+	// TLSModeNone is for local catch-all servers such as MailHog.
+	TLSModeNone = "none"
+)
+
+var (
+	ErrSenderClosed = errors.New("smtp sender is closed")
+	// ErrUnavailable wraps failures to open a session: dialling, TLS and
+	// authentication. They are never permanent, since fixing the server or
+	// the credentials makes the same message deliverable.
+	ErrUnavailable = errors.New("smtp server unavailable")
+)
+
+// Sender delivers a message. A returned error for which IsPermanent is true
+// will fail the same way on retry.
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+	Close() error
+}
+
+// IsPermanent reports whether err is an SMTP 5xx reply or a malformed
+// message, which retrying cannot fix. Connection failures and 4xx replies
+// are transient.
+func IsPermanent(err error) bool {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code >= 500
+	}
+	return errors.Is(err, ErrNoRecipients) || errors.Is(err, errInvalidMessage)
+}
+
+var errInvalidMessage = errors.New("invalid email")
+
+// Invalid marks err, e.g. a template that does not render, as a message
+// problem that IsPermanent reports as permanent.
+func Invalid(err error) error {
+	return fmt.Errorf("%w: %v", errInvalidMessage, err)
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      string
+	// LocalName is sent in EHLO; empty means "localhost".
+	LocalName string
+
+	// Timeout bounds dialling and each send when ctx has no earlier
+	// deadline.
+	Timeout time.Duration
+	// MaxConnections caps concurrent sessions; idle ones are kept open for
+	// IdleTimeout and reused for the next message.
+	MaxConnections int
+	IdleTimeout    time.Duration
+
+	// TLSConfig overrides the client TLS settings, e.g. to trust a test CA.
+	TLSConfig *tls.Config
+}
+
+// SMTPSender keeps up to MaxConnections authenticated sessions open and
+// sends one message per transaction on them. A session that the server
+// has dropped is replaced transparently.
+type SMTPSender struct {
+	cfg  SMTPConfig
+	addr string
+
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*session
+	closed bool
+}
+
+type session struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func NewSMTPSender(cfg SMTPConfig) (*SMTPSender, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("smtp host is not set")
+	}
+	switch cfg.TLS {
+	case "":
+		cfg.TLS = TLSModeStartTLS
+	case TLSModeStartTLS, TLSModeImplicit, TLSModeNone:
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode %q", cfg.TLS)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+		if cfg.TLS == TLSModeImplicit {
+			cfg.Port = 465
+		}
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.MaxConnections <= 0 {
+		cfg.MaxConnections = 2
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 30 * time.Second
+	}
+
+	return &SMTPSender{
+		cfg:   cfg,
+		addr:  net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		slots: make(chan struct{}, cfg.MaxConnections),
+	}, nil
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	from, err := envelopeAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("%w: sender: %v", errInvalidMessage, err)
+	}
+	recipients := make([]string, 0, len(msg.To))
+	for _, to := range msg.To {
+		addr, err := envelopeAddress(to)
+		if err != nil {
+			return fmt.Errorf("%w: recipient: %v", errInvalidMessage, err)
+		}
+		recipients = append(recipients, addr)
+	}
+	body, err := msg.Bytes()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidMessage, err)
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.slots }()
+
+	// A pooled session may have been closed by the server since its last
+	// use (or be closing, with a 421 reply); that only shows when it is
+	// used, so one stale session is discarded and the message retried on a
+	// fresh one.
+	for attempt := 0; ; attempt++ {
+		sess, reused, err := s.session(ctx)
+		if err != nil {
+			return err
+		}
+		err = s.transact(ctx, sess, from, recipients, body)
+		if err == nil {
+			s.putIdle(sess)
+			return nil
+		}
+
+		var tpErr *textproto.Error
+		if errors.As(err, &tpErr) && tpErr.Code != 421 {
+			// The server answered, so the session is still in sync; reset the
+			// failed transaction and keep it.
+			if sess.client.Reset() == nil {
+				s.putIdle(sess)
+			} else {
+				sess.close()
+			}
+			return err
+		}
+		sess.close()
+		if !reused || attempt > 0 || ctx.Err() != nil {
+			return fmt.Errorf("smtp send: %w", err)
+		}
+	}
+}
+
+func (s *SMTPSender) transact(ctx context.Context, sess *session, from string, to []string, body []byte) error {
+	deadline := time.Now().Add(s.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	sess.conn.SetDeadline(deadline)
+	defer sess.conn.SetDeadline(time.Time{})
+
+	if err := sess.client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := sess.client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := sess.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// session returns an idle session that has not timed out, or dials a new
+// one. The bool reports whether the session was reused.
+func (s *SMTPSender) session(ctx context.Context) (*session, bool, error) {
+	for {
+		sess, err := s.takeIdle()
+		if err != nil {
+			return nil, false, err
+		}
+		if sess == nil {
+			break
+		}
+		if time.Since(sess.lastUsed) > s.cfg.IdleTimeout {
+			sess.quit()
+			continue
+		}
+		return sess, true, nil
+	}
+
+	sess, err := s.dial(ctx)
+	return sess, false, err
+}
+
+func (s *SMTPSender) dial(ctx context.Context) (*session, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	tlsConfig := s.tlsConfig()
+	var conn net.Conn
+	var err error
+	if s.cfg.TLS == TLSModeImplicit {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(dialCtx, "tcp", s.addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(dialCtx, "tcp", s.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: dial %s: %v", ErrUnavailable, s.addr, err)
+	}
+	if d, ok := dialCtx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: greeting: %v", ErrUnavailable, err)
+	}
+	sess := &session{conn: conn, client: client}
+
+	if err := s.handshake(client, tlsConfig); err != nil {
+		sess.close()
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return sess, nil
+}
+
+func (s *SMTPSender) handshake(client *smtp.Client, tlsConfig *tls.Config) error {
+	localName := s.cfg.LocalName
+	if localName == "" {
+		localName = "localhost"
+	}
+	if err := client.Hello(localName); err != nil {
+		return fmt.Errorf("smtp ehlo: %w", err)
+	}
+
+	if s.cfg.TLS == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not offer STARTTLS; set SMTP_TLS=none to send in the clear", s.addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+
+	if s.cfg.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp server %s does not support AUTH", s.addr)
+		}
+		// PlainAuth refuses to send credentials over an unencrypted
+		// connection to anything but localhost.
+		auth := smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SMTPSender) tlsConfig() *tls.Config {
+	if s.cfg.TLSConfig != nil {
+		c := s.cfg.TLSConfig.Clone()
+		if c.ServerName == "" {
+			c.ServerName = s.cfg.Host
+		}
+		return c
+	}
+	return &tls.Config{ServerName: s.cfg.Host, MinVersion: tls.VersionTLS12}
+}
+
+func (s *SMTPSender) takeIdle() (*session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrSenderClosed
+	}
+	if len(s.idle) == 0 {
+		return nil, nil
+	}
+	sess := s.idle[len(s.idle)-1]
+	s.idle = s.idle[:len(s.idle)-1]
+	return sess, nil
+}
+
+func (s *SMTPSender) putIdle(sess *session) {
+	sess.lastUsed = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		sess.quit()
+		return
+	}
+	s.idle = append(s.idle, sess)
+}
+
+// Close ends all idle sessions with QUIT. Sends in flight finish first and
+// then close their session.
+func (s *SMTPSender) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle, s.closed = nil, true
+	s.mu.Unlock()
+
+	for _, sess := range idle {
+		sess.quit()
+	}
+	return nil
+}
+
+func (sess *session) quit() {
+	sess.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := sess.client.Quit(); err != nil {
+		sess.close()
+	}
+}
+
+func (sess *session) close() {
+	sess.client.Close()
+}
+
+// LogSender only logs messages. It stands in when no SMTP server is
+// configured so that development setups run without one.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg *Message) error {
+	if len(msg.To) == 0 {
+		return ErrNoRecipients
+	}
+	log.Printf("INFO: [email not sent, SMTP is not configured] To: %v Subject: %q", msg.To, msg.Subject)
+	return nil
+}
+
+func (LogSender) Close() error { return nil }
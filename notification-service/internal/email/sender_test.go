@@ -0,0 +1,185 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/email/smtptest"
+)
+
+func newTestServer(t *testing.T, opts ...smtptest.Option) *smtptest.Server {
+	t.Helper()
+	srv, err := smtptest.NewServer(opts...)
+	if err != nil {
+		t.Fatalf("start smtp server: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestSender(t *testing.T, cfg SMTPConfig) *SMTPSender {
+	t.Helper()
+	s, err := NewSMTPSender(cfg)
+	if err != nil {
+		t.Fatalf("NewSMTPSender: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func testMessage(to string) *Message {
+	return &Message{
+		From:    "Filmnesia <no-reply@filmnesia.com>",
+		To:      []string{to},
+		Subject: "Selamat datang — welcome",
+		Text:    "Hi there",
+		HTML:    "<p>Hi there</p>",
+	}
+}
+
+func TestSendOverStartTLSWithAuth(t *testing.T) {
+	serverTLS, clientTLS, err := smtptest.NewTLSConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, smtptest.WithStartTLS(serverTLS), smtptest.WithAuth("mailer", "s3cret"))
+	sender := newTestSender(t, SMTPConfig{
+		Host: srv.Host(), Port: srv.Port(), TLS: TLSModeStartTLS,
+		Username: "mailer", Password: "s3cret", TLSConfig: clientTLS,
+	})
+
+	if err := sender.Send(context.Background(), testMessage("Budi <budi@example.com>")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("server got %d messages, want 1", len(msgs))
+	}
+	got := msgs[0]
+	if !got.TLS || got.Auth != "mailer" || got.From != "no-reply@filmnesia.com" || got.To[0] != "budi@example.com" {
+		t.Errorf("unexpected transaction: tls=%t auth=%q from=%q to=%v", got.TLS, got.Auth, got.From, got.To)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(got.Data))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != "Selamat datang — welcome" {
+		t.Errorf("Subject = %q", subject)
+	}
+	mediaType, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q", mediaType)
+	}
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	var parts []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		parts = append(parts, part.Header.Get("Content-Type")+": "+string(body))
+	}
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "text/plain") || !strings.HasPrefix(parts[1], "text/html") {
+		t.Errorf("parts = %q", parts)
+	}
+}
+
+func TestStartTLSIsRequiredUnlessDisabled(t *testing.T) {
+	srv := newTestServer(t)
+	sender := newTestSender(t, SMTPConfig{Host: srv.Host(), Port: srv.Port(), TLS: TLSModeStartTLS})
+
+	err := sender.Send(context.Background(), testMessage("budi@example.com"))
+	if !errors.Is(err, ErrUnavailable) || IsPermanent(err) {
+		t.Errorf("want a transient ErrUnavailable without STARTTLS, got %v", err)
+	}
+	if len(srv.Messages()) != 0 {
+		t.Error("message was sent in the clear")
+	}
+}
+
+func TestSessionsAreReused(t *testing.T) {
+	srv := newTestServer(t)
+	sender := newTestSender(t, SMTPConfig{Host: srv.Host(), Port: srv.Port(), TLS: TLSModeNone, MaxConnections: 1})
+
+	for i := 0; i < 3; i++ {
+		if err := sender.Send(context.Background(), testMessage("budi@example.com")); err != nil {
+			t.Fatalf("Send #%d: %v", i+1, err)
+		}
+	}
+	if n := srv.Connections(); n != 1 {
+		t.Errorf("opened %d connections for 3 messages, want 1", n)
+	}
+
+	// A session the server dropped is replaced without failing the send.
+	srv.DropConnections()
+	if err := sender.Send(context.Background(), testMessage("budi@example.com")); err != nil {
+		t.Fatalf("Send after the server dropped the session: %v", err)
+	}
+	if n := srv.Connections(); n != 2 {
+		t.Errorf("connections = %d, want 2", n)
+	}
+	if n := len(srv.Messages()); n != 4 {
+		t.Errorf("server got %d messages, want 4", n)
+	}
+}
+
+func TestRejectionsAreClassified(t *testing.T) {
+	srv := newTestServer(t)
+	sender := newTestSender(t, SMTPConfig{Host: srv.Host(), Port: srv.Port(), TLS: TLSModeNone})
+
+	srv.SetHook(func(command, arg string) error {
+		switch {
+		case command == "RCPT" && strings.Contains(arg, "nobody@"):
+			return &textproto.Error{Code: 550, Msg: "No such user"}
+		case command == "RCPT" && strings.Contains(arg, "full@"):
+			return &textproto.Error{Code: 452, Msg: "Mailbox full, try later"}
+		}
+		return nil
+	})
+
+	err := sender.Send(context.Background(), testMessage("nobody@example.com"))
+	if err == nil || !IsPermanent(err) {
+		t.Errorf("550: want a permanent error, got %v", err)
+	}
+	err = sender.Send(context.Background(), testMessage("full@example.com"))
+	if err == nil || IsPermanent(err) {
+		t.Errorf("452: want a transient error, got %v", err)
+	}
+	if err := sender.Send(context.Background(), testMessage("budi@example.com")); err != nil {
+		t.Errorf("session not usable after a rejected transaction: %v", err)
+	}
+	if n := srv.Connections(); n != 1 {
+		t.Errorf("rejections cost %d connections, want 1", n)
+	}
+
+	if err := sender.Send(context.Background(), &Message{From: "no-reply@filmnesia.com", To: []string{"not an address"}, Text: "x"}); !IsPermanent(err) {
+		t.Errorf("malformed recipient: want a permanent error, got %v", err)
+	}
+}
+
+func TestUnreachableServerIsTransient(t *testing.T) {
+	srv := newTestServer(t)
+	host, port := srv.Host(), srv.Port()
+	srv.Close()
+
+	sender := newTestSender(t, SMTPConfig{Host: host, Port: port, TLS: TLSModeNone})
+	err := sender.Send(context.Background(), testMessage("budi@example.com"))
+	if !errors.Is(err, ErrUnavailable) || IsPermanent(err) {
+		t.Errorf("want a transient ErrUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,354 @@
+// Package smtptest provides an in-process SMTP server for tests, in the
+// spirit of net/http/httptest. It speaks enough ESMTP for net/smtp:
+// EHLO/HELO, STARTTLS, AUTH PLAIN, MAIL, RCPT, DATA, RSET, NOOP and QUIT,
+// and records every message it accepts.
+package smtptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a mail transaction the server accepted.
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+	// TLS and Auth report whether the session had upgraded and
+	// authenticated when the message was sent.
+	TLS  bool
+	Auth string
+}
+
+// Server is a fake SMTP server listening on 127.0.0.1.
+type Server struct {
+	// Addr is host:port of the listener.
+	Addr string
+
+	listener  net.Listener
+	tlsConfig *tls.Config
+	username  string
+	password  string
+
+	mu       sync.Mutex
+	hook     func(command, arg string) error
+	messages []Message
+	conns    map[net.Conn]struct{}
+	accepted int
+	wg       sync.WaitGroup
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithStartTLS offers STARTTLS using config.
+func WithStartTLS(config *tls.Config) Option {
+	return func(s *Server) { s.tlsConfig = config }
+}
+
+// WithAuth requires AUTH PLAIN with the given credentials before MAIL.
+func WithAuth(username, password string) Option {
+	return func(s *Server) { s.username, s.password = username, password }
+}
+
+// NewServer starts a server; call Close when done.
+func NewServer(opts ...Option) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: l.Addr().String(), listener: l, conns: map[net.Conn]struct{}{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Host and Port split Addr for sender configuration.
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr)
+	return host
+}
+
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.Addr)
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// SetHook installs fn to run before the server handles each command (upper
+// case, e.g. "RCPT") with its argument. Returning a *textproto.Error makes
+// the server reply with that code and message instead; a 421 reply also
+// closes the connection. nil removes the hook.
+func (s *Server) SetHook(fn func(command, arg string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = fn
+}
+
+// Messages returns the accepted messages in order.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Connections is the number of connections accepted so far.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// DropConnections closes every open connection without a reply, as a
+// server restart or an idle timeout would.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *Server) Close() {
+	s.listener.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.accepted++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+type session struct {
+	conn  net.Conn
+	text  *textproto.Conn
+	tls   bool
+	auth  string
+	from  string
+	to    []string
+	greet bool
+}
+
+func (s *Server) handle(conn net.Conn) {
+	sess := &session{conn: conn, text: textproto.NewConn(conn)}
+	sess.text.PrintfLine("220 smtptest ESMTP ready")
+
+	for {
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, arg, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+
+		s.mu.Lock()
+		hook := s.hook
+		s.mu.Unlock()
+		if hook != nil {
+			if err := hook(command, arg); err != nil {
+				var tpErr *textproto.Error
+				if errors.As(err, &tpErr) {
+					sess.text.PrintfLine("%d %s", tpErr.Code, tpErr.Msg)
+					if tpErr.Code == 421 {
+						return
+					}
+					continue
+				}
+			}
+		}
+
+		if quit := s.command(sess, command, arg); quit {
+			return
+		}
+	}
+}
+
+// command handles one command and reports whether the session is over.
+func (s *Server) command(sess *session, command, arg string) bool {
+	reply := func(format string, args ...any) { sess.text.PrintfLine(format, args...) }
+
+	switch command {
+	case "EHLO":
+		sess.greet = true
+		lines := []string{"smtptest", "8BITMIME"}
+		if s.tlsConfig != nil && !sess.tls {
+			lines = append(lines, "STARTTLS")
+		}
+		if s.username != "" {
+			lines = append(lines, "AUTH PLAIN")
+		}
+		for i, l := range lines {
+			sep := "-"
+			if i == len(lines)-1 {
+				sep = " "
+			}
+			reply("250%s%s", sep, l)
+		}
+	case "HELO":
+		sess.greet = true
+		reply("250 smtptest")
+	case "STARTTLS":
+		if s.tlsConfig == nil || sess.tls {
+			reply("502 STARTTLS not available")
+			return false
+		}
+		reply("220 Ready to start TLS")
+		tlsConn := tls.Server(sess.conn, s.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return true
+		}
+		sess.conn = tlsConn
+		sess.text = textproto.NewConn(tlsConn)
+		sess.tls, sess.greet = true, false
+		sess.from, sess.to = "", nil
+	case "AUTH":
+		mechanism, initial, _ := strings.Cut(arg, " ")
+		if s.username == "" || !strings.EqualFold(mechanism, "PLAIN") {
+			reply("504 Unrecognized authentication type")
+			return false
+		}
+		if initial == "" {
+			reply("334 ")
+			line, err := sess.text.ReadLine()
+			if err != nil {
+				return true
+			}
+			initial = line
+		}
+		decoded, err := base64.StdEncoding.DecodeString(initial)
+		parts := strings.Split(string(decoded), "\x00")
+		if err != nil || len(parts) != 3 || parts[1] != s.username || parts[2] != s.password {
+			reply("535 Authentication credentials invalid")
+			return false
+		}
+		sess.auth = parts[1]
+		reply("235 Authentication successful")
+	case "MAIL":
+		switch {
+		case !sess.greet:
+			reply("503 Send EHLO first")
+		case s.username != "" && sess.auth == "":
+			reply("530 Authentication required")
+		default:
+			sess.from, sess.to = address(arg), nil
+			reply("250 OK")
+		}
+	case "RCPT":
+		if sess.from == "" {
+			reply("503 Need MAIL first")
+			return false
+		}
+		sess.to = append(sess.to, address(arg))
+		reply("250 OK")
+	case "DATA":
+		if len(sess.to) == 0 {
+			reply("503 Need RCPT first")
+			return false
+		}
+		reply("354 End data with <CR><LF>.<CR><LF>")
+		data, err := io.ReadAll(sess.text.DotReader())
+		if err != nil {
+			return true
+		}
+		s.mu.Lock()
+		s.messages = append(s.messages, Message{From: sess.from, To: sess.to, Data: data, TLS: sess.tls, Auth: sess.auth})
+		s.mu.Unlock()
+		sess.from, sess.to = "", nil
+		reply("250 OK queued")
+	case "RSET":
+		sess.from, sess.to = "", nil
+		reply("250 OK")
+	case "NOOP":
+		reply("250 OK")
+	case "QUIT":
+		reply("221 Bye")
+		return true
+	default:
+		reply("502 Command not implemented")
+	}
+	return false
+}
+
+// address extracts the path from "FROM:<a@b>" or "TO:<a@b> SIZE=1".
+func address(arg string) string {
+	_, rest, _ := strings.Cut(arg, ":")
+	rest = strings.TrimSpace(rest)
+	if end := strings.IndexByte(rest, '>'); strings.HasPrefix(rest, "<") && end > 0 {
+		return rest[1:end]
+	}
+	return rest
+}
+
+// NewTLSConfigs returns a server config with a self-signed certificate for
+// 127.0.0.1 and localhost, and a client config that trusts it.
+func NewTLSConfigs() (server, client *tls.Config, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "smtptest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}}}
+	client = &tls.Config{RootCAs: pool}
+	return server, client, nil
+}
@@ -0,0 +1,344 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/i18n"
+)
+
+//go:embed templates locales
+var templateFiles embed.FS
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Content is a rendered template, ready to be put into a Message.
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Renderer renders the templates under templates/<name>/: subject.txt,
+// body.txt and body.html. Names are the routing keys of the events they
+// are sent for. HTML bodies define a "content" block that is wrapped in
+// templates/layout.html; text bodies are wrapped in templates/layout.txt
+// when it exists. Any of the two bodies may be missing. An optional
+// inapp.txt defines the "title" and "body" of the in-app notification for
+// the same event.
+//
+// Templates hold no prose of their own: they look it up in the catalogs
+// under locales/ with the i18n functions (t, plural, date, number), so
+// one template serves every language.
+type Renderer struct {
+	templates map[string]*emailTemplate
+	catalog   *i18n.Catalog
+}
+
+type emailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+	inApp   *texttemplate.Template
+}
+
+// InAppContent is a rendered in-app notification.
+type InAppContent struct {
+	Title string
+	Body  string
+}
+
+// NewRenderer parses every embedded template and loads the catalogs, so a
+// broken template or a key missing from the default locale fails at
+// startup rather than on the first event.
+func NewRenderer(defaultLocale string) (*Renderer, error) {
+	catalog, err := i18n.Load(templateFiles, "locales", defaultLocale)
+	if err != nil {
+		return nil, fmt.Errorf("load email translations: %w", err)
+	}
+	return newRenderer(templateFiles, "templates", catalog)
+}
+
+func newRenderer(fsys fs.FS, root string, catalog *i18n.Catalog) (*Renderer, error) {
+	layout, err := fs.ReadFile(fsys, path.Join(root, "layout.html"))
+	if err != nil {
+		return nil, fmt.Errorf("read email layout: %w", err)
+	}
+	textLayout, err := fs.ReadFile(fsys, path.Join(root, "layout.txt"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read email text layout: %w", err)
+	}
+
+	entries, err := fs.ReadDir(fsys, root)
+	if err != nil {
+		return nil, err
+	}
+	r := &Renderer{templates: map[string]*emailTemplate{}, catalog: catalog}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t, err := parseTemplate(fsys, path.Join(root, entry.Name()), layout, textLayout)
+		if err != nil {
+			return nil, fmt.Errorf("email template %s: %w", entry.Name(), err)
+		}
+		r.templates[entry.Name()] = t
+	}
+
+	if report := catalog.Check(r.Usages()); report.Fatal() {
+		var missing []string
+		for _, p := range report.Problems {
+			if p.Locale == report.DefaultLocale && p.Kind == i18n.ProblemMissing {
+				missing = append(missing, p.Key)
+			}
+		}
+		return nil, fmt.Errorf("default locale %s has no translation for %s", report.DefaultLocale, strings.Join(missing, ", "))
+	}
+	return r, nil
+}
+
+// templateFuncs are the functions templates may call besides the i18n
+// ones. They are bound per message by Render. Templates print the address
+// with recipient rather than take it from their data, so that the data can
+// be kept, e.g. for resending, without it.
+func templateFuncs(to, unsubscribeURL string) map[string]any {
+	return map[string]any{
+		"recipient":      func() string { return to },
+		"unsubscribeURL": func() string { return unsubscribeURL },
+	}
+}
+
+func stubFuncs() map[string]any {
+	funcs := i18n.StubFuncs()
+	for name, fn := range templateFuncs("", "") {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+func parseTemplate(fsys fs.FS, dir string, layout, textLayout []byte) (*emailTemplate, error) {
+	subject, err := fs.ReadFile(fsys, path.Join(dir, "subject.txt"))
+	if err != nil {
+		return nil, err
+	}
+	t := &emailTemplate{}
+	if t.subject, err = texttemplate.New("subject").Funcs(stubFuncs()).Option("missingkey=error").Parse(strings.TrimSpace(string(subject))); err != nil {
+		return nil, err
+	}
+
+	if body, err := fs.ReadFile(fsys, path.Join(dir, "body.txt")); err == nil {
+		text := texttemplate.New("layout").Funcs(stubFuncs()).Option("missingkey=error")
+		sources := []string{string(body)}
+		if textLayout != nil {
+			sources = []string{string(textLayout), `{{define "content"}}` + string(body) + `{{end}}`}
+		}
+		for _, src := range sources {
+			if text, err = text.Parse(src); err != nil {
+				return nil, err
+			}
+		}
+		t.text = text
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if body, err := fs.ReadFile(fsys, path.Join(dir, "body.html")); err == nil {
+		html := htmltemplate.New("layout").Funcs(stubFuncs()).Option("missingkey=error")
+		for _, src := range []string{string(layout), `{{define "subject"}}` + strings.TrimSpace(string(subject)) + `{{end}}`, string(body)} {
+			if html, err = html.Parse(src); err != nil {
+				return nil, err
+			}
+		}
+		t.html = html
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if t.text == nil && t.html == nil {
+		return nil, errors.New("neither body.txt nor body.html exists")
+	}
+
+	if src, err := fs.ReadFile(fsys, path.Join(dir, "inapp.txt")); err == nil {
+		if t.inApp, err = texttemplate.New("inapp").Funcs(stubFuncs()).Option("missingkey=error").Parse(string(src)); err != nil {
+			return nil, err
+		}
+		if t.inApp.Lookup("title") == nil {
+			return nil, errors.New(`inapp.txt does not define "title"`)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Names lists the available templates.
+func (r *Renderer) Names() []string {
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Renderer) Has(name string) bool {
+	_, ok := r.templates[name]
+	return ok
+}
+
+func (r *Renderer) Catalog() *i18n.Catalog {
+	return r.catalog
+}
+
+// Usages lists the translation keys the templates reference, for checking
+// them against the catalogs.
+func (r *Renderer) Usages() []i18n.Usage {
+	var usages []i18n.Usage
+	for _, name := range r.Names() {
+		t := r.templates[name]
+		for _, st := range t.subject.Templates() {
+			usages = append(usages, i18n.ExtractKeys(name+"/subject.txt", st.Tree)...)
+		}
+		if t.text != nil {
+			for _, tt := range t.text.Templates() {
+				usages = append(usages, i18n.ExtractKeys(name+"/body.txt", tt.Tree)...)
+			}
+		}
+		if t.html != nil {
+			for _, ht := range t.html.Templates() {
+				usages = append(usages, i18n.ExtractKeys(name+"/body.html", ht.Tree)...)
+			}
+		}
+		if t.inApp != nil {
+			for _, it := range t.inApp.Templates() {
+				usages = append(usages, i18n.ExtractKeys(name+"/inapp.txt", it.Tree)...)
+			}
+		}
+	}
+	return usages
+}
+
+// Render executes template name with data in locale, falling back to the
+// base language and then the default locale for a locale, or keys, the
+// catalogs do not have. Referencing a field that data does not have is an
+// error, not an empty string.
+func (r *Renderer) Render(name, locale string, data any) (Content, error) {
+	return r.render(name, locale, data, "", "")
+}
+
+func (r *Renderer) render(name, locale string, data any, to, unsubscribeURL string) (Content, error) {
+	t, ok := r.templates[name]
+	if !ok {
+		return Content{}, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+	t, err := t.localize(r.funcs(locale, to, unsubscribeURL))
+	if err != nil {
+		return Content{}, fmt.Errorf("render %s: %w", name, err)
+	}
+
+	var content Content
+	var buf bytes.Buffer
+	if err := t.subject.Execute(&buf, data); err != nil {
+		return Content{}, fmt.Errorf("render %s subject: %w", name, err)
+	}
+	content.Subject = strings.TrimSpace(buf.String())
+
+	if t.text != nil {
+		buf.Reset()
+		if err := t.text.Execute(&buf, data); err != nil {
+			return Content{}, fmt.Errorf("render %s text body: %w", name, err)
+		}
+		content.Text = buf.String()
+	}
+	if t.html != nil {
+		buf.Reset()
+		if err := t.html.Execute(&buf, data); err != nil {
+			return Content{}, fmt.Errorf("render %s html body: %w", name, err)
+		}
+		content.HTML = buf.String()
+	}
+	return content, nil
+}
+
+// HasInApp reports whether template name has an in-app notification.
+func (r *Renderer) HasInApp(name string) bool {
+	t, ok := r.templates[name]
+	return ok && t.inApp != nil
+}
+
+// RenderInApp renders the in-app notification of template name, like
+// Render does the email.
+func (r *Renderer) RenderInApp(name, locale string, data any) (InAppContent, error) {
+	t, ok := r.templates[name]
+	if !ok || t.inApp == nil {
+		return InAppContent{}, fmt.Errorf("%w: %s (in-app)", ErrUnknownTemplate, name)
+	}
+	t, err := t.localize(r.funcs(locale, "", ""))
+	if err != nil {
+		return InAppContent{}, fmt.Errorf("render %s: %w", name, err)
+	}
+
+	var content InAppContent
+	var buf bytes.Buffer
+	if err := t.inApp.ExecuteTemplate(&buf, "title", data); err != nil {
+		return InAppContent{}, fmt.Errorf("render %s in-app title: %w", name, err)
+	}
+	content.Title = strings.TrimSpace(buf.String())
+	if t.inApp.Lookup("body") != nil {
+		buf.Reset()
+		if err := t.inApp.ExecuteTemplate(&buf, "body", data); err != nil {
+			return InAppContent{}, fmt.Errorf("render %s in-app body: %w", name, err)
+		}
+		content.Body = strings.TrimSpace(buf.String())
+	}
+	return content, nil
+}
+
+// funcs are the template functions for one message.
+func (r *Renderer) funcs(locale, to, unsubscribeURL string) map[string]any {
+	funcs := templateFuncs(to, unsubscribeURL)
+	for name, fn := range r.catalog.Funcs(locale) {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// localize returns a copy of t bound to funcs. The parsed templates are
+// never executed themselves, which keeps them cloneable.
+func (t *emailTemplate) localize(funcs map[string]any) (*emailTemplate, error) {
+	subject, err := t.subject.Clone()
+	if err != nil {
+		return nil, err
+	}
+	out := &emailTemplate{subject: subject.Funcs(funcs)}
+	if t.text != nil {
+		text, err := t.text.Clone()
+		if err != nil {
+			return nil, err
+		}
+		out.text = text.Funcs(funcs)
+	}
+	if t.html != nil {
+		html, err := t.html.Clone()
+		if err != nil {
+			return nil, err
+		}
+		out.html = html.Funcs(funcs)
+	}
+	if t.inApp != nil {
+		inApp, err := t.inApp.Clone()
+		if err != nil {
+			return nil, err
+		}
+		out.inApp = inApp.Funcs(funcs)
+	}
+	return out, nil
+}
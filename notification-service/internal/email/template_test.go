@@ -0,0 +1,114 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/virhanali/filmnesia/notification-service/internal/i18n"
+)
+
+func TestEmbeddedTemplatesParse(t *testing.T) {
+	r, err := NewRenderer("id")
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+	if !r.Has("user.registered") {
+		t.Errorf("templates = %v, want user.registered", r.Names())
+	}
+}
+
+func testRenderer(t *testing.T, fsys fstest.MapFS) *Renderer {
+	t.Helper()
+	catalog, err := i18n.Load(fsys, "l", "id")
+	if err != nil {
+		t.Fatalf("load catalog: %v", err)
+	}
+	r, err := newRenderer(fsys, "t", catalog)
+	if err != nil {
+		t.Fatalf("newRenderer: %v", err)
+	}
+	return r
+}
+
+func TestRenderEscapesHTMLOnly(t *testing.T) {
+	r := testRenderer(t, fstest.MapFS{
+		"l/id.json":              {Data: []byte(`{}`)},
+		"t/layout.html":          {Data: []byte(`{{define "layout"}}<title>{{template "subject" .}}</title>{{template "content" .}}{{end}}`)},
+		"t/greeting/subject.txt": {Data: []byte("Hi {{.Name}}\n")},
+		"t/greeting/body.txt":    {Data: []byte("Hello {{.Name}}")},
+		"t/greeting/body.html":   {Data: []byte(`{{define "content"}}<p>Hello {{.Name}}</p>{{end}}`)},
+	})
+
+	content, err := r.Render("greeting", "id", map[string]string{"Name": "<Budi>"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if content.Subject != "Hi <Budi>" || content.Text != "Hello <Budi>" {
+		t.Errorf("text parts were escaped: %+v", content)
+	}
+	if !strings.Contains(content.HTML, "<p>Hello &lt;Budi&gt;</p>") || !strings.Contains(content.HTML, "<title>Hi &lt;Budi&gt;</title>") {
+		t.Errorf("html = %q", content.HTML)
+	}
+
+	if _, err := r.Render("greeting", "id", map[string]string{}); err == nil {
+		t.Error("rendered with a missing field")
+	}
+	if _, err := r.Render("farewell", "id", nil); !errors.Is(err, ErrUnknownTemplate) {
+		t.Errorf("want ErrUnknownTemplate, got %v", err)
+	}
+}
+
+func TestRenderLocalizes(t *testing.T) {
+	r := testRenderer(t, fstest.MapFS{
+		"l/id.json":          {Data: []byte(`{"subject": "Halo {name}", "items": "{count} film", "footer": "Sampai jumpa"}`)},
+		"l/en.json":          {Data: []byte(`{"subject": "Hi {name}", "items": {"one": "{count} film", "other": "{count} films"}}`)},
+		"t/layout.html":      {Data: []byte(`{{define "layout"}}<html lang="{{locale}}">{{template "content" .}}</html>{{end}}`)},
+		"t/list/subject.txt": {Data: []byte(`{{t "subject" "name" .Name}}`)},
+		"t/list/body.txt":    {Data: []byte(`{{plural "items" .Count}} | {{t "footer"}}`)},
+		"t/list/body.html":   {Data: []byte(`{{define "content"}}{{number .Count}}{{end}}`)},
+	})
+	data := map[string]any{"Name": "Budi", "Count": 1200}
+
+	tests := []struct {
+		locale, subject, text, html string
+	}{
+		{"id", "Halo Budi", "1.200 film | Sampai jumpa", `<html lang="id">1.200</html>`},
+		// en-GB falls back to en, and keys en lacks to the default locale.
+		{"en-GB", "Hi Budi", "1,200 films | Sampai jumpa", `<html lang="en">1,200</html>`},
+		{"fr", "Halo Budi", "1.200 film | Sampai jumpa", `<html lang="id">1.200</html>`},
+		{"", "Halo Budi", "1.200 film | Sampai jumpa", `<html lang="id">1.200</html>`},
+	}
+	for _, tt := range tests {
+		content, err := r.Render("list", tt.locale, data)
+		if err != nil {
+			t.Fatalf("Render(%q): %v", tt.locale, err)
+		}
+		if content.Subject != tt.subject || content.Text != tt.text || content.HTML != tt.html {
+			t.Errorf("Render(%q) = %+v, want %q / %q / %q", tt.locale, content, tt.subject, tt.text, tt.html)
+		}
+	}
+
+	one, _ := r.Render("list", "en", map[string]any{"Name": "Budi", "Count": 1})
+	if one.Text != "1 film | Sampai jumpa" {
+		t.Errorf("en singular = %q", one.Text)
+	}
+}
+
+func TestDefaultLocaleMustTranslateEveryKey(t *testing.T) {
+	fsys := fstest.MapFS{
+		"l/id.json":          {Data: []byte(`{}`)},
+		"l/en.json":          {Data: []byte(`{"subject": "Hi"}`)},
+		"t/layout.html":      {Data: []byte(`{{define "layout"}}{{template "content" .}}{{end}}`)},
+		"t/note/subject.txt": {Data: []byte(`{{t "subject"}}`)},
+		"t/note/body.txt":    {Data: []byte(`x`)},
+	}
+	catalog, err := i18n.Load(fsys, "l", "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newRenderer(fsys, "t", catalog); err == nil || !strings.Contains(err.Error(), "subject") {
+		t.Errorf("want an error naming the missing key, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+// Package handler holds the notification handlers. Each one is a
+// consumer.Route: the events it subscribes to and what it does with them.
+// To react to a new event type, add a route here and list it in Routes.
+package handler
+
+import (
+	"github.com/virhanali/filmnesia/notification-service/internal/consumer"
+	"github.com/virhanali/filmnesia/notification-service/internal/email"
+	"github.com/virhanali/filmnesia/notification-service/internal/notify"
+	"github.com/virhanali/filmnesia/notification-service/internal/profile"
+	"github.com/virhanali/filmnesia/notification-service/internal/webhook"
+)
+
+// UserEventsExchange is the topic exchange user-service publishes to.
+const UserEventsExchange = "user_events"
+
+// Deps are the services handlers deliver notifications through, and the
+// replicated state they address them with.
+type Deps struct {
+	Dispatcher *notify.Dispatcher
+	Profiles   profile.Store
+	Webhooks   *webhook.Worker
+}
+
+// Routes returns every handler the service runs.
+func Routes(deps Deps) []consumer.Route {
+	return []consumer.Route{
+		ProfileReplica(deps.Profiles),
+		WelcomeEmail(deps.Dispatcher, deps.Profiles),
+		WelcomeInApp(deps.Dispatcher, deps.Profiles),
+		PartnerWebhooks(deps.Webhooks),
+	}
+}
+
+// deliveryError makes failures that will not go away on retry permanent,
+// so they are parked instead of cycling through the retry queues.
+func deliveryError(err error) error {
+	if email.IsPermanent(err) {
+		return consumer.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/consumer"
+	"github.com/virhanali/filmnesia/notification-service/internal/domain"
+	"github.com/virhanali/filmnesia/notification-service/internal/profile"
+)
+
+// ProfileReplica keeps the local copy of user profiles current, so
+// handlers can address users in their language even when the event they
+// react to does not say which one.
+func ProfileReplica(store profile.Store) consumer.Route {
+	registered := consumer.Handle(func(ctx context.Context, event domain.UserRegisteredEvent) error {
+		return store.Upsert(ctx, profile.Profile{
+			UserID: event.UserID, Email: event.Email, Username: event.Username,
+			Locale: event.Locale, UpdatedAt: event.RegisteredAt,
+		})
+	})
+	updated := consumer.Handle(func(ctx context.Context, event domain.UserUpdatedEvent) error {
+		return store.Upsert(ctx, profile.Profile{
+			UserID: event.UserID, Email: event.Email, Username: event.Username,
+			Locale: event.Locale, UpdatedAt: event.UpdatedAt,
+		})
+	})
+
+	return consumer.Route{
+		Name:     "profile_replica",
+		Exchange: UserEventsExchange,
+		Bindings: []string{contracts.EventUserRegistered, contracts.EventUserUpdated},
+		Handler: func(ctx context.Context, envelope contracts.Envelope) error {
+			switch envelope.RoutingKey() {
+			case contracts.EventUserRegistered:
+				return registered(ctx, envelope)
+			case contracts.EventUserUpdated:
+				return updated(ctx, envelope)
+			}
+			return consumer.Permanent(fmt.Errorf("profile replica: unexpected event %s", envelope.Type))
+		},
+	}
+}
+
+// recipientLocale picks the language to notify a user in: the one on the
+// event, else the replicated profile's. An empty result means the
+// renderer's default locale.
+func recipientLocale(ctx context.Context, store profile.Store, userID uuid.UUID, eventLocale string) string {
+	if eventLocale != "" {
+		return eventLocale
+	}
+	return profile.Locale(ctx, store, userID)
+}
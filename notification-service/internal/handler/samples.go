@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/notification-service/internal/domain"
+	"github.com/virhanali/filmnesia/notification-service/internal/notify"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+)
+
+// Samples returns example data for every email template, keyed by template
+// name, for previews and template tests. Add an entry with each template.
+func Samples() map[string]any {
+	return map[string]any{
+		"user.registered": domain.UserRegisteredEvent{
+			UserID:       uuid.MustParse("5f0c6a8e-9d4b-4c1e-8f3a-2b7d9e1c4a60"),
+			Email:        "budi@example.com",
+			Username:     "budi",
+			RegisteredAt: time.Date(2025, 6, 1, 9, 30, 0, 0, time.UTC),
+		},
+		notify.DigestTemplate: notify.Digest{
+			Category:  preference.CategorySocial,
+			Frequency: preference.FrequencyDaily,
+			Count:     2,
+			Items: []notify.DigestEntry{
+				{Summary: "Sari started following you", At: time.Date(2025, 6, 1, 9, 30, 0, 0, time.UTC)},
+				{Summary: "Andi liked your review of Pengabdi Setan", At: time.Date(2025, 6, 1, 14, 5, 0, 0, time.UTC)},
+			},
+		},
+	}
+}
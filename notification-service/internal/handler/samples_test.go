@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/email"
+)
+
+func TestEveryTemplateRendersWithItsSample(t *testing.T) {
+	renderer, err := email.NewRenderer(contracts.DefaultLocale)
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+	samples := Samples()
+	for _, name := range renderer.Names() {
+		data, ok := samples[name]
+		if !ok {
+			t.Errorf("template %s has no sample in Samples", name)
+			continue
+		}
+		for _, locale := range renderer.Catalog().Locales() {
+			content, err := renderer.Render(name, locale, data)
+			if err != nil {
+				t.Errorf("render %s in %s: %v", name, locale, err)
+				continue
+			}
+			if content.Subject == "" || (content.Text == "" && content.HTML == "") {
+				t.Errorf("%s rendered empty in %s: %+v", name, locale, content)
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/consumer"
+	"github.com/virhanali/filmnesia/notification-service/internal/webhook"
+)
+
+// PartnerWebhooks queues user events for the webhook subscriptions that
+// want them; the webhook worker delivers them. Unlike the other handlers
+// it does no more than a database write per subscriber, so delivery
+// retries never hold up the queue.
+func PartnerWebhooks(worker *webhook.Worker) consumer.Route {
+	return consumer.Route{
+		Name:     "partner_webhooks",
+		Exchange: UserEventsExchange,
+		Bindings: []string{"user.#"},
+		Handler: func(ctx context.Context, envelope contracts.Envelope) error {
+			_, err := worker.Enqueue(ctx, envelope)
+			return err
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"log"
+
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/consumer"
+	"github.com/virhanali/filmnesia/notification-service/internal/domain"
+	"github.com/virhanali/filmnesia/notification-service/internal/notify"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+	"github.com/virhanali/filmnesia/notification-service/internal/profile"
+)
+
+// WelcomeEmail greets newly registered users. It keeps the queue name the
+// service used before handlers were declared, so deployments pick up where
+// they left off.
+func WelcomeEmail(dispatcher *notify.Dispatcher, profiles profile.Store) consumer.Route {
+	return consumer.Route{
+		Name:     "welcome_email",
+		Exchange: UserEventsExchange,
+		Queue:    "user.registered.notifications.queue",
+		Bindings: []string{contracts.EventUserRegistered},
+		Handler: consumer.Handle(func(ctx context.Context, event domain.UserRegisteredEvent) error {
+			log.Printf("INFO: Sending welcome email to UserID: %s", event.UserID)
+			locale := recipientLocale(ctx, profiles, event.UserID, event.Locale)
+			// The template prints the address it is sent to; the data is
+			// kept for resending and must not carry it.
+			data := event
+			data.Email = ""
+			return deliveryError(dispatcher.SendEmail(ctx, notify.Email{
+				EventID:  consumer.EventID(ctx),
+				UserID:   event.UserID,
+				Category: preference.CategoryAccount,
+				Template: contracts.EventUserRegistered,
+				Locale:   locale,
+				To:       event.Email,
+				Data:     data,
+			}))
+		}),
+	}
+}
+
+// WelcomeInApp puts a welcome notification into the new user's inbox. It
+// is a route of its own so a retried email cannot duplicate it.
+func WelcomeInApp(dispatcher *notify.Dispatcher, profiles profile.Store) consumer.Route {
+	return consumer.Route{
+		Name:     "welcome_inapp",
+		Exchange: UserEventsExchange,
+		Bindings: []string{contracts.EventUserRegistered},
+		Handler: consumer.Handle(func(ctx context.Context, event domain.UserRegisteredEvent) error {
+			return deliveryError(dispatcher.SendInApp(ctx, notify.InApp{
+				EventID:  consumer.EventID(ctx),
+				UserID:   event.UserID,
+				Category: preference.CategoryAccount,
+				Template: contracts.EventUserRegistered,
+				Locale:   recipientLocale(ctx, profiles, event.UserID, event.Locale),
+				Data:     event,
+			}))
+		}),
+	}
+}
@@ -0,0 +1,206 @@
+// Package i18n translates notification text. Messages live in one JSON
+// catalog per locale; lookups walk a fallback chain from the requested
+// locale to its base language and finally the default locale, so a missing
+// translation degrades to the default language instead of failing.
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+var ErrMissingKey = errors.New("missing translation")
+
+// Message is one catalog entry. Plain strings have only "other"; entries
+// that depend on a count have a form per plural category ("one", "other",
+// ...) of the locale.
+type Message struct {
+	Forms map[string]string
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		m.Forms = map[string]string{"other": text}
+		return nil
+	}
+	var forms map[string]string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return fmt.Errorf("message must be a string or an object of plural forms: %w", err)
+	}
+	if _, ok := forms["other"]; !ok {
+		return errors.New(`plural message has no "other" form`)
+	}
+	m.Forms = forms
+	return nil
+}
+
+// IsPlural reports whether the message has forms beyond "other".
+func (m Message) IsPlural() bool {
+	return len(m.Forms) > 1
+}
+
+// Catalog holds the messages of every locale.
+type Catalog struct {
+	defaultLocale string
+	locales       map[string]map[string]Message
+}
+
+// Load reads <dir>/<locale>.json for every locale in fsys. The default
+// locale must be among them.
+func Load(fsys fs.FS, dir, defaultLocale string) (*Catalog, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{defaultLocale: Normalize(defaultLocale), locales: map[string]map[string]Message{}}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".json" {
+			continue
+		}
+		raw, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]Message
+		if err := json.Unmarshal(raw, &messages); err != nil {
+			return nil, fmt.Errorf("catalog %s: %w", name, err)
+		}
+		c.locales[Normalize(strings.TrimSuffix(name, ".json"))] = messages
+	}
+	if _, ok := c.locales[c.defaultLocale]; !ok {
+		return nil, fmt.Errorf("no catalog for the default locale %q in %s", defaultLocale, dir)
+	}
+	return c, nil
+}
+
+func (c *Catalog) DefaultLocale() string {
+	return c.defaultLocale
+}
+
+// Locales lists the locales with a catalog, sorted.
+func (c *Catalog) Locales() []string {
+	locales := make([]string, 0, len(c.locales))
+	for locale := range c.locales {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Keys lists the keys defined for locale, sorted.
+func (c *Catalog) Keys(locale string) []string {
+	messages := c.locales[Normalize(locale)]
+	keys := make([]string, 0, len(messages))
+	for key := range messages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Chain is the fallback chain for locale: the locale itself, its base
+// language and the default locale, without duplicates and limited to
+// locales that have a catalog. It is never empty.
+func (c *Catalog) Chain(locale string) []string {
+	locale = Normalize(locale)
+	var chain []string
+	seen := map[string]bool{}
+	for _, candidate := range []string{locale, Base(locale), c.defaultLocale} {
+		if _, ok := c.locales[candidate]; !ok || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		chain = append(chain, candidate)
+	}
+	return chain
+}
+
+// Resolve returns the first locale in the chain of locale that has a
+// catalog; templates are rendered in that locale.
+func (c *Catalog) Resolve(locale string) string {
+	return c.Chain(locale)[0]
+}
+
+// Lookup finds key along the fallback chain of locale and returns the
+// message with the locale it came from.
+func (c *Catalog) Lookup(locale, key string) (Message, string, error) {
+	for _, l := range c.Chain(locale) {
+		if m, ok := c.locales[l][key]; ok {
+			return m, l, nil
+		}
+	}
+	return Message{}, "", fmt.Errorf("%w: %q in %s", ErrMissingKey, key, strings.Join(c.Chain(locale), " > "))
+}
+
+// Translate returns key in locale with {name} placeholders replaced from
+// args, which alternate names and values.
+func (c *Catalog) Translate(locale, key string, args ...any) (string, error) {
+	m, from, err := c.Lookup(locale, key)
+	if err != nil {
+		return "", err
+	}
+	return interpolate(from, m.Forms["other"], args)
+}
+
+// Plural picks the form of key for count according to the plural rules of
+// the locale the message was found in; {count} is available as a
+// formatted number in addition to args.
+func (c *Catalog) Plural(locale, key string, count any, args ...any) (string, error) {
+	m, from, err := c.Lookup(locale, key)
+	if err != nil {
+		return "", err
+	}
+	n, ok := toFloat(count)
+	if !ok {
+		return "", fmt.Errorf("plural %q: count %v is not a number", key, count)
+	}
+	form, ok := m.Forms[PluralCategory(from, n)]
+	if !ok {
+		form = m.Forms["other"]
+	}
+	return interpolate(from, form, append([]any{"count", count}, args...))
+}
+
+// interpolate replaces {name} with the matching value from args. Numbers
+// and times are formatted for locale.
+func interpolate(locale, text string, args []any) (string, error) {
+	if len(args)%2 != 0 {
+		return "", fmt.Errorf("translation arguments must be name/value pairs, got %d values", len(args))
+	}
+	if !strings.Contains(text, "{") {
+		return text, nil
+	}
+	pairs := make([]string, 0, len(args))
+	for i := 0; i < len(args); i += 2 {
+		name, ok := args[i].(string)
+		if !ok {
+			return "", fmt.Errorf("translation argument name %v is not a string", args[i])
+		}
+		pairs = append(pairs, "{"+name+"}", formatValue(locale, args[i+1]))
+	}
+	return strings.NewReplacer(pairs...).Replace(text), nil
+}
+
+// Normalize canonicalises a language tag: "EN_us" becomes "en-US".
+func Normalize(locale string) string {
+	locale = strings.ReplaceAll(strings.TrimSpace(locale), "_", "-")
+	lang, region, found := strings.Cut(locale, "-")
+	lang = strings.ToLower(lang)
+	if !found {
+		return lang
+	}
+	return lang + "-" + strings.ToUpper(region)
+}
+
+// Base is the language part of a tag: "en-US" becomes "en".
+func Base(locale string) string {
+	lang, _, _ := strings.Cut(Normalize(locale), "-")
+	return lang
+}
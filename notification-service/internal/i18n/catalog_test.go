@@ -0,0 +1,131 @@
+package i18n
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	c, err := Load(fstest.MapFS{
+		"l/id.json":    {Data: []byte(`{"hello": "Halo {name}", "films": "{count} film", "only.id": "hanya"}`)},
+		"l/en.json":    {Data: []byte(`{"hello": "Hi {name}", "films": {"one": "{count} film", "other": "{count} films"}}`)},
+		"l/en-GB.json": {Data: []byte(`{"hello": "Hello {name}"}`)},
+	}, "l", "id")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return c
+}
+
+func TestChainFallsBackToBaseAndDefault(t *testing.T) {
+	c := testCatalog(t)
+	tests := map[string][]string{
+		"en_gb": {"en-GB", "en", "id"},
+		"en-US": {"en", "id"},
+		"id":    {"id"},
+		"fr":    {"id"},
+		"":      {"id"},
+	}
+	for locale, want := range tests {
+		if got := c.Chain(locale); !reflect.DeepEqual(got, want) {
+			t.Errorf("Chain(%q) = %v, want %v", locale, got, want)
+		}
+	}
+
+	if got, _ := c.Translate("en-GB", "hello", "name", "Budi"); got != "Hello Budi" {
+		t.Errorf("en-GB hello = %q", got)
+	}
+	if got, _ := c.Translate("en-GB", "only.id"); got != "hanya" {
+		t.Errorf("en-GB only.id = %q, want the default locale's", got)
+	}
+	if _, err := c.Translate("en", "nope"); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("want ErrMissingKey, got %v", err)
+	}
+}
+
+func TestPluralUsesTheRulesOfTheMessageLocale(t *testing.T) {
+	c := testCatalog(t)
+	tests := []struct {
+		locale string
+		count  any
+		want   string
+	}{
+		{"en", 1, "1 film"},
+		{"en", 0, "0 films"},
+		{"en", 2500, "2,500 films"},
+		{"id", 1, "1 film"},
+		{"id", 2500, "2.500 film"},
+	}
+	for _, tt := range tests {
+		got, err := c.Plural(tt.locale, "films", tt.count)
+		if err != nil || got != tt.want {
+			t.Errorf("Plural(%s, %v) = %q, %v; want %q", tt.locale, tt.count, got, err, tt.want)
+		}
+	}
+	if _, err := c.Plural("en", "films", "many"); err == nil {
+		t.Error("accepted a non-numeric count")
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	numbers := []struct {
+		locale string
+		n      any
+		want   string
+	}{
+		{"id", 1234567.5, "1.234.567,5"},
+		{"en", 1234567.5, "1,234,567.5"},
+		{"id", -1000, "-1.000"},
+		{"en", 999, "999"},
+		{"fr", 1000, "1,000"},
+	}
+	for _, tt := range numbers {
+		if got := FormatNumber(tt.locale, tt.n); got != tt.want {
+			t.Errorf("FormatNumber(%s, %v) = %q, want %q", tt.locale, tt.n, got, tt.want)
+		}
+	}
+
+	day := time.Date(2025, time.August, 17, 10, 0, 0, 0, time.UTC)
+	if got := FormatDate("id", day); got != "17 Agustus 2025" {
+		t.Errorf("id date = %q", got)
+	}
+	if got := FormatDate("en-US", day); got != "August 17, 2025" {
+		t.Errorf("en date = %q", got)
+	}
+}
+
+func TestCheckReportsGaps(t *testing.T) {
+	c := testCatalog(t)
+	report := c.Check([]Usage{
+		{Key: "hello", Source: "a/subject.txt"},
+		{Key: "films", Plural: true, Source: "a/body.txt"},
+		{Key: "missing", Source: "a/body.txt"},
+	})
+
+	got := map[string]bool{}
+	for _, p := range report.Problems {
+		got[p.Locale+" "+p.Kind+" "+p.Key] = true
+	}
+	want := []string{
+		"id missing missing",
+		"en missing missing",
+		"en-GB missing films",
+		"en-GB missing missing",
+		"id unused only.id",
+	}
+	for _, w := range want {
+		if !got[w] {
+			t.Errorf("report lacks %q: %+v", w, report.Problems)
+		}
+	}
+	if len(report.Problems) != len(want) {
+		t.Errorf("got %d problems, want %d: %+v", len(report.Problems), len(want), report.Problems)
+	}
+	if !report.Fatal() {
+		t.Error("a key missing from the default locale is not fatal")
+	}
+}
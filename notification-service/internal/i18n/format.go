@@ -0,0 +1,146 @@
+package i18n
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PluralCategory returns the CLDR plural category of n in locale.
+// Indonesian does not inflect for number, so it only has "other".
+func PluralCategory(locale string, n float64) string {
+	switch Base(locale) {
+	case "id", "ms", "ja", "ko", "zh", "th", "vi":
+		return "other"
+	default:
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}
+}
+
+// PluralForms lists the categories a plural message needs in locale.
+func PluralForms(locale string) []string {
+	if PluralCategory(locale, 1) == "other" {
+		return []string{"other"}
+	}
+	return []string{"one", "other"}
+}
+
+type numberSymbols struct {
+	group, decimal string
+}
+
+var symbols = map[string]numberSymbols{
+	"id": {group: ".", decimal: ","},
+	"en": {group: ",", decimal: "."},
+}
+
+func symbolsFor(locale string) numberSymbols {
+	if s, ok := symbols[Base(locale)]; ok {
+		return s
+	}
+	return symbols["en"]
+}
+
+// FormatNumber formats n with the digit grouping and decimal separator of
+// locale: 1234567.5 is "1.234.567,5" in Indonesian and "1,234,567.5" in
+// English. Integers are printed without decimals.
+func FormatNumber(locale string, n any) string {
+	f, ok := toFloat(n)
+	if !ok {
+		return fmt.Sprint(n)
+	}
+	s := symbolsFor(locale)
+
+	text := strconv.FormatFloat(math.Abs(f), 'f', -1, 64)
+	whole, frac, _ := strings.Cut(text, ".")
+	var b strings.Builder
+	if f < 0 {
+		b.WriteByte('-')
+	}
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(s.group)
+		}
+		b.WriteRune(digit)
+	}
+	if frac != "" {
+		b.WriteString(s.decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+var monthNames = map[string][12]string{
+	"id": {"Januari", "Februari", "Maret", "April", "Mei", "Juni", "Juli", "Agustus", "September", "Oktober", "November", "Desember"},
+	"en": {"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+}
+
+// FormatDate writes the calendar date of t the way locale does: "1 Juni
+// 2025" in Indonesian, "June 1, 2025" in English.
+func FormatDate(locale string, t time.Time) string {
+	lang := Base(locale)
+	months, ok := monthNames[lang]
+	if !ok {
+		lang, months = "en", monthNames["en"]
+	}
+	month := months[t.Month()-1]
+	if lang == "en" {
+		return fmt.Sprintf("%s %d, %d", month, t.Day(), t.Year())
+	}
+	return fmt.Sprintf("%d %s %d", t.Day(), month, t.Year())
+}
+
+// formatValue renders an interpolated value: numbers and times in the
+// conventions of locale, anything else with fmt.
+func formatValue(locale string, v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return FormatDate(locale, v)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return FormatDate(locale, *v)
+	}
+	if _, ok := toFloat(v); ok {
+		return FormatNumber(locale, v)
+	}
+	return fmt.Sprint(v)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,204 @@
+package i18n
+
+import (
+	"sort"
+	"text/template/parse"
+	"time"
+)
+
+// Template function names. Templates call them as
+//
+//	{{t "welcome.greeting" "name" .Username}}
+//	{{plural "watchlist.items" .Count}}
+//	{{date .RegisteredAt}}  {{number .Total}}
+const (
+	FuncTranslate = "t"
+	FuncPlural    = "plural"
+	FuncDate      = "date"
+	FuncNumber    = "number"
+	FuncLocale    = "locale"
+)
+
+// Funcs returns the template functions for rendering in locale. Pass it to
+// Funcs on a clone of the parsed template before executing it.
+func (c *Catalog) Funcs(locale string) map[string]any {
+	resolved := c.Resolve(locale)
+	return map[string]any{
+		FuncTranslate: func(key string, args ...any) (string, error) {
+			return c.Translate(resolved, key, args...)
+		},
+		FuncPlural: func(key string, count any, args ...any) (string, error) {
+			return c.Plural(resolved, key, count, args...)
+		},
+		FuncDate:   func(t time.Time) string { return FormatDate(resolved, t) },
+		FuncNumber: func(n any) string { return FormatNumber(resolved, n) },
+		FuncLocale: func() string { return resolved },
+	}
+}
+
+// StubFuncs has the signatures of Funcs for parsing templates before a
+// locale is known.
+func StubFuncs() map[string]any {
+	return map[string]any{
+		FuncTranslate: func(string, ...any) (string, error) { return "", nil },
+		FuncPlural:    func(string, any, ...any) (string, error) { return "", nil },
+		FuncDate:      func(time.Time) string { return "" },
+		FuncNumber:    func(any) string { return "" },
+		FuncLocale:    func() string { return "" },
+	}
+}
+
+// Usage records a translation key referenced by a template: whether it was
+// used with plural, and where.
+type Usage struct {
+	Key    string
+	Plural bool
+	Source string
+}
+
+// ExtractKeys finds the constant keys passed to t and plural in tree.
+// Keys built at runtime cannot be checked and are skipped.
+func ExtractKeys(source string, tree *parse.Tree) []Usage {
+	if tree == nil || tree.Root == nil {
+		return nil
+	}
+	var usages []Usage
+	var walk func(parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			if len(n.Args) >= 2 {
+				if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && (ident.Ident == FuncTranslate || ident.Ident == FuncPlural) {
+					if key, ok := n.Args[1].(*parse.StringNode); ok {
+						usages = append(usages, Usage{Key: key.Text, Plural: ident.Ident == FuncPlural, Source: source})
+					}
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		}
+	}
+	walk(tree.Root)
+	return usages
+}
+
+// Problem is one finding of Check.
+type Problem struct {
+	Locale string
+	Key    string
+	Kind   string
+	Detail string
+}
+
+const (
+	ProblemMissing       = "missing"
+	ProblemUnused        = "unused"
+	ProblemPluralForms   = "plural-forms"
+	ProblemNotPlural     = "not-plural"
+	ProblemMissingSource = "missing-in-default"
+)
+
+// Report is the result of checking a catalog against the keys templates
+// use.
+type Report struct {
+	DefaultLocale string
+	Problems      []Problem
+}
+
+// Fatal reports whether the default locale lacks a key. Other locales fall
+// back to it, so only a gap there can make a render fail.
+func (r Report) Fatal() bool {
+	for _, p := range r.Problems {
+		if p.Locale == r.DefaultLocale && p.Kind == ProblemMissing {
+			return true
+		}
+	}
+	return false
+}
+
+// Check compares every locale of the catalog with usages: keys a locale
+// does not translate (it falls back at runtime), keys no template uses,
+// plural messages lacking a form the locale needs, and keys used with
+// plural that are plain strings.
+func (c *Catalog) Check(usages []Usage) Report {
+	used := map[string]Usage{}
+	for _, u := range usages {
+		if prev, ok := used[u.Key]; ok {
+			u.Plural = u.Plural || prev.Plural
+			u.Source = prev.Source
+		}
+		used[u.Key] = u
+	}
+
+	report := Report{DefaultLocale: c.defaultLocale}
+	for _, locale := range c.Locales() {
+		messages := c.locales[locale]
+		for _, key := range sortedKeys(used) {
+			m, ok := messages[key]
+			if !ok {
+				report.Problems = append(report.Problems, Problem{Locale: locale, Key: key, Kind: ProblemMissing, Detail: "used in " + used[key].Source})
+				continue
+			}
+			if used[key].Plural {
+				for _, form := range PluralForms(locale) {
+					if _, ok := m.Forms[form]; !ok {
+						report.Problems = append(report.Problems, Problem{Locale: locale, Key: key, Kind: ProblemPluralForms, Detail: "no " + form + " form"})
+					}
+				}
+			} else if m.IsPlural() {
+				report.Problems = append(report.Problems, Problem{Locale: locale, Key: key, Kind: ProblemNotPlural, Detail: "plural message used with t"})
+			}
+		}
+		for _, key := range c.Keys(locale) {
+			if _, ok := used[key]; ok {
+				continue
+			}
+			if _, ok := c.locales[c.defaultLocale][key]; !ok && locale != c.defaultLocale {
+				report.Problems = append(report.Problems, Problem{Locale: locale, Key: key, Kind: ProblemMissingSource, Detail: "not in the default locale " + c.defaultLocale})
+				continue
+			}
+			report.Problems = append(report.Problems, Problem{Locale: locale, Key: key, Kind: ProblemUnused, Detail: "no template uses it"})
+		}
+	}
+	return report
+}
+
+func sortedKeys(m map[string]Usage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,80 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	done      bool
+	claimedAt time.Time
+}
+
+// MemoryStore keeps keys in process memory. It only deduplicates within
+// one process lifetime and is meant for tests and local development.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry), now: time.Now}
+}
+
+func memoryKey(consumer, key string) string { return consumer + "\x00" + key }
+
+func (s *MemoryStore) Claim(ctx context.Context, consumer, key string, lease time.Duration) (ClaimResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoryKey(consumer, key)
+	now := s.now()
+	if e, ok := s.entries[k]; ok {
+		if e.done {
+			return Duplicate, nil
+		}
+		if now.Sub(e.claimedAt) < lease {
+			return InProgress, nil
+		}
+	}
+	s.entries[k] = memoryEntry{claimedAt: now}
+	return Claimed, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, consumer, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoryKey(consumer, key)
+	e := s.entries[k]
+	e.done = true
+	s.entries[k] = e
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, consumer, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoryKey(consumer, key)
+	if e, ok := s.entries[k]; ok && !e.done {
+		delete(s.entries, k)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Cleanup(ctx context.Context, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for k, e := range s.entries {
+		if e.claimedAt.Before(cutoff) {
+			delete(s.entries, k)
+			n++
+		}
+	}
+	return n, nil
+}
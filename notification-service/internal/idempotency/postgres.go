@@ -0,0 +1,83 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps keys in the processed_events table (see
+// migrations/000001_create_processed_events).
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+// Claim inserts a 'processing' row, or takes over one whose lease has
+// expired. When neither happens the existing row says whether the key is
+// done or still being worked on.
+func (s *PostgresStore) Claim(ctx context.Context, consumer, key string, lease time.Duration) (ClaimResult, error) {
+	const claim = `
+		INSERT INTO processed_events (consumer, idempotency_key, status, claimed_at)
+		VALUES ($1, $2, 'processing', now())
+		ON CONFLICT (consumer, idempotency_key) DO UPDATE
+			SET claimed_at = now()
+			WHERE processed_events.status = 'processing'
+			  AND processed_events.claimed_at < now() - make_interval(secs => $3)
+		RETURNING 1`
+
+	var one int
+	err := s.pool.QueryRow(ctx, claim, consumer, key, lease.Seconds()).Scan(&one)
+	if err == nil {
+		return Claimed, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	var status string
+	err = s.pool.QueryRow(ctx,
+		`SELECT status FROM processed_events WHERE consumer = $1 AND idempotency_key = $2`,
+		consumer, key).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Released between the two statements; let the retry claim it.
+		return InProgress, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if status == "done" {
+		return Duplicate, nil
+	}
+	return InProgress, nil
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, consumer, key string) error {
+	_, err := s.pool.Exec(ctx,
+		`UPDATE processed_events SET status = 'done', completed_at = now()
+		 WHERE consumer = $1 AND idempotency_key = $2`,
+		consumer, key)
+	return err
+}
+
+func (s *PostgresStore) Release(ctx context.Context, consumer, key string) error {
+	_, err := s.pool.Exec(ctx,
+		`DELETE FROM processed_events
+		 WHERE consumer = $1 AND idempotency_key = $2 AND status = 'processing'`,
+		consumer, key)
+	return err
+}
+
+func (s *PostgresStore) Cleanup(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM processed_events WHERE claimed_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,145 @@
+// Package idempotency makes message handlers run once per event even though
+// RabbitMQ delivers at least once.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ClaimResult says what a consumer should do with a message.
+type ClaimResult int
+
+const (
+	// Claimed: nobody has handled this key; the caller now owns it.
+	Claimed ClaimResult = iota
+	// Duplicate: the key was already handled successfully.
+	Duplicate
+	// InProgress: another worker holds an unexpired claim on the key.
+	InProgress
+)
+
+// ErrInProgress is returned by Run when another worker is still handling the
+// same key; the message should be retried later rather than dropped.
+var ErrInProgress = errors.New("idempotency key is being processed by another worker")
+
+// Store records which idempotency keys each consumer has processed.
+// A claim that is neither completed nor released within its lease is
+// considered abandoned (the worker crashed) and may be claimed again.
+type Store interface {
+	Claim(ctx context.Context, consumer, key string, lease time.Duration) (ClaimResult, error)
+	Complete(ctx context.Context, consumer, key string) error
+	Release(ctx context.Context, consumer, key string) error
+	// Cleanup forgets keys claimed before cutoff and reports how many.
+	Cleanup(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// Stats are reported on the health endpoint.
+type Stats struct {
+	Processed            uint64 `json:"processed"`
+	DuplicatesSuppressed uint64 `json:"duplicates_suppressed"`
+	InProgressDeferred   uint64 `json:"in_progress_deferred"`
+	MissingKey           uint64 `json:"missing_key"`
+	StoreErrors          uint64 `json:"store_errors"`
+}
+
+// Deduplicator wraps handler calls with claim/complete bookkeeping.
+type Deduplicator struct {
+	store Store
+	lease time.Duration
+
+	processed  atomic.Uint64
+	duplicates atomic.Uint64
+	inProgress atomic.Uint64
+	missingKey atomic.Uint64
+	storeErrs  atomic.Uint64
+}
+
+// NewDeduplicator uses lease as the time after which an unfinished claim is
+// considered abandoned; it should exceed the handler timeout.
+func NewDeduplicator(store Store, lease time.Duration) *Deduplicator {
+	return &Deduplicator{store: store, lease: lease}
+}
+
+// Run calls fn unless consumer has already processed key. A duplicate
+// returns nil so the message is acked. If fn fails the claim is released so
+// that a retry can run it again. Messages without a key are handled
+// without deduplication.
+func (d *Deduplicator) Run(ctx context.Context, consumer, key string, fn func(context.Context) error) error {
+	if key == "" {
+		d.missingKey.Add(1)
+		return fn(ctx)
+	}
+
+	result, err := d.store.Claim(ctx, consumer, key, d.lease)
+	if err != nil {
+		d.storeErrs.Add(1)
+		return err
+	}
+	switch result {
+	case Duplicate:
+		d.duplicates.Add(1)
+		log.Printf("INFO: Suppressed duplicate %s on '%s'.", key, consumer)
+		return nil
+	case InProgress:
+		d.inProgress.Add(1)
+		return ErrInProgress
+	}
+
+	if err := fn(ctx); err != nil {
+		// Release outside ctx: a handler timeout must not leave the claim
+		// blocking retries until the lease runs out.
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if errRelease := d.store.Release(releaseCtx, consumer, key); errRelease != nil {
+			d.storeErrs.Add(1)
+			log.Printf("WARNING: Failed to release idempotency claim %s on '%s': %v", key, consumer, errRelease)
+		}
+		return err
+	}
+
+	d.processed.Add(1)
+	completeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := d.store.Complete(completeCtx, consumer, key); err != nil {
+		// The handler already ran; failing now would only cause a retry
+		// that repeats it. The claim stays until its lease expires.
+		d.storeErrs.Add(1)
+		log.Printf("WARNING: Failed to record %s as processed on '%s': %v", key, consumer, err)
+	}
+	return nil
+}
+
+func (d *Deduplicator) Stats() Stats {
+	return Stats{
+		Processed:            d.processed.Load(),
+		DuplicatesSuppressed: d.duplicates.Load(),
+		InProgressDeferred:   d.inProgress.Load(),
+		MissingKey:           d.missingKey.Load(),
+		StoreErrors:          d.storeErrs.Load(),
+	}
+}
+
+// StartCleanup deletes keys older than ttl every interval until ctx is done.
+func (d *Deduplicator) StartCleanup(ctx context.Context, ttl, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := d.store.Cleanup(ctx, time.Now().Add(-ttl))
+				if err != nil {
+					log.Printf("WARNING: Idempotency key cleanup failed: %v", err)
+				} else if n > 0 {
+					log.Printf("INFO: Removed %d idempotency keys older than %s.", n, ttl)
+				}
+			}
+		}
+	}()
+}
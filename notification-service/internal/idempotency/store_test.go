@@ -0,0 +1,89 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeduplicatorRunsHandlerOncePerKey(t *testing.T) {
+	d := NewDeduplicator(NewMemoryStore(), time.Minute)
+	ctx := context.Background()
+
+	calls := 0
+	handler := func(context.Context) error { calls++; return nil }
+
+	for i := 0; i < 3; i++ {
+		if err := d.Run(ctx, "q", "user.registered:1", handler); err != nil {
+			t.Fatalf("Run #%d: %v", i+1, err)
+		}
+	}
+	if err := d.Run(ctx, "other.q", "user.registered:1", handler); err != nil {
+		t.Fatalf("Run on another consumer: %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want once per consumer (2)", calls)
+	}
+	if s := d.Stats(); s.Processed != 2 || s.DuplicatesSuppressed != 2 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestDeduplicatorReleasesClaimOnFailure(t *testing.T) {
+	d := NewDeduplicator(NewMemoryStore(), time.Minute)
+	ctx := context.Background()
+	boom := errors.New("smtp down")
+
+	if err := d.Run(ctx, "q", "k", func(context.Context) error { return boom }); !errors.Is(err, boom) {
+		t.Fatalf("want handler error, got %v", err)
+	}
+
+	ran := false
+	if err := d.Run(ctx, "q", "k", func(context.Context) error { ran = true; return nil }); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if !ran {
+		t.Error("retry after a failure was suppressed as a duplicate")
+	}
+}
+
+func TestClaimLeaseExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if r, _ := store.Claim(ctx, "q", "k", time.Minute); r != Claimed {
+		t.Fatalf("first claim = %v", r)
+	}
+	if r, _ := store.Claim(ctx, "q", "k", time.Minute); r != InProgress {
+		t.Fatalf("second claim within lease = %v, want InProgress", r)
+	}
+
+	d := NewDeduplicator(store, time.Minute)
+	if err := d.Run(ctx, "q", "k", func(context.Context) error { return nil }); !errors.Is(err, ErrInProgress) {
+		t.Fatalf("Run during another worker's claim: want ErrInProgress, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if r, _ := store.Claim(ctx, "q", "k", time.Minute); r != Claimed {
+		t.Errorf("claim after the lease expired = %v, want Claimed", r)
+	}
+
+	if n, _ := store.Cleanup(ctx, now.Add(time.Second)); n != 1 {
+		t.Errorf("Cleanup removed %d keys, want 1", n)
+	}
+}
+
+func TestMessagesWithoutKeyAreNotDeduplicated(t *testing.T) {
+	d := NewDeduplicator(NewMemoryStore(), time.Minute)
+	calls := 0
+	for i := 0; i < 2; i++ {
+		d.Run(context.Background(), "q", "", func(context.Context) error { calls++; return nil })
+	}
+	if calls != 2 || d.Stats().MissingKey != 2 {
+		t.Errorf("calls=%d stats=%+v", calls, d.Stats())
+	}
+}
@@ -0,0 +1,177 @@
+// Package inbox persists the in-app notifications users see in the
+// product, newest first, with read state.
+package inbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound      = errors.New("notification not found")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+type Notification struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"-"`
+	// Category is a preference.Category; Type names what happened, e.g.
+	// the routing key of the event.
+	Category string          `json:"category"`
+	Type     string          `json:"type"`
+	Title    string          `json:"title"`
+	Body     string          `json:"body"`
+	Link     string          `json:"link,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	// CreatedAt is truncated to microseconds, the precision Postgres
+	// keeps, so cursors compare the same in every store.
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// Query selects a page of a user's notifications.
+type Query struct {
+	UnreadOnly bool
+	// Cursor is the NextCursor of the previous page; empty for the first.
+	Cursor string
+	Limit  int
+}
+
+type Page struct {
+	Items []Notification `json:"items"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Store holds notifications. Every method is scoped to one user: a
+// notification of another user is ErrNotFound.
+type Store interface {
+	Create(ctx context.Context, n Notification) error
+	List(ctx context.Context, userID uuid.UUID, q Query) (Page, error)
+	UnreadCount(ctx context.Context, userID uuid.UUID) (int, error)
+	// MarkRead is idempotent; it keeps the first read time.
+	MarkRead(ctx context.Context, userID, id uuid.UUID, at time.Time) error
+	// MarkAllRead marks every notification created up to before as read,
+	// so one that arrives while the user clicks stays unread.
+	MarkAllRead(ctx context.Context, userID uuid.UUID, before, at time.Time) (int64, error)
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+	// Since returns up to limit notifications that come after the position
+	// after (see Notification.Position), oldest first.
+	Since(ctx context.Context, userID uuid.UUID, after string, limit int) ([]Notification, error)
+}
+
+// New prepares a notification for Create: it assigns an ID and the
+// creation time.
+func New(userID uuid.UUID, category, typ, title, body string) Notification {
+	return Notification{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Category:  category,
+		Type:      typ,
+		Title:     title,
+		Body:      body,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+}
+
+// Position is where n sits in its user's inbox. It serves as a page
+// cursor and as the event ID of the notification stream, from which a
+// client resumes with Since.
+func (n Notification) Position() string {
+	return encodeCursor(n)
+}
+
+// Normalize clamps the page size into [1, MaxPageSize].
+func (q Query) Normalize() Query {
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+	return q
+}
+
+// Pages are ordered by (created_at, id) descending. A cursor is the
+// position of the last item of a page, so it stays valid when new
+// notifications arrive or old ones are deleted.
+type cursor struct {
+	createdAt time.Time
+	id        uuid.UUID
+}
+
+func encodeCursor(n Notification) string {
+	raw := strconv.FormatInt(n.CreatedAt.UnixMicro(), 10) + "|" + n.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	micros, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	us, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor{createdAt: time.UnixMicro(us).UTC(), id: uid}, nil
+}
+
+// newer reports whether the notification at (aTime, aID) comes before
+// the one at (bTime, bID) in a page. IDs compare bytewise, as Postgres
+// compares UUIDs.
+func newer(aTime time.Time, aID uuid.UUID, bTime time.Time, bID uuid.UUID) bool {
+	if !aTime.Equal(bTime) {
+		return aTime.After(bTime)
+	}
+	return bytes.Compare(aID[:], bID[:]) > 0
+}
+
+// precedes reports whether n comes after the cursor in time, the reverse
+// of admits; a nil cursor precedes everything.
+func (c *cursor) precedes(n Notification) bool {
+	return c == nil || newer(n.CreatedAt, n.ID, c.createdAt, c.id)
+}
+
+// admits reports whether n belongs on a page after the cursor.
+func (c *cursor) admits(n Notification) bool {
+	return c == nil || newer(c.createdAt, c.id, n.CreatedAt, n.ID)
+}
+
+// page cuts items, fetched with one extra row, to q.Limit and sets the
+// cursor for the next page.
+func page(items []Notification, limit int) Page {
+	p := Page{Items: items}
+	if len(items) > limit {
+		p.Items = items[:limit]
+		p.NextCursor = encodeCursor(p.Items[limit-1])
+	}
+	if p.Items == nil {
+		p.Items = []Notification{}
+	}
+	return p
+}
@@ -0,0 +1,131 @@
+package inbox
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps notifications in process memory, for tests and local
+// development without a database.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]map[uuid.UUID]Notification
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{users: make(map[uuid.UUID]map[uuid.UUID]Notification)}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, n Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users[n.UserID] == nil {
+		s.users[n.UserID] = map[uuid.UUID]Notification{}
+	}
+	s.users[n.UserID][n.ID] = n
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, userID uuid.UUID, q Query) (Page, error) {
+	q = q.Normalize()
+	after, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return Page{}, err
+	}
+
+	s.mu.RLock()
+	var items []Notification
+	for _, n := range s.users[userID] {
+		if (q.UnreadOnly && n.ReadAt != nil) || !after.admits(n) {
+			continue
+		}
+		items = append(items, n)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return newer(items[i].CreatedAt, items[i].ID, items[j].CreatedAt, items[j].ID)
+	})
+	if len(items) > q.Limit+1 {
+		items = items[:q.Limit+1]
+	}
+	return page(items, q.Limit), nil
+}
+
+func (s *MemoryStore) UnreadCount(ctx context.Context, userID uuid.UUID) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	count := 0
+	for _, n := range s.users[userID] {
+		if n.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) MarkRead(ctx context.Context, userID, id uuid.UUID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.users[userID][id]
+	if !ok {
+		return ErrNotFound
+	}
+	if n.ReadAt == nil {
+		n.ReadAt = &at
+		s.users[userID][id] = n
+	}
+	return nil
+}
+
+func (s *MemoryStore) MarkAllRead(ctx context.Context, userID uuid.UUID, before, at time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var updated int64
+	for id, n := range s.users[userID] {
+		if n.ReadAt == nil && !n.CreatedAt.After(before) {
+			n.ReadAt = &at
+			s.users[userID][id] = n
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID][id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.users[userID], id)
+	return nil
+}
+
+func (s *MemoryStore) Since(ctx context.Context, userID uuid.UUID, after string, limit int) ([]Notification, error) {
+	from, err := decodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	var items []Notification
+	for _, n := range s.users[userID] {
+		if from.precedes(n) {
+			items = append(items, n)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return newer(items[j].CreatedAt, items[j].ID, items[i].CreatedAt, items[i].ID)
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
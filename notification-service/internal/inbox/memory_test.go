@@ -0,0 +1,130 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestListPagesNewestFirst(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	userID := uuid.New()
+	base := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		n := New(userID, "social", "test", "n", "")
+		// Two notifications share a timestamp to exercise the ID tiebreak.
+		n.CreatedAt = base.Add(time.Duration(i/2*2) * time.Minute)
+		store.Create(ctx, n)
+		ids = append(ids, n.ID)
+	}
+	store.Create(ctx, New(uuid.New(), "social", "test", "someone else's", ""))
+
+	var seen []uuid.UUID
+	q := Query{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not end")
+		}
+		page, err := store.List(ctx, userID, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range page.Items {
+			seen = append(seen, n.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if len(seen) != 5 {
+		t.Fatalf("saw %d notifications, want 5", len(seen))
+	}
+	unique := map[uuid.UUID]bool{}
+	for _, id := range seen {
+		unique[id] = true
+	}
+	if len(unique) != 5 {
+		t.Errorf("pages overlapped: %v", seen)
+	}
+
+	if _, err := store.List(ctx, userID, Query{Cursor: "%%%"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("want ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestReadState(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	userID := uuid.New()
+	first, second := New(userID, "social", "test", "1", ""), New(userID, "social", "test", "2", "")
+	store.Create(ctx, first)
+	store.Create(ctx, second)
+	now := time.Now()
+
+	if err := store.MarkRead(ctx, userID, first.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MarkRead(ctx, uuid.New(), second.ID, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("marked another user's notification: %v", err)
+	}
+	if n, _ := store.UnreadCount(ctx, userID); n != 1 {
+		t.Errorf("unread = %d, want 1", n)
+	}
+	page, _ := store.List(ctx, userID, Query{UnreadOnly: true})
+	if len(page.Items) != 1 || page.Items[0].ID != second.ID {
+		t.Errorf("unread page = %+v", page.Items)
+	}
+
+	late := New(userID, "social", "test", "late", "")
+	late.CreatedAt = now.Add(time.Minute)
+	store.Create(ctx, late)
+	if updated, _ := store.MarkAllRead(ctx, userID, now, now); updated != 1 {
+		t.Errorf("MarkAllRead updated %d, want 1", updated)
+	}
+	if n, _ := store.UnreadCount(ctx, userID); n != 1 {
+		t.Errorf("a notification newer than the request was marked read")
+	}
+
+	if err := store.Delete(ctx, userID, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, userID, first.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete: %v", err)
+	}
+}
+
+func TestSinceResumesOldestFirst(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	userID := uuid.New()
+	base := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	var all []Notification
+	for i := 0; i < 4; i++ {
+		n := New(userID, "social", "test", "n", "")
+		n.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		store.Create(ctx, n)
+		all = append(all, n)
+	}
+
+	items, err := store.Since(ctx, userID, all[1].Position(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].ID != all[2].ID || items[1].ID != all[3].ID {
+		t.Errorf("Since = %+v, want the last two oldest first", items)
+	}
+	if items, _ := store.Since(ctx, userID, "", 3); len(items) != 3 || items[0].ID != all[0].ID {
+		t.Errorf("Since from the start = %+v", items)
+	}
+	if _, err := store.Since(ctx, userID, "%%%", 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("want ErrInvalidCursor, got %v", err)
+	}
+}
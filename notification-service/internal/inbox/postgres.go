@@ -0,0 +1,162 @@
+package inbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps notifications in the notifications table (see
+// migrations/000004_create_notifications).
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+const selectNotificationColumns = `id, user_id, category, type, title, body, link, data, created_at, read_at`
+
+func scanNotification(row pgx.Row) (Notification, error) {
+	var n Notification
+	var data []byte
+	err := row.Scan(&n.ID, &n.UserID, &n.Category, &n.Type, &n.Title, &n.Body, &n.Link, &data, &n.CreatedAt, &n.ReadAt)
+	if len(data) > 0 {
+		n.Data = data
+	}
+	return n, err
+}
+
+func (s *PostgresStore) Create(ctx context.Context, n Notification) error {
+	var data []byte
+	if len(n.Data) > 0 {
+		data = n.Data
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO notifications (id, user_id, category, type, title, body, link, data, created_at, read_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO NOTHING`,
+		n.ID, n.UserID, n.Category, n.Type, n.Title, n.Body, n.Link, data, n.CreatedAt, n.ReadAt)
+	return err
+}
+
+// List reads one row more than the page size to learn whether there is a
+// next page. The row comparison uses idx_notifications_user_created.
+func (s *PostgresStore) List(ctx context.Context, userID uuid.UUID, q Query) (Page, error) {
+	q = q.Normalize()
+	after, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return Page{}, err
+	}
+	var afterTime *time.Time
+	var afterID *uuid.UUID
+	if after != nil {
+		afterTime, afterID = &after.createdAt, &after.id
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+selectNotificationColumns+` FROM notifications
+		WHERE user_id = $1
+		  AND ($2::boolean IS FALSE OR read_at IS NULL)
+		  AND ($3::timestamptz IS NULL OR (created_at, id) < ($3, $4::uuid))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5`,
+		userID, q.UnreadOnly, afterTime, afterID, q.Limit+1)
+	if err != nil {
+		return Page{}, err
+	}
+	defer rows.Close()
+
+	var items []Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return Page{}, err
+		}
+		items = append(items, n)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, err
+	}
+	return page(items, q.Limit), nil
+}
+
+func (s *PostgresStore) UnreadCount(ctx context.Context, userID uuid.UUID) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx,
+		`SELECT count(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) MarkRead(ctx context.Context, userID, id uuid.UUID, at time.Time) error {
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE notifications SET read_at = COALESCE(read_at, $3) WHERE user_id = $1 AND id = $2`,
+		userID, id, at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) MarkAllRead(ctx context.Context, userID uuid.UUID, before, at time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE notifications SET read_at = $3
+		 WHERE user_id = $1 AND read_at IS NULL AND created_at <= $2`,
+		userID, before, at)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM notifications WHERE user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) Since(ctx context.Context, userID uuid.UUID, after string, limit int) ([]Notification, error) {
+	from, err := decodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+	var fromTime *time.Time
+	var fromID *uuid.UUID
+	if from != nil {
+		fromTime, fromID = &from.createdAt, &from.id
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+selectNotificationColumns+` FROM notifications
+		WHERE user_id = $1
+		  AND ($2::timestamptz IS NULL OR (created_at, id) > ($2, $3::uuid))
+		ORDER BY created_at, id
+		LIMIT $4`,
+		userID, fromTime, fromID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,498 @@
+// Package notify decides whether and how a notification reaches a user.
+// Handlers describe what happened and to whom; the Dispatcher applies the
+// user's preferences and hands the notification to the channel.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/deliverylog"
+	"github.com/virhanali/filmnesia/notification-service/internal/digest"
+	"github.com/virhanali/filmnesia/notification-service/internal/email"
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+	"github.com/virhanali/filmnesia/notification-service/internal/profile"
+	"github.com/virhanali/filmnesia/notification-service/internal/ratelimit"
+	"github.com/virhanali/filmnesia/notification-service/internal/schedule"
+	"github.com/virhanali/filmnesia/notification-service/internal/stream"
+)
+
+// UnsubscribePath is where unsubscribe tokens are redeemed, relative to the
+// public base URL.
+const UnsubscribePath = "/api/v1/notifications/unsubscribe"
+
+var (
+	// ErrNotResendable is returned by Resend for entries without an email,
+	// such as in-app notifications and suppressed emails, and for emails
+	// of templates that cannot be rendered again.
+	ErrNotResendable = errors.New("only emails that were sent or attempted can be resent")
+	// ErrNoRecipient is returned by Resend when the user's current address
+	// is not known.
+	ErrNoRecipient = errors.New("the user's email address is not known")
+	// ErrSuppressed is returned by Resend when the user has turned the
+	// category off since.
+	ErrSuppressed = errors.New("the user has turned this category off")
+)
+
+// Email is a notification sent by email. EventID, when set, is the event
+// it was caused by; retries of the event share a delivery log entry.
+type Email struct {
+	EventID  string
+	UserID   uuid.UUID
+	Category preference.Category
+	Template string
+	Locale   string
+	To       string
+	Data     any
+}
+
+// InApp is a notification stored in the user's inbox. Template names the
+// template whose inapp.txt renders it.
+type InApp struct {
+	EventID  string
+	UserID   uuid.UUID
+	Category preference.Category
+	Template string
+	Locale   string
+	Data     any
+	// Link is where the client takes the user when they open it.
+	Link string
+}
+
+// ChannelStats count what the dispatcher did on one channel. Digested
+// and Deferred count emails held back for a digest or until quiet hours
+// end, and Throttled notifications held back by a rate limit; they are
+// counted as Sent when they go out.
+type ChannelStats struct {
+	Sent       int64 `json:"sent"`
+	Suppressed int64 `json:"suppressed"`
+	Digested   int64 `json:"digested"`
+	Deferred   int64 `json:"deferred"`
+	Throttled  int64 `json:"throttled"`
+}
+
+type Stats map[preference.Channel]ChannelStats
+
+type counters struct {
+	sent, suppressed, digested, deferred, throttled atomic.Int64
+}
+
+// Rate limit scopes, as reported by ratelimit.Error.
+const (
+	LimitRecipient = "recipient"
+	LimitTemplate  = "template"
+	LimitChannel   = "channel"
+)
+
+// RateLimits cap how fast notifications go out; zero limits are not
+// enforced. Recipient limits the emails one address receives, and
+// Template the emails of one template to one address unless Templates
+// has a limit for that template. Channels limit everything sent on a
+// channel. All of them are enforced by this replica alone; give it its
+// share of a limit meant for all of them (ratelimit.Limit.Share).
+type RateLimits struct {
+	Recipient ratelimit.Limit
+	Template  ratelimit.Limit
+	Templates map[string]ratelimit.Limit
+	Channels  map[preference.Channel]ratelimit.Limit
+}
+
+// Config wires a Dispatcher to the channels and the preferences store.
+type Config struct {
+	Mailer      *email.Mailer
+	Inbox       inbox.Store
+	Preferences preference.Store
+	Signer      *preference.Signer
+	// Stream, when set, pushes new in-app notifications to the user's
+	// open connections.
+	Stream stream.Publisher
+	// PublicURL is the address users reach the API through (the
+	// gateway); unsubscribe links point there.
+	PublicURL string
+	// DeliveryLog, when set, records the outcome of every notification.
+	// Addresses are only stored as HashRecipient under RecipientKey.
+	DeliveryLog  deliverylog.Store
+	RecipientKey []byte
+	// Profiles, when set, supplies the current address of a user an email
+	// is resent to.
+	Profiles profile.Store
+	// RateLimits throttle sends with a *ratelimit.Error, which the
+	// consumer and the scheduler retry once the limit allows.
+	RateLimits RateLimits
+
+	// Scheduler, when set, holds back emails that can wait: into digests,
+	// and until the user's quiet hours end. Schedules and Digests must be
+	// set with it. Call RegisterJobs before starting the scheduler.
+	Scheduler *schedule.Scheduler
+	Schedules preference.ScheduleStore
+	Digests   digest.Store
+	// TimeZone is the time zone of users who did not choose one. Digests
+	// are sent at DigestHour o'clock in the user's time zone, weekly ones
+	// on DigestWeekday.
+	TimeZone      *time.Location
+	DigestHour    int
+	DigestWeekday time.Weekday
+}
+
+type Dispatcher struct {
+	mailer      *email.Mailer
+	inbox       inbox.Store
+	preferences preference.Store
+	signer      *preference.Signer
+	stream      stream.Publisher
+	publicURL   string
+	log         deliverylog.Store
+	hashKey     []byte
+	profiles    profile.Store
+	limits      RateLimits
+	limiter     *ratelimit.Limiter
+
+	scheduler     *schedule.Scheduler
+	schedules     preference.ScheduleStore
+	digests       digest.Store
+	timeZone      *time.Location
+	digestHour    int
+	digestWeekday time.Weekday
+	now           func() time.Time
+
+	stats map[preference.Channel]*counters
+}
+
+func NewDispatcher(cfg Config) *Dispatcher {
+	d := &Dispatcher{
+		mailer:      cfg.Mailer,
+		inbox:       cfg.Inbox,
+		preferences: cfg.Preferences,
+		signer:      cfg.Signer,
+		stream:      cfg.Stream,
+		publicURL:   strings.TrimSuffix(cfg.PublicURL, "/"),
+		log:         cfg.DeliveryLog,
+		hashKey:     cfg.RecipientKey,
+		profiles:    cfg.Profiles,
+		limits:      cfg.RateLimits,
+		limiter:     ratelimit.NewLimiter(),
+
+		scheduler:     cfg.Scheduler,
+		schedules:     cfg.Schedules,
+		digests:       cfg.Digests,
+		timeZone:      cfg.TimeZone,
+		digestHour:    cfg.DigestHour,
+		digestWeekday: cfg.DigestWeekday,
+		now:           time.Now,
+
+		stats: map[preference.Channel]*counters{},
+	}
+	if d.timeZone == nil {
+		d.timeZone = time.UTC
+	}
+	for _, channel := range preference.Channels {
+		d.stats[channel] = &counters{}
+	}
+	return d
+}
+
+// allowed loads the preferences of userID and reports whether category
+// may be sent on channel, counting a suppression when not.
+func (d *Dispatcher) allowed(ctx context.Context, userID uuid.UUID, category preference.Category, channel preference.Channel, template string) (bool, error) {
+	if !category.Valid() {
+		return false, fmt.Errorf("%w: %q", preference.ErrUnknownCategory, category)
+	}
+	prefs, err := preference.Load(ctx, d.preferences, userID)
+	if err != nil {
+		return false, fmt.Errorf("load preferences of %s: %w", userID, err)
+	}
+	if !prefs.Allows(category, channel) {
+		d.stats[channel].suppressed.Add(1)
+		log.Printf("INFO: Not sending %s to UserID %s: %s is turned off on %s", template, userID, category, channel)
+		return false, nil
+	}
+	return true, nil
+}
+
+// SendEmail delivers n unless the user turned its category off for email.
+// A suppressed notification is not an error. Non-transactional emails
+// carry a one-click unsubscribe link for their category, and with a
+// scheduler go into the user's digest or wait out their quiet hours.
+func (d *Dispatcher) SendEmail(ctx context.Context, n Email) error {
+	entry := d.logEntry(n.EventID, n.UserID, preference.ChannelEmail, n.Category, n.Template, n.To)
+	ok, err := d.allowed(ctx, n.UserID, n.Category, preference.ChannelEmail, n.Template)
+	if !ok {
+		if err == nil {
+			d.record(ctx, entry, deliverylog.StatusSuppressed, "turned off by the user")
+		}
+		return err
+	}
+	if d.scheduler != nil && !n.Category.Transactional() {
+		held, err := d.holdBack(ctx, n, entry)
+		if held || err != nil {
+			return err
+		}
+	}
+
+	_, err = d.send(ctx, entry, d.mail(n))
+	return err
+}
+
+// send composes mail and delivers it. A mail that does not render is
+// recorded as failed.
+func (d *Dispatcher) send(ctx context.Context, entry deliverylog.Entry, mail email.Mail) (deliverylog.Entry, error) {
+	msg, err := d.mailer.Compose(mail)
+	if err != nil {
+		entry.Attempts = 1
+		return d.record(ctx, entry, deliverylog.StatusFailed, err.Error()), err
+	}
+	entry.Locale, entry.Data = mail.Locale, d.resendData(mail.Template, mail.Data)
+	return d.deliver(ctx, entry, msg)
+}
+
+// deliver sends msg and records the attempt in the log entry.
+func (d *Dispatcher) deliver(ctx context.Context, entry deliverylog.Entry, msg *email.Message) (deliverylog.Entry, error) {
+	var to string
+	if len(msg.To) > 0 {
+		to = msg.To[0]
+	}
+	if err := d.throttle(preference.ChannelEmail, entry.Template, to); err != nil {
+		return d.record(ctx, entry, deliverylog.StatusThrottled, err.Error()), err
+	}
+	entry.Attempts = 1
+	if err := d.mailer.Deliver(ctx, msg); err != nil {
+		return d.record(ctx, entry, deliverylog.StatusFailed, err.Error()), err
+	}
+	d.stats[preference.ChannelEmail].sent.Add(1)
+	return d.record(ctx, entry, deliverylog.StatusSent, "accepted"), nil
+}
+
+func (d *Dispatcher) mail(n Email) email.Mail {
+	mail := email.Mail{Template: n.Template, Locale: n.Locale, To: n.To, Data: n.Data}
+	if !n.Category.Transactional() {
+		mail.UnsubscribeURL = d.UnsubscribeURL(n.UserID, n.Category, preference.ChannelEmail)
+	}
+	return mail
+}
+
+// SendInApp renders n in the user's locale and stores it in their inbox,
+// unless they turned its category off in-app. A template that does not
+// render is a permanent failure. Pushing it to open connections is best
+// effort: the inbox is what clients resume from.
+func (d *Dispatcher) SendInApp(ctx context.Context, n InApp) error {
+	entry := d.logEntry(n.EventID, n.UserID, preference.ChannelInApp, n.Category, n.Template, "")
+	ok, err := d.allowed(ctx, n.UserID, n.Category, preference.ChannelInApp, n.Template)
+	if !ok {
+		if err == nil {
+			d.record(ctx, entry, deliverylog.StatusSuppressed, "turned off by the user")
+		}
+		return err
+	}
+
+	if err := d.throttle(preference.ChannelInApp, n.Template, ""); err != nil {
+		d.record(ctx, entry, deliverylog.StatusThrottled, err.Error())
+		return err
+	}
+	entry.Attempts = 1
+	content, err := d.mailer.Renderer().RenderInApp(n.Template, n.Locale, n.Data)
+	if err != nil {
+		d.record(ctx, entry, deliverylog.StatusFailed, err.Error())
+		return email.Invalid(err)
+	}
+	item := inbox.New(n.UserID, string(n.Category), n.Template, content.Title, content.Body)
+	item.Link = n.Link
+	if err := d.inbox.Create(ctx, item); err != nil {
+		d.record(ctx, entry, deliverylog.StatusFailed, err.Error())
+		return fmt.Errorf("store in-app notification: %w", err)
+	}
+	d.stats[preference.ChannelInApp].sent.Add(1)
+	d.record(ctx, entry, deliverylog.StatusSent, "stored as "+item.ID.String())
+	if d.stream != nil {
+		if err := d.stream.Publish(ctx, stream.Event{UserID: n.UserID, Notification: item}); err != nil {
+			log.Printf("WARNING: Failed to push notification %s to the streams of UserID %s: %v", item.ID, n.UserID, err)
+		}
+	}
+	return nil
+}
+
+// Resend renders the email of a delivery log entry again, to the user's
+// current address, and records it as a new entry. Unless the email is
+// transactional, the user's preferences are checked again.
+func (d *Dispatcher) Resend(ctx context.Context, id uuid.UUID) (deliverylog.Entry, error) {
+	if d.log == nil {
+		return deliverylog.Entry{}, deliverylog.ErrNotFound
+	}
+	orig, err := d.log.Get(ctx, id)
+	if err != nil {
+		return deliverylog.Entry{}, err
+	}
+	newData, ok := resendable[orig.Template]
+	if !ok || !orig.Resendable() {
+		return orig, ErrNotResendable
+	}
+	data := newData()
+	if err := json.Unmarshal(orig.Data, data); err != nil {
+		return deliverylog.Entry{}, fmt.Errorf("decode the email of delivery %s: %w", orig.ID, err)
+	}
+	to, err := d.recipient(ctx, orig.UserID)
+	if err != nil {
+		return deliverylog.Entry{}, err
+	}
+
+	category := preference.Category(orig.Category)
+	entry := d.logEntry("", orig.UserID, preference.ChannelEmail, category, orig.Template, to)
+	entry.EventID, entry.ResendOf = orig.EventID, &orig.ID
+	ok, err = d.allowed(ctx, orig.UserID, category, preference.ChannelEmail, orig.Template)
+	if err != nil {
+		return deliverylog.Entry{}, err
+	}
+	if !ok {
+		return d.record(ctx, entry, deliverylog.StatusSuppressed, "turned off by the user"), ErrSuppressed
+	}
+	log.Printf("INFO: Resending %s of delivery %s to UserID %s", orig.Template, orig.ID, orig.UserID)
+	return d.send(ctx, entry, d.mail(Email{
+		UserID:   orig.UserID,
+		Category: category,
+		Template: orig.Template,
+		Locale:   orig.Locale,
+		To:       to,
+		Data:     data,
+	}))
+}
+
+// recipient returns the current address of userID from the profile
+// replica.
+func (d *Dispatcher) recipient(ctx context.Context, userID uuid.UUID) (string, error) {
+	if d.profiles == nil {
+		return "", ErrNoRecipient
+	}
+	p, err := d.profiles.Get(ctx, userID)
+	switch {
+	case errors.Is(err, profile.ErrNotFound):
+		return "", ErrNoRecipient
+	case err != nil:
+		return "", fmt.Errorf("load profile of %s: %w", userID, err)
+	case p.Email == "":
+		return "", ErrNoRecipient
+	}
+	return p.Email, nil
+}
+
+// resendable maps the templates whose emails can be resent to a new value
+// of the data they are rendered with, for decoding the data a delivery log
+// entry holds.
+var resendable = map[string]func() any{
+	contracts.EventUserRegistered: func() any { return &contracts.UserRegisteredEvent{} },
+	DigestTemplate:                func() any { return &Digest{} },
+}
+
+// resendData encodes the data of an email for its delivery log entry, or
+// returns nil when it could not be resent anyway.
+func (d *Dispatcher) resendData(template string, data any) json.RawMessage {
+	if _, ok := resendable[template]; !ok || d.log == nil {
+		return nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("WARNING: Failed to encode the data of %s for resending: %v", template, err)
+		return nil
+	}
+	return raw
+}
+
+// throttle takes a token for a notification on channel, or returns the
+// *ratelimit.Error to try it again after. Emails to an address are also
+// limited per recipient and per template.
+func (d *Dispatcher) throttle(channel preference.Channel, template, to string) error {
+	rules := []ratelimit.Rule{{Scope: LimitChannel, Key: string(channel), Limit: d.limits.Channels[channel]}}
+	if channel == preference.ChannelEmail && to != "" {
+		recipient := strings.ToLower(strings.TrimSpace(to))
+		limit, ok := d.limits.Templates[template]
+		if !ok {
+			limit = d.limits.Template
+		}
+		rules = append(rules,
+			ratelimit.Rule{Scope: LimitRecipient, Key: recipient, Limit: d.limits.Recipient},
+			ratelimit.Rule{Scope: LimitTemplate, Key: template + " " + recipient, Limit: limit})
+	}
+	if err := d.limiter.Take(rules...); err != nil {
+		d.stats[channel].throttled.Add(1)
+		log.Printf("WARNING: Throttling %s on %s: %v", template, channel, err)
+		return err
+	}
+	return nil
+}
+
+// RateLimitStats counts the sends throttled per scope.
+func (d *Dispatcher) RateLimitStats() ratelimit.Stats {
+	return d.limiter.Stats()
+}
+
+// logEntry starts the delivery log entry of a notification. Entries of
+// the same event share a key, so that its retries update one entry.
+func (d *Dispatcher) logEntry(eventID string, userID uuid.UUID, channel preference.Channel, category preference.Category, template, to string) deliverylog.Entry {
+	key := uuid.NewString()
+	if eventID != "" {
+		key = strings.Join([]string{eventID, userID.String(), string(channel), template}, "/")
+	}
+	now := d.now().UTC()
+	return deliverylog.Entry{
+		ID:            uuid.New(),
+		Key:           key,
+		UserID:        userID,
+		EventID:       eventID,
+		Channel:       string(channel),
+		Category:      string(category),
+		Template:      template,
+		RecipientHash: d.HashRecipient(to),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// HashRecipient is how delivery log entries identify an address; search
+// them by it.
+func (d *Dispatcher) HashRecipient(address string) string {
+	return deliverylog.HashRecipient(d.hashKey, address)
+}
+
+// record writes entry with its outcome to the delivery log and returns it
+// as stored. It is best effort: a log that cannot be written must not
+// fail, and so repeat, a delivery.
+func (d *Dispatcher) record(ctx context.Context, entry deliverylog.Entry, status deliverylog.Status, response string) deliverylog.Entry {
+	if d.log == nil {
+		return entry
+	}
+	entry.Status, entry.Response = status, response
+	stored, err := d.log.Record(ctx, entry)
+	if err != nil {
+		log.Printf("WARNING: Failed to record the %s delivery of %s to UserID %s: %v", entry.Channel, entry.Template, entry.UserID, err)
+		return entry
+	}
+	return stored
+}
+
+// UnsubscribeURL is a signed link that turns category off on channel for
+// userID.
+func (d *Dispatcher) UnsubscribeURL(userID uuid.UUID, category preference.Category, channel preference.Channel) string {
+	return d.publicURL + UnsubscribePath + "?token=" + url.QueryEscape(d.signer.Sign(userID, category, channel))
+}
+
+func (d *Dispatcher) Stats() Stats {
+	stats := Stats{}
+	for channel, c := range d.stats {
+		stats[channel] = ChannelStats{
+			Sent:       c.sent.Load(),
+			Suppressed: c.suppressed.Load(),
+			Digested:   c.digested.Load(),
+			Deferred:   c.deferred.Load(),
+			Throttled:  c.throttled.Load(),
+		}
+	}
+	return stats
+}
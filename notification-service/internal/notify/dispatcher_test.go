@@ -0,0 +1,425 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/notification-service/internal/deliverylog"
+	"github.com/virhanali/filmnesia/notification-service/internal/digest"
+	"github.com/virhanali/filmnesia/notification-service/internal/email"
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+	"github.com/virhanali/filmnesia/notification-service/internal/profile"
+	"github.com/virhanali/filmnesia/notification-service/internal/ratelimit"
+	"github.com/virhanali/filmnesia/notification-service/internal/schedule"
+	"github.com/virhanali/filmnesia/notification-service/internal/stream"
+)
+
+type recordingSender struct {
+	mu   sync.Mutex
+	sent []*email.Message
+	// err, when set, fails every send.
+	err error
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg *email.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func (s *recordingSender) Close() error { return nil }
+
+type testDispatcher struct {
+	*Dispatcher
+	sender   *recordingSender
+	store    preference.Store
+	inbox    inbox.Store
+	log      *deliverylog.MemoryStore
+	profiles *profile.MemoryStore
+	signer   *preference.Signer
+	hub      *stream.Hub
+}
+
+func newTestDispatcher(t *testing.T) testDispatcher {
+	t.Helper()
+	renderer, err := email.NewRenderer(contracts.DefaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	td := testDispatcher{
+		sender:   &recordingSender{},
+		store:    preference.NewMemoryStore(),
+		inbox:    inbox.NewMemoryStore(),
+		log:      deliverylog.NewMemoryStore(),
+		profiles: profile.NewMemoryStore(),
+		signer:   preference.NewSigner([]byte("secret")),
+		hub:      stream.NewHub(0),
+	}
+	td.Dispatcher = NewDispatcher(Config{
+		Mailer:       email.NewMailer(renderer, td.sender, "no-reply@filmnesia.com"),
+		Inbox:        td.inbox,
+		Preferences:  td.store,
+		Signer:       td.signer,
+		Stream:       td.hub,
+		PublicURL:    "https://filmnesia.example/",
+		DeliveryLog:  td.log,
+		RecipientKey: []byte("recipient key"),
+		Profiles:     td.profiles,
+	})
+	return td
+}
+
+func welcome(userID uuid.UUID, category preference.Category) Email {
+	return Email{
+		UserID:   userID,
+		Category: category,
+		Template: contracts.EventUserRegistered,
+		Locale:   "en",
+		To:       "budi@example.com",
+		Data: contracts.UserRegisteredEvent{
+			UserID: userID, Username: "budi", RegisteredAt: time.Now(),
+		},
+	}
+}
+
+func TestDispatcherHonoursPreferences(t *testing.T) {
+	d := newTestDispatcher(t)
+	sender, store := d.sender, d.store
+	ctx := context.Background()
+	userID := uuid.New()
+
+	// Marketing is opt-in: nothing is sent by default.
+	if err := d.SendEmail(ctx, welcome(userID, preference.CategoryMarketing)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatal("sent a marketing email without consent")
+	}
+
+	store.Set(ctx, userID, []preference.Setting{{Category: preference.CategorySocial, Channel: preference.ChannelEmail, Enabled: false}})
+	d.SendEmail(ctx, welcome(userID, preference.CategorySocial))
+	if len(sender.sent) != 0 {
+		t.Fatal("sent a social email after the user turned them off")
+	}
+
+	// Transactional emails go out regardless and carry no unsubscribe link.
+	store.Set(ctx, userID, []preference.Setting{{Category: preference.CategoryAccount, Channel: preference.ChannelEmail, Enabled: false}})
+	if err := d.SendEmail(ctx, welcome(userID, preference.CategoryAccount)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].Headers["List-Unsubscribe"] != "" {
+		t.Fatalf("account email: %+v", sender.sent)
+	}
+	if s := d.Stats()[preference.ChannelEmail]; s.Sent != 1 || s.Suppressed != 2 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestNonTransactionalEmailsCarryOneClickUnsubscribe(t *testing.T) {
+	d := newTestDispatcher(t)
+	sender, signer := d.sender, d.signer
+	userID := uuid.New()
+
+	if err := d.SendEmail(context.Background(), welcome(userID, preference.CategoryReleaseReminders)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d emails", len(sender.sent))
+	}
+	msg := sender.sent[0]
+	if msg.Headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %q", msg.Headers["List-Unsubscribe-Post"])
+	}
+	header := msg.Headers["List-Unsubscribe"]
+	link, err := url.Parse(strings.Trim(header, "<>"))
+	if err != nil || link.Scheme != "https" || link.Host != "filmnesia.example" || link.Path != UnsubscribePath {
+		t.Fatalf("List-Unsubscribe = %q", header)
+	}
+	u, err := signer.Verify(link.Query().Get("token"))
+	if err != nil || u.UserID != userID || u.Category != preference.CategoryReleaseReminders || u.Channel != preference.ChannelEmail {
+		t.Errorf("token = %+v, %v", u, err)
+	}
+
+	if !strings.Contains(msg.Text, link.String()) || !strings.Contains(msg.HTML, "Unsubscribe from emails like this") {
+		t.Errorf("bodies do not offer the link:\n%s\n%s", msg.Text, msg.HTML)
+	}
+}
+
+func TestSendInAppStoresLocalizedNotification(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	n := InApp{
+		UserID:   userID,
+		Category: preference.CategoryAccount,
+		Template: contracts.EventUserRegistered,
+		Locale:   "en",
+		Data:     contracts.UserRegisteredEvent{UserID: userID, Username: "budi"},
+	}
+	sub, _ := d.hub.Subscribe(userID)
+
+	if err := d.SendInApp(ctx, n); err != nil {
+		t.Fatalf("SendInApp: %v", err)
+	}
+	page, _ := d.inbox.List(ctx, userID, inbox.Query{})
+	if len(page.Items) != 1 || page.Items[0].Title != "Welcome to Filmnesia, budi!" || page.Items[0].Type != contracts.EventUserRegistered {
+		t.Fatalf("inbox = %+v", page.Items)
+	}
+	select {
+	case e := <-sub.Events():
+		if e.Notification.ID != page.Items[0].ID {
+			t.Errorf("streamed %s, want %s", e.Notification.ID, page.Items[0].ID)
+		}
+	default:
+		t.Error("the notification was not pushed to the user's stream")
+	}
+
+	n.Category, n.Template = preference.CategorySocial, "no.such.template"
+	d.store.Set(ctx, userID, []preference.Setting{{Category: preference.CategorySocial, Channel: preference.ChannelInApp, Enabled: false}})
+	if err := d.SendInApp(ctx, n); err != nil {
+		t.Errorf("suppressed notification: %v", err)
+	}
+	d.store.Set(ctx, userID, []preference.Setting{{Category: preference.CategorySocial, Channel: preference.ChannelInApp, Enabled: true}})
+	if err := d.SendInApp(ctx, n); !email.IsPermanent(err) {
+		t.Errorf("unknown template: want a permanent error, got %v", err)
+	}
+}
+
+// newSchedulingDispatcher holds emails back with a scheduler. The
+// dispatcher's clock is fixed in the past, so every job it schedules is
+// due for the scheduler, which runs on the real clock.
+func newSchedulingDispatcher(t *testing.T, now time.Time) (testDispatcher, *schedule.Scheduler, *preference.MemoryStore) {
+	t.Helper()
+	d := newTestDispatcher(t)
+	prefs := d.store.(*preference.MemoryStore)
+	scheduler := schedule.New(schedule.NewMemoryStore(), schedule.Config{})
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Dispatcher = NewDispatcher(Config{
+		Mailer:        d.mailer,
+		Inbox:         d.inbox,
+		Preferences:   prefs,
+		Signer:        d.signer,
+		PublicURL:     "https://filmnesia.example/",
+		DeliveryLog:   d.log,
+		Scheduler:     scheduler,
+		Schedules:     prefs,
+		Digests:       digest.NewMemoryStore(),
+		TimeZone:      jakarta,
+		DigestHour:    8,
+		DigestWeekday: time.Monday,
+	})
+	d.now = func() time.Time { return now }
+	d.RegisterJobs()
+	return d, scheduler, prefs
+}
+
+func TestLowPriorityEmailsGoOutAsOneDigest(t *testing.T) {
+	ctx := context.Background()
+	d, scheduler, _ := newSchedulingDispatcher(t, time.Date(2025, 6, 2, 3, 0, 0, 0, time.UTC))
+	userID := uuid.New()
+
+	// Social emails are batched daily by default.
+	for i := 0; i < 2; i++ {
+		if err := d.SendEmail(ctx, welcome(userID, preference.CategorySocial)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(d.sender.sent) != 0 {
+		t.Fatal("a digested email went out at once")
+	}
+	if s := scheduler.Stats(); s.Scheduled != 1 {
+		t.Fatalf("scheduled %d jobs, want one digest", s.Scheduled)
+	}
+
+	scheduler.RunDue(ctx)
+	if len(d.sender.sent) != 1 {
+		t.Fatalf("sent %d emails, want one digest", len(d.sender.sent))
+	}
+	msg := d.sender.sent[0]
+	if msg.Subject != "Your Filmnesia digest: 2 updates" || strings.Count(msg.Text, "Welcome to Filmnesia, budi!") != 2 {
+		t.Errorf("digest:\n%s\n%s", msg.Subject, msg.Text)
+	}
+	if msg.Headers["List-Unsubscribe"] == "" {
+		t.Error("the digest carries no unsubscribe link")
+	}
+	if s := d.Stats()[preference.ChannelEmail]; s.Digested != 2 || s.Sent != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestQuietHoursDeferEmailsButNotTransactionalOnes(t *testing.T) {
+	ctx := context.Background()
+	// 23:00 in Jakarta.
+	d, scheduler, prefs := newSchedulingDispatcher(t, time.Date(2025, 6, 2, 16, 0, 0, 0, time.UTC))
+	userID := uuid.New()
+	prefs.SetSchedule(ctx, userID, preference.Schedule{
+		QuietHours: &preference.QuietHours{Start: "22:00", End: "07:00"},
+		Digests:    map[preference.Category]preference.Frequency{preference.CategorySocial: preference.FrequencyImmediate},
+	})
+
+	if err := d.SendEmail(ctx, welcome(userID, preference.CategoryAccount)); err != nil {
+		t.Fatal(err)
+	}
+	// A redelivered event does not defer the email twice, but another
+	// event that renders the same email is deferred all the same.
+	for _, eventID := range []string{"evt-1", "evt-1", "evt-2"} {
+		n := welcome(userID, preference.CategorySocial)
+		n.EventID = eventID
+		if err := d.SendEmail(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(d.sender.sent) != 1 || d.sender.sent[0].Headers["List-Unsubscribe"] != "" {
+		t.Fatalf("sent %d emails during quiet hours, want only the transactional one", len(d.sender.sent))
+	}
+	if s := scheduler.Stats(); s.Scheduled != 2 {
+		t.Fatalf("scheduled %d jobs, want one deferred email per event", s.Scheduled)
+	}
+
+	scheduler.RunDue(ctx)
+	if len(d.sender.sent) != 3 || d.sender.sent[1].Headers["List-Unsubscribe"] == "" || d.sender.sent[2].Headers["List-Unsubscribe"] == "" {
+		t.Fatalf("sent %+v after the quiet hours", d.sender.sent)
+	}
+	if s := d.Stats()[preference.ChannelEmail]; s.Deferred != 2 || s.Sent != 3 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestDeliveryLogRecordsAttemptsAndResends(t *testing.T) {
+	d := newTestDispatcher(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	n := welcome(userID, preference.CategoryAccount)
+	n.EventID = "evt-1"
+
+	// The first attempt fails and the redelivered event succeeds.
+	d.sender.err = errors.New("421 try again later")
+	if err := d.SendEmail(ctx, n); err == nil {
+		t.Fatal("the failing send returned no error")
+	}
+	d.sender.err = nil
+	if err := d.SendEmail(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := d.log.Search(ctx, deliverylog.Query{EventID: "evt-1"})
+	if len(entries) != 1 {
+		t.Fatalf("%d entries for one event", len(entries))
+	}
+	sent := entries[0]
+	if sent.Status != deliverylog.StatusSent || sent.Attempts != 2 || sent.RecipientHash != d.HashRecipient("budi@example.com") || sent.Template != contracts.EventUserRegistered {
+		t.Errorf("entry = %+v", sent)
+	}
+
+	d.SendEmail(ctx, welcome(userID, preference.CategoryMarketing))
+	if entries, _ := d.log.Search(ctx, deliverylog.Query{Status: deliverylog.StatusSuppressed}); len(entries) != 1 || entries[0].Category != "marketing" {
+		t.Errorf("suppressed entries = %+v", entries)
+	}
+
+	// The entry keeps what the email was rendered from, not the address.
+	if !sent.Resendable() || strings.Contains(string(sent.Data), "budi@example.com") {
+		t.Errorf("entry data = %s", sent.Data)
+	}
+	if sent.RecipientHash == deliverylog.HashRecipient(nil, "budi@example.com") {
+		t.Error("the recipient hash is not keyed")
+	}
+
+	// A resend goes to the address the user has now.
+	if _, err := d.Resend(ctx, sent.ID); !errors.Is(err, ErrNoRecipient) {
+		t.Errorf("resending without a known address: %v", err)
+	}
+	d.profiles.Upsert(ctx, profile.Profile{UserID: userID, Email: "budi@example.org", UpdatedAt: time.Now()})
+	resent, err := d.Resend(ctx, sent.ID)
+	if err != nil || resent.ID == sent.ID || resent.ResendOf == nil || *resent.ResendOf != sent.ID || resent.Status != deliverylog.StatusSent {
+		t.Fatalf("resend = %+v, %v", resent, err)
+	}
+	if resent.RecipientHash != d.HashRecipient("budi@example.org") {
+		t.Errorf("resent entry = %+v", resent)
+	}
+	if len(d.sender.sent) != 2 || d.sender.sent[1].Subject != d.sender.sent[0].Subject ||
+		d.sender.sent[1].To[0] != "budi@example.org" || !strings.Contains(d.sender.sent[1].Text, "budi@example.org") {
+		t.Errorf("resent %+v", d.sender.sent)
+	}
+
+	suppressed, _ := d.log.Search(ctx, deliverylog.Query{Status: deliverylog.StatusSuppressed})
+	if _, err := d.Resend(ctx, suppressed[0].ID); !errors.Is(err, ErrNotResendable) {
+		t.Errorf("resending a suppressed email: %v", err)
+	}
+	if _, err := d.Resend(ctx, uuid.New()); !errors.Is(err, deliverylog.ErrNotFound) {
+		t.Errorf("resending an unknown entry: %v", err)
+	}
+
+	// An unsubscribed user does not get a resent newsletter.
+	d.store.Set(ctx, userID, []preference.Setting{{Category: preference.CategorySocial, Channel: preference.ChannelEmail, Enabled: true}})
+	d.SendEmail(ctx, welcome(userID, preference.CategorySocial))
+	social, _ := d.log.Search(ctx, deliverylog.Query{UserID: userID, Status: deliverylog.StatusSent, Limit: 1})
+	d.store.Set(ctx, userID, []preference.Setting{{Category: preference.CategorySocial, Channel: preference.ChannelEmail, Enabled: false}})
+	if _, err := d.Resend(ctx, social[0].ID); !errors.Is(err, ErrSuppressed) {
+		t.Errorf("resending to an unsubscribed user: %v", err)
+	}
+}
+
+func TestRateLimitsThrottlePerRecipientAndTemplate(t *testing.T) {
+	for scope, limits := range map[string]RateLimits{
+		LimitRecipient: {Recipient: ratelimit.Limit{Burst: 2, Per: time.Hour}},
+		LimitTemplate: {
+			Recipient: ratelimit.Limit{Burst: 5, Per: time.Hour},
+			Template:  ratelimit.Limit{Burst: 1, Per: time.Hour},
+			Templates: map[string]ratelimit.Limit{contracts.EventUserRegistered: {Burst: 2, Per: time.Hour}},
+		},
+	} {
+		d := newTestDispatcher(t)
+		d.limits = limits
+		ctx := context.Background()
+		userID := uuid.New()
+		send := func(eventID string) error {
+			n := welcome(userID, preference.CategoryAccount)
+			n.EventID = eventID
+			return d.SendEmail(ctx, n)
+		}
+
+		for _, eventID := range []string{"evt-1", "evt-2"} {
+			if err := send(eventID); err != nil {
+				t.Fatalf("%s: %s: %v", scope, eventID, err)
+			}
+		}
+		var limited *ratelimit.Error
+		if err := send("evt-3"); !errors.As(err, &limited) || limited.Scope != scope || limited.RetryAfter <= 0 {
+			t.Fatalf("%s: third email: %v", scope, err)
+		}
+		// Another recipient is not held back.
+		other := welcome(uuid.New(), preference.CategoryAccount)
+		other.To = "ani@example.com"
+		if err := d.SendEmail(ctx, other); err != nil {
+			t.Errorf("%s: other recipient: %v", scope, err)
+		}
+
+		if len(d.sender.sent) != 3 {
+			t.Errorf("%s: sent %d emails, want 3", scope, len(d.sender.sent))
+		}
+		throttled, _ := d.log.Search(ctx, deliverylog.Query{Status: deliverylog.StatusThrottled})
+		if len(throttled) != 1 || throttled[0].EventID != "evt-3" {
+			t.Errorf("%s: throttled entries = %+v", scope, throttled)
+		}
+		if got := d.Stats()[preference.ChannelEmail].Throttled; got != 1 {
+			t.Errorf("%s: Throttled = %d, want 1", scope, got)
+		}
+		if got := d.RateLimitStats().Throttled[scope]; got != 1 {
+			t.Errorf("%s: RateLimitStats = %d, want 1", scope, got)
+		}
+	}
+}
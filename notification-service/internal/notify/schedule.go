@@ -0,0 +1,282 @@
+package notify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/notification-service/internal/deliverylog"
+	"github.com/virhanali/filmnesia/notification-service/internal/digest"
+	"github.com/virhanali/filmnesia/notification-service/internal/email"
+	"github.com/virhanali/filmnesia/notification-service/internal/preference"
+	"github.com/virhanali/filmnesia/notification-service/internal/ratelimit"
+	"github.com/virhanali/filmnesia/notification-service/internal/schedule"
+)
+
+// Kinds of the jobs the dispatcher schedules.
+const (
+	JobDigest        = "notification.digest"
+	JobDeferredEmail = "notification.deferred_email"
+)
+
+// DigestTemplate is the email template digests are rendered with.
+const DigestTemplate = "notification.digest"
+
+// Digest is the data of DigestTemplate: the notifications of one category
+// collected since the last digest, oldest first.
+type Digest struct {
+	Category  preference.Category
+	Frequency preference.Frequency
+	Count     int
+	Items     []DigestEntry
+}
+
+type DigestEntry struct {
+	Summary string
+	At      time.Time
+}
+
+type digestJob struct {
+	UserID    uuid.UUID            `json:"user_id"`
+	Category  preference.Category  `json:"category"`
+	Frequency preference.Frequency `json:"frequency"`
+}
+
+// deferredEmail is an email rendered when it arrived and sent when the
+// quiet hours are over. Locale and Data are kept for its delivery log
+// entry, so that it can be resent.
+type deferredEmail struct {
+	EventID  string              `json:"event_id,omitempty"`
+	UserID   uuid.UUID           `json:"user_id"`
+	Category preference.Category `json:"category"`
+	Template string              `json:"template"`
+	Locale   string              `json:"locale,omitempty"`
+	Data     json.RawMessage     `json:"data,omitempty"`
+	Message  *email.Message      `json:"message"`
+}
+
+// RegisterJobs registers the digest and deferred email jobs with the
+// scheduler. Every replica must, since any may run them.
+func (d *Dispatcher) RegisterJobs() {
+	d.scheduler.Handle(JobDigest, d.runDigest)
+	d.scheduler.Handle(JobDeferredEmail, d.runDeferredEmail)
+}
+
+// holdBack puts n into the user's digest, or defers it while their quiet
+// hours last, and reports whether it did either.
+func (d *Dispatcher) holdBack(ctx context.Context, n Email, entry deliverylog.Entry) (bool, error) {
+	s, err := d.schedules.GetSchedule(ctx, n.UserID)
+	if err != nil {
+		return false, fmt.Errorf("load schedule of %s: %w", n.UserID, err)
+	}
+	now := d.now()
+	loc := s.Location(d.timeZone)
+	if f := s.Frequency(n.Category); f != preference.FrequencyImmediate {
+		return true, d.addToDigest(ctx, n, entry, f, s.NextDigest(now, f, loc, d.digestHour, d.digestWeekday))
+	}
+	if until, quiet := s.QuietUntil(now, loc); quiet {
+		return true, d.deferEmail(ctx, n, entry, until)
+	}
+	return false, nil
+}
+
+// addToDigest schedules the digest before adding the item: a retry after
+// a failure in between adds the item once, and the digest never misses
+// it.
+func (d *Dispatcher) addToDigest(ctx context.Context, n Email, entry deliverylog.Entry, f preference.Frequency, at time.Time) error {
+	summary, err := d.summarize(n)
+	if err != nil {
+		return email.Invalid(err)
+	}
+	if err := d.scheduleDigest(ctx, n.UserID, n.Category, f, at); err != nil {
+		return err
+	}
+	err = d.digests.Add(ctx, digest.Item{
+		ID:        uuid.New(),
+		UserID:    n.UserID,
+		Category:  string(n.Category),
+		Template:  n.Template,
+		Summary:   summary,
+		To:        n.To,
+		Locale:    n.Locale,
+		CreatedAt: d.now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("add %s to the digest of UserID %s: %w", n.Template, n.UserID, err)
+	}
+	d.stats[preference.ChannelEmail].digested.Add(1)
+	d.record(ctx, entry, deliverylog.StatusDigested, "in the "+string(f)+" digest at "+at.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// scheduleDigest keys the job by user, category and time, so every item
+// collected for the same digest schedules the same job.
+func (d *Dispatcher) scheduleDigest(ctx context.Context, userID uuid.UUID, category preference.Category, f preference.Frequency, at time.Time) error {
+	key := fmt.Sprintf("digest:%s:%s:%s", userID, category, at.UTC().Format(time.RFC3339))
+	_, err := d.scheduler.Schedule(ctx, JobDigest, key, at, digestJob{UserID: userID, Category: category, Frequency: f})
+	return err
+}
+
+// summarize renders n as one line: the title of its in-app notification,
+// else its subject.
+func (d *Dispatcher) summarize(n Email) (string, error) {
+	renderer := d.mailer.Renderer()
+	if renderer.HasInApp(n.Template) {
+		content, err := renderer.RenderInApp(n.Template, n.Locale, n.Data)
+		return content.Title, err
+	}
+	content, err := renderer.Render(n.Template, n.Locale, n.Data)
+	return content.Subject, err
+}
+
+// deferEmail renders n now, so that only templates that can be resent
+// need their data stored, and schedules it for until. The job is keyed on
+// the event, so that a redelivered event does not schedule the email
+// twice while two events that render the same email each get theirs.
+// Emails without an event fall back to a hash of the message.
+func (d *Dispatcher) deferEmail(ctx context.Context, n Email, entry deliverylog.Entry, until time.Time) error {
+	msg, err := d.mailer.Compose(d.mail(n))
+	if err != nil {
+		return err
+	}
+	job := deferredEmail{
+		EventID:  n.EventID,
+		UserID:   n.UserID,
+		Category: n.Category,
+		Template: n.Template,
+		Locale:   n.Locale,
+		Data:     d.resendData(n.Template, n.Data),
+		Message:  msg,
+	}
+	key := "deferred:" + n.UserID.String() + ":" + n.Template + ":" + n.EventID
+	if n.EventID == "" {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(payload)
+		key = "deferred:" + n.UserID.String() + ":" + hex.EncodeToString(sum[:16])
+	}
+	created, err := d.scheduler.Schedule(ctx, JobDeferredEmail, key, until, job)
+	if !created {
+		return err
+	}
+	d.stats[preference.ChannelEmail].deferred.Add(1)
+	d.record(ctx, entry, deliverylog.StatusDeferred, "quiet hours until "+until.UTC().Format(time.RFC3339))
+	log.Printf("INFO: Deferring %s to UserID %s until their quiet hours end at %s", n.Template, n.UserID, until.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// runDigest sends the items collected for a digest job, unless the user
+// turned the category off in the meantime.
+func (d *Dispatcher) runDigest(ctx context.Context, job schedule.Job) error {
+	var p digestJob
+	if err := job.Decode(&p); err != nil {
+		return schedule.Permanent(err)
+	}
+	items, err := d.digests.Collect(ctx, job.ID, p.UserID, string(p.Category))
+	if err != nil {
+		return fmt.Errorf("collect digest: %w", err)
+	}
+	if len(items) == 0 {
+		// Collected by an earlier job, e.g. after a time zone change.
+		return nil
+	}
+
+	latest := items[len(items)-1]
+	entry := d.logEntry("", p.UserID, preference.ChannelEmail, p.Category, DigestTemplate, latest.To)
+	entry.Key = job.Key
+	ok, err := d.allowed(ctx, p.UserID, p.Category, preference.ChannelEmail, DigestTemplate)
+	if err != nil {
+		return err
+	}
+	if ok {
+		data := Digest{Category: p.Category, Frequency: p.Frequency, Count: len(items)}
+		for _, item := range items {
+			data.Items = append(data.Items, DigestEntry{Summary: item.Summary, At: item.CreatedAt})
+		}
+		_, err := d.send(ctx, entry, email.Mail{
+			Template:       DigestTemplate,
+			Locale:         latest.Locale,
+			To:             latest.To,
+			Data:           data,
+			UnsubscribeURL: d.UnsubscribeURL(p.UserID, p.Category, preference.ChannelEmail),
+		})
+		if err != nil {
+			return scheduleError(err)
+		}
+	} else {
+		d.record(ctx, entry, deliverylog.StatusSuppressed, "turned off by the user")
+	}
+
+	// The digest is out; failing the job now would send it again.
+	if err := d.digests.Delete(ctx, job.ID); err != nil {
+		log.Printf("ERROR: delete the items of digest %s: %v", job.Key, err)
+	}
+	d.rescheduleLeftovers(ctx, p)
+	return nil
+}
+
+// rescheduleLeftovers schedules the next digest for items that arrived
+// while this one was collected and found its job already claimed.
+func (d *Dispatcher) rescheduleLeftovers(ctx context.Context, p digestJob) {
+	pending, err := d.digests.Pending(ctx, p.UserID, string(p.Category))
+	if err != nil || pending == 0 {
+		return
+	}
+	s, err := d.schedules.GetSchedule(ctx, p.UserID)
+	if err != nil {
+		log.Printf("WARNING: load schedule of %s: %v", p.UserID, err)
+	}
+	f := s.Frequency(p.Category)
+	if f == preference.FrequencyImmediate {
+		f = p.Frequency
+	}
+	next := s.NextDigest(d.now(), f, s.Location(d.timeZone), d.digestHour, d.digestWeekday)
+	if err := d.scheduleDigest(ctx, p.UserID, p.Category, f, next); err != nil {
+		log.Printf("WARNING: %d digest items of UserID %s wait for the next one: %v", pending, p.UserID, err)
+	}
+}
+
+// runDeferredEmail sends an email held back by quiet hours, unless the
+// user turned its category off in the meantime.
+func (d *Dispatcher) runDeferredEmail(ctx context.Context, job schedule.Job) error {
+	var p deferredEmail
+	if err := job.Decode(&p); err != nil || p.Message == nil {
+		return schedule.Permanent(fmt.Errorf("decode deferred email: %v", err))
+	}
+	var to string
+	if len(p.Message.To) > 0 {
+		to = p.Message.To[0]
+	}
+	entry := d.logEntry(p.EventID, p.UserID, preference.ChannelEmail, p.Category, p.Template, to)
+	entry.Locale, entry.Data = p.Locale, p.Data
+	ok, err := d.allowed(ctx, p.UserID, p.Category, preference.ChannelEmail, p.Template)
+	if !ok {
+		if err == nil {
+			d.record(ctx, entry, deliverylog.StatusSuppressed, "turned off by the user")
+		}
+		return err
+	}
+	_, err = d.deliver(ctx, entry, p.Message)
+	return scheduleError(err)
+}
+
+// scheduleError makes delivery failures that will not go away on retry
+// permanent, and postpones throttled ones until the limit allows.
+func scheduleError(err error) error {
+	var limited *ratelimit.Error
+	switch {
+	case errors.As(err, &limited):
+		return schedule.Postpone(err, limited.RetryAfter)
+	case email.IsPermanent(err):
+		return schedule.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package preference
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type memoryKey struct {
+	category Category
+	channel  Channel
+}
+
+// MemoryStore keeps settings in process memory, for tests and local
+// development without a database.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	users     map[uuid.UUID]map[memoryKey]Setting
+	schedules map[uuid.UUID]Schedule
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:     make(map[uuid.UUID]map[memoryKey]Setting),
+		schedules: make(map[uuid.UUID]Schedule),
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, userID uuid.UUID) ([]Setting, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	settings := make([]Setting, 0, len(s.users[userID]))
+	for _, setting := range s.users[userID] {
+		settings = append(settings, setting)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		if settings[i].Category != settings[j].Category {
+			return settings[i].Category < settings[j].Category
+		}
+		return settings[i].Channel < settings[j].Channel
+	})
+	return settings, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, userID uuid.UUID, settings []Setting) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users[userID] == nil {
+		s.users[userID] = map[memoryKey]Setting{}
+	}
+	for _, setting := range settings {
+		s.users[userID][memoryKey{setting.Category, setting.Channel}] = setting
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetSchedule(ctx context.Context, userID uuid.UUID) (Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schedule := s.schedules[userID]
+	schedule.Digests = maps.Clone(schedule.Digests)
+	return schedule, nil
+}
+
+func (s *MemoryStore) SetSchedule(ctx context.Context, userID uuid.UUID, schedule Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedule.Digests = maps.Clone(schedule.Digests)
+	s.schedules[userID] = schedule
+	return nil
+}
@@ -0,0 +1,98 @@
+package preference
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps settings in the notification_preferences table (see
+// migrations/000003_create_notification_preferences) and schedules in
+// notification_schedules (000006_create_digests).
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, userID uuid.UUID) ([]Setting, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT category, channel, enabled, updated_at FROM notification_preferences
+		 WHERE user_id = $1 ORDER BY category, channel`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []Setting
+	for rows.Next() {
+		var s Setting
+		if err := rows.Scan(&s.Category, &s.Channel, &s.Enabled, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		settings = append(settings, s)
+	}
+	return settings, rows.Err()
+}
+
+// Set writes all settings in one transaction, so an update is applied
+// completely or not at all.
+func (s *PostgresStore) Set(ctx context.Context, userID uuid.UUID, settings []Setting) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		for _, setting := range settings {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO notification_preferences (user_id, category, channel, enabled, updated_at)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (user_id, category, channel) DO UPDATE
+					SET enabled = EXCLUDED.enabled, updated_at = EXCLUDED.updated_at`,
+				userID, setting.Category, setting.Channel, setting.Enabled, setting.UpdatedAt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) GetSchedule(ctx context.Context, userID uuid.UUID) (Schedule, error) {
+	var schedule Schedule
+	var quietStart, quietEnd string
+	err := s.pool.QueryRow(ctx, `
+		SELECT time_zone, quiet_start, quiet_end, digests, updated_at
+		FROM notification_schedules WHERE user_id = $1`, userID).
+		Scan(&schedule.TimeZone, &quietStart, &quietEnd, &schedule.Digests, &schedule.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Schedule{}, nil
+	}
+	if err != nil {
+		return Schedule{}, err
+	}
+	if quietStart != "" {
+		schedule.QuietHours = &QuietHours{Start: quietStart, End: quietEnd}
+	}
+	return schedule, nil
+}
+
+func (s *PostgresStore) SetSchedule(ctx context.Context, userID uuid.UUID, schedule Schedule) error {
+	var quietStart, quietEnd string
+	if q := schedule.QuietHours; q != nil {
+		quietStart, quietEnd = q.Start, q.End
+	}
+	digests := schedule.Digests
+	if digests == nil {
+		digests = map[Category]Frequency{}
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO notification_schedules (user_id, time_zone, quiet_start, quiet_end, digests, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE
+			SET time_zone = EXCLUDED.time_zone, quiet_start = EXCLUDED.quiet_start,
+			    quiet_end = EXCLUDED.quiet_end, digests = EXCLUDED.digests, updated_at = EXCLUDED.updated_at`,
+		userID, schedule.TimeZone, quietStart, quietEnd, digests, schedule.UpdatedAt)
+	return err
+}
@@ -0,0 +1,145 @@
+// Package preference stores which notifications each user wants on which
+// channel, and signs the unsubscribe links that change them from an email.
+package preference
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Category groups notifications a user can opt in or out of as a whole.
+type Category string
+
+const (
+	// Security and account notifications are transactional: they are
+	// about something the user did or must know, and cannot be turned
+	// off.
+	CategorySecurity Category = "security"
+	CategoryAccount  Category = "account"
+
+	CategoryMarketing        Category = "marketing"
+	CategoryReleaseReminders Category = "release_reminders"
+	CategorySocial           Category = "social"
+)
+
+// Categories lists every category, in the order the API returns them.
+var Categories = []Category{CategorySecurity, CategoryAccount, CategoryMarketing, CategoryReleaseReminders, CategorySocial}
+
+func (c Category) Valid() bool {
+	for _, known := range Categories {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Transactional categories are always delivered and carry no unsubscribe
+// link.
+func (c Category) Transactional() bool {
+	return c == CategorySecurity || c == CategoryAccount
+}
+
+// Channel is a way of reaching a user.
+type Channel string
+
+const (
+	ChannelEmail Channel = "email"
+	ChannelInApp Channel = "in_app"
+	ChannelPush  Channel = "push"
+)
+
+var Channels = []Channel{ChannelEmail, ChannelInApp, ChannelPush}
+
+func (c Channel) Valid() bool {
+	for _, known := range Channels {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Default is whether a category is enabled on a channel before the user
+// says otherwise. Marketing needs explicit consent; everything else is
+// on.
+func Default(category Category, channel Channel) bool {
+	return category != CategoryMarketing
+}
+
+var (
+	ErrUnknownCategory = errors.New("unknown notification category")
+	ErrUnknownChannel  = errors.New("unknown notification channel")
+	ErrTransactional   = errors.New("transactional notifications cannot be turned off")
+)
+
+// Setting is one explicit choice of a user.
+type Setting struct {
+	Category  Category  `json:"category"`
+	Channel   Channel   `json:"channel"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks that s names a known category and channel and does not
+// turn off a transactional category.
+func (s Setting) Validate() error {
+	if !s.Category.Valid() {
+		return ErrUnknownCategory
+	}
+	if !s.Channel.Valid() {
+		return ErrUnknownChannel
+	}
+	if s.Category.Transactional() && !s.Enabled {
+		return ErrTransactional
+	}
+	return nil
+}
+
+// Preferences are the explicit settings of one user over the defaults.
+type Preferences struct {
+	UserID   uuid.UUID
+	settings map[Category]map[Channel]bool
+}
+
+func NewPreferences(userID uuid.UUID, settings []Setting) Preferences {
+	p := Preferences{UserID: userID, settings: map[Category]map[Channel]bool{}}
+	for _, s := range settings {
+		if p.settings[s.Category] == nil {
+			p.settings[s.Category] = map[Channel]bool{}
+		}
+		p.settings[s.Category][s.Channel] = s.Enabled
+	}
+	return p
+}
+
+// Allows reports whether notifications of category may be sent on
+// channel.
+func (p Preferences) Allows(category Category, channel Channel) bool {
+	if category.Transactional() {
+		return true
+	}
+	if enabled, ok := p.settings[category][channel]; ok {
+		return enabled
+	}
+	return Default(category, channel)
+}
+
+// Store persists settings. Set upserts each setting; Get returns the
+// user's settings, or none when the user never changed anything.
+type Store interface {
+	Get(ctx context.Context, userID uuid.UUID) ([]Setting, error)
+	Set(ctx context.Context, userID uuid.UUID, settings []Setting) error
+}
+
+// Load returns the effective preferences of userID.
+func Load(ctx context.Context, store Store, userID uuid.UUID) (Preferences, error) {
+	settings, err := store.Get(ctx, userID)
+	if err != nil {
+		return Preferences{}, err
+	}
+	return NewPreferences(userID, settings), nil
+}
@@ -0,0 +1,149 @@
+package preference
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestAllowsAppliesDefaultsAndSettings(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	userID := uuid.New()
+
+	prefs, err := Load(ctx, store, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs.Allows(CategoryMarketing, ChannelEmail) || !prefs.Allows(CategorySocial, ChannelEmail) {
+		t.Error("defaults: marketing must be opt-in and social on")
+	}
+
+	store.Set(ctx, userID, []Setting{
+		{Category: CategoryMarketing, Channel: ChannelEmail, Enabled: true},
+		{Category: CategorySocial, Channel: ChannelPush, Enabled: false},
+		// Not accepted by Validate, and ignored if stored anyway.
+		{Category: CategorySecurity, Channel: ChannelEmail, Enabled: false},
+	})
+	prefs, _ = Load(ctx, store, userID)
+	if !prefs.Allows(CategoryMarketing, ChannelEmail) || prefs.Allows(CategoryMarketing, ChannelPush) {
+		t.Error("marketing email opt-in was not applied to email alone")
+	}
+	if prefs.Allows(CategorySocial, ChannelPush) || !prefs.Allows(CategorySocial, ChannelEmail) {
+		t.Error("social push opt-out was not applied to push alone")
+	}
+	if !prefs.Allows(CategorySecurity, ChannelEmail) {
+		t.Error("security emails were turned off")
+	}
+
+	if err := (Setting{Category: CategoryAccount, Channel: ChannelEmail}).Validate(); !errors.Is(err, ErrTransactional) {
+		t.Errorf("want ErrTransactional, got %v", err)
+	}
+	if err := (Setting{Category: "newsletter", Channel: ChannelEmail, Enabled: true}).Validate(); !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("want ErrUnknownCategory, got %v", err)
+	}
+}
+
+func TestUnsubscribeTokens(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	signer.now = func() time.Time { return time.Unix(1750000000, 0) }
+	userID := uuid.New()
+
+	token := signer.Sign(userID, CategoryReleaseReminders, ChannelEmail)
+	u, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if u.UserID != userID || u.Category != CategoryReleaseReminders || u.Channel != ChannelEmail || u.IssuedAt.Unix() != 1750000000 {
+		t.Errorf("Verify = %+v", u)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := NewSigner([]byte("secret")).Sign(uuid.New(), CategoryMarketing, ChannelEmail)
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	for name, bad := range map[string]string{
+		"other secret":    NewSigner([]byte("other")).Sign(userID, CategoryMarketing, ChannelEmail),
+		"swapped payload": forgedPayload + "." + sig,
+		"truncated":       payload,
+		"empty":           "",
+		"transactional":   NewSigner([]byte("secret")).Sign(userID, CategorySecurity, ChannelEmail),
+		"garbage":         "not.a-token",
+	} {
+		if _, err := signer.Verify(bad); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: want ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestScheduleQuietHoursAndDigestTimes(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	s := Schedule{QuietHours: &QuietHours{Start: "22:00", End: "07:30"}}
+	at := func(day, hour, minute int) time.Time { return time.Date(2025, 6, day, hour, minute, 0, 0, jakarta) }
+
+	for _, c := range []struct {
+		t     time.Time
+		until time.Time
+		quiet bool
+	}{
+		{at(2, 21, 59), time.Time{}, false},
+		{at(2, 23, 0), at(3, 7, 30), true},
+		{at(3, 6, 0), at(3, 7, 30), true},
+		{at(3, 7, 30), time.Time{}, false},
+	} {
+		until, quiet := s.QuietUntil(c.t.UTC(), jakarta)
+		if quiet != c.quiet || !until.Equal(c.until) {
+			t.Errorf("QuietUntil(%s) = %s, %t; want %s, %t", c.t, until, quiet, c.until, c.quiet)
+		}
+	}
+
+	// 2 June 2025 is a Monday.
+	if got := s.NextDigest(at(2, 9, 0), FrequencyDaily, jakarta, 8, time.Monday); !got.Equal(at(3, 8, 0)) {
+		t.Errorf("daily digest after 09:00 = %s", got)
+	}
+	if got := s.NextDigest(at(2, 7, 0), FrequencyWeekly, jakarta, 8, time.Monday); !got.Equal(at(2, 8, 0)) {
+		t.Errorf("weekly digest on Monday 07:00 = %s", got)
+	}
+	if got := s.NextDigest(at(2, 8, 0), FrequencyWeekly, jakarta, 8, time.Monday); !got.Equal(at(9, 8, 0)) {
+		t.Errorf("weekly digest on Monday 08:00 = %s", got)
+	}
+	if got := s.NextDigest(at(2, 9, 0), FrequencyDaily, jakarta, 6, time.Monday); !got.Equal(at(3, 7, 30)) {
+		t.Errorf("digest due in quiet hours = %s, want their end", got)
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	valid := Schedule{
+		TimeZone:   "Asia/Makassar",
+		QuietHours: &QuietHours{Start: "22:00", End: "06:00"},
+		Digests:    map[Category]Frequency{CategorySocial: FrequencyWeekly, CategoryMarketing: FrequencyImmediate},
+	}
+	if _, err := time.LoadLocation(valid.TimeZone); err == nil {
+		if err := valid.Validate(); err != nil {
+			t.Errorf("valid schedule: %v", err)
+		}
+	}
+	for name, c := range map[string]struct {
+		s    Schedule
+		want error
+	}{
+		"time zone":     {Schedule{TimeZone: "Mars/Olympus"}, ErrUnknownTimeZone},
+		"quiet format":  {Schedule{QuietHours: &QuietHours{Start: "10pm", End: "06:00"}}, ErrInvalidQuietTime},
+		"empty window":  {Schedule{QuietHours: &QuietHours{Start: "06:00", End: "06:00"}}, ErrInvalidQuietTime},
+		"frequency":     {Schedule{Digests: map[Category]Frequency{CategorySocial: "hourly"}}, ErrInvalidFrequency},
+		"transactional": {Schedule{Digests: map[Category]Frequency{CategorySecurity: FrequencyDaily}}, ErrNoSchedule},
+	} {
+		if err := c.s.Validate(); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", name, err, c.want)
+		}
+	}
+	if (Schedule{}).Frequency(CategorySocial) != FrequencyDaily || (Schedule{}).Frequency(CategoryAccount) != FrequencyImmediate {
+		t.Error("default frequencies")
+	}
+}
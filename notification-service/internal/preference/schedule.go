@@ -0,0 +1,183 @@
+package preference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Frequency is how often emails of a category reach a user: one by one,
+// or collected into a digest.
+type Frequency string
+
+const (
+	FrequencyImmediate Frequency = "immediate"
+	FrequencyDaily     Frequency = "daily"
+	FrequencyWeekly    Frequency = "weekly"
+)
+
+func (f Frequency) Valid() bool {
+	return f == FrequencyImmediate || f == FrequencyDaily || f == FrequencyWeekly
+}
+
+// DefaultFrequency is how a category is delivered before the user says
+// otherwise. Social activity and release reminders are many small,
+// low-priority emails and come as a daily digest.
+func DefaultFrequency(category Category) Frequency {
+	if category == CategorySocial || category == CategoryReleaseReminders {
+		return FrequencyDaily
+	}
+	return FrequencyImmediate
+}
+
+var (
+	ErrUnknownTimeZone  = errors.New("unknown time zone")
+	ErrInvalidFrequency = errors.New("frequency must be immediate, daily or weekly")
+	ErrInvalidQuietTime = errors.New("quiet hours must be HH:MM and start differ from end")
+	ErrNoSchedule       = errors.New("transactional notifications are always sent immediately")
+)
+
+// QuietHours is a daily window, in the user's time zone, during which
+// no email that can wait is sent. End before Start spans midnight.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Schedule is when a user wants to be emailed. Its zero value means the
+// defaults: no quiet hours and DefaultFrequency in the service's time
+// zone.
+type Schedule struct {
+	// TimeZone is an IANA name such as "Asia/Jakarta"; empty is the
+	// service default.
+	TimeZone   string                 `json:"time_zone"`
+	QuietHours *QuietHours            `json:"quiet_hours"`
+	Digests    map[Category]Frequency `json:"digests"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+}
+
+// Validate checks the time zone, the quiet hours and that only categories
+// that can wait are digested.
+func (s Schedule) Validate() error {
+	if s.TimeZone != "" {
+		if _, err := time.LoadLocation(s.TimeZone); err != nil {
+			return fmt.Errorf("%w: %q", ErrUnknownTimeZone, s.TimeZone)
+		}
+	}
+	if q := s.QuietHours; q != nil {
+		start, err1 := parseClock(q.Start)
+		end, err2 := parseClock(q.End)
+		if err1 != nil || err2 != nil || start == end {
+			return ErrInvalidQuietTime
+		}
+	}
+	for category, f := range s.Digests {
+		switch {
+		case !category.Valid():
+			return fmt.Errorf("%w: %q", ErrUnknownCategory, category)
+		case !f.Valid():
+			return fmt.Errorf("%s: %w", category, ErrInvalidFrequency)
+		case category.Transactional() && f != FrequencyImmediate:
+			return fmt.Errorf("%s: %w", category, ErrNoSchedule)
+		}
+	}
+	return nil
+}
+
+// Frequency returns how category is delivered.
+func (s Schedule) Frequency(category Category) Frequency {
+	if category.Transactional() {
+		return FrequencyImmediate
+	}
+	if f, ok := s.Digests[category]; ok {
+		return f
+	}
+	return DefaultFrequency(category)
+}
+
+// Location returns the user's time zone, or fallback when it is unset or
+// no longer known.
+func (s Schedule) Location(fallback *time.Location) *time.Location {
+	if s.TimeZone == "" {
+		return fallback
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return fallback
+	}
+	return loc
+}
+
+// QuietUntil reports whether t falls into the quiet hours in loc, and if
+// so when they end.
+func (s Schedule) QuietUntil(t time.Time, loc *time.Location) (time.Time, bool) {
+	if s.QuietHours == nil {
+		return time.Time{}, false
+	}
+	start, err1 := parseClock(s.QuietHours.Start)
+	end, err2 := parseClock(s.QuietHours.End)
+	if err1 != nil || err2 != nil || start == end {
+		return time.Time{}, false
+	}
+
+	local := t.In(loc)
+	now := local.Hour()*60 + local.Minute()
+	var quiet bool
+	if start < end {
+		quiet = now >= start && now < end
+	} else {
+		quiet = now >= start || now < end
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+	until := atClock(local, end)
+	if !until.After(t) {
+		until = atClock(local.AddDate(0, 0, 1), end)
+	}
+	return until, true
+}
+
+// NextDigest returns when a digest of frequency f collected at t is sent:
+// the next hour:00 in loc, for weekly digests on weekday, and after the
+// quiet hours if it falls into them.
+func (s Schedule) NextDigest(t time.Time, f Frequency, loc *time.Location, hour int, weekday time.Weekday) time.Time {
+	local := t.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, loc)
+	if f == FrequencyWeekly {
+		next = next.AddDate(0, 0, (int(weekday)-int(next.Weekday())+7)%7)
+	}
+	if !next.After(t) {
+		if f == FrequencyWeekly {
+			next = next.AddDate(0, 0, 7)
+		} else {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	if until, quiet := s.QuietUntil(next, loc); quiet {
+		next = until
+	}
+	return next
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func atClock(day time.Time, minutes int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minutes/60, minutes%60, 0, 0, day.Location())
+}
+
+// ScheduleStore persists schedules. GetSchedule returns the zero Schedule
+// for a user who never set one.
+type ScheduleStore interface {
+	GetSchedule(ctx context.Context, userID uuid.UUID) (Schedule, error)
+	SetSchedule(ctx context.Context, userID uuid.UUID, s Schedule) error
+}
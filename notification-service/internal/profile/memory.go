@@ -0,0 +1,56 @@
+package profile
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps the replica in process memory, for tests and local
+// development without a database.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	profiles map[uuid.UUID]Profile
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{profiles: make(map[uuid.UUID]Profile)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, userID uuid.UUID) (Profile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.profiles[userID]
+	if !ok {
+		return Profile{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *MemoryStore) Upsert(ctx context.Context, p Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.profiles[p.UserID]
+	if ok && p.UpdatedAt.Before(current.UpdatedAt) {
+		return nil
+	}
+	if ok {
+		p = merge(current, p)
+	}
+	s.profiles[p.UserID] = p
+	return nil
+}
+
+func merge(current, next Profile) Profile {
+	if next.Email == "" {
+		next.Email = current.Email
+	}
+	if next.Username == "" {
+		next.Username = current.Username
+	}
+	if next.Locale == "" {
+		next.Locale = current.Locale
+	}
+	return next
+}
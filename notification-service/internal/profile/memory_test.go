@@ -0,0 +1,40 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestUpsertKeepsTheNewestProfile(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	id := uuid.New()
+	t0 := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	if _, err := store.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+	store.Upsert(ctx, Profile{UserID: id, Email: "budi@example.com", Username: "budi", Locale: "id", UpdatedAt: t0})
+	// A later update without a locale keeps the known one.
+	store.Upsert(ctx, Profile{UserID: id, Email: "budi@example.com", Username: "budi2", UpdatedAt: t0.Add(time.Hour)})
+	// A redelivered older event does not roll the profile back.
+	store.Upsert(ctx, Profile{UserID: id, Username: "budi", Locale: "en", UpdatedAt: t0})
+
+	p, err := store.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Username != "budi2" || p.Locale != "id" || !p.UpdatedAt.Equal(t0.Add(time.Hour)) {
+		t.Errorf("profile = %+v", p)
+	}
+	if got := Locale(ctx, store, id); got != "id" {
+		t.Errorf("Locale = %q", got)
+	}
+	if got := Locale(ctx, store, uuid.New()); got != "" {
+		t.Errorf("Locale of an unknown user = %q", got)
+	}
+}
@@ -0,0 +1,45 @@
+package profile
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps the replica in the user_profiles table (see
+// migrations/000002_create_user_profiles).
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, userID uuid.UUID) (Profile, error) {
+	var p Profile
+	err := s.pool.QueryRow(ctx,
+		`SELECT user_id, email, username, locale, updated_at FROM user_profiles WHERE user_id = $1`,
+		userID).Scan(&p.UserID, &p.Email, &p.Username, &p.Locale, &p.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Profile{}, ErrNotFound
+	}
+	return p, err
+}
+
+func (s *PostgresStore) Upsert(ctx context.Context, p Profile) error {
+	const upsert = `
+		INSERT INTO user_profiles (user_id, email, username, locale, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			email      = COALESCE(NULLIF(EXCLUDED.email, ''), user_profiles.email),
+			username   = COALESCE(NULLIF(EXCLUDED.username, ''), user_profiles.username),
+			locale     = COALESCE(NULLIF(EXCLUDED.locale, ''), user_profiles.locale),
+			updated_at = EXCLUDED.updated_at
+		WHERE user_profiles.updated_at <= EXCLUDED.updated_at`
+	_, err := s.pool.Exec(ctx, upsert, p.UserID, p.Email, p.Username, p.Locale, p.UpdatedAt)
+	return err
+}
@@ -0,0 +1,45 @@
+// Package profile keeps a replica of the user attributes notifications
+// depend on, built from user-service events. Events that do not carry them
+// (older schema versions, or events about something other than the
+// profile) are resolved against it.
+package profile
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNotFound = errors.New("profile not replicated")
+
+// Profile is the replicated state of one user. Empty fields are unknown.
+type Profile struct {
+	UserID    uuid.UUID
+	Email     string
+	Username  string
+	Locale    string
+	UpdatedAt time.Time
+}
+
+// Store holds the replica. Upsert ignores a profile older than the stored
+// one, so redelivered or reordered events cannot roll it back, and keeps
+// stored fields that the new profile leaves empty.
+type Store interface {
+	Get(ctx context.Context, userID uuid.UUID) (Profile, error)
+	Upsert(ctx context.Context, p Profile) error
+}
+
+// Locale returns the replicated locale of userID, or "" when it is not
+// known; lookups are best effort and never fail a notification.
+func Locale(ctx context.Context, store Store, userID uuid.UUID) string {
+	if store == nil {
+		return ""
+	}
+	p, err := store.Get(ctx, userID)
+	if err != nil {
+		return ""
+	}
+	return p.Locale
+}
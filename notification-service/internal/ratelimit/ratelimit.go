@@ -0,0 +1,199 @@
+// Package ratelimit keeps token buckets that cap how fast notifications
+// go out. Buckets live in process memory, so every replica enforces the
+// limits on its own: with N replicas up to N times a limit goes out in
+// total. Split a limit between them with Share.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limit is a token bucket that holds Burst tokens and refills Burst of
+// them every Per. The zero Limit is unlimited.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseLimit parses "count/period", e.g. "10/1m" for bursts of ten and ten
+// more every minute. An empty string or "unlimited" is unlimited.
+func ParseLimit(raw string) (Limit, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "unlimited" {
+		return Limit{}, nil
+	}
+	count, period, ok := strings.Cut(raw, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q is not count/period", raw)
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || burst <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: count must be a positive integer", raw)
+	}
+	per, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: period must be a positive duration", raw)
+	}
+	return Limit{Burst: burst, Per: per}, nil
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Burst <= 0 || l.Per <= 0
+}
+
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/%s", l.Burst, l.Per)
+}
+
+// Share returns the part of l one of n replicas enforces, so that
+// together they stay within l: Burst divided by n, rounded down but at
+// least one, over the same Per. With n <= 1, or unlimited, it is l.
+func (l Limit) Share(n int) Limit {
+	if n <= 1 || l.Unlimited() {
+		return l
+	}
+	return Limit{Burst: max(l.Burst/n, 1), Per: l.Per}
+}
+
+// interval is the time one token takes to refill.
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Burst)
+}
+
+// Rule applies a limit to one key within a scope, e.g. the recipient
+// scope to one address.
+type Rule struct {
+	Scope string
+	Key   string
+	Limit Limit
+}
+
+// Error reports that a rule's bucket is empty and when it has a token
+// again.
+type Error struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rate limit per %s reached, retry in %s", e.Scope, e.RetryAfter)
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	// full is when the bucket has refilled and can be forgotten.
+	full time.Time
+}
+
+// Limiter keeps a bucket per scope and key.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+
+	throttled sync.Map // scope -> *atomic.Int64
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+// Take takes a token from the bucket of every rule, or from none of them.
+// It returns nil, or an *Error naming the rule that waits longest.
+func (l *Limiter) Take(rules ...Rule) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	var worst *Error
+	buckets := make([]*bucket, len(rules))
+	for i, r := range rules {
+		if r.Limit.Unlimited() {
+			continue
+		}
+		b := l.refill(r, now)
+		buckets[i] = b
+		if b.tokens >= 1 {
+			continue
+		}
+		wait := time.Duration(math.Ceil((1 - b.tokens) * float64(r.Limit.interval())))
+		if worst == nil || wait > worst.RetryAfter {
+			worst = &Error{Scope: r.Scope, RetryAfter: wait}
+		}
+	}
+	if worst != nil {
+		l.counter(worst.Scope).Add(1)
+		return worst
+	}
+	for i, b := range buckets {
+		if b != nil {
+			b.tokens--
+			missing := float64(rules[i].Limit.Burst) - b.tokens
+			b.full = now.Add(time.Duration(missing * float64(rules[i].Limit.interval())))
+		}
+	}
+	return nil
+}
+
+func (l *Limiter) refill(r Rule, now time.Time) *bucket {
+	key := r.Scope + "\x00" + r.Key
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(r.Limit.Burst), at: now, full: now}
+		l.buckets[key] = b
+		return b
+	}
+	elapsed := now.Sub(b.at)
+	b.tokens = min(float64(r.Limit.Burst), b.tokens+float64(elapsed)/float64(r.Limit.interval()))
+	b.at = now
+	return b
+}
+
+// sweep forgets the buckets that have refilled, once a minute, so that
+// one-off recipients do not accumulate.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if !b.full.After(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *Limiter) counter(scope string) *atomic.Int64 {
+	c, _ := l.throttled.LoadOrStore(scope, &atomic.Int64{})
+	return c.(*atomic.Int64)
+}
+
+// Stats counts the throttled sends per scope, and the buckets held.
+type Stats struct {
+	Throttled map[string]int64 `json:"throttled"`
+	Buckets   int              `json:"buckets"`
+}
+
+func (l *Limiter) Stats() Stats {
+	stats := Stats{Throttled: map[string]int64{}}
+	l.throttled.Range(func(scope, c any) bool {
+		stats.Throttled[scope.(string)] = c.(*atomic.Int64).Load()
+		return true
+	})
+	l.mu.Lock()
+	stats.Buckets = len(l.buckets)
+	l.mu.Unlock()
+	return stats
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	for raw, want := range map[string]Limit{
+		"":          {},
+		"unlimited": {},
+		"10/1m":     {Burst: 10, Per: time.Minute},
+		" 3/15m ":   {Burst: 3, Per: 15 * time.Minute},
+	} {
+		if got, err := ParseLimit(raw); err != nil || got != want {
+			t.Errorf("ParseLimit(%q) = %v, %v", raw, got, err)
+		}
+	}
+	for _, raw := range []string{"10", "0/1m", "ten/1m", "10/soon", "10/-1m"} {
+		if _, err := ParseLimit(raw); err == nil {
+			t.Errorf("ParseLimit(%q) accepted", raw)
+		}
+	}
+}
+
+func TestShareSplitsALimitBetweenReplicas(t *testing.T) {
+	hour := Limit{Burst: 20, Per: time.Hour}
+	for _, tc := range []struct {
+		limit    Limit
+		replicas int
+		want     Limit
+	}{
+		{hour, 0, hour},
+		{hour, 1, hour},
+		{hour, 3, Limit{Burst: 6, Per: time.Hour}},
+		{hour, 40, Limit{Burst: 1, Per: time.Hour}},
+		{Limit{}, 3, Limit{}},
+	} {
+		if got := tc.limit.Share(tc.replicas); got != tc.want {
+			t.Errorf("%s.Share(%d) = %s, want %s", tc.limit, tc.replicas, got, tc.want)
+		}
+	}
+}
+
+func TestTakeRefillsAndTakesFromAllBucketsOrNone(t *testing.T) {
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	l := NewLimiter()
+	l.now = func() time.Time { return now }
+	recipient := Rule{Scope: "recipient", Key: "budi@example.com", Limit: Limit{Burst: 2, Per: time.Minute}}
+	channel := Rule{Scope: "channel", Key: "email", Limit: Limit{Burst: 3, Per: time.Second}}
+	unlimited := Rule{Scope: "template", Key: "welcome"}
+
+	for i := 0; i < 2; i++ {
+		if err := l.Take(recipient, channel, unlimited); err != nil {
+			t.Fatalf("take %d: %v", i, err)
+		}
+	}
+	err := l.Take(recipient, channel)
+	var limited *Error
+	if !errors.As(err, &limited) || limited.Scope != "recipient" || limited.RetryAfter != 30*time.Second {
+		t.Fatalf("third take: %v", err)
+	}
+	// The throttled take left the channel bucket alone.
+	other := Rule{Scope: "recipient", Key: "sari@example.com", Limit: recipient.Limit}
+	if err := l.Take(other, channel); err != nil {
+		t.Errorf("another recipient: %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if err := l.Take(recipient, channel); err != nil {
+		t.Errorf("after a refill: %v", err)
+	}
+	if s := l.Stats(); s.Throttled["recipient"] != 1 || s.Buckets != 3 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// Buckets that refilled are forgotten.
+	now = now.Add(time.Hour)
+	l.Take()
+	if s := l.Stats(); s.Buckets != 0 {
+		t.Errorf("%d buckets kept after they refilled", s.Buckets)
+	}
+}
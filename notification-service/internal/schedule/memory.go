@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps jobs in process memory, for tests and local
+// development without a database. Its jobs do not survive a restart.
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: map[string]Job{}}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, j Job) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[j.Key]; ok {
+		return false, nil
+	}
+	s.jobs[j.Key] = j
+	return true, nil
+}
+
+func (s *MemoryStore) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Job
+	for _, j := range s.jobs {
+		if j.Status == StatusPending && !j.RunAt.After(now) {
+			due = append(due, j)
+		}
+	}
+	sort.Slice(due, func(i, k int) bool { return due[i].RunAt.Before(due[k].RunAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		leased := due[i]
+		leased.RunAt = now.Add(lease)
+		s.jobs[leased.Key] = leased
+	}
+	return due, nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, j Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.jobs[j.Key]
+	if !ok || stored.ID != j.ID {
+		return ErrNotFound
+	}
+	s.jobs[j.Key] = j
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[key]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return j, nil
+}
+
+func (s *MemoryStore) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for key, j := range s.jobs {
+		if j.Status != StatusPending && j.UpdatedAt.Before(cutoff) {
+			delete(s.jobs, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,102 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps jobs in the scheduled_jobs table (see
+// migrations/000006_create_digests).
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+const selectJobColumns = `id, key, kind, run_at, payload, status, attempts, last_error, created_at, updated_at`
+
+func scanJob(row pgx.Row) (Job, error) {
+	var j Job
+	var payload []byte
+	err := row.Scan(&j.ID, &j.Key, &j.Kind, &j.RunAt, &payload, &j.Status, &j.Attempts, &j.LastError, &j.CreatedAt, &j.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, ErrNotFound
+	}
+	j.Payload = payload
+	return j, err
+}
+
+func (s *PostgresStore) Create(ctx context.Context, j Job) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		INSERT INTO scheduled_jobs (`+selectJobColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (key) DO NOTHING`,
+		j.ID, j.Key, j.Kind, j.RunAt, []byte(j.Payload), j.Status, j.Attempts, j.LastError, j.CreatedAt, j.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ClaimDue skips rows another scheduler holds locked, so replicas share
+// the jobs without blocking each other.
+func (s *PostgresStore) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Job, error) {
+	rows, err := s.pool.Query(ctx, `
+		UPDATE scheduled_jobs j SET run_at = $2
+		FROM (
+			SELECT id, run_at FROM scheduled_jobs
+			WHERE status = 'pending' AND run_at <= $1
+			ORDER BY run_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		) due
+		WHERE j.id = due.id
+		RETURNING j.id, j.key, j.kind, due.run_at, j.payload, j.status, j.attempts, j.last_error, j.created_at, j.updated_at`,
+		now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *PostgresStore) Update(ctx context.Context, j Job) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE scheduled_jobs SET status = $2, attempts = $3, run_at = $4, last_error = $5, updated_at = $6
+		WHERE id = $1`,
+		j.ID, j.Status, j.Attempts, j.RunAt, j.LastError, j.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (Job, error) {
+	return scanJob(s.pool.QueryRow(ctx, `SELECT `+selectJobColumns+` FROM scheduled_jobs WHERE key = $1`, key))
+}
+
+func (s *PostgresStore) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM scheduled_jobs WHERE status <> 'pending' AND updated_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
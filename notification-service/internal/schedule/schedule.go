@@ -0,0 +1,61 @@
+// Package schedule runs jobs at a given time from a store that survives
+// restarts. Every job has a key, and scheduling a key that exists creates
+// nothing, so callers can schedule on every event without coordinating:
+// a restart neither drops a job nor runs it twice. Jobs are claimed with
+// a lease; one whose runner died is picked up again when it expires.
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+var ErrNotFound = errors.New("scheduled job not found")
+
+// Job is one run of a handler. Payload is the JSON the handler was
+// scheduled with.
+type Job struct {
+	ID        uuid.UUID       `json:"id"`
+	Key       string          `json:"key"`
+	Kind      string          `json:"kind"`
+	RunAt     time.Time       `json:"run_at"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    Status          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Decode unmarshals the payload of j into v.
+func (j Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Store keeps jobs.
+type Store interface {
+	// Create stores j unless a job with its key exists, and reports
+	// whether it did.
+	Create(ctx context.Context, j Job) (bool, error)
+	// ClaimDue returns up to limit pending jobs due by now and postpones
+	// them by lease, so that no other scheduler takes them while they run.
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Job, error)
+	// Update saves the status, attempts, run time and error of j.
+	Update(ctx context.Context, j Job) error
+	Get(ctx context.Context, key string) (Job, error)
+	// Purge deletes the finished jobs last updated before cutoff and
+	// reports how many.
+	Purge(ctx context.Context, cutoff time.Time) (int64, error)
+}
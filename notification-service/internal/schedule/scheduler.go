@@ -0,0 +1,264 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Handler runs a job. Returning an error retries it later, unless the
+// error is Permanent; a Postpone error runs it again without counting
+// the attempt.
+type Handler func(ctx context.Context, job Job) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as one that retrying will not fix; the job fails
+// at once.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+type postponedError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e postponedError) Error() string { return e.err.Error() }
+func (e postponedError) Unwrap() error { return e.err }
+
+// Postpone runs the job again after delay without counting the attempt,
+// for a handler that could not run it yet, e.g. because of a rate limit.
+func Postpone(err error, delay time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return postponedError{err, delay}
+}
+
+// Config tunes a Scheduler. Zero values take the defaults in New.
+type Config struct {
+	// PollInterval is how often due jobs are looked for, and Concurrency
+	// how many run at once.
+	PollInterval time.Duration
+	Concurrency  int
+	// Lease is how long a claimed job is hidden from other schedulers; it
+	// must outlast a run.
+	Lease time.Duration
+	// MaxAttempts is how often a job runs before it fails. The delay
+	// before retry n is RetryMin doubled n-1 times, at most RetryMax.
+	MaxAttempts int
+	RetryMin    time.Duration
+	RetryMax    time.Duration
+	// Retention is how long finished jobs are kept. Their keys can be
+	// scheduled again once they are purged.
+	Retention time.Duration
+}
+
+type Stats struct {
+	Scheduled int64 `json:"scheduled"`
+	Succeeded int64 `json:"succeeded"`
+	Retried   int64 `json:"retried"`
+	Postponed int64 `json:"postponed"`
+	Failed    int64 `json:"failed"`
+}
+
+type Scheduler struct {
+	store    Store
+	cfg      Config
+	handlers map[string]Handler
+	now      func() time.Time
+
+	scheduled, succeeded, retried, postponed, failed atomic.Int64
+	done                                             chan struct{}
+}
+
+func New(store Store, cfg Config) *Scheduler {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 4
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = 5 * time.Minute
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.RetryMin <= 0 {
+		cfg.RetryMin = 30 * time.Second
+	}
+	if cfg.RetryMax < cfg.RetryMin {
+		cfg.RetryMax = max(time.Hour, cfg.RetryMin)
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 30 * 24 * time.Hour
+	}
+	return &Scheduler{store: store, cfg: cfg, handlers: map[string]Handler{}, now: time.Now}
+}
+
+// Handle registers the handler of kind. Register every kind before Start.
+func (s *Scheduler) Handle(kind string, h Handler) {
+	s.handlers[kind] = h
+}
+
+// Schedule creates a job of kind to run at runAt with payload, unless a
+// job with key exists, and reports whether it created one.
+func (s *Scheduler) Schedule(ctx context.Context, kind, key string, runAt time.Time, payload any) (bool, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return false, fmt.Errorf("encode %s job payload: %w", kind, err)
+	}
+	now := s.now().UTC()
+	created, err := s.store.Create(ctx, Job{
+		ID:        uuid.New(),
+		Key:       key,
+		Kind:      kind,
+		RunAt:     runAt.UTC(),
+		Payload:   data,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return false, fmt.Errorf("schedule %s: %w", key, err)
+	}
+	if created {
+		s.scheduled.Add(1)
+	}
+	return created, nil
+}
+
+// Start runs due jobs, and purges old ones hourly, until ctx is
+// cancelled; Wait returns once the runs in flight have finished.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.cfg.PollInterval)
+		defer ticker.Stop()
+		var lastPurge time.Time
+		for {
+			s.RunDue(ctx)
+			if now := s.now(); now.Sub(lastPurge) >= time.Hour {
+				lastPurge = now
+				if n, err := s.store.Purge(ctx, now.Add(-s.cfg.Retention)); err != nil {
+					log.Printf("WARNING: Scheduled job cleanup failed: %v", err)
+				} else if n > 0 {
+					log.Printf("INFO: Removed %d scheduled jobs finished more than %s ago.", n, s.cfg.Retention)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) Wait() {
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+// RunDue runs the jobs that are due, Concurrency at a time, until none is
+// left.
+func (s *Scheduler) RunDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := s.store.ClaimDue(ctx, s.now().UTC(), s.cfg.Lease, s.cfg.Concurrency)
+		if err != nil {
+			log.Printf("ERROR: claim due scheduled jobs: %v", err)
+			return
+		}
+		var wg sync.WaitGroup
+		for _, j := range due {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.run(ctx, j)
+			}()
+		}
+		wg.Wait()
+		if len(due) < s.cfg.Concurrency {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, j Job) {
+	var err error
+	if h, ok := s.handlers[j.Kind]; ok {
+		err = h(ctx, j)
+	} else {
+		// Possibly scheduled by a newer replica during a rollout.
+		err = fmt.Errorf("no handler for job kind %q", j.Kind)
+	}
+	if err != nil && ctx.Err() != nil {
+		// Cut short by shutdown: runs again when the lease expires.
+		return
+	}
+
+	j.UpdatedAt = s.now().UTC()
+	var postponed postponedError
+	if errors.As(err, &postponed) {
+		j.RunAt, j.LastError = j.UpdatedAt.Add(postponed.delay), err.Error()
+		s.postponed.Add(1)
+		if err := s.store.Update(ctx, j); err != nil {
+			log.Printf("ERROR: record scheduled job %s: %v", j.Key, err)
+		}
+		return
+	}
+
+	j.Attempts++
+	var permanent permanentError
+	switch {
+	case err == nil:
+		j.Status, j.LastError = StatusDone, ""
+		s.succeeded.Add(1)
+	case errors.As(err, &permanent) || j.Attempts >= s.cfg.MaxAttempts:
+		j.Status, j.LastError = StatusFailed, err.Error()
+		s.failed.Add(1)
+		log.Printf("ERROR: Scheduled job %s failed after %d attempts: %v", j.Key, j.Attempts, err)
+	default:
+		j.RunAt, j.LastError = j.UpdatedAt.Add(s.retryDelay(j.Attempts)), err.Error()
+		s.retried.Add(1)
+		log.Printf("WARNING: Scheduled job %s failed, retrying at %s: %v", j.Key, j.RunAt.Format(time.RFC3339), err)
+	}
+	if err := s.store.Update(ctx, j); err != nil {
+		log.Printf("ERROR: record scheduled job %s: %v", j.Key, err)
+	}
+}
+
+func (s *Scheduler) retryDelay(attempts int) time.Duration {
+	delay := s.cfg.RetryMin
+	for i := 1; i < attempts && delay < s.cfg.RetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, s.cfg.RetryMax)
+}
+
+func (s *Scheduler) Stats() Stats {
+	return Stats{
+		Scheduled: s.scheduled.Load(),
+		Succeeded: s.succeeded.Load(),
+		Retried:   s.retried.Load(),
+		Postponed: s.postponed.Load(),
+		Failed:    s.failed.Load(),
+	}
+}
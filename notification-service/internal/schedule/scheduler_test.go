@@ -0,0 +1,177 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newScheduler(store Store, clk *clock, cfg Config) *Scheduler {
+	s := New(store, cfg)
+	s.now = clk.now
+	return s
+}
+
+type payload struct {
+	Name string `json:"name"`
+}
+
+func TestJobsRunOnceWhenDue(t *testing.T) {
+	ctx := context.Background()
+	clk := &clock{t: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	s := newScheduler(store, clk, Config{})
+
+	var ran []string
+	s.Handle("greet", func(ctx context.Context, j Job) error {
+		var p payload
+		if err := j.Decode(&p); err != nil {
+			return err
+		}
+		ran = append(ran, p.Name)
+		return nil
+	})
+
+	for _, name := range []string{"budi", "again"} {
+		created, err := s.Schedule(ctx, "greet", "greet:1", clk.now().Add(time.Hour), payload{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != (name == "budi") {
+			t.Errorf("scheduling %s created = %t", name, created)
+		}
+	}
+
+	s.RunDue(ctx)
+	if len(ran) != 0 {
+		t.Fatal("ran before it was due")
+	}
+	clk.advance(time.Hour)
+	s.RunDue(ctx)
+	s.RunDue(ctx)
+
+	// A restarted scheduler on the same store does not run it again.
+	restarted := newScheduler(store, clk, Config{})
+	restarted.Handle("greet", func(ctx context.Context, j Job) error {
+		t.Error("a finished job ran after a restart")
+		return nil
+	})
+	restarted.RunDue(ctx)
+
+	if len(ran) != 1 || ran[0] != "budi" {
+		t.Errorf("ran = %v, want the first payload once", ran)
+	}
+	if j, _ := store.Get(ctx, "greet:1"); j.Status != StatusDone || j.Attempts != 1 {
+		t.Errorf("job = %+v", j)
+	}
+}
+
+func TestAbandonedJobRunsAfterItsLease(t *testing.T) {
+	ctx := context.Background()
+	clk := &clock{t: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	s := newScheduler(store, clk, Config{Lease: time.Minute})
+	runs := 0
+	s.Handle("job", func(ctx context.Context, j Job) error { runs++; return nil })
+	s.Schedule(ctx, "job", "job:1", clk.now(), nil)
+
+	// A replica claims the job and dies before recording it.
+	if claimed, _ := store.ClaimDue(ctx, clk.now(), time.Minute, 10); len(claimed) != 1 {
+		t.Fatalf("claimed %d jobs", len(claimed))
+	}
+	s.RunDue(ctx)
+	if runs != 0 {
+		t.Fatal("a leased job ran")
+	}
+	clk.advance(time.Minute)
+	s.RunDue(ctx)
+	if runs != 1 {
+		t.Errorf("runs = %d after the lease expired, want 1", runs)
+	}
+}
+
+func TestFailedJobsRetryThenFail(t *testing.T) {
+	ctx := context.Background()
+	clk := &clock{t: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	s := newScheduler(store, clk, Config{MaxAttempts: 3, RetryMin: time.Minute})
+	runs := 0
+	s.Handle("flaky", func(ctx context.Context, j Job) error { runs++; return errors.New("smtp down") })
+	s.Handle("broken", func(ctx context.Context, j Job) error { return Permanent(errors.New("bad payload")) })
+	s.Schedule(ctx, "flaky", "flaky:1", clk.now(), nil)
+	s.Schedule(ctx, "broken", "broken:1", clk.now(), nil)
+
+	s.RunDue(ctx)
+	clk.advance(time.Minute)
+	s.RunDue(ctx)
+	if runs != 2 {
+		t.Fatalf("runs = %d, want the retry after a minute", runs)
+	}
+	clk.advance(time.Minute)
+	s.RunDue(ctx)
+	if runs != 2 {
+		t.Fatal("second retry did not back off")
+	}
+	clk.advance(time.Minute)
+	s.RunDue(ctx)
+
+	if j, _ := store.Get(ctx, "flaky:1"); j.Status != StatusFailed || j.Attempts != 3 || j.LastError != "smtp down" {
+		t.Errorf("flaky job = %+v", j)
+	}
+	if j, _ := store.Get(ctx, "broken:1"); j.Status != StatusFailed || j.Attempts != 1 {
+		t.Errorf("permanently failing job = %+v", j)
+	}
+	if stats := s.Stats(); stats.Retried != 2 || stats.Failed != 2 || stats.Scheduled != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if n, _ := store.Purge(ctx, clk.now().Add(time.Second)); n != 2 {
+		t.Errorf("purged %d finished jobs, want 2", n)
+	}
+}
+
+func TestPostponedJobRunsLaterWithoutSpendingAnAttempt(t *testing.T) {
+	ctx := context.Background()
+	clk := &clock{t: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	s := newScheduler(store, clk, Config{MaxAttempts: 1})
+	runs := 0
+	s.Handle("limited", func(ctx context.Context, j Job) error {
+		runs++
+		if runs < 3 {
+			return Postpone(errors.New("rate limited"), 10*time.Second)
+		}
+		return nil
+	})
+	s.Schedule(ctx, "limited", "limited:1", clk.now(), nil)
+
+	for i := 0; i < 3; i++ {
+		s.RunDue(ctx)
+		clk.advance(10 * time.Second)
+	}
+	if j, _ := store.Get(ctx, "limited:1"); runs != 3 || j.Status != StatusDone || j.Attempts != 1 {
+		t.Errorf("runs = %d, job = %+v", runs, j)
+	}
+	if stats := s.Stats(); stats.Postponed != 2 || stats.Failed != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,191 @@
+// Package stream pushes in-app notifications to the clients a user has
+// connected, over Server-Sent Events or WebSockets. Every replica keeps a
+// Hub of its own connections; a notification created on one replica
+// reaches the others through a fanout exchange (see consumer.StreamRelay).
+package stream
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+)
+
+var (
+	ErrTooManyConnections = errors.New("too many open notification streams")
+	ErrClosed             = errors.New("notification stream hub is closed")
+)
+
+// Event is a notification on its way to the user's connections.
+type Event struct {
+	UserID       uuid.UUID          `json:"user_id"`
+	Notification inbox.Notification `json:"notification"`
+}
+
+// ID is the event ID clients resume from; see inbox.Notification.Position.
+func (e Event) ID() string {
+	return e.Notification.Position()
+}
+
+// Publisher announces a new notification to every replica.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// subscriptionBuffer is how many events a connection may fall behind
+// before it is dropped.
+const subscriptionBuffer = 32
+
+// Subscription receives the events of one user for one connection.
+type Subscription struct {
+	hub    *Hub
+	userID uuid.UUID
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Events delivers the user's events in the order this replica received
+// them.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done is closed when the subscription ends: the connection closed it,
+// it fell too far behind, or the hub shut down. A client that reconnects
+// with the ID of the last event it saw misses nothing.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close releases the connection's slot; it may be called more than once.
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+}
+
+func (s *Subscription) end() {
+	s.once.Do(func() { close(s.done) })
+}
+
+type Stats struct {
+	Connections int   `json:"connections"`
+	Users       int   `json:"users"`
+	Delivered   int64 `json:"delivered"`
+	Dropped     int64 `json:"dropped"`
+	Rejected    int64 `json:"rejected"`
+}
+
+// Hub tracks the connections open on this replica and hands each event to
+// those of its user. It is also a Publisher that delivers locally, for
+// tests and single-replica setups.
+type Hub struct {
+	maxPerUser int
+
+	mu     sync.Mutex
+	users  map[uuid.UUID]map[*Subscription]struct{}
+	closed bool
+
+	delivered, dropped, rejected atomic.Int64
+}
+
+// NewHub returns a hub that allows up to maxPerUser connections per user;
+// zero or less means no limit. The count only covers this hub, which is to
+// say this replica; other replicas track their own.
+func NewHub(maxPerUser int) *Hub {
+	return &Hub{maxPerUser: maxPerUser, users: map[uuid.UUID]map[*Subscription]struct{}{}}
+}
+
+// Subscribe opens a connection slot for userID.
+func (h *Hub) Subscribe(userID uuid.UUID) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrClosed
+	}
+	subs := h.users[userID]
+	if h.maxPerUser > 0 && len(subs) >= h.maxPerUser {
+		h.rejected.Add(1)
+		return nil, ErrTooManyConnections
+	}
+	if subs == nil {
+		subs = map[*Subscription]struct{}{}
+		h.users[userID] = subs
+	}
+	s := &Subscription{
+		hub:    h,
+		userID: userID,
+		events: make(chan Event, subscriptionBuffer),
+		done:   make(chan struct{}),
+	}
+	subs[s] = struct{}{}
+	return s, nil
+}
+
+func (h *Hub) remove(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(s)
+}
+
+func (h *Hub) removeLocked(s *Subscription) {
+	if subs, ok := h.users[s.userID]; ok {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(h.users, s.userID)
+		}
+	}
+	s.end()
+}
+
+// Deliver hands e to the user's connections on this replica. A connection
+// whose buffer is full is dropped rather than allowed to hold up the
+// others; its client resumes from the last event it saw.
+func (h *Hub) Deliver(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.users[e.UserID] {
+		select {
+		case s.events <- e:
+			h.delivered.Add(1)
+		default:
+			h.dropped.Add(1)
+			h.removeLocked(s)
+		}
+	}
+}
+
+func (h *Hub) Publish(ctx context.Context, e Event) error {
+	h.Deliver(e)
+	return nil
+}
+
+// Close ends every subscription and refuses new ones, so that long-lived
+// connections do not hold up a server shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.users {
+		for s := range subs {
+			h.removeLocked(s)
+		}
+	}
+}
+
+func (h *Hub) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := Stats{
+		Users:     len(h.users),
+		Delivered: h.delivered.Load(),
+		Dropped:   h.dropped.Load(),
+		Rejected:  h.rejected.Load(),
+	}
+	for _, subs := range h.users {
+		stats.Connections += len(subs)
+	}
+	return stats
+}
@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/notification-service/internal/inbox"
+)
+
+func TestHubDeliversToTheUsersConnections(t *testing.T) {
+	hub := NewHub(2)
+	alice, bob := uuid.New(), uuid.New()
+
+	first, _ := hub.Subscribe(alice)
+	second, _ := hub.Subscribe(alice)
+	other, _ := hub.Subscribe(bob)
+	if _, err := hub.Subscribe(alice); !errors.Is(err, ErrTooManyConnections) {
+		t.Fatalf("third connection: %v", err)
+	}
+
+	e := Event{UserID: alice, Notification: inbox.New(alice, "social", "test", "hi", "")}
+	hub.Deliver(e)
+	for _, s := range []*Subscription{first, second} {
+		select {
+		case got := <-s.Events():
+			if got.ID() != e.ID() {
+				t.Errorf("got event %s, want %s", got.ID(), e.ID())
+			}
+		default:
+			t.Error("a connection of the user missed the event")
+		}
+	}
+	select {
+	case <-other.Events():
+		t.Error("another user received the event")
+	default:
+	}
+
+	first.Close()
+	first.Close()
+	if _, err := hub.Subscribe(alice); err != nil {
+		t.Errorf("closing a connection did not free its slot: %v", err)
+	}
+}
+
+func TestHubDropsSlowConnections(t *testing.T) {
+	hub := NewHub(0)
+	userID := uuid.New()
+	s, _ := hub.Subscribe(userID)
+
+	for i := 0; i <= subscriptionBuffer; i++ {
+		hub.Deliver(Event{UserID: userID, Notification: inbox.New(userID, "social", "test", "n", "")})
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("a connection that fell behind was kept")
+	}
+	if stats := hub.Stats(); stats.Dropped != 1 || stats.Connections != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestHubCloseEndsSubscriptions(t *testing.T) {
+	hub := NewHub(0)
+	s, _ := hub.Subscribe(uuid.New())
+	hub.Close()
+	select {
+	case <-s.Done():
+	default:
+		t.Error("subscription still open after Close")
+	}
+	if _, err := hub.Subscribe(uuid.New()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Subscribe after Close: %v", err)
+	}
+}
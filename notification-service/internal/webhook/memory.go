@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps webhooks in process memory, for tests and local
+// development without a database.
+type MemoryStore struct {
+	mu            sync.Mutex
+	subscriptions map[uuid.UUID]Subscription
+	deliveries    map[uuid.UUID]Delivery
+	attempts      map[uuid.UUID][]Attempt
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		subscriptions: map[uuid.UUID]Subscription{},
+		deliveries:    map[uuid.UUID]Delivery{},
+		attempts:      map[uuid.UUID][]Attempt{},
+	}
+}
+
+func (s *MemoryStore) CreateSubscription(ctx context.Context, sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.EventTypes = slices.Clone(sub.EventTypes)
+	s.subscriptions[sub.ID] = sub
+	return nil
+}
+
+func (s *MemoryStore) GetSubscription(ctx context.Context, id uuid.UUID) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	return sub, nil
+}
+
+func (s *MemoryStore) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs, nil
+}
+
+func (s *MemoryStore) UpdateSubscription(ctx context.Context, sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[sub.ID]; !ok {
+		return ErrNotFound
+	}
+	sub.EventTypes = slices.Clone(sub.EventTypes)
+	s.subscriptions[sub.ID] = sub
+	return nil
+}
+
+func (s *MemoryStore) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.subscriptions, id)
+	for did, d := range s.deliveries {
+		if d.SubscriptionID == id {
+			delete(s.deliveries, did)
+			delete(s.attempts, did)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Subscribers(ctx context.Context, eventType string) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var subs []Subscription
+	for _, sub := range s.subscriptions {
+		if sub.Wants(eventType) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (s *MemoryStore) RecordOutcome(ctx context.Context, id uuid.UUID, ok bool, disableAfter int, at time.Time) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, found := s.subscriptions[id]
+	if !found {
+		return Subscription{}, ErrNotFound
+	}
+	sub = applyOutcome(sub, ok, disableAfter, at)
+	s.subscriptions[id] = sub
+	return sub, nil
+}
+
+// applyOutcome is RecordOutcome on a subscription value.
+func applyOutcome(sub Subscription, ok bool, disableAfter int, at time.Time) Subscription {
+	if ok {
+		sub.ConsecutiveFailures = 0
+		return sub
+	}
+	sub.ConsecutiveFailures++
+	if sub.Active && disableAfter > 0 && sub.ConsecutiveFailures >= disableAfter {
+		sub.Active = false
+		sub.DisabledReason = disabledReason(sub.ConsecutiveFailures)
+		sub.UpdatedAt = at
+	}
+	return sub
+}
+
+func disabledReason(failures int) string {
+	return fmt.Sprintf("disabled after %d consecutive failed attempts", failures)
+}
+
+func (s *MemoryStore) CreateDelivery(ctx context.Context, d Delivery) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[d.SubscriptionID]; !ok {
+		return false, ErrNotFound
+	}
+	for _, existing := range s.deliveries {
+		if existing.SubscriptionID == d.SubscriptionID && existing.EventID == d.EventID {
+			return false, nil
+		}
+	}
+	s.deliveries[d.ID] = d
+	return true, nil
+}
+
+func (s *MemoryStore) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Delivery
+	for _, d := range s.deliveries {
+		if d.Status == StatusPending && d.NextAttemptAt != nil && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(*due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	leased := now.Add(lease)
+	for i := range due {
+		due[i].NextAttemptAt = &leased
+		s.deliveries[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+func (s *MemoryStore) UpdateDelivery(ctx context.Context, d Delivery, a *Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.deliveries[d.ID]; !ok {
+		return fmt.Errorf("delivery %s: %w", d.ID, ErrNotFound)
+	}
+	s.deliveries[d.ID] = d
+	if a != nil {
+		s.attempts[d.ID] = append(s.attempts[d.ID], *a)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Delivery
+	for _, d := range s.deliveries {
+		if d.SubscriptionID == subscriptionID {
+			out = append(out, d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) Attempts(ctx context.Context, deliveryID uuid.UUID) ([]Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.attempts[deliveryID]), nil
+}
@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/contracts"
+)
+
+// partnerEvents lists the event types partners may subscribe to, each with
+// the projection of its data that is sent to them. Projections carry IDs
+// and timestamps only: no email addresses, usernames or roles leave
+// Filmnesia through a webhook. An event type that is not listed here
+// cannot be subscribed to, even if contracts has a schema for it.
+var partnerEvents = map[string]func(contracts.Envelope) (any, error){
+	contracts.EventUserRegistered: project(func(e contracts.UserRegisteredEvent) any {
+		return partnerUserRegistered{UserID: e.UserID, RegisteredAt: e.RegisteredAt}
+	}),
+	contracts.EventUserUpdated: project(func(e contracts.UserUpdatedEvent) any {
+		return partnerUserUpdated{UserID: e.UserID, UpdatedAt: e.UpdatedAt}
+	}),
+	contracts.EventUserEmailChanged: project(func(e contracts.UserEmailChangedEvent) any {
+		return partnerUserChanged{UserID: e.UserID, ChangedAt: e.ChangedAt}
+	}),
+	contracts.EventUserRoleChanged: project(func(e contracts.UserRoleChangedEvent) any {
+		return partnerUserChanged{UserID: e.UserID, ChangedAt: e.ChangedAt}
+	}),
+	contracts.EventUserDeleted: project(func(e contracts.UserDeletedEvent) any {
+		return partnerUserDeleted{UserID: e.UserID, DeletedAt: e.DeletedAt}
+	}),
+}
+
+type partnerUserRegistered struct {
+	UserID       uuid.UUID `json:"user_id"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+type partnerUserUpdated struct {
+	UserID    uuid.UUID `json:"user_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type partnerUserChanged struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type partnerUserDeleted struct {
+	UserID    uuid.UUID `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func project[T any](fn func(T) any) func(contracts.Envelope) (any, error) {
+	return func(event contracts.Envelope) (any, error) {
+		var data T
+		if err := event.DecodeData(&data); err != nil {
+			return nil, fmt.Errorf("decode %s data: %w", event.RoutingKey(), err)
+		}
+		return fn(data), nil
+	}
+}
+
+// PartnerEventTypes returns the event types partners may subscribe to,
+// sorted.
+func PartnerEventTypes() []string {
+	return slices.Sorted(maps.Keys(partnerEvents))
+}
+
+// PartnerDataSchema identifies the partner projection of an event type.
+// It is versioned separately from the internal schema, which partners
+// never see.
+func PartnerDataSchema(eventType string) string {
+	return "/webhooks/" + eventType + "/v1"
+}
+
+// partnerEnvelope returns event with its data replaced by the partner
+// projection. ok is false for event types partners cannot receive.
+func partnerEnvelope(event contracts.Envelope) (contracts.Envelope, bool, error) {
+	projection, ok := partnerEvents[event.RoutingKey()]
+	if !ok {
+		return contracts.Envelope{}, false, nil
+	}
+	data, err := projection(event)
+	if err != nil {
+		return contracts.Envelope{}, true, err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return contracts.Envelope{}, true, err
+	}
+	event.DataSchema = PartnerDataSchema(event.RoutingKey())
+	event.Data = raw
+	return event, true, nil
+}
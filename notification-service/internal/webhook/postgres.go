@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore keeps webhooks in the webhook_subscriptions,
+// webhook_deliveries and webhook_attempts tables (see
+// migrations/000005_create_webhooks).
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+const selectSubscriptionColumns = `id, url, event_types, description, secret, active,
+	consecutive_failures, disabled_reason, created_by, created_at, updated_at`
+
+func scanSubscription(row pgx.Row) (Subscription, error) {
+	var s Subscription
+	err := row.Scan(&s.ID, &s.URL, &s.EventTypes, &s.Description, &s.Secret, &s.Active,
+		&s.ConsecutiveFailures, &s.DisabledReason, &s.CreatedBy, &s.CreatedAt, &s.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Subscription{}, ErrNotFound
+	}
+	return s, err
+}
+
+func collectSubscriptions(rows pgx.Rows, err error) ([]Subscription, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []Subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+func (s *PostgresStore) CreateSubscription(ctx context.Context, sub Subscription) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO webhook_subscriptions (`+selectSubscriptionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		sub.ID, sub.URL, sub.EventTypes, sub.Description, sub.Secret, sub.Active,
+		sub.ConsecutiveFailures, sub.DisabledReason, sub.CreatedBy, sub.CreatedAt, sub.UpdatedAt)
+	return err
+}
+
+func (s *PostgresStore) GetSubscription(ctx context.Context, id uuid.UUID) (Subscription, error) {
+	return scanSubscription(s.pool.QueryRow(ctx,
+		`SELECT `+selectSubscriptionColumns+` FROM webhook_subscriptions WHERE id = $1`, id))
+}
+
+func (s *PostgresStore) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	return collectSubscriptions(s.pool.Query(ctx,
+		`SELECT `+selectSubscriptionColumns+` FROM webhook_subscriptions ORDER BY created_at`))
+}
+
+func (s *PostgresStore) UpdateSubscription(ctx context.Context, sub Subscription) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE webhook_subscriptions
+		SET url = $2, event_types = $3, description = $4, secret = $5, active = $6,
+		    consecutive_failures = $7, disabled_reason = $8, updated_at = $9
+		WHERE id = $1`,
+		sub.ID, sub.URL, sub.EventTypes, sub.Description, sub.Secret, sub.Active,
+		sub.ConsecutiveFailures, sub.DisabledReason, sub.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) Subscribers(ctx context.Context, eventType string) ([]Subscription, error) {
+	return collectSubscriptions(s.pool.Query(ctx,
+		`SELECT `+selectSubscriptionColumns+` FROM webhook_subscriptions
+		 WHERE active AND $1 = ANY(event_types)`, eventType))
+}
+
+// RecordOutcome updates the counter in one statement, so concurrent
+// workers cannot lose a failure. Every right-hand side sees the row as it
+// was before the update.
+func (s *PostgresStore) RecordOutcome(ctx context.Context, id uuid.UUID, ok bool, disableAfter int, at time.Time) (Subscription, error) {
+	const disable = `(NOT $2 AND active AND $3 > 0 AND consecutive_failures + 1 >= $3)`
+	return scanSubscription(s.pool.QueryRow(ctx, `
+		UPDATE webhook_subscriptions
+		SET consecutive_failures = CASE WHEN $2 THEN 0 ELSE consecutive_failures + 1 END,
+		    active = active AND NOT `+disable+`,
+		    disabled_reason = CASE WHEN `+disable+`
+		        THEN 'disabled after ' || (consecutive_failures + 1) || ' consecutive failed attempts'
+		        ELSE disabled_reason END,
+		    updated_at = CASE WHEN `+disable+` THEN $4 ELSE updated_at END
+		WHERE id = $1
+		RETURNING `+selectSubscriptionColumns,
+		id, ok, disableAfter, at))
+}
+
+const selectDeliveryColumns = `id, subscription_id, event_id, event_type, payload, status,
+	attempts, next_attempt_at, created_at, updated_at`
+
+func scanDelivery(row pgx.Row) (Delivery, error) {
+	var d Delivery
+	var payload []byte
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &payload, &d.Status,
+		&d.Attempts, &d.NextAttemptAt, &d.CreatedAt, &d.UpdatedAt)
+	d.Payload = payload
+	return d, err
+}
+
+func collectDeliveries(rows pgx.Rows, err error) ([]Delivery, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s *PostgresStore) CreateDelivery(ctx context.Context, d Delivery) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		INSERT INTO webhook_deliveries (`+selectDeliveryColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (subscription_id, event_id) DO NOTHING`,
+		d.ID, d.SubscriptionID, d.EventID, d.EventType, []byte(d.Payload), d.Status,
+		d.Attempts, d.NextAttemptAt, d.CreatedAt, d.UpdatedAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ClaimDue skips rows another worker holds locked, so replicas share the
+// queue without blocking each other.
+func (s *PostgresStore) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	return collectDeliveries(s.pool.Query(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+selectDeliveryColumns,
+		now, now.Add(lease), limit))
+}
+
+func (s *PostgresStore) UpdateDelivery(ctx context.Context, d Delivery, a *Attempt) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4, updated_at = $5
+		WHERE id = $1`,
+		d.ID, d.Status, d.Attempts, d.NextAttemptAt, d.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if a != nil {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO webhook_attempts (delivery_id, number, attempted_at, status_code, error, response_body, duration_ms)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (delivery_id, number) DO NOTHING`,
+			d.ID, a.Number, a.At, a.StatusCode, a.Error, a.ResponseBody, a.DurationMS)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, limit int) ([]Delivery, error) {
+	return collectDeliveries(s.pool.Query(ctx,
+		`SELECT `+selectDeliveryColumns+` FROM webhook_deliveries
+		 WHERE subscription_id = $1 ORDER BY created_at DESC LIMIT $2`, subscriptionID, limit))
+}
+
+func (s *PostgresStore) Attempts(ctx context.Context, deliveryID uuid.UUID) ([]Attempt, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT number, attempted_at, status_code, error, response_body, duration_ms
+		FROM webhook_attempts WHERE delivery_id = $1 ORDER BY number`, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Attempt
+	for rows.Next() {
+		a := Attempt{DeliveryID: deliveryID}
+		if err := rows.Scan(&a.Number, &a.At, &a.StatusCode, &a.Error, &a.ResponseBody, &a.DurationMS); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery. The signature covers the timestamp
+// and the body, "<timestamp>.<body>", so a captured request cannot be
+// replayed later with a fresh timestamp.
+const (
+	HeaderID        = "X-Filmnesia-Webhook-Id"
+	HeaderEvent     = "X-Filmnesia-Webhook-Event"
+	HeaderTimestamp = "X-Filmnesia-Webhook-Timestamp"
+	HeaderSignature = "X-Filmnesia-Webhook-Signature"
+
+	signatureVersion = "v1"
+)
+
+var (
+	ErrMissingSignature = errors.New("webhook signature headers are missing")
+	ErrBadSignature     = errors.New("webhook signature does not match")
+	ErrStaleTimestamp   = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// Sign returns the value of HeaderSignature for body sent at timestamp:
+// "v1=" and the hex HMAC-SHA256 of "<unix timestamp>.<body>" under secret.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return signatureVersion + "=" + hex.EncodeToString(mac(secret, strconv.FormatInt(timestamp.Unix(), 10), body))
+}
+
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Verify checks the signature headers of a delivery the way a receiver
+// should: the signature must match and the timestamp be within tolerance
+// of now. Receivers in Go can use it as is.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp, signature := header.Get(HeaderTimestamp), header.Get(HeaderSignature)
+	if timestamp == "" || signature == "" {
+		return ErrMissingSignature
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrMissingSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrStaleTimestamp
+	}
+
+	want := mac(secret, timestamp, body)
+	// Several signatures may be sent, comma separated, e.g. while a secret
+	// is rotated; one match is enough.
+	for _, part := range strings.Split(signature, ",") {
+		version, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || version != signatureVersion {
+			continue
+		}
+		got, err := hex.DecodeString(value)
+		if err == nil && hmac.Equal(got, want) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
@@ -0,0 +1,204 @@
+// Package webhook delivers Filmnesia events to partner endpoints over
+// HTTP. A subscription names a URL and the event types it wants; every
+// matching event becomes a delivery, which is signed, POSTed and retried
+// with exponential backoff until it succeeds or runs out of attempts.
+// Endpoints that keep failing are disabled.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventTest is the type of the events SendTest sends; it cannot be
+// subscribed to.
+const EventTest = "webhook.test"
+
+// MinSecretLength is the shortest signing secret a subscription accepts.
+const MinSecretLength = 16
+
+var (
+	ErrNotFound         = errors.New("webhook subscription not found")
+	ErrInvalidURL       = errors.New("invalid webhook URL")
+	ErrUnknownEventType = errors.New("unknown event type")
+	ErrNoEventTypes     = errors.New("at least one event type is required")
+	ErrWeakSecret       = fmt.Errorf("secret must be at least %d characters", MinSecretLength)
+)
+
+type Subscription struct {
+	ID          uuid.UUID `json:"id"`
+	URL         string    `json:"url"`
+	EventTypes  []string  `json:"event_types"`
+	Description string    `json:"description,omitempty"`
+	// Secret signs every delivery; it is only shown when the subscription
+	// is created.
+	Secret string `json:"-"`
+	Active bool   `json:"active"`
+	// ConsecutiveFailures counts the failed attempts since the last
+	// successful one; at Config.DisableAfter the subscription is turned
+	// off and DisabledReason says why.
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	DisabledReason      string    `json:"disabled_reason,omitempty"`
+	CreatedBy           uuid.UUID `json:"created_by"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// Wants reports whether s is active and subscribed to eventType.
+func (s Subscription) Wants(eventType string) bool {
+	return s.Active && slices.Contains(s.EventTypes, eventType)
+}
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Delivery is one event on its way to one subscription. Its ID is sent
+// with every attempt, so receivers can drop repeats.
+type Delivery struct {
+	ID             uuid.UUID       `json:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         Status          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	// NextAttemptAt is nil once the delivery succeeded or failed for good.
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Attempt records one POST of a delivery. StatusCode is zero when no
+// response arrived; ResponseBody is truncated.
+type Attempt struct {
+	DeliveryID   uuid.UUID `json:"-"`
+	Number       int       `json:"number"`
+	At           time.Time `json:"at"`
+	StatusCode   int       `json:"status_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+	DurationMS   int64     `json:"duration_ms"`
+}
+
+// Succeeded reports whether the endpoint accepted the delivery.
+func (a Attempt) Succeeded() bool {
+	return a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+// Store keeps subscriptions, deliveries and their attempts.
+type Store interface {
+	CreateSubscription(ctx context.Context, s Subscription) error
+	GetSubscription(ctx context.Context, id uuid.UUID) (Subscription, error)
+	ListSubscriptions(ctx context.Context) ([]Subscription, error)
+	// UpdateSubscription replaces the editable fields, activity and
+	// failure count of s.
+	UpdateSubscription(ctx context.Context, s Subscription) error
+	// DeleteSubscription deletes s with its deliveries.
+	DeleteSubscription(ctx context.Context, id uuid.UUID) error
+	// Subscribers returns the active subscriptions to eventType.
+	Subscribers(ctx context.Context, eventType string) ([]Subscription, error)
+	// RecordOutcome resets the subscription's failure count after a
+	// success, or counts a failure and disables it once disableAfter
+	// failures ran in a row. It returns the subscription as updated.
+	RecordOutcome(ctx context.Context, id uuid.UUID, ok bool, disableAfter int, at time.Time) (Subscription, error)
+
+	// CreateDelivery stores d unless the subscription already has a
+	// delivery of the same event, and reports whether it did.
+	CreateDelivery(ctx context.Context, d Delivery) (bool, error)
+	// ClaimDue returns up to limit pending deliveries due by now and
+	// postpones them by lease, so that no other worker takes them while
+	// they are attempted.
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error)
+	// UpdateDelivery saves the new state of d, and a when it is not nil.
+	UpdateDelivery(ctx context.Context, d Delivery, a *Attempt) error
+	// ListDeliveries returns the latest deliveries of a subscription,
+	// newest first.
+	ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, limit int) ([]Delivery, error)
+	Attempts(ctx context.Context, deliveryID uuid.UUID) ([]Attempt, error)
+}
+
+// NewSubscription validates the fields a client supplies and prepares a
+// subscription for CreateSubscription. An empty secret is generated.
+func NewSubscription(rawURL string, eventTypes []string, description, secret string, createdBy uuid.UUID, allowPrivate bool) (Subscription, error) {
+	if err := ValidateURL(rawURL, allowPrivate); err != nil {
+		return Subscription{}, err
+	}
+	if err := ValidateEventTypes(eventTypes); err != nil {
+		return Subscription{}, err
+	}
+	if secret == "" {
+		secret = GenerateSecret()
+	} else if len(secret) < MinSecretLength {
+		return Subscription{}, ErrWeakSecret
+	}
+	now := time.Now().UTC()
+	return Subscription{
+		ID:          uuid.New(),
+		URL:         rawURL,
+		EventTypes:  slices.Compact(slices.Sorted(slices.Values(eventTypes))),
+		Description: description,
+		Secret:      secret,
+		Active:      true,
+		CreatedBy:   createdBy,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, nil
+}
+
+// ValidateURL accepts absolute https URLs. With allowPrivate, meant for
+// local development and tests, plain http is accepted too; see
+// Config.AllowPrivate for the addresses it opens up.
+func ValidateURL(rawURL string, allowPrivate bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	switch {
+	case u.Scheme != "https" && !(allowPrivate && u.Scheme == "http"):
+		return fmt.Errorf("%w: must use https", ErrInvalidURL)
+	case u.Host == "" || u.Hostname() == "":
+		return fmt.Errorf("%w: host is missing", ErrInvalidURL)
+	case u.User != nil:
+		return fmt.Errorf("%w: credentials are not allowed in the URL", ErrInvalidURL)
+	case u.Fragment != "":
+		return fmt.Errorf("%w: fragments are not allowed", ErrInvalidURL)
+	}
+	return nil
+}
+
+// ValidateEventTypes accepts the event types partners may subscribe to;
+// see PartnerEventTypes.
+func ValidateEventTypes(eventTypes []string) error {
+	if len(eventTypes) == 0 {
+		return ErrNoEventTypes
+	}
+	for _, t := range eventTypes {
+		if _, ok := partnerEvents[t]; !ok {
+			return fmt.Errorf("%w: %q (partners may subscribe to %v)", ErrUnknownEventType, t, PartnerEventTypes())
+		}
+	}
+	return nil
+}
+
+// GenerateSecret returns a random signing secret.
+func GenerateSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("webhook: generate secret: %v", err))
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,399 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/contracts"
+)
+
+// EventSource is the CloudEvents source of the test events.
+const EventSource = "/notification-service"
+
+// maxResponseBody is how much of a response an attempt keeps.
+const maxResponseBody = 1024
+
+var ErrForbiddenAddress = errors.New("webhook endpoint resolves to a private address")
+
+// Config tunes delivery. Zero values take the defaults in NewWorker.
+type Config struct {
+	// MaxAttempts is how often a delivery is tried before it fails.
+	MaxAttempts int
+	// The delay before retry n is RetryMin doubled n-1 times, at most
+	// RetryMax.
+	RetryMin time.Duration
+	RetryMax time.Duration
+	// Timeout bounds one attempt, from dialling to the response headers.
+	Timeout time.Duration
+	// DisableAfter is how many attempts in a row may fail before the
+	// subscription is disabled; zero or less never disables.
+	DisableAfter int
+	// AllowPrivate accepts http:// URLs and endpoints on loopback and
+	// private networks. Without it those are refused, so a subscription
+	// cannot be used to reach services inside the cluster.
+	AllowPrivate bool
+	// PollInterval is how often due deliveries are looked for, and
+	// Concurrency how many are attempted at once.
+	PollInterval time.Duration
+	Concurrency  int
+}
+
+type Stats struct {
+	Enqueued  int64 `json:"enqueued"`
+	Succeeded int64 `json:"succeeded"`
+	Retried   int64 `json:"retried"`
+	Failed    int64 `json:"failed"`
+	Disabled  int64 `json:"disabled"`
+}
+
+// Worker turns events into deliveries and attempts them. Several
+// replicas may run workers on one Postgres store; ClaimDue keeps them
+// from attempting the same delivery at once.
+type Worker struct {
+	store  Store
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	enqueued, succeeded, retried, failed, disabled atomic.Int64
+	done                                           chan struct{}
+}
+
+func NewWorker(store Store, cfg Config) *Worker {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.RetryMin <= 0 {
+		cfg.RetryMin = 30 * time.Second
+	}
+	if cfg.RetryMax < cfg.RetryMin {
+		cfg.RetryMax = max(6*time.Hour, cfg.RetryMin)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 4
+	}
+	return &Worker{store: store, cfg: cfg, client: newClient(cfg), now: time.Now}
+}
+
+// AllowPrivate reports whether the worker reaches private endpoints, and
+// so which URLs subscriptions may use; see ValidateURL.
+func (w *Worker) AllowPrivate() bool {
+	return w.cfg.AllowPrivate
+}
+
+func newClient(cfg Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		// Checked on the resolved address, so a public name that points
+		// inside the network is refused too.
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if cfg.AllowPrivate {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !publicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			// No proxy from the environment: it would dial on our behalf
+			// and bypass the address check.
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   cfg.Timeout,
+			ResponseHeaderTimeout: cfg.Timeout,
+			MaxIdleConnsPerHost:   2,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		// A redirect is reported as the failed attempt it is rather than
+		// followed to wherever it points.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// Enqueue creates a delivery of event for every active subscription to
+// its type and returns how many it created. The payload is the event's
+// partner projection; event types partners cannot receive are skipped.
+// Enqueueing an event again creates nothing, so redelivered messages are
+// harmless.
+func (w *Worker) Enqueue(ctx context.Context, event contracts.Envelope) (int, error) {
+	projected, ok, err := partnerEnvelope(event)
+	if !ok || err != nil {
+		return 0, err
+	}
+	subs, err := w.store.Subscribers(ctx, event.RoutingKey())
+	if err != nil {
+		return 0, fmt.Errorf("find webhook subscribers of %s: %w", event.RoutingKey(), err)
+	}
+	if len(subs) == 0 {
+		return 0, nil
+	}
+	payload, err := json.Marshal(projected)
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	now := w.now().UTC()
+	for _, sub := range subs {
+		ok, err := w.store.CreateDelivery(ctx, Delivery{
+			ID:             uuid.New(),
+			SubscriptionID: sub.ID,
+			EventID:        event.ID,
+			EventType:      event.RoutingKey(),
+			Payload:        payload,
+			Status:         StatusPending,
+			NextAttemptAt:  &now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+		switch {
+		case errors.Is(err, ErrNotFound):
+			// Deleted since Subscribers.
+		case err != nil:
+			return created, fmt.Errorf("enqueue webhook delivery for subscription %s: %w", sub.ID, err)
+		case ok:
+			created++
+		}
+	}
+	w.enqueued.Add(int64(created))
+	return created, nil
+}
+
+// Start attempts due deliveries until ctx is cancelled; Wait returns once
+// the attempts in flight have finished. An attempt cut short by the
+// cancellation is not recorded and runs again when its lease expires.
+func (w *Worker) Start(ctx context.Context) {
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.cfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			w.RunDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (w *Worker) Wait() {
+	if w.done != nil {
+		<-w.done
+	}
+}
+
+// RunDue attempts the deliveries that are due, Concurrency at a time,
+// until none is left.
+func (w *Worker) RunDue(ctx context.Context) {
+	// The lease outlasts an attempt with room to record it.
+	lease := 2*w.cfg.Timeout + time.Minute
+	for ctx.Err() == nil {
+		due, err := w.store.ClaimDue(ctx, w.now().UTC(), lease, w.cfg.Concurrency)
+		if err != nil {
+			log.Printf("ERROR: claim due webhook deliveries: %v", err)
+			return
+		}
+		var wg sync.WaitGroup
+		for _, d := range due {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.attempt(ctx, d)
+			}()
+		}
+		wg.Wait()
+		if len(due) < w.cfg.Concurrency {
+			return
+		}
+	}
+}
+
+func (w *Worker) attempt(ctx context.Context, d Delivery) {
+	sub, err := w.store.GetSubscription(ctx, d.SubscriptionID)
+	if errors.Is(err, ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: load webhook subscription %s: %v", d.SubscriptionID, err)
+		return
+	}
+	if !sub.Active {
+		d.Status, d.NextAttemptAt, d.UpdatedAt = StatusFailed, nil, w.now().UTC()
+		if err := w.store.UpdateDelivery(ctx, d, nil); err != nil {
+			log.Printf("ERROR: fail webhook delivery %s of a disabled subscription: %v", d.ID, err)
+		}
+		w.failed.Add(1)
+		return
+	}
+
+	a := w.post(ctx, sub, d.ID, d.EventType, d.Payload, d.Attempts+1)
+	if ctx.Err() != nil {
+		return
+	}
+	now := w.now().UTC()
+	d.Attempts++
+	d.UpdatedAt = now
+	switch {
+	case a.Succeeded():
+		d.Status, d.NextAttemptAt = StatusSucceeded, nil
+		w.succeeded.Add(1)
+	case d.Attempts >= w.cfg.MaxAttempts:
+		d.Status, d.NextAttemptAt = StatusFailed, nil
+		w.failed.Add(1)
+		log.Printf("WARNING: Webhook delivery %s of %s to subscription %s failed after %d attempts", d.ID, d.EventType, sub.ID, d.Attempts)
+	default:
+		next := now.Add(w.retryDelay(d.Attempts))
+		d.NextAttemptAt = &next
+		w.retried.Add(1)
+	}
+	if err := w.store.UpdateDelivery(ctx, d, &a); err != nil {
+		log.Printf("ERROR: record webhook delivery %s: %v", d.ID, err)
+		return
+	}
+
+	updated, err := w.store.RecordOutcome(ctx, sub.ID, a.Succeeded(), w.cfg.DisableAfter, now)
+	if err != nil {
+		log.Printf("ERROR: record outcome for webhook subscription %s: %v", sub.ID, err)
+		return
+	}
+	if sub.Active && !updated.Active {
+		w.disabled.Add(1)
+		log.Printf("WARNING: Webhook subscription %s (%s) was %s", sub.ID, sub.URL, updated.DisabledReason)
+	}
+}
+
+// retryDelay is the wait after the given number of failed attempts.
+func (w *Worker) retryDelay(attempts int) time.Duration {
+	d := w.cfg.RetryMin
+	for i := 1; i < attempts && d < w.cfg.RetryMax; i++ {
+		d *= 2
+	}
+	return min(d, w.cfg.RetryMax)
+}
+
+// post signs and sends one attempt of a delivery.
+func (w *Worker) post(ctx context.Context, sub Subscription, deliveryID uuid.UUID, eventType string, body []byte, number int) Attempt {
+	start := w.now()
+	a := Attempt{DeliveryID: deliveryID, Number: number, At: start.UTC()}
+
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Filmnesia-Webhooks/1.0")
+	req.Header.Set(HeaderID, deliveryID.String())
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(start.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, start, body))
+
+	resp, err := w.client.Do(req)
+	a.DurationMS = w.now().Sub(start).Milliseconds()
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	defer resp.Body.Close()
+	a.StatusCode = resp.StatusCode
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	a.ResponseBody = string(bytes.ToValidUTF8(excerpt, nil))
+	// Draining a little lets the connection be reused.
+	io.CopyN(io.Discard, resp.Body, 64<<10)
+	return a
+}
+
+// SendTest sends a webhook.test event to sub right away, whether or not
+// it is active, and records it as a delivery with a single attempt. It
+// does not count towards disabling the subscription.
+func (w *Worker) SendTest(ctx context.Context, sub Subscription) (Delivery, Attempt, error) {
+	data, _ := json.Marshal(map[string]string{
+		"subscription_id": sub.ID.String(),
+		"message":         "This is a test event from Filmnesia.",
+	})
+	now := w.now().UTC()
+	event := contracts.Envelope{
+		SpecVersion:     contracts.SpecVersion,
+		ID:              uuid.NewString(),
+		Source:          EventSource,
+		Type:            contracts.TypePrefix + EventTest,
+		Time:            now,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return Delivery{}, Attempt{}, err
+	}
+	d := Delivery{
+		ID:             uuid.New(),
+		SubscriptionID: sub.ID,
+		EventID:        event.ID,
+		EventType:      EventTest,
+		Payload:        payload,
+		Status:         StatusPending,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if _, err := w.store.CreateDelivery(ctx, d); err != nil {
+		return Delivery{}, Attempt{}, err
+	}
+
+	a := w.post(ctx, sub, d.ID, EventTest, payload, 1)
+	d.Attempts = 1
+	d.UpdatedAt = w.now().UTC()
+	d.Status = StatusFailed
+	if a.Succeeded() {
+		d.Status = StatusSucceeded
+	}
+	if err := w.store.UpdateDelivery(ctx, d, &a); err != nil {
+		return Delivery{}, Attempt{}, err
+	}
+	return d, a, nil
+}
+
+func (w *Worker) Stats() Stats {
+	return Stats{
+		Enqueued:  w.enqueued.Load(),
+		Succeeded: w.succeeded.Load(),
+		Retried:   w.retried.Load(),
+		Failed:    w.failed.Load(),
+		Disabled:  w.disabled.Load(),
+	}
+}
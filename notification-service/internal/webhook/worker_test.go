@@ -0,0 +1,334 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/contracts"
+)
+
+// receiver is a local endpoint that verifies signatures and answers with
+// the status codes it is given, then 200.
+type receiver struct {
+	*httptest.Server
+	secret string
+	now    func() time.Time
+
+	mu       sync.Mutex
+	statuses []int
+	got      []*http.Request
+	bodies   []string
+	verified []error
+}
+
+func newReceiver(t *testing.T, secret string, now func() time.Time, statuses ...int) *receiver {
+	r := &receiver{secret: secret, now: now, statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.got = append(r.got, req)
+		r.bodies = append(r.bodies, string(body))
+		r.verified = append(r.verified, Verify(r.secret, req.Header, body, 5*time.Minute, r.now()))
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "ack")
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) requests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.got)
+}
+
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+const testSecret = "0123456789abcdef-secret"
+
+func setup(t *testing.T, cfg Config, statuses ...int) (*Worker, Store, *receiver, Subscription, *clock) {
+	t.Helper()
+	clk := &clock{t: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)}
+	rcv := newReceiver(t, testSecret, clk.now, statuses...)
+	store := NewMemoryStore()
+	cfg.AllowPrivate = true
+	w := NewWorker(store, cfg)
+	w.now = clk.now
+
+	sub, err := NewSubscription(rcv.URL+"/hooks", []string{contracts.EventUserRegistered}, "cinema", testSecret, uuid.New(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.CreateSubscription(context.Background(), sub)
+	return w, store, rcv, sub, clk
+}
+
+func registered(t *testing.T) contracts.Envelope {
+	t.Helper()
+	env, err := contracts.NewEnvelope("/user-service", contracts.UserRegisteredEvent{
+		UserID: uuid.New(), Username: "budi", Email: "budi@example.com", RegisteredAt: time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestDeliveryIsSignedAndRecorded(t *testing.T) {
+	ctx := context.Background()
+	w, store, rcv, sub, _ := setup(t, Config{})
+	event := registered(t)
+
+	for i := 0; i < 2; i++ {
+		n, err := w.Enqueue(ctx, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := 1 - i; n != want {
+			t.Errorf("enqueue #%d created %d deliveries, want %d", i+1, n, want)
+		}
+	}
+	w.RunDue(ctx)
+
+	if rcv.requests() != 1 {
+		t.Fatalf("receiver got %d requests, want 1", rcv.requests())
+	}
+	if rcv.verified[0] != nil {
+		t.Errorf("signature: %v", rcv.verified[0])
+	}
+	req := rcv.got[0]
+	if req.Header.Get(HeaderEvent) != contracts.EventUserRegistered || req.Header.Get(HeaderID) == "" {
+		t.Errorf("headers = %v", req.Header)
+	}
+	if !strings.Contains(rcv.bodies[0], `"id":"`+event.ID+`"`) {
+		t.Errorf("body is not the event envelope: %s", rcv.bodies[0])
+	}
+	for _, private := range []string{"budi@example.com", `"budi"`, `"email"`, `"username"`} {
+		if strings.Contains(rcv.bodies[0], private) {
+			t.Errorf("body leaks %s: %s", private, rcv.bodies[0])
+		}
+	}
+	if !strings.Contains(rcv.bodies[0], `"dataschema":"/webhooks/user.registered/v1"`) {
+		t.Errorf("body does not carry the partner schema: %s", rcv.bodies[0])
+	}
+
+	deliveries, _ := store.ListDeliveries(ctx, sub.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != StatusSucceeded || deliveries[0].NextAttemptAt != nil {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+	attempts, _ := store.Attempts(ctx, deliveries[0].ID)
+	if len(attempts) != 1 || attempts[0].StatusCode != 200 || attempts[0].ResponseBody != "ack" {
+		t.Errorf("attempts = %+v", attempts)
+	}
+}
+
+func TestFailedDeliveriesRetryWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	w, store, rcv, sub, clk := setup(t, Config{MaxAttempts: 3, RetryMin: time.Minute, RetryMax: time.Hour},
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable)
+	w.Enqueue(ctx, registered(t))
+
+	w.RunDue(ctx)
+	w.RunDue(ctx)
+	if rcv.requests() != 1 {
+		t.Fatalf("retried before the backoff: %d requests", rcv.requests())
+	}
+	clk.advance(time.Minute)
+	w.RunDue(ctx)
+	clk.advance(time.Minute)
+	w.RunDue(ctx)
+	if rcv.requests() != 2 {
+		t.Fatalf("second retry came before twice the delay: %d requests", rcv.requests())
+	}
+	clk.advance(time.Minute)
+	w.RunDue(ctx)
+
+	deliveries, _ := store.ListDeliveries(ctx, sub.ID, 10)
+	if d := deliveries[0]; d.Status != StatusFailed || d.Attempts != 3 {
+		t.Fatalf("delivery = %+v, want failed after 3 attempts", d)
+	}
+	attempts, _ := store.Attempts(ctx, deliveries[0].ID)
+	if len(attempts) != 3 || attempts[2].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("attempts = %+v", attempts)
+	}
+	if stats := w.Stats(); stats.Retried != 2 || stats.Failed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestPersistentlyFailingEndpointIsDisabled(t *testing.T) {
+	ctx := context.Background()
+	w, store, _, sub, _ := setup(t, Config{DisableAfter: 2},
+		http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+
+	w.Enqueue(ctx, registered(t))
+	w.Enqueue(ctx, registered(t))
+	w.RunDue(ctx)
+
+	got, _ := store.GetSubscription(ctx, sub.ID)
+	if got.Active || got.DisabledReason == "" || got.ConsecutiveFailures != 2 {
+		t.Fatalf("subscription = %+v, want disabled", got)
+	}
+	if subs, _ := store.Subscribers(ctx, contracts.EventUserRegistered); len(subs) != 0 {
+		t.Errorf("a disabled subscription still receives events")
+	}
+}
+
+func TestSuccessResetsFailureCount(t *testing.T) {
+	ctx := context.Background()
+	w, store, _, sub, clk := setup(t, Config{DisableAfter: 2, RetryMin: time.Minute}, http.StatusInternalServerError)
+	w.Enqueue(ctx, registered(t))
+	w.RunDue(ctx)
+	clk.advance(time.Minute)
+	w.RunDue(ctx)
+
+	if got, _ := store.GetSubscription(ctx, sub.ID); !got.Active || got.ConsecutiveFailures != 0 {
+		t.Errorf("subscription = %+v, want active with no failures", got)
+	}
+}
+
+func TestSendTest(t *testing.T) {
+	ctx := context.Background()
+	w, store, rcv, sub, _ := setup(t, Config{DisableAfter: 1}, http.StatusNotFound)
+
+	d, a, err := w.SendTest(ctx, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.StatusCode != http.StatusNotFound || d.Status != StatusFailed {
+		t.Errorf("test delivery = %+v, attempt = %+v", d, a)
+	}
+	if rcv.got[0].Header.Get(HeaderEvent) != EventTest || rcv.verified[0] != nil {
+		t.Errorf("test event headers = %v, signature: %v", rcv.got[0].Header, rcv.verified[0])
+	}
+	if got, _ := store.GetSubscription(ctx, sub.ID); !got.Active {
+		t.Error("a failed test event disabled the subscription")
+	}
+}
+
+func TestPrivateEndpointsAreRefused(t *testing.T) {
+	rcv := newReceiver(t, testSecret, time.Now)
+	w := NewWorker(NewMemoryStore(), Config{})
+	sub := Subscription{ID: uuid.New(), URL: rcv.URL, Secret: testSecret, Active: true}
+
+	a := w.post(context.Background(), sub, uuid.New(), EventTest, []byte("{}"), 1)
+	if a.Succeeded() || !strings.Contains(a.Error, ErrForbiddenAddress.Error()) {
+		t.Errorf("attempt = %+v, want the address refused", a)
+	}
+	if rcv.requests() != 0 {
+		t.Error("the request reached a loopback endpoint")
+	}
+	if err := ValidateURL(rcv.URL, false); !errors.Is(err, ErrInvalidURL) {
+		t.Errorf("http URL accepted without AllowPrivate: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"hello":"world"}`)
+	at := time.Unix(1700000000, 0)
+	header := http.Header{}
+	header.Set(HeaderTimestamp, "1700000000")
+	header.Set(HeaderSignature, "v1=deadbeef, "+Sign(testSecret, at, body))
+
+	if err := Verify(testSecret, header, body, time.Minute, at.Add(30*time.Second)); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+	if err := Verify(testSecret, header, []byte(`{}`), time.Minute, at); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("tampered body: %v", err)
+	}
+	if err := Verify("another-secret-value", header, body, time.Minute, at); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("wrong secret: %v", err)
+	}
+	if err := Verify(testSecret, header, body, time.Minute, at.Add(2*time.Minute)); !errors.Is(err, ErrStaleTimestamp) {
+		t.Errorf("replayed later: %v", err)
+	}
+	if err := Verify(testSecret, http.Header{}, body, time.Minute, at); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("no headers: %v", err)
+	}
+}
+
+func TestNewSubscriptionValidates(t *testing.T) {
+	owner := uuid.New()
+	cases := map[string]struct {
+		url, secret string
+		events      []string
+		want        error
+	}{
+		"unknown event":    {"https://partner.example/hook", "", []string{"user.exploded"}, ErrUnknownEventType},
+		"not for partners": {"https://partner.example/hook", "", []string{contracts.EventUserLoggedIn}, ErrUnknownEventType},
+		"no events":        {"https://partner.example/hook", "", nil, ErrNoEventTypes},
+		"plain http":       {"http://partner.example/hook", "", []string{contracts.EventUserDeleted}, ErrInvalidURL},
+		"credentials":      {"https://u:p@partner.example/hook", "", []string{contracts.EventUserDeleted}, ErrInvalidURL},
+		"weak secret":      {"https://partner.example/hook", "short", []string{contracts.EventUserDeleted}, ErrWeakSecret},
+	}
+	for name, c := range cases {
+		if _, err := NewSubscription(c.url, c.events, "", c.secret, owner, false); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", name, err, c.want)
+		}
+	}
+
+	sub, err := NewSubscription("https://partner.example/hook", []string{"user.deleted", "user.deleted"}, "", "", owner, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sub.Secret, "whsec_") || len(sub.EventTypes) != 1 || !sub.Active {
+		t.Errorf("subscription = %+v", sub)
+	}
+}
+
+func TestOnlyPartnerEventsAreEnqueued(t *testing.T) {
+	for _, eventType := range PartnerEventTypes() {
+		if len(contracts.Versions(eventType)) == 0 {
+			t.Errorf("partner event %s has no contract", eventType)
+		}
+	}
+
+	// A subscription stored before the allowlist existed may still name
+	// an event partners cannot receive.
+	ctx := context.Background()
+	w, store, rcv, sub, _ := setup(t, Config{})
+	sub.EventTypes = append(sub.EventTypes, contracts.EventUserLoggedIn)
+	store.UpdateSubscription(ctx, sub)
+
+	env, err := contracts.NewEnvelope("/user-service", contracts.UserLoggedInEvent{
+		UserID: uuid.New(), Username: "budi", LoggedInAt: time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := w.Enqueue(ctx, env); n != 0 || err != nil {
+		t.Errorf("Enqueue(user.logged_in) = %d, %v", n, err)
+	}
+	w.RunDue(ctx)
+	if rcv.requests() != 0 {
+		t.Errorf("receiver got %d requests for a non-partner event", rcv.requests())
+	}
+}
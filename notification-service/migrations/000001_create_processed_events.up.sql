@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS processed_events (
+    consumer        TEXT        NOT NULL,
+    idempotency_key TEXT        NOT NULL,
+    status          TEXT        NOT NULL CHECK (status IN ('processing', 'done')),
+    claimed_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    completed_at    TIMESTAMPTZ,
+    PRIMARY KEY (consumer, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_processed_events_claimed_at ON processed_events (claimed_at);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_profiles (
+    user_id    UUID        PRIMARY KEY,
+    email      TEXT        NOT NULL DEFAULT '',
+    username   TEXT        NOT NULL DEFAULT '',
+    locale     TEXT        NOT NULL DEFAULT '',
+    updated_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id    UUID        NOT NULL,
+    category   TEXT        NOT NULL,
+    channel    TEXT        NOT NULL,
+    enabled    BOOLEAN     NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, category, channel)
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id         UUID        PRIMARY KEY,
+    user_id    UUID        NOT NULL,
+    category   TEXT        NOT NULL,
+    type       TEXT        NOT NULL,
+    title      TEXT        NOT NULL,
+    body       TEXT        NOT NULL DEFAULT '',
+    link       TEXT        NOT NULL DEFAULT '',
+    data       JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    read_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_created ON notifications (user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_unread ON notifications (user_id) WHERE read_at IS NULL;
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id                   UUID        PRIMARY KEY,
+    url                  TEXT        NOT NULL,
+    event_types          TEXT[]      NOT NULL,
+    description          TEXT        NOT NULL DEFAULT '',
+    -- The signing secret has to be kept in the clear to compute HMACs.
+    secret               TEXT        NOT NULL,
+    active               BOOLEAN     NOT NULL DEFAULT TRUE,
+    consecutive_failures INTEGER     NOT NULL DEFAULT 0,
+    disabled_reason      TEXT        NOT NULL DEFAULT '',
+    created_by           UUID        NOT NULL,
+    created_at           TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at           TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              UUID        PRIMARY KEY,
+    subscription_id UUID        NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id        TEXT        NOT NULL,
+    event_type      TEXT        NOT NULL,
+    payload         JSONB       NOT NULL,
+    status          TEXT        NOT NULL,
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries (subscription_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    delivery_id   UUID        NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    number        INTEGER     NOT NULL,
+    attempted_at  TIMESTAMPTZ NOT NULL,
+    status_code   INTEGER     NOT NULL DEFAULT 0,
+    error         TEXT        NOT NULL DEFAULT '',
+    response_body TEXT        NOT NULL DEFAULT '',
+    duration_ms   BIGINT      NOT NULL DEFAULT 0,
+    PRIMARY KEY (delivery_id, number)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS digest_items;
+DROP TABLE IF EXISTS scheduled_jobs;
+DROP TABLE IF EXISTS notification_schedules;
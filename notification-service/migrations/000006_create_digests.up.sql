@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS notification_schedules (
+    user_id     UUID        PRIMARY KEY,
+    time_zone   TEXT        NOT NULL DEFAULT '',
+    -- "HH:MM" in time_zone; empty when the user has no quiet hours.
+    quiet_start TEXT        NOT NULL DEFAULT '',
+    quiet_end   TEXT        NOT NULL DEFAULT '',
+    -- Category to frequency, for the categories the user changed.
+    digests     JSONB       NOT NULL DEFAULT '{}',
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    id         UUID        PRIMARY KEY,
+    key        TEXT        NOT NULL UNIQUE,
+    kind       TEXT        NOT NULL,
+    run_at     TIMESTAMPTZ NOT NULL,
+    payload    JSONB       NOT NULL,
+    status     TEXT        NOT NULL,
+    attempts   INTEGER     NOT NULL DEFAULT 0,
+    last_error TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_jobs_due ON scheduled_jobs (run_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS digest_items (
+    id         UUID        PRIMARY KEY,
+    user_id    UUID        NOT NULL,
+    category   TEXT        NOT NULL,
+    template   TEXT        NOT NULL,
+    summary    TEXT        NOT NULL,
+    recipient  TEXT        NOT NULL,
+    locale     TEXT        NOT NULL DEFAULT '',
+    -- The digest job that collected the item; NULL while it waits.
+    job_id     UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_digest_items_pending ON digest_items (user_id, category) WHERE job_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_digest_items_job ON digest_items (job_id);
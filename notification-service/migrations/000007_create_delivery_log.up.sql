@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS delivery_log (
+    id             UUID        PRIMARY KEY,
+    -- Event, user, channel and template: retries of one notification
+    -- update the same row.
+    key            TEXT        NOT NULL UNIQUE,
+    user_id        UUID        NOT NULL,
+    event_id       TEXT        NOT NULL DEFAULT '',
+    channel        TEXT        NOT NULL,
+    category       TEXT        NOT NULL,
+    template       TEXT        NOT NULL,
+    -- SHA-256 of the normalised email address; empty for in-app.
+    recipient_hash TEXT        NOT NULL DEFAULT '',
+    status         TEXT        NOT NULL,
+    response       TEXT        NOT NULL DEFAULT '',
+    attempts       INTEGER     NOT NULL DEFAULT 0,
+    resend_of      UUID        REFERENCES delivery_log (id) ON DELETE SET NULL,
+    -- The email as sent, for resending.
+    message        JSONB,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_delivery_log_user ON delivery_log (user_id, updated_at DESC);
+CREATE INDEX IF NOT EXISTS idx_delivery_log_event ON delivery_log (event_id) WHERE event_id <> '';
+CREATE INDEX IF NOT EXISTS idx_delivery_log_recipient ON delivery_log (recipient_hash) WHERE recipient_hash <> '';
+CREATE INDEX IF NOT EXISTS idx_delivery_log_updated ON delivery_log (updated_at);
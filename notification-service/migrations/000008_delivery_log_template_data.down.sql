@@ -0,0 +1,3 @@
+ALTER TABLE delivery_log DROP COLUMN IF EXISTS data;
+ALTER TABLE delivery_log DROP COLUMN IF EXISTS locale;
+ALTER TABLE delivery_log ADD COLUMN IF NOT EXISTS message JSONB;
@@ -0,0 +1,10 @@
+-- Entries no longer keep the rendered email, which held the address, the
+-- body and an unsubscribe token, but what it was rendered from; a resend
+-- renders it again for the user's current address.
+ALTER TABLE delivery_log DROP COLUMN IF EXISTS message;
+ALTER TABLE delivery_log ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT '';
+ALTER TABLE delivery_log ADD COLUMN IF NOT EXISTS data JSONB;
+
+-- Recipient hashes are now keyed (HMAC-SHA256). The unkeyed ones can be
+-- reversed by hashing known addresses and no longer match a search.
+UPDATE delivery_log SET recipient_hash = '' WHERE recipient_hash <> '';
@@ -0,0 +1,82 @@
+// Command pii-reencrypt encrypts legacy plaintext emails and re-encrypts
+// rows still on a retired key after PII_ACTIVE_KEY_ID has been rotated.
+//
+//	go run ./cmd/pii-reencrypt -config .            # backfill / rotate
+//	go run ./cmd/pii-reencrypt -config . -check     # list emails differing only in case
+//	go run ./cmd/pii-reencrypt -config . -decrypt   # before migrating 000002 down
+//
+// The backfill refuses to start while legacy emails differ from other
+// users' only in case; merge or change those accounts first.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/virhanali/filmnesia/user-service/internal/config"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/database"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/pii"
+	"github.com/virhanali/filmnesia/user-service/internal/user/repository"
+)
+
+func main() {
+	configPath := flag.String("config", ".", "directory containing the service .env file")
+	batchSize := flag.Int("batch-size", 500, "rows to rewrite per transaction")
+	decrypt := flag.Bool("decrypt", false, "restore plaintext emails instead of encrypting them")
+	check := flag.Bool("check", false, "only list users whose emails differ only in case")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("FATAL: Failed to load configuration: %v", err)
+	}
+
+	keys, err := pii.ParseKeyring(cfg.PIIEncryptionKeys, cfg.PIIActiveKeyID, cfg.PIIBlindIndexKey)
+	if err != nil {
+		log.Fatalf("FATAL: Invalid PII encryption configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := database.NewPostgresPool(ctx, cfg, nil)
+	if err != nil {
+		log.Fatalf("FATAL: Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	job := repository.NewEmailReencryptor(db, keys, *batchSize)
+
+	if *check || !*decrypt {
+		collisions, err := job.FindEmailCollisions(ctx)
+		if err != nil {
+			log.Fatalf("FATAL: Failed to check for email collisions: %v", err)
+		}
+		for _, c := range collisions {
+			log.Printf("WARNING: Users share an email up to case: %v", c.UserIDs)
+		}
+		if len(collisions) > 0 {
+			log.Fatalf("FATAL: %d groups of users have emails that differ only in case; resolve them before the backfill.", len(collisions))
+		}
+		if *check {
+			log.Println("INFO: No emails differ only in case.")
+			return
+		}
+	}
+
+	var n int
+	if *decrypt {
+		n, err = job.Decrypt(ctx)
+	} else {
+		log.Printf("INFO: Re-encrypting emails with key %s", keys.ActiveKeyID())
+		n, err = job.Run(ctx)
+	}
+	if err != nil {
+		log.Fatalf("FATAL: Email re-encryption stopped after %d rows: %v", n, err)
+	}
+	log.Printf("INFO: Email re-encryption complete, %d rows rewritten.", n)
+}
@@ -2,7 +2,6 @@ package app
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"log"
@@ -13,19 +12,26 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/virhanali/filmnesia/contracts"
 	"github.com/virhanali/filmnesia/user-service/internal/config"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/cache"
 	"github.com/virhanali/filmnesia/user-service/internal/platform/database"
 	"github.com/virhanali/filmnesia/user-service/internal/platform/messagebroker"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/pii"
 	userHttp "github.com/virhanali/filmnesia/user-service/internal/user/delivery/http"
+	"github.com/virhanali/filmnesia/user-service/internal/user/domain"
 	userRepo "github.com/virhanali/filmnesia/user-service/internal/user/repository"
 	userUsecase "github.com/virhanali/filmnesia/user-service/internal/user/usecase"
 )
 
 type App struct {
 	Config    config.Config
-	DB        *sql.DB
+	DB        *database.Cluster
 	Router    *gin.Engine
 	Publisher *messagebroker.RabbitMQPublisher
+
+	cacheSubscriber *messagebroker.CacheInvalidationSubscriber
+	redisCache      *cache.Redis
 }
 
 func NewApp(configPath string) (*App, error) {
@@ -42,11 +48,16 @@ func NewApp(configPath string) (*App, error) {
 		cfg.JWTExpirationHours = 24
 	}
 
+	piiKeys, err := pii.ParseKeyring(cfg.PIIEncryptionKeys, cfg.PIIActiveKeyID, cfg.PIIBlindIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PII encryption configuration: %w", err)
+	}
+
 	if cfg.RabbitMQURL == "" {
 		log.Println("WARNING: RabbitMQURL is empty in configuration. Skipping RabbitMQ publisher initialization.")
 	}
 
-	db, err := database.NewPostgresSQLDB(cfg)
+	db, err := database.NewCluster(context.Background(), cfg, userRepo.PreparedStatements)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -57,15 +68,60 @@ func NewApp(configPath string) (*App, error) {
 		return nil, fmt.Errorf("failed to initialize RabbitMQ publisher: %w", err)
 	}
 
-	pgUserRepo := userRepo.NewPostgresUserRepository(db)
-	ucase := userUsecase.NewUserUsecase(pgUserRepo, cfg, publisher)
+	var userRepository userRepo.UserRepository = userRepo.NewPostgresUserRepository(db, piiKeys)
+
+	var cachedUserRepo *userRepo.CachedUserRepository
+	var redisCache *cache.Redis
+	var cacheSubscriber *messagebroker.CacheInvalidationSubscriber
+	if cfg.UserCacheEnabled {
+		var userCache cache.Cache = cache.NewLRU(cfg.UserCacheSize)
+		if cfg.RedisURL != "" {
+			redisCache, err = cache.NewRedisFromURL(context.Background(), cfg.RedisURL, "filmnesia:")
+			if err != nil {
+				publisher.Close()
+				db.Close()
+				return nil, fmt.Errorf("failed to initialize Redis cache: %w", err)
+			}
+			userCache = cache.NewTiered(userCache, redisCache, cfg.UserCacheLocalTTL)
+		}
+
+		cachedUserRepo = userRepo.NewCachedUserRepository(userRepository, userCache, userRepo.CacheOptions{
+			TTL:         cfg.UserCacheTTL,
+			NegativeTTL: cfg.UserCacheNegativeTTL,
+			FreshWindow: cfg.DBReadYourWritesWindow,
+		})
+		userRepository = cachedUserRepo
+
+		cacheSubscriber, err = messagebroker.NewCacheInvalidationSubscriber(cfg, cachedUserRepo)
+		if err == nil {
+			err = cacheSubscriber.Start(context.Background(), messagebroker.UserEventsExchange,
+				domain.EventUserUpdated, domain.EventUserDeleted)
+		}
+		if err != nil {
+			if cacheSubscriber != nil {
+				cacheSubscriber.Close()
+			}
+			if redisCache != nil {
+				redisCache.Close()
+			}
+			publisher.Close()
+			db.Close()
+			return nil, fmt.Errorf("failed to subscribe to user cache invalidation events: %w", err)
+		}
+	}
+
+	ucase := userUsecase.NewUserUsecase(userRepository, cfg, publisher)
 	userHandler := userHttp.NewUserHandler(ucase)
 
 	router := gin.Default()
 
-	authMiddleware := userHttp.AuthMiddleware(cfg.JWTSecretKey)
+	identity := contracts.NewIdentityVerifier([]byte(cfg.GatewayIdentitySecret), contracts.DefaultIdentityMaxAge)
+	authMiddleware := userHttp.AuthMiddleware(cfg.JWTSecretKey, identity)
+
+	readYourWrites := userHttp.ReadYourWritesMiddleware(db.Writes())
 
 	publicRoutes := router.Group("/api/v1/users")
+	publicRoutes.Use(readYourWrites)
 	{
 		publicRoutes.POST("/register", userHandler.Register)
 		publicRoutes.POST("/login", userHandler.Login)
@@ -74,7 +130,7 @@ func NewApp(configPath string) (*App, error) {
 	}
 
 	authenticatedRoutes := router.Group("/api/v1")
-	authenticatedRoutes.Use(authMiddleware)
+	authenticatedRoutes.Use(authMiddleware, readYourWrites)
 	{
 		authenticatedRoutes.GET("/users/me", userHandler.GetMyProfile)
 		authenticatedRoutes.GET("/users/:id", userHandler.GetUserByID)
@@ -83,18 +139,46 @@ func NewApp(configPath string) (*App, error) {
 	}
 
 	router.GET("/health", func(c *gin.Context) {
-		errDB := db.PingContext(c.Request.Context())
+		errDB := db.Primary().Ping(c.Request.Context())
 		if errDB != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"status": "unhealthy", "db_error": errDB.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+		c.JSON(http.StatusOK, gin.H{"status": "healthy", "db_pool": database.Stats(db.Primary()), "rabbitmq": publisher.Status()})
+	})
+
+	// The gateway's health checks use /health/live, which leaves out the
+	// broker's state.
+	router.GET("/health/live", func(c *gin.Context) {
+		if errDB := db.Primary().Ping(c.Request.Context()); errDB != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "db_error": errDB.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
+	})
+
+	router.GET("/health/cache/stats", func(c *gin.Context) {
+		if cachedUserRepo == nil {
+			c.JSON(http.StatusOK, gin.H{"enabled": false})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"enabled": true, "redis": redisCache != nil, "stats": cachedUserRepo.Stats()})
+	})
+
+	router.GET("/health/db/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"primary":  database.Stats(db.Primary()),
+			"replicas": db.ReplicaStatuses(),
+		})
 	})
 
 	return &App{
-		Config: cfg,
-		DB:     db,
-		Router: router,
+		Config:          cfg,
+		DB:              db,
+		Router:          router,
+		Publisher:       publisher,
+		cacheSubscriber: cacheSubscriber,
+		redisCache:      redisCache,
 	}, nil
 }
 
@@ -103,13 +187,16 @@ func (a *App) Run() {
 	if a.Publisher != nil {
 		defer a.Publisher.Close()
 	}
+	if a.cacheSubscriber != nil {
+		defer a.cacheSubscriber.Close()
+	}
+	if a.redisCache != nil {
+		defer a.redisCache.Close()
+	}
 	if a.DB != nil {
 		defer func() {
-			if err := a.DB.Close(); err != nil {
-				log.Printf("WARNING: Failed to close database connection: %v", err)
-			} else {
-				log.Println("Database connection closed.")
-			}
+			a.DB.Close()
+			log.Println("Database connection pools closed.")
 		}()
 	}
 
@@ -3,10 +3,17 @@ package config
 import (
 	"log"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+// Values for RABBITMQ_PUBLISH_MODE.
+const (
+	PublishModeBuffer   = "buffer"
+	PublishModeFailFast = "fail_fast"
+)
+
 type Config struct {
 	ServicePort string `mapstructure:"USER_SERVICE_PORT"`
 
@@ -17,9 +24,45 @@ type Config struct {
 	DBName     string `mapstructure:"DB_NAME"`
 	DBSSLMode  string `mapstructure:"DB_SSLMODE"`
 
+	DBMaxConns          int32         `mapstructure:"DB_MAX_CONNS"`
+	DBMinConns          int32         `mapstructure:"DB_MIN_CONNS"`
+	DBMaxConnLifetime   time.Duration `mapstructure:"DB_MAX_CONN_LIFETIME"`
+	DBMaxConnIdleTime   time.Duration `mapstructure:"DB_MAX_CONN_IDLE_TIME"`
+	DBHealthCheckPeriod time.Duration `mapstructure:"DB_HEALTH_CHECK_PERIOD"`
+	DBStatementTimeout  time.Duration `mapstructure:"DB_STATEMENT_TIMEOUT"`
+	DBConnectRetries    int           `mapstructure:"DB_CONNECT_RETRIES"`
+	DBConnectBackoff    time.Duration `mapstructure:"DB_CONNECT_BACKOFF"`
+
+	DBReplicaDSNs          []string      `mapstructure:"DB_REPLICA_DSNS"`
+	DBReplicaMaxLag        time.Duration `mapstructure:"DB_REPLICA_MAX_LAG"`
+	DBReplicaCheckInterval time.Duration `mapstructure:"DB_REPLICA_CHECK_INTERVAL"`
+	DBReadYourWritesWindow time.Duration `mapstructure:"DB_READ_YOUR_WRITES_WINDOW"`
+
 	JWTSecretKey       string `mapstructure:"JWT_SECRET_KEY"`
 	JWTExpirationHours int    `mapstructure:"JWT_EXPIRATION_HOURS"`
-	RabbitMQURL        string `mapstructure:"RABBITMQ_URL"`
+	// GatewayIdentitySecret, when set, lets requests authenticate with the
+	// identity headers the gateway signs with the same secret, not only
+	// with an access token.
+	GatewayIdentitySecret string `mapstructure:"GATEWAY_IDENTITY_SECRET"`
+	RabbitMQURL           string `mapstructure:"RABBITMQ_URL"`
+
+	RabbitMQPublishChannels     int           `mapstructure:"RABBITMQ_PUBLISH_CHANNELS"`
+	RabbitMQConfirmTimeout      time.Duration `mapstructure:"RABBITMQ_CONFIRM_TIMEOUT"`
+	RabbitMQPublishMode         string        `mapstructure:"RABBITMQ_PUBLISH_MODE"`
+	RabbitMQPublishBufferSize   int           `mapstructure:"RABBITMQ_PUBLISH_BUFFER_SIZE"`
+	RabbitMQReconnectMinBackoff time.Duration `mapstructure:"RABBITMQ_RECONNECT_MIN_BACKOFF"`
+	RabbitMQReconnectMaxBackoff time.Duration `mapstructure:"RABBITMQ_RECONNECT_MAX_BACKOFF"`
+
+	UserCacheEnabled     bool          `mapstructure:"USER_CACHE_ENABLED"`
+	UserCacheSize        int           `mapstructure:"USER_CACHE_SIZE"`
+	UserCacheTTL         time.Duration `mapstructure:"USER_CACHE_TTL"`
+	UserCacheLocalTTL    time.Duration `mapstructure:"USER_CACHE_LOCAL_TTL"`
+	UserCacheNegativeTTL time.Duration `mapstructure:"USER_CACHE_NEGATIVE_TTL"`
+	RedisURL             string        `mapstructure:"REDIS_URL"`
+
+	PIIEncryptionKeys string `mapstructure:"PII_ENCRYPTION_KEYS"`
+	PIIActiveKeyID    string `mapstructure:"PII_ACTIVE_KEY_ID"`
+	PIIBlindIndexKey  string `mapstructure:"PII_BLIND_INDEX_KEY"`
 }
 
 func LoadConfig(configPath string) (config Config, err error) {
@@ -52,9 +95,38 @@ func LoadConfig(configPath string) (config Config, err error) {
 	viper.BindEnv("DB_PASSWORD")
 	viper.BindEnv("DB_NAME")
 	viper.BindEnv("DB_SSLMODE")
+	viper.BindEnv("DB_MAX_CONNS")
+	viper.BindEnv("DB_MIN_CONNS")
+	viper.BindEnv("DB_MAX_CONN_LIFETIME")
+	viper.BindEnv("DB_MAX_CONN_IDLE_TIME")
+	viper.BindEnv("DB_HEALTH_CHECK_PERIOD")
+	viper.BindEnv("DB_STATEMENT_TIMEOUT")
+	viper.BindEnv("DB_CONNECT_RETRIES")
+	viper.BindEnv("DB_CONNECT_BACKOFF")
+	viper.BindEnv("DB_REPLICA_DSNS")
+	viper.BindEnv("DB_REPLICA_MAX_LAG")
+	viper.BindEnv("DB_REPLICA_CHECK_INTERVAL")
+	viper.BindEnv("DB_READ_YOUR_WRITES_WINDOW")
 	viper.BindEnv("JWT_SECRET_KEY")
 	viper.BindEnv("JWT_EXPIRATION_HOURS")
+	viper.BindEnv("GATEWAY_IDENTITY_SECRET")
 	viper.BindEnv("RABBITMQ_URL")
+	viper.BindEnv("RABBITMQ_PUBLISH_CHANNELS")
+	viper.BindEnv("RABBITMQ_CONFIRM_TIMEOUT")
+	viper.BindEnv("RABBITMQ_PUBLISH_MODE")
+	viper.BindEnv("RABBITMQ_PUBLISH_BUFFER_SIZE")
+	viper.BindEnv("RABBITMQ_RECONNECT_MIN_BACKOFF")
+	viper.BindEnv("RABBITMQ_RECONNECT_MAX_BACKOFF")
+	viper.BindEnv("USER_CACHE_ENABLED")
+	viper.BindEnv("USER_CACHE_SIZE")
+	viper.BindEnv("USER_CACHE_TTL")
+	viper.BindEnv("USER_CACHE_LOCAL_TTL")
+	viper.BindEnv("USER_CACHE_NEGATIVE_TTL")
+	viper.BindEnv("REDIS_URL")
+	viper.BindEnv("PII_ENCRYPTION_KEYS")
+	viper.BindEnv("PII_ACTIVE_KEY_ID")
+	viper.BindEnv("PII_BLIND_INDEX_KEY")
+	viper.SetDefault("USER_CACHE_ENABLED", true)
 
 	if unmarshalErr := viper.Unmarshal(&config); unmarshalErr != nil {
 		log.Printf("ERROR: Error unmarshalling User Service config into struct: %v", unmarshalErr)
@@ -70,10 +142,99 @@ func LoadConfig(configPath string) (config Config, err error) {
 		log.Printf("INFO: JWT_EXPIRATION_HOURS not set or invalid, using default: %d hours", config.JWTExpirationHours)
 	}
 
+	if config.DBMaxConns <= 0 {
+		config.DBMaxConns = 10
+	}
+	if config.DBMinConns < 0 || config.DBMinConns > config.DBMaxConns {
+		log.Printf("WARNING: DB_MIN_CONNS (%d) is out of range, using 0", config.DBMinConns)
+		config.DBMinConns = 0
+	}
+	if config.DBMaxConnLifetime <= 0 {
+		config.DBMaxConnLifetime = time.Hour
+	}
+	if config.DBMaxConnIdleTime <= 0 {
+		config.DBMaxConnIdleTime = 30 * time.Minute
+	}
+	if config.DBHealthCheckPeriod <= 0 {
+		config.DBHealthCheckPeriod = time.Minute
+	}
+	if config.DBStatementTimeout <= 0 {
+		config.DBStatementTimeout = 5 * time.Second
+	}
+	if config.DBConnectRetries <= 0 {
+		config.DBConnectRetries = 5
+	}
+	if config.DBConnectBackoff <= 0 {
+		config.DBConnectBackoff = time.Second
+	}
+	replicaDSNs := make([]string, 0, len(config.DBReplicaDSNs))
+	for _, dsn := range config.DBReplicaDSNs {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			replicaDSNs = append(replicaDSNs, dsn)
+		}
+	}
+	config.DBReplicaDSNs = replicaDSNs
+	if config.DBReplicaMaxLag <= 0 {
+		config.DBReplicaMaxLag = 5 * time.Second
+	}
+	if config.DBReplicaCheckInterval <= 0 {
+		config.DBReplicaCheckInterval = 5 * time.Second
+	}
+	if config.DBReadYourWritesWindow <= 0 {
+		config.DBReadYourWritesWindow = config.DBReplicaMaxLag
+	}
+
+	if config.RabbitMQPublishChannels <= 0 {
+		config.RabbitMQPublishChannels = 4
+	}
+	if config.RabbitMQConfirmTimeout <= 0 {
+		config.RabbitMQConfirmTimeout = 5 * time.Second
+	}
+	switch config.RabbitMQPublishMode {
+	case PublishModeBuffer, PublishModeFailFast:
+	case "":
+		config.RabbitMQPublishMode = PublishModeBuffer
+	default:
+		log.Printf("WARNING: RABBITMQ_PUBLISH_MODE %q is not %q or %q, using %q",
+			config.RabbitMQPublishMode, PublishModeBuffer, PublishModeFailFast, PublishModeBuffer)
+		config.RabbitMQPublishMode = PublishModeBuffer
+	}
+	if config.RabbitMQPublishBufferSize <= 0 {
+		config.RabbitMQPublishBufferSize = 1000
+	}
+	if config.RabbitMQReconnectMinBackoff <= 0 {
+		config.RabbitMQReconnectMinBackoff = 500 * time.Millisecond
+	}
+	if config.RabbitMQReconnectMaxBackoff < config.RabbitMQReconnectMinBackoff {
+		config.RabbitMQReconnectMaxBackoff = max(30*time.Second, config.RabbitMQReconnectMinBackoff)
+	}
+
+	if config.UserCacheSize <= 0 {
+		config.UserCacheSize = 10000
+	}
+	if config.UserCacheTTL <= 0 {
+		config.UserCacheTTL = 5 * time.Minute
+	}
+	if config.UserCacheLocalTTL <= 0 || config.UserCacheLocalTTL > config.UserCacheTTL {
+		config.UserCacheLocalTTL = min(30*time.Second, config.UserCacheTTL)
+	}
+	if config.UserCacheNegativeTTL <= 0 {
+		config.UserCacheNegativeTTL = 30 * time.Second
+	}
+
 	log.Printf("User Service - Service Port loaded: [%s]", config.ServicePort)
 	log.Printf("User Service - DB Host loaded: [%s] (DBName: [%s])", config.DBHost, config.DBName)
+	log.Printf("User Service - DB Pool: max=%d min=%d lifetime=%s idle=%s health_check=%s statement_timeout=%s",
+		config.DBMaxConns, config.DBMinConns, config.DBMaxConnLifetime, config.DBMaxConnIdleTime, config.DBHealthCheckPeriod, config.DBStatementTimeout)
+	log.Printf("User Service - DB Read Replicas: %d (max lag %s)", len(config.DBReplicaDSNs), config.DBReplicaMaxLag)
+	log.Printf("User Service - User Cache: enabled=%t size=%d ttl=%s redis=%t",
+		config.UserCacheEnabled, config.UserCacheSize, config.UserCacheTTL, config.RedisURL != "")
 	log.Printf("User Service - RabbitMQ URL loaded: [%s]", config.RabbitMQURL)
+	log.Printf("User Service - RabbitMQ Publisher: channels=%d confirm_timeout=%s mode=%s buffer=%d",
+		config.RabbitMQPublishChannels, config.RabbitMQConfirmTimeout, config.RabbitMQPublishMode, config.RabbitMQPublishBufferSize)
 	log.Printf("User Service - JWT Expiration Hours: [%d]", config.JWTExpirationHours)
+	log.Printf("User Service - Gateway identity headers trusted: [%t]", config.GatewayIdentitySecret != "")
+	log.Printf("User Service - PII active key ID: [%s]", config.PIIActiveKeyID)
 	if config.JWTSecretKey == "" {
 		log.Println("WARNING: JWT_SECRET_KEY IS EMPTY. THIS IS INSECURE.")
 	}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMiss is returned by Get when the key is absent or expired.
+var ErrMiss = errors.New("cache miss")
+
+// Cache is a byte-oriented key/value store with per-entry TTLs.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Tiered reads from the in-process cache first and falls back to a shared
+// cache (Redis), back-filling the local tier on a shared hit. Writes and
+// deletes go to both tiers.
+type Tiered struct {
+	local    Cache
+	shared   Cache
+	localTTL time.Duration
+}
+
+func NewTiered(local, shared Cache, localTTL time.Duration) *Tiered {
+	return &Tiered{local: local, shared: shared, localTTL: localTTL}
+}
+
+func (t *Tiered) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := t.local.Get(ctx, key)
+	if err == nil {
+		return value, nil
+	}
+
+	value, err = t.shared.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	_ = t.local.Set(ctx, key, value, t.localTTL)
+	return value, nil
+}
+
+func (t *Tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	localTTL := ttl
+	if t.localTTL < localTTL {
+		localTTL = t.localTTL
+	}
+	_ = t.local.Set(ctx, key, value, localTTL)
+	return t.shared.Set(ctx, key, value, ttl)
+}
+
+func (t *Tiered) Delete(ctx context.Context, keys ...string) error {
+	_ = t.local.Delete(ctx, keys...)
+	return t.shared.Delete(ctx, keys...)
+}
+
+// Local returns the in-process tier so cross-instance invalidations can
+// drop entries that the shared tier has already forgotten.
+func (t *Tiered) Local() Cache {
+	return t.local
+}
+
+// LocalOnly returns the in-process tier of c, or c itself when it is not
+// tiered. Entries that must never leave the process are written here.
+func LocalOnly(c Cache) Cache {
+	if t, ok := c.(*Tiered); ok {
+		return t.local
+	}
+	return c
+}
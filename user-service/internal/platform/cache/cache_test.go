@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	if _, err := c.Get(ctx, "a"); err != nil {
+		t.Fatalf("Get(a): %v", err)
+	}
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, err := c.Get(ctx, "b"); !errors.Is(err, ErrMiss) {
+		t.Errorf("b should have been evicted, got %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := c.Get(ctx, key); err != nil {
+			t.Errorf("Get(%s): %v", key, err)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+}
+
+func TestLRUExpiresEntries(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(10)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "k", []byte("v"), time.Second)
+	if v, err := c.Get(ctx, "k"); err != nil || string(v) != "v" {
+		t.Fatalf("Get before expiry = %q, %v", v, err)
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Get after expiry: want ErrMiss, got %v", err)
+	}
+	if c.Len() != 0 {
+		t.Errorf("expired entry not removed, Len = %d", c.Len())
+	}
+}
+
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedis(client, "test:"), mr
+}
+
+func TestRedisRoundTripAndTTL(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestRedis(t)
+
+	if err := c.Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if !mr.Exists("test:k") {
+		t.Fatal("key not stored under prefix")
+	}
+	if v, err := c.Get(ctx, "k"); err != nil || string(v) != "v" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+
+	mr.FastForward(2 * time.Minute)
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, ErrMiss) {
+		t.Errorf("Get after TTL: want ErrMiss, got %v", err)
+	}
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	if err := c.Delete(ctx, "a", "b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if mr.Exists("test:a") || mr.Exists("test:b") {
+		t.Error("Delete left keys behind")
+	}
+}
+
+func TestTieredBackfillsLocalAndDeletesBoth(t *testing.T) {
+	ctx := context.Background()
+	shared, _ := newTestRedis(t)
+	local := NewLRU(10)
+	c := NewTiered(local, shared, time.Second)
+
+	shared.Set(ctx, "k", []byte("v"), time.Minute)
+	if v, err := c.Get(ctx, "k"); err != nil || string(v) != "v" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if _, err := local.Get(ctx, "k"); err != nil {
+		t.Errorf("shared hit was not back-filled locally: %v", err)
+	}
+
+	if err := c.Delete(ctx, "k"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := local.Get(ctx, "k"); !errors.Is(err, ErrMiss) {
+		t.Errorf("local tier still has k: %v", err)
+	}
+	if _, err := shared.Get(ctx, "k"); !errors.Is(err, ErrMiss) {
+		t.Errorf("shared tier still has k: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LastWriteCookie and LastWriteHeader carry the time of a client's last
+// write back to it, so that its next request pins reads to the primary
+// whichever replica of the service it lands on. Browsers return the
+// cookie on their own; API clients echo the header.
+const (
+	LastWriteCookie = "filmnesia_last_write"
+	LastWriteHeader = "X-Last-Write"
+)
+
+// WriteTracker remembers which users wrote recently so their follow-up
+// requests can be pinned to the primary until replicas have caught up.
+// It only knows about writes made through this process; LastWriteMarker
+// covers the requests that reach another one.
+type WriteTracker struct {
+	mu        sync.Mutex
+	window    time.Duration
+	writes    map[uuid.UUID]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewWriteTracker(window time.Duration) *WriteTracker {
+	return &WriteTracker{
+		window: window,
+		writes: make(map[uuid.UUID]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Window is how long after a write reads stay on the primary.
+func (t *WriteTracker) Window() time.Duration {
+	return t.window
+}
+
+func (t *WriteTracker) RecordWrite(userID uuid.UUID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.writes[userID] = now
+	t.sweep(now)
+}
+
+// sweep forgets the writes that left the window, at most once a window,
+// so that users who wrote once do not accumulate.
+func (t *WriteTracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.window {
+		return
+	}
+	t.lastSweep = now
+	for id, at := range t.writes {
+		if now.Sub(at) > t.window {
+			delete(t.writes, id)
+		}
+	}
+}
+
+func (t *WriteTracker) WroteRecently(userID uuid.UUID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at, ok := t.writes[userID]
+	if !ok {
+		return false
+	}
+	if t.now().Sub(at) > t.window {
+		delete(t.writes, userID)
+		return false
+	}
+	return true
+}
+
+// LastWriteMarker is the value of LastWriteCookie and LastWriteHeader for
+// a write made now: Unix milliseconds.
+func (t *WriteTracker) LastWriteMarker() string {
+	return strconv.FormatInt(t.now().UnixMilli(), 10)
+}
+
+// MarkerRecent reports whether a LastWriteMarker names a write within the
+// window. Markers from the future are ignored; a forged marker can only
+// move the client's own reads to the primary.
+func (t *WriteTracker) MarkerRecent(marker string) bool {
+	ms, err := strconv.ParseInt(marker, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := t.now().Sub(time.UnixMilli(ms))
+	return age >= 0 && age <= t.window
+}
@@ -1,25 +1,158 @@
 package database
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/virhanali/filmnesia/user-service/internal/config"
 )
 
-func NewPostgresSQLDB(cfg config.Config) (*sql.DB, error) {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBSSLMode)
+// PreparedStatements maps statement names to SQL. Every connection in the
+// pool prepares them once when it is established.
+type PreparedStatements map[string]string
 
-	log.Printf("Trying to connect to database: %s", dsn)
+// PoolStats is a JSON-friendly snapshot of pgxpool.Stat used for monitoring.
+type PoolStats struct {
+	TotalConns              int32  `json:"total_conns"`
+	IdleConns               int32  `json:"idle_conns"`
+	AcquiredConns           int32  `json:"acquired_conns"`
+	ConstructingConns       int32  `json:"constructing_conns"`
+	MaxConns                int32  `json:"max_conns"`
+	AcquireCount            int64  `json:"acquire_count"`
+	EmptyAcquireCount       int64  `json:"empty_acquire_count"`
+	CanceledAcquireCount    int64  `json:"canceled_acquire_count"`
+	AcquireDuration         string `json:"acquire_duration"`
+	NewConnsCount           int64  `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64  `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64  `json:"max_idle_destroy_count"`
+}
+
+func BuildDSN(cfg config.Config) string {
+	return buildDSN(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBSSLMode)
+}
+
+func buildDSN(host, port, user, password, dbName, sslMode string) string {
+	u := &url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(user, password),
+		Host:   net.JoinHostPort(host, port),
+		Path:   "/" + dbName,
+	}
+	q := url.Values{}
+	if sslMode != "" {
+		q.Set("sslmode", sslMode)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// RedactDSN hides the password of a postgres URL so it can be logged safely.
+func RedactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "<unparseable dsn>"
+	}
+	return u.Redacted()
+}
+
+func NewPostgresPool(ctx context.Context, cfg config.Config, statements PreparedStatements) (*pgxpool.Pool, error) {
+	return NewPostgresPoolFromDSN(ctx, cfg, BuildDSN(cfg), statements)
+}
+
+// NewPostgresPoolFromDSN opens a pool against dsn using the pool tuning from
+// cfg, and blocks until the database answers a ping or the retries run out.
+func NewPostgresPoolFromDSN(ctx context.Context, cfg config.Config, dsn string, statements PreparedStatements) (*pgxpool.Pool, error) {
+	log.Printf("Trying to connect to database: %s", RedactDSN(dsn))
 
-	db, err := sql.Open("postgres", dsn)
+	pool, err := openPool(ctx, cfg, dsn, statements)
 	if err != nil {
 		return nil, err
 	}
-	log.Println("Connected to database")
 
-	return db, nil
+	if err := pingWithRetry(ctx, pool, cfg.DBConnectRetries, cfg.DBConnectBackoff); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	log.Printf("Connected to database: %s", RedactDSN(dsn))
+
+	return pool, nil
+}
+
+// openPool builds the pool without waiting for the server; pgxpool dials
+// lazily, so this only fails on configuration errors.
+func openPool(ctx context.Context, cfg config.Config, dsn string, statements PreparedStatements) (*pgxpool.Pool, error) {
+	poolCfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database configuration for %s: %w", RedactDSN(dsn), err)
+	}
+
+	poolCfg.MaxConns = cfg.DBMaxConns
+	poolCfg.MinConns = cfg.DBMinConns
+	poolCfg.MaxConnLifetime = cfg.DBMaxConnLifetime
+	poolCfg.MaxConnIdleTime = cfg.DBMaxConnIdleTime
+	poolCfg.HealthCheckPeriod = cfg.DBHealthCheckPeriod
+	poolCfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.DBStatementTimeout.Milliseconds(), 10)
+	poolCfg.ConnConfig.RuntimeParams["application_name"] = "filmnesia-user-service"
+
+	if len(statements) > 0 {
+		poolCfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			for name, sql := range statements {
+				if _, err := conn.Prepare(ctx, name, sql); err != nil {
+					return fmt.Errorf("failed to prepare statement %q: %w", name, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	return pgxpool.NewWithConfig(ctx, poolCfg)
+}
+
+func pingWithRetry(ctx context.Context, pool *pgxpool.Pool, attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = pool.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		wait := backoff * time.Duration(1<<(attempt-1))
+		log.Printf("WARNING: Database ping failed (attempt %d/%d): %v. Retrying in %s", attempt, attempts, err, wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return fmt.Errorf("database not reachable after %d attempts: %w", attempts, err)
+}
+
+func Stats(pool *pgxpool.Pool) PoolStats {
+	s := pool.Stat()
+	return PoolStats{
+		TotalConns:              s.TotalConns(),
+		IdleConns:               s.IdleConns(),
+		AcquiredConns:           s.AcquiredConns(),
+		ConstructingConns:       s.ConstructingConns(),
+		MaxConns:                s.MaxConns(),
+		AcquireCount:            s.AcquireCount(),
+		EmptyAcquireCount:       s.EmptyAcquireCount(),
+		CanceledAcquireCount:    s.CanceledAcquireCount(),
+		AcquireDuration:         s.AcquireDuration().String(),
+		NewConnsCount:           s.NewConnsCount(),
+		MaxLifetimeDestroyCount: s.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     s.MaxIdleDestroyCount(),
+	}
 }
@@ -0,0 +1,227 @@
+package database
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/virhanali/filmnesia/user-service/internal/config"
+)
+
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() THEN 0
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END::float8
+`
+
+type primaryCtxKey struct{}
+
+// WithPrimary marks ctx so that every read made with it goes to the primary.
+// Use it for read-your-writes: a request that must observe a write which a
+// lagging replica may not have replayed yet.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtxKey{}, true)
+}
+
+func UsePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryCtxKey{}).(bool)
+	return v
+}
+
+type replica struct {
+	dsn     string
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+	lag     atomic.Int64
+	lastErr atomic.Value
+}
+
+// ReplicaStatus is the health snapshot of one replica as last observed.
+type ReplicaStatus struct {
+	DSN     string    `json:"dsn"`
+	Healthy bool      `json:"healthy"`
+	Lag     string    `json:"lag"`
+	Error   string    `json:"error,omitempty"`
+	Pool    PoolStats `json:"pool"`
+}
+
+// Cluster routes queries between the primary and any read replicas. Writes
+// always go to Primary; Reader picks a healthy replica whose replication lag
+// is within DB_REPLICA_MAX_LAG, falling back to the primary when none qualifies.
+type Cluster struct {
+	primary  *pgxpool.Pool
+	replicas []*replica
+	maxLag   time.Duration
+	next     atomic.Uint64
+	writes   *WriteTracker
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewCluster connects to the primary (failing if it is unreachable) and
+// opens a lazily-dialled pool per replica DSN. Replicas start out unhealthy
+// and only receive traffic once the first health check has passed.
+func NewCluster(ctx context.Context, cfg config.Config, statements PreparedStatements) (*Cluster, error) {
+	return NewClusterFromDSN(ctx, cfg, BuildDSN(cfg), statements)
+}
+
+func NewClusterFromDSN(ctx context.Context, cfg config.Config, primaryDSN string, statements PreparedStatements) (*Cluster, error) {
+	primary, err := NewPostgresPoolFromDSN(ctx, cfg, primaryDSN, statements)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		primary: primary,
+		maxLag:  cfg.DBReplicaMaxLag,
+		writes:  NewWriteTracker(cfg.DBReadYourWritesWindow),
+		stop:    make(chan struct{}),
+	}
+
+	for _, dsn := range cfg.DBReplicaDSNs {
+		pool, err := openPool(ctx, cfg, dsn, statements)
+		if err != nil {
+			log.Printf("WARNING: Skipping read replica %s: %v", RedactDSN(dsn), err)
+			continue
+		}
+		c.replicas = append(c.replicas, &replica{dsn: dsn, pool: pool})
+		log.Printf("Registered read replica: %s", RedactDSN(dsn))
+	}
+
+	if len(c.replicas) > 0 {
+		c.checkReplicas(ctx)
+		c.wg.Add(1)
+		go c.healthLoop(cfg.DBReplicaCheckInterval)
+	}
+
+	return c, nil
+}
+
+func (c *Cluster) Primary() *pgxpool.Pool {
+	return c.primary
+}
+
+// Writes tracks users who recently wrote through this cluster.
+func (c *Cluster) Writes() *WriteTracker {
+	return c.writes
+}
+
+// Reader returns the pool a read-only query should use.
+func (c *Cluster) Reader(ctx context.Context) *pgxpool.Pool {
+	if len(c.replicas) == 0 || UsePrimary(ctx) {
+		return c.primary
+	}
+
+	n := uint64(len(c.replicas))
+	start := c.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		r := c.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r.pool
+		}
+	}
+	return c.primary
+}
+
+// ReportFailure takes a replica out of rotation after a query against it
+// failed. The next successful health check puts it back.
+func (c *Cluster) ReportFailure(pool *pgxpool.Pool, err error) {
+	for _, r := range c.replicas {
+		if r.pool == pool {
+			if r.healthy.Swap(false) {
+				log.Printf("WARNING: Read replica %s marked unhealthy after query error: %v", RedactDSN(r.dsn), err)
+			}
+			r.lastErr.Store(err.Error())
+			return
+		}
+	}
+}
+
+func (c *Cluster) IsPrimary(pool *pgxpool.Pool) bool {
+	return pool == c.primary
+}
+
+func (c *Cluster) ReplicaStatuses() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(c.replicas))
+	for _, r := range c.replicas {
+		lastErr, _ := r.lastErr.Load().(string)
+		statuses = append(statuses, ReplicaStatus{
+			DSN:     RedactDSN(r.dsn),
+			Healthy: r.healthy.Load(),
+			Lag:     time.Duration(r.lag.Load()).String(),
+			Error:   lastErr,
+			Pool:    Stats(r.pool),
+		})
+	}
+	return statuses
+}
+
+func (c *Cluster) healthLoop(interval time.Duration) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			c.checkReplicas(ctx)
+			cancel()
+		}
+	}
+}
+
+func (c *Cluster) checkReplicas(ctx context.Context) {
+	for _, r := range c.replicas {
+		var lagSeconds float64
+		err := r.pool.QueryRow(ctx, replicaLagQuery).Scan(&lagSeconds)
+		c.observe(r, time.Duration(lagSeconds*float64(time.Second)), err)
+	}
+}
+
+// observe applies the outcome of a health check to r: it takes traffic
+// only when reachable and no further behind than maxLag.
+func (c *Cluster) observe(r *replica, lag time.Duration, err error) {
+	if err != nil {
+		if r.healthy.Swap(false) {
+			log.Printf("WARNING: Read replica %s is unreachable: %v", RedactDSN(r.dsn), err)
+		}
+		r.lastErr.Store(err.Error())
+		return
+	}
+
+	r.lag.Store(int64(lag))
+
+	if c.maxLag > 0 && lag > c.maxLag {
+		if r.healthy.Swap(false) {
+			log.Printf("WARNING: Read replica %s lag %s exceeds %s, routing reads elsewhere", RedactDSN(r.dsn), lag, c.maxLag)
+		}
+		r.lastErr.Store("replication lag " + lag.String() + " exceeds limit")
+		return
+	}
+
+	r.lastErr.Store("")
+	if !r.healthy.Swap(true) {
+		log.Printf("Read replica %s is healthy (lag %s)", RedactDSN(r.dsn), lag)
+	}
+}
+
+func (c *Cluster) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		c.wg.Wait()
+		for _, r := range c.replicas {
+			r.pool.Close()
+		}
+		c.primary.Close()
+	})
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testCluster has a primary and two replicas whose pools never dial: the
+// tests feed health check results to observe instead.
+func testCluster(t *testing.T) (*Cluster, []*replica) {
+	t.Helper()
+	pool := func() *pgxpool.Pool {
+		p, err := pgxpool.New(context.Background(), "postgres://u:p@127.0.0.1:1/db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(p.Close)
+		return p
+	}
+	c := &Cluster{primary: pool(), maxLag: 5 * time.Second}
+	for _, dsn := range []string{"postgres://replica-a", "postgres://replica-b"} {
+		c.replicas = append(c.replicas, &replica{dsn: dsn, pool: pool()})
+	}
+	return c, c.replicas
+}
+
+func TestReaderRoutesToHealthyReplicasWithinLag(t *testing.T) {
+	c, replicas := testCluster(t)
+	ctx := context.Background()
+
+	if got := c.Reader(ctx); got != c.primary {
+		t.Fatal("replicas start out unhealthy but got a read")
+	}
+
+	c.observe(replicas[0], time.Second, nil)
+	c.observe(replicas[1], time.Minute, nil)
+	for i := 0; i < 4; i++ {
+		if got := c.Reader(ctx); got != replicas[0].pool {
+			t.Fatalf("read %d did not go to the only replica within lag", i)
+		}
+	}
+	if status := c.ReplicaStatuses()[1]; status.Healthy || status.Error == "" || status.Lag != time.Minute.String() {
+		t.Errorf("lagging replica status = %+v", status)
+	}
+
+	c.observe(replicas[1], 0, nil)
+	seen := map[*pgxpool.Pool]bool{}
+	for i := 0; i < 4; i++ {
+		seen[c.Reader(ctx)] = true
+	}
+	if len(seen) != 2 || seen[c.primary] {
+		t.Errorf("reads not spread over both replicas: %d pools", len(seen))
+	}
+
+	if got := c.Reader(WithPrimary(ctx)); got != c.primary {
+		t.Error("WithPrimary read went to a replica")
+	}
+
+	c.observe(replicas[0], 0, errors.New("connection refused"))
+	c.observe(replicas[1], 0, errors.New("connection refused"))
+	if got := c.Reader(ctx); got != c.primary {
+		t.Error("unreachable replicas still get reads")
+	}
+}
+
+func TestReportFailureTakesAReplicaOutUntilTheNextCheck(t *testing.T) {
+	c, replicas := testCluster(t)
+	ctx := context.Background()
+	c.observe(replicas[0], 0, nil)
+
+	c.ReportFailure(replicas[0].pool, errors.New("conn reset"))
+	if got := c.Reader(ctx); got != c.primary {
+		t.Fatal("failed replica still gets reads")
+	}
+	if status := c.ReplicaStatuses()[0]; status.Error != "conn reset" {
+		t.Errorf("status = %+v", status)
+	}
+	// Failures of the primary are not the cluster's to act on.
+	c.ReportFailure(c.primary, errors.New("boom"))
+
+	c.observe(replicas[0], 0, nil)
+	if got := c.Reader(ctx); got != replicas[0].pool {
+		t.Error("replica not back after a passing check")
+	}
+}
+
+func TestWriteTrackerMarkers(t *testing.T) {
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	tracker := NewWriteTracker(5 * time.Second)
+	tracker.now = func() time.Time { return now }
+
+	userID := uuid.New()
+	tracker.RecordWrite(userID)
+	marker := tracker.LastWriteMarker()
+
+	now = now.Add(3 * time.Second)
+	if !tracker.WroteRecently(userID) || !tracker.MarkerRecent(marker) {
+		t.Fatal("write within the window not recent")
+	}
+	for _, bad := range []string{"", "soon", tracker.LastWriteMarker() + "000"} {
+		if tracker.MarkerRecent(bad) {
+			t.Errorf("marker %q accepted", bad)
+		}
+	}
+
+	now = now.Add(3 * time.Second)
+	if tracker.WroteRecently(userID) || tracker.MarkerRecent(marker) {
+		t.Error("write past the window still recent")
+	}
+}
+
+func TestWriteTrackerSweepsOncePerWindow(t *testing.T) {
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	tracker := NewWriteTracker(5 * time.Second)
+	tracker.now = func() time.Time { return now }
+
+	old := uuid.New()
+	tracker.RecordWrite(old)
+	now = now.Add(6 * time.Second)
+	tracker.RecordWrite(uuid.New())
+	if _, ok := tracker.writes[old]; ok {
+		t.Fatal("write past the window kept after a sweep was due")
+	}
+
+	stale := uuid.New()
+	tracker.RecordWrite(stale)
+	now = now.Add(6 * time.Second)
+	tracker.lastSweep = now.Add(-time.Second)
+	tracker.RecordWrite(uuid.New())
+	if _, ok := tracker.writes[stale]; !ok {
+		t.Fatal("swept again before a window had passed")
+	}
+	if tracker.WroteRecently(stale) {
+		t.Error("unswept write past the window still recent")
+	}
+}
@@ -0,0 +1,202 @@
+package messagebroker
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrConnectionClosed = errors.New("rabbitmq connection manager closed")
+
+// ConnectionOptions bounds the jittered exponential backoff used between
+// reconnect attempts.
+type ConnectionOptions struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Connection owns an AMQP connection and re-dials it whenever the broker
+// closes it. Components that need topology or channels on the connection
+// register an OnConnect hook, which runs again after every reconnect.
+type Connection struct {
+	url  string
+	opts ConnectionOptions
+
+	mu         sync.RWMutex
+	conn       *amqp.Connection
+	hooks      []func(*amqp.Connection) error
+	reconnects int
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// DialConnection makes the initial connection synchronously, so startup
+// still fails loudly on a bad URL, and then watches it in the background.
+func DialConnection(url string, opts ConnectionOptions) (*Connection, error) {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(30*time.Second, opts.MinBackoff)
+	}
+
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Connection{
+		url:    url,
+		opts:   opts,
+		conn:   conn,
+		closed: make(chan struct{}),
+	}
+	go c.watch(conn)
+	return c, nil
+}
+
+// OnConnect runs fn against the current connection, if there is one, and
+// again after every successful reconnect. An error from the immediate call
+// is returned; errors on reconnect cause the new connection to be dropped
+// and retried.
+func (c *Connection) OnConnect(fn func(*amqp.Connection) error) error {
+	c.mu.Lock()
+	c.hooks = append(c.hooks, fn)
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return fn(conn)
+}
+
+// Current returns the live connection, or nil while reconnecting.
+func (c *Connection) Current() *amqp.Connection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+func (c *Connection) IsConnected() bool {
+	conn := c.Current()
+	return conn != nil && !conn.IsClosed()
+}
+
+// Reconnects counts how many times the connection has been re-established.
+func (c *Connection) Reconnects() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.reconnects
+}
+
+func (c *Connection) watch(conn *amqp.Connection) {
+	for {
+		notify := conn.NotifyClose(make(chan *amqp.Error, 1))
+		select {
+		case <-c.closed:
+			return
+		case amqpErr := <-notify:
+			c.mu.Lock()
+			c.conn = nil
+			c.mu.Unlock()
+			if amqpErr == nil {
+				// Closed by us through Close; nothing to recover.
+				return
+			}
+			log.Printf("WARNING: RabbitMQ connection lost: %v. Reconnecting...", amqpErr)
+		}
+
+		var ok bool
+		conn, ok = c.reconnect()
+		if !ok {
+			return
+		}
+	}
+}
+
+func (c *Connection) reconnect() (*amqp.Connection, bool) {
+	for attempt := 0; ; attempt++ {
+		delay := backoff(attempt, c.opts.MinBackoff, c.opts.MaxBackoff)
+		select {
+		case <-c.closed:
+			return nil, false
+		case <-time.After(delay):
+		}
+
+		conn, err := amqp.Dial(c.url)
+		if err != nil {
+			log.Printf("WARNING: RabbitMQ reconnect attempt %d failed: %v", attempt+1, err)
+			continue
+		}
+
+		c.mu.RLock()
+		hooks := append([]func(*amqp.Connection) error(nil), c.hooks...)
+		c.mu.RUnlock()
+
+		var hookErr error
+		for _, hook := range hooks {
+			if hookErr = hook(conn); hookErr != nil {
+				break
+			}
+		}
+		if hookErr != nil {
+			log.Printf("WARNING: RabbitMQ reconnect attempt %d failed during setup: %v", attempt+1, hookErr)
+			conn.Close()
+			continue
+		}
+
+		c.mu.Lock()
+		select {
+		case <-c.closed:
+			c.mu.Unlock()
+			conn.Close()
+			return nil, false
+		default:
+		}
+		c.conn = conn
+		c.reconnects++
+		c.mu.Unlock()
+
+		log.Printf("RabbitMQ connection re-established after %d attempt(s).", attempt+1)
+		return conn, true
+	}
+}
+
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+
+		c.mu.Lock()
+		conn := c.conn
+		c.conn = nil
+		c.mu.Unlock()
+
+		if conn == nil {
+			return
+		}
+		if err := conn.Close(); err != nil {
+			log.Printf("Error closing RabbitMQ connection: %v", err)
+		} else {
+			log.Println("RabbitMQ connection closed.")
+		}
+	})
+}
+
+// backoff returns an exponentially growing delay capped at maxDelay, with
+// "equal jitter": half the delay is fixed and half is random, so instances
+// that lost the broker at the same moment do not reconnect in lockstep.
+func backoff(attempt int, minDelay, maxDelay time.Duration) time.Duration {
+	d := minDelay
+	for i := 0; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
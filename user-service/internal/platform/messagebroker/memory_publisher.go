@@ -0,0 +1,63 @@
+package messagebroker
+
+import (
+	"context"
+	"sync"
+)
+
+// InMemoryPublisher records published events instead of sending them. It
+// is safe for concurrent use and intended for tests.
+type InMemoryPublisher struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+}
+
+func NewInMemoryPublisher() *InMemoryPublisher {
+	return &InMemoryPublisher{}
+}
+
+func (p *InMemoryPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *InMemoryPublisher) Close() {}
+
+// FailWith makes every subsequent Publish return err; nil restores success.
+func (p *InMemoryPublisher) FailWith(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// Events returns a copy of everything published so far, in order.
+func (p *InMemoryPublisher) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Event(nil), p.events...)
+}
+
+// RoutingKeys lists the routing key of every published event, in order.
+func (p *InMemoryPublisher) RoutingKeys() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := make([]string, len(p.events))
+	for i, event := range p.events {
+		keys[i] = event.RoutingKey()
+	}
+	return keys
+}
+
+func (p *InMemoryPublisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = nil
+}
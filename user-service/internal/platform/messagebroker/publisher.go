@@ -3,100 +3,443 @@ package messagebroker
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/virhanali/filmnesia/contracts"
 	"github.com/virhanali/filmnesia/user-service/internal/config"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const UserEventsExchange = "user_events"
+
+// UserEventsExchangeKind is topic so that consumers can bind with patterns
+// such as "user.*". It used to be direct; RabbitMQ refuses to redeclare an
+// exchange with another type, so an existing user_events exchange must be
+// deleted once when upgrading.
+const UserEventsExchangeKind = "topic"
+
+// EventSource is the CloudEvents source of everything this service publishes.
+const EventSource = "/user-service"
+
+// IdempotencyKeyHeader carries the key consumers deduplicate on. The AMQP
+// message ID is unique per Publish call; the idempotency key is the same
+// for every publish of the same business event.
+const IdempotencyKeyHeader = "x-idempotency-key"
+
+var (
+	ErrNotConnected      = errors.New("rabbitmq publisher is not connected")
+	ErrPublishNacked     = errors.New("rabbitmq broker nacked the message")
+	ErrConfirmTimeout    = errors.New("timed out waiting for rabbitmq publisher confirm")
+	ErrPublishBufferFull = errors.New("rabbitmq publish buffer is full")
+)
+
+// Event is anything that can be published: a payload with a schema in the
+// contracts module. The routing key identifies its type on the exchange.
+type Event = contracts.Payload
+
+// IdempotentEvent lets an event choose its idempotency key. Events that do
+// not implement it use their message ID, which still collapses broker
+// redeliveries and buffered re-publishes of a single Publish call.
+type IdempotentEvent interface {
+	Event
+	IdempotencyKey() string
+}
+
+// EventPublisher publishes domain events without tying callers to a broker.
+type EventPublisher interface {
+	Publish(ctx context.Context, event Event) error
+	Close()
+}
+
+// PublisherOptions controls confirm handling and what happens to events
+// published while the broker is unreachable. A BufferSize of zero makes
+// Publish fail fast with ErrNotConnected; otherwise up to BufferSize events
+// are held in memory and published after the next reconnect.
+type PublisherOptions struct {
+	Channels       int
+	ConfirmTimeout time.Duration
+	BufferSize     int
+	Reconnect      ConnectionOptions
+}
+
+// PublisherStatus is reported on the health endpoint.
+type PublisherStatus struct {
+	Connected  bool   `json:"connected"`
+	Reconnects int    `json:"reconnects"`
+	Mode       string `json:"mode"`
+	Buffered   int    `json:"buffered"`
+}
+
+type pendingMessage struct {
+	routingKey string
+	publishing amqp.Publishing
+}
+
+// RabbitMQPublisher publishes in confirm mode over a pool of channels, so
+// concurrent request goroutines never share an *amqp.Channel and Publish
+// only returns nil once the broker has taken responsibility for the message.
 type RabbitMQPublisher struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	cfg     config.Config
+	conn     *Connection
+	exchange string
+	opts     PublisherOptions
+
+	mu   sync.RWMutex
+	pool *channelPool
+
+	buffer chan pendingMessage
+	wake   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	closeOnce sync.Once
 }
 
+// NewRabbitMQPublisher connects and declares the user_events exchange. The
+// exchange is declared again, and the channel pool rebuilt, after every
+// reconnect.
 func NewRabbitMQPublisher(cfg config.Config) (*RabbitMQPublisher, error) {
 	log.Printf("Attempting to connect to RabbitMQ at %s", cfg.RabbitMQURL)
-	conn, err := amqp.Dial(cfg.RabbitMQURL)
+	reconnect := ConnectionOptions{
+		MinBackoff: cfg.RabbitMQReconnectMinBackoff,
+		MaxBackoff: cfg.RabbitMQReconnectMaxBackoff,
+	}
+	conn, err := DialConnection(cfg.RabbitMQURL, reconnect)
 	if err != nil {
 		log.Printf("Failed to connect to RabbitMQ: %v", err)
 		return nil, err
 	}
 
-	ch, err := conn.Channel()
+	opts := PublisherOptions{
+		Channels:       cfg.RabbitMQPublishChannels,
+		ConfirmTimeout: cfg.RabbitMQConfirmTimeout,
+		Reconnect:      reconnect,
+	}
+	if cfg.RabbitMQPublishMode == config.PublishModeBuffer {
+		opts.BufferSize = cfg.RabbitMQPublishBufferSize
+	}
+
+	p, err := newRabbitMQPublisher(conn, UserEventsExchange, opts)
 	if err != nil {
-		log.Printf("Failed to open a channel: %v", err)
 		conn.Close()
 		return nil, err
 	}
 
-	log.Println("Successfully connected to RabbitMQ and opened a channel.")
-	return &RabbitMQPublisher{
-		conn:    conn,
-		channel: ch,
-		cfg:     cfg,
-	}, nil
-}
-
-func (p *RabbitMQPublisher) PublishUserRegisteredEvent(ctx context.Context, exchangeName, routingKey string, eventData interface{}) error {
-	err := p.channel.ExchangeDeclare(
-		exchangeName,
-		"direct",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
+	log.Printf("Successfully connected to RabbitMQ (%d confirm channels, mode %s).", opts.Channels, p.mode())
+	return p, nil
+}
+
+func newRabbitMQPublisher(conn *Connection, exchange string, opts PublisherOptions) (*RabbitMQPublisher, error) {
+	if opts.Channels <= 0 {
+		opts.Channels = 4
+	}
+	if opts.ConfirmTimeout <= 0 {
+		opts.ConfirmTimeout = 5 * time.Second
+	}
+	if opts.BufferSize < 0 {
+		opts.BufferSize = 0
+	}
+
+	p := &RabbitMQPublisher{
+		conn:     conn,
+		exchange: exchange,
+		opts:     opts,
+		buffer:   make(chan pendingMessage, opts.BufferSize),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	if err := conn.OnConnect(p.setup); err != nil {
+		return nil, err
+	}
+
+	if opts.BufferSize > 0 {
+		p.wg.Add(1)
+		go p.flushLoop()
+	}
+	return p, nil
+}
+
+// setup runs on every (re)connect: it declares the exchange on a throwaway
+// channel and swaps in a fresh pool bound to the new connection.
+func (p *RabbitMQPublisher) setup(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
 	if err != nil {
-		log.Printf("Failed to declare an exchange '%s': %v", exchangeName, err)
+		return fmt.Errorf("open channel: %w", err)
+	}
+	defer ch.Close()
+
+	if err := ch.ExchangeDeclare(p.exchange, UserEventsExchangeKind, true, false, false, false, nil); err != nil {
+		log.Printf("Failed to declare an exchange '%s': %v", p.exchange, err)
 		return err
 	}
-	log.Printf("Exchange '%s' declared successfully or already exists.", exchangeName)
 
-	body, err := json.Marshal(eventData)
+	p.mu.Lock()
+	p.pool = newChannelPool(conn, p.opts.Channels)
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (p *RabbitMQPublisher) Publish(ctx context.Context, event Event) error {
+	routingKey := event.RoutingKey()
+
+	// NewEnvelope validates the payload against its schema; an event that
+	// consumers would reject is never published.
+	envelope, err := contracts.NewEnvelope(EventSource, event)
+	if err != nil {
+		log.Printf("Refusing to publish invalid %s event: %v", routingKey, err)
+		return err
+	}
+	body, err := json.Marshal(envelope)
 	if err != nil {
 		log.Printf("Failed to marshal event data to JSON: %v", err)
 		return err
 	}
 
-	err = p.channel.PublishWithContext(ctx,
-		exchangeName,
-		routingKey,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType:  "application/json",
+	messageID := envelope.ID
+	idempotencyKey := messageID
+	if ie, ok := event.(IdempotentEvent); ok {
+		idempotencyKey = ie.IdempotencyKey()
+	}
+
+	msg := pendingMessage{
+		routingKey: routingKey,
+		publishing: amqp.Publishing{
+			Headers:      amqp.Table{IdempotencyKeyHeader: idempotencyKey},
+			MessageId:    messageID,
+			ContentType:  contracts.ContentType,
 			DeliveryMode: amqp.Persistent,
-			Timestamp:    time.Now(),
+			Timestamp:    envelope.Time,
+			Type:         routingKey,
 			Body:         body,
-		})
+		},
+	}
+
+	err = p.publish(ctx, msg)
+	if errors.Is(err, ErrNotConnected) && p.opts.BufferSize > 0 {
+		err = p.enqueue(msg)
+		if err == nil {
+			log.Printf("RabbitMQ unavailable; buffered message for routing key '%s' (%d buffered).", routingKey, len(p.buffer))
+			return nil
+		}
+	}
 	if err != nil {
-		log.Printf("Failed to publish a message to exchange '%s' with routing key '%s': %v", exchangeName, routingKey, err)
+		log.Printf("Failed to publish a message to exchange '%s' with routing key '%s': %v", p.exchange, routingKey, err)
 		return err
 	}
 
-	log.Printf("Message published to exchange '%s' with routing key '%s'. Body: %s", exchangeName, routingKey, string(body))
+	log.Printf("Message %s published to exchange '%s' with routing key '%s' (%d bytes).", messageID, p.exchange, routingKey, len(body))
 	return nil
 }
 
-func (p *RabbitMQPublisher) Close() {
-	if p.channel != nil {
-		err := p.channel.Close()
-		if err != nil {
-			log.Printf("Error closing RabbitMQ channel: %v", err)
-		} else {
-			log.Println("RabbitMQ channel closed.")
+func (p *RabbitMQPublisher) publish(ctx context.Context, msg pendingMessage) error {
+	p.mu.RLock()
+	pool := p.pool
+	p.mu.RUnlock()
+	if pool == nil || pool.conn.IsClosed() {
+		return ErrNotConnected
+	}
+
+	ch, err := pool.acquire(ctx)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("%w: %v", ErrNotConnected, err)
+	}
+	defer pool.release(ch)
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, p.exchange, msg.routingKey, false, false, msg.publishing)
+	if err != nil {
+		if ch.IsClosed() {
+			return fmt.Errorf("%w: %v", ErrNotConnected, err)
+		}
+		return err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, p.opts.ConfirmTimeout)
+	defer cancel()
+	acked, err := confirm.WaitContext(waitCtx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return ErrConfirmTimeout
+		}
+		return err
+	}
+	if !acked {
+		// The library nacks outstanding confirms when the channel dies, so
+		// only a nack on a live channel came from the broker itself. In the
+		// former case the broker may still have routed the message, so a
+		// buffered retry gives at-least-once delivery.
+		if ch.IsClosed() {
+			return fmt.Errorf("%w: channel closed before confirm", ErrNotConnected)
+		}
+		return ErrPublishNacked
+	}
+	return nil
+}
+
+func (p *RabbitMQPublisher) enqueue(msg pendingMessage) error {
+	select {
+	case <-p.done:
+		return ErrNotConnected
+	default:
+	}
+	select {
+	case p.buffer <- msg:
+		return nil
+	default:
+		return ErrPublishBufferFull
+	}
+}
+
+// flushLoop publishes buffered messages once the connection is back. A
+// message that fails because the broker went away again is held until the
+// next reconnect; any other failure is logged and the message dropped.
+func (p *RabbitMQPublisher) flushLoop() {
+	defer p.wg.Done()
+
+	var pending *pendingMessage
+	for {
+		if pending == nil {
+			select {
+			case <-p.done:
+				p.drain(nil)
+				return
+			case msg := <-p.buffer:
+				pending = &msg
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), p.opts.ConfirmTimeout)
+		err := p.publish(ctx, *pending)
+		cancel()
+
+		switch {
+		case err == nil:
+			pending = nil
+		case errors.Is(err, ErrNotConnected):
+			select {
+			case <-p.done:
+				p.drain(pending)
+				return
+			case <-p.wake:
+			}
+		default:
+			log.Printf("ERROR: Dropping buffered message for routing key '%s': %v", pending.routingKey, err)
+			pending = nil
 		}
 	}
-	if p.conn != nil {
-		err := p.conn.Close()
+}
+
+// drain makes a last attempt to publish pending and whatever is still
+// buffered when the publisher closes, and reports anything left unsent.
+func (p *RabbitMQPublisher) drain(pending *pendingMessage) {
+	for {
+		if pending == nil {
+			select {
+			case msg := <-p.buffer:
+				pending = &msg
+			default:
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), p.opts.ConfirmTimeout)
+		err := p.publish(ctx, *pending)
+		cancel()
 		if err != nil {
-			log.Printf("Error closing RabbitMQ connection: %v", err)
-		} else {
-			log.Println("RabbitMQ connection closed.")
+			lost := len(p.buffer) + 1
+			log.Printf("WARNING: RabbitMQ publisher closed with %d unpublished buffered message(s): %v", lost, err)
+			return
 		}
+		pending = nil
+	}
+}
+
+func (p *RabbitMQPublisher) Status() PublisherStatus {
+	return PublisherStatus{
+		Connected:  p.connected(),
+		Reconnects: p.conn.Reconnects(),
+		Mode:       p.mode(),
+		Buffered:   len(p.buffer),
+	}
+}
+
+func (p *RabbitMQPublisher) connected() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pool != nil && !p.pool.conn.IsClosed()
+}
+
+func (p *RabbitMQPublisher) mode() string {
+	if p.opts.BufferSize > 0 {
+		return config.PublishModeBuffer
+	}
+	return config.PublishModeFailFast
+}
+
+func (p *RabbitMQPublisher) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+		p.conn.Close()
+	})
+}
+
+// channelPool hands out at most size confirm-mode channels on one
+// connection. Slots start empty and are filled lazily; a channel that the
+// broker closed is discarded on release and reopened by the next acquire.
+type channelPool struct {
+	conn  *amqp.Connection
+	slots chan *amqp.Channel
+}
+
+func newChannelPool(conn *amqp.Connection, size int) *channelPool {
+	p := &channelPool{conn: conn, slots: make(chan *amqp.Channel, size)}
+	for i := 0; i < size; i++ {
+		p.slots <- nil
+	}
+	return p
+}
+
+func (p *channelPool) acquire(ctx context.Context) (*amqp.Channel, error) {
+	var ch *amqp.Channel
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case ch = <-p.slots:
+	}
+
+	if ch != nil && !ch.IsClosed() {
+		return ch, nil
+	}
+
+	ch, err := p.conn.Channel()
+	if err == nil {
+		if err = ch.Confirm(false); err != nil {
+			ch.Close()
+		}
+	}
+	if err != nil {
+		p.slots <- nil
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (p *channelPool) release(ch *amqp.Channel) {
+	if ch != nil && ch.IsClosed() {
+		ch = nil
 	}
+	p.slots <- ch
 }
@@ -0,0 +1,117 @@
+package messagebroker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/virhanali/filmnesia/contracts"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+}
+
+func (testEvent) RoutingKey() string { return "test.event" }
+func (testEvent) SchemaVersion() int { return 1 }
+
+func init() {
+	schema := `{"type":"object","required":["name"],"properties":{"name":{"type":"string","minLength":1}}}`
+	if err := contracts.RegisterSchema("test.event", 1, []byte(schema)); err != nil {
+		panic(err)
+	}
+}
+
+// disconnectedPublisher builds a publisher whose connection manager is
+// between reconnect attempts, which is the state these tests care about.
+func disconnectedPublisher(t *testing.T, opts PublisherOptions) *RabbitMQPublisher {
+	t.Helper()
+	conn := &Connection{closed: make(chan struct{})}
+	p, err := newRabbitMQPublisher(conn, "test_events", opts)
+	if err != nil {
+		t.Fatalf("newRabbitMQPublisher: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestPublishFailsFastWhenDisconnected(t *testing.T) {
+	p := disconnectedPublisher(t, PublisherOptions{})
+
+	err := p.Publish(context.Background(), testEvent{Name: "a"})
+	if !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("want ErrNotConnected, got %v", err)
+	}
+	if s := p.Status(); s.Connected || s.Mode != "fail_fast" || s.Buffered != 0 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestPublishBuffersWhenDisconnected(t *testing.T) {
+	p := disconnectedPublisher(t, PublisherOptions{BufferSize: 2})
+
+	for _, name := range []string{"a", "b"} {
+		if err := p.Publish(context.Background(), testEvent{Name: name}); err != nil {
+			t.Fatalf("Publish(%s): %v", name, err)
+		}
+	}
+
+	// The flush loop may already hold the first message, waiting for a
+	// reconnect, which frees one slot; fill whatever room is left.
+	deadline := time.Now().Add(time.Second)
+	var err error
+	for time.Now().Before(deadline) {
+		if err = p.Publish(context.Background(), testEvent{Name: "overflow"}); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrPublishBufferFull) {
+		t.Fatalf("want ErrPublishBufferFull once the buffer is full, got %v", err)
+	}
+	if s := p.Status(); s.Mode != "buffer" || s.Buffered != 2 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestPublishRejectsInvalidEvents(t *testing.T) {
+	p := disconnectedPublisher(t, PublisherOptions{BufferSize: 10})
+
+	if err := p.Publish(context.Background(), testEvent{}); !errors.Is(err, contracts.ErrInvalidPayload) {
+		t.Fatalf("want ErrInvalidPayload, got %v", err)
+	}
+	if s := p.Status(); s.Buffered != 0 {
+		t.Errorf("invalid event was buffered: %+v", s)
+	}
+}
+
+func TestPublishAfterCloseIsRejected(t *testing.T) {
+	p := disconnectedPublisher(t, PublisherOptions{BufferSize: 10})
+	p.Close()
+
+	if err := p.Publish(context.Background(), testEvent{Name: "late"}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("want ErrNotConnected after Close, got %v", err)
+	}
+}
+
+func TestBackoffIsJitteredAndCapped(t *testing.T) {
+	minDelay, maxDelay := 100*time.Millisecond, 2*time.Second
+
+	for attempt := 0; attempt < 10; attempt++ {
+		ceiling := min(minDelay<<attempt, maxDelay)
+		for i := 0; i < 50; i++ {
+			d := backoff(attempt, minDelay, maxDelay)
+			if d < ceiling/2 || d > ceiling {
+				t.Fatalf("backoff(%d) = %s, want within [%s, %s]", attempt, d, ceiling/2, ceiling)
+			}
+		}
+	}
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		seen[backoff(5, minDelay, maxDelay)] = true
+	}
+	if len(seen) < 2 {
+		t.Error("backoff is not jittered")
+	}
+}
@@ -0,0 +1,163 @@
+package messagebroker
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/user-service/internal/config"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// UserCacheInvalidator drops cached entries for a user.
+type UserCacheInvalidator interface {
+	InvalidateUser(ctx context.Context, id uuid.UUID, emails, usernames []string) error
+}
+
+// userChangedMessage covers the fields of both user.updated and
+// user.deleted that identify cache keys.
+type userChangedMessage struct {
+	UserID           uuid.UUID `json:"user_id"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	PreviousUsername string    `json:"previous_username"`
+	PreviousEmail    string    `json:"previous_email"`
+}
+
+// CacheInvalidationSubscriber listens for user.updated and user.deleted on
+// an exclusive, auto-deleted queue so that every user-service instance gets
+// its own copy and can evict its in-process cache. The queue is declared,
+// bound and consumed again after every reconnect; events published while
+// the connection was down are lost with the old queue and left to the
+// cache TTLs.
+type CacheInvalidationSubscriber struct {
+	conn        *Connection
+	invalidator UserCacheInvalidator
+}
+
+func NewCacheInvalidationSubscriber(cfg config.Config, invalidator UserCacheInvalidator) (*CacheInvalidationSubscriber, error) {
+	conn, err := DialConnection(cfg.RabbitMQURL, ConnectionOptions{
+		MinBackoff: cfg.RabbitMQReconnectMinBackoff,
+		MaxBackoff: cfg.RabbitMQReconnectMaxBackoff,
+	})
+	if err != nil {
+		log.Printf("Failed to connect to RabbitMQ for cache invalidation: %v", err)
+		return nil, err
+	}
+
+	return &CacheInvalidationSubscriber{
+		conn:        conn,
+		invalidator: invalidator,
+	}, nil
+}
+
+// Start subscribes now and registers the subscription to be rebuilt on
+// every reconnect. Deliveries are handled until ctx is done or Close is
+// called.
+func (s *CacheInvalidationSubscriber) Start(ctx context.Context, exchangeName string, routingKeys ...string) error {
+	return s.conn.OnConnect(func(conn *amqp.Connection) error {
+		return s.subscribe(ctx, conn, exchangeName, routingKeys)
+	})
+}
+
+func (s *CacheInvalidationSubscriber) subscribe(ctx context.Context, conn *amqp.Connection, exchangeName string, routingKeys []string) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Printf("Failed to open a cache invalidation channel: %v", err)
+		return err
+	}
+
+	msgs, err := s.declare(ch, exchangeName, routingKeys)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+	log.Printf("Listening for %v on '%s' to invalidate the user cache.", routingKeys, exchangeName)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				ch.Close()
+				return
+			case d, ok := <-msgs:
+				if !ok {
+					s.channelClosed(ctx, conn, exchangeName, routingKeys)
+					return
+				}
+				s.handle(ctx, d)
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *CacheInvalidationSubscriber) declare(ch *amqp.Channel, exchangeName string, routingKeys []string) (<-chan amqp.Delivery, error) {
+	err := ch.ExchangeDeclare(exchangeName, UserEventsExchangeKind, true, false, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to declare an exchange '%s': %v", exchangeName, err)
+		return nil, err
+	}
+
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		log.Printf("Failed to declare cache invalidation queue: %v", err)
+		return nil, err
+	}
+
+	for _, routingKey := range routingKeys {
+		if err := ch.QueueBind(q.Name, routingKey, exchangeName, false, nil); err != nil {
+			log.Printf("Failed to bind queue '%s' to exchange '%s' with routing key '%s': %v", q.Name, exchangeName, routingKey, err)
+			return nil, err
+		}
+	}
+
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		log.Printf("Failed to register a consumer for queue '%s': %v", q.Name, err)
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// channelClosed resubscribes when only the channel was closed. When the
+// connection went with it, the Connection's OnConnect hook resubscribes
+// once it has reconnected.
+func (s *CacheInvalidationSubscriber) channelClosed(ctx context.Context, conn *amqp.Connection, exchangeName string, routingKeys []string) {
+	if ctx.Err() != nil {
+		return
+	}
+	if conn.IsClosed() {
+		log.Println("WARNING: Cache invalidation channel closed with the connection; resubscribing after reconnect.")
+		return
+	}
+	log.Println("WARNING: Cache invalidation channel closed; resubscribing.")
+	if err := s.subscribe(ctx, conn, exchangeName, routingKeys); err != nil {
+		log.Printf("WARNING: Failed to resubscribe for cache invalidation; relying on cache TTLs until the next reconnect: %v", err)
+	}
+}
+
+func (s *CacheInvalidationSubscriber) handle(ctx context.Context, d amqp.Delivery) {
+	event, err := contracts.Decode(d.Body, d.ContentType, d.RoutingKey)
+	if err != nil {
+		log.Printf("ERROR: Failed to decode %s message: %v", d.RoutingKey, err)
+		return
+	}
+	var msg userChangedMessage
+	if err := event.DecodeData(&msg); err != nil {
+		log.Printf("ERROR: Failed to unmarshal %s message: %v", d.RoutingKey, err)
+		return
+	}
+
+	emails := []string{msg.Email, msg.PreviousEmail}
+	usernames := []string{msg.Username, msg.PreviousUsername}
+	if err := s.invalidator.InvalidateUser(ctx, msg.UserID, emails, usernames); err != nil {
+		log.Printf("WARNING: Failed to invalidate cache for UserID %s: %v", msg.UserID, err)
+	}
+}
+
+func (s *CacheInvalidationSubscriber) Close() {
+	s.conn.Close()
+}
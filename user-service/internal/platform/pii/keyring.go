@@ -0,0 +1,170 @@
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Field names are bound into both the ciphertext (as GCM additional data)
+// and the blind index, so a value encrypted for one column cannot be copied
+// into another and indexes of different columns never collide.
+const (
+	FieldUserEmail = "users.email"
+)
+
+const envelopeVersion = "v1"
+
+var (
+	ErrUnknownKey        = errors.New("pii: ciphertext encrypted with unknown key")
+	ErrMalformedEnvelope = errors.New("pii: malformed ciphertext envelope")
+)
+
+// Keyring encrypts PII with AES-256-GCM. Every ciphertext records the ID of
+// the key that produced it, so old keys stay usable for decryption while the
+// active key is rotated and rows are re-encrypted in the background.
+type Keyring struct {
+	activeKeyID string
+	aeads       map[string]cipher.AEAD
+	indexKey    []byte
+}
+
+// NewKeyring builds a keyring from key ID -> 32-byte key material. indexKey
+// is the HMAC key for blind indexes; it cannot be rotated without
+// recomputing every index.
+func NewKeyring(keys map[string][]byte, activeKeyID string, indexKey []byte) (*Keyring, error) {
+	if _, ok := keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("pii: active key %q is not in the keyring", activeKeyID)
+	}
+	if len(indexKey) < 32 {
+		return nil, errors.New("pii: blind index key must be at least 32 bytes")
+	}
+
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("pii: invalid key ID %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("pii: key %q must be 32 bytes, got %d", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("pii: key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("pii: key %q: %w", id, err)
+		}
+		aeads[id] = aead
+	}
+
+	return &Keyring{activeKeyID: activeKeyID, aeads: aeads, indexKey: indexKey}, nil
+}
+
+// ParseKeyring reads keys in the PII_ENCRYPTION_KEYS format
+// "id1:base64key,id2:base64key" plus a base64 blind index key.
+func ParseKeyring(encodedKeys, activeKeyID, encodedIndexKey string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	for i, pair := range strings.Split(encodedKeys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			// Without a separator the entry may be a bare key; never echo it.
+			return nil, fmt.Errorf("pii: key entry %d must be id:base64key", i+1)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("pii: key %q is not valid base64: %w", id, err)
+		}
+		keys[id] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("pii: no encryption keys configured")
+	}
+
+	indexKey, err := base64.StdEncoding.DecodeString(encodedIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("pii: blind index key is not valid base64: %w", err)
+	}
+
+	return NewKeyring(keys, activeKeyID, indexKey)
+}
+
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeKeyID
+}
+
+// Encrypt returns "v1:<keyID>:<base64(nonce||ciphertext)>" for plaintext
+// using the active key.
+func (k *Keyring) Encrypt(field, plaintext string) (string, error) {
+	aead := k.aeads[k.activeKeyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("pii: failed to generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(field))
+	return envelopeVersion + ":" + k.activeKeyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keyring) Decrypt(field, envelope string) (string, error) {
+	keyID, payload, err := splitEnvelope(envelope)
+	if err != nil {
+		return "", err
+	}
+	aead, ok := k.aeads[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrMalformedEnvelope
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("pii: failed to decrypt %s: %w", field, err)
+	}
+	return string(plaintext), nil
+}
+
+// KeyID reports which key encrypted envelope.
+func KeyID(envelope string) (string, error) {
+	keyID, _, err := splitEnvelope(envelope)
+	return keyID, err
+}
+
+// BlindIndex is a keyed, deterministic hash of the normalized value that
+// allows equality lookups and unique constraints without storing plaintext.
+func (k *Keyring) BlindIndex(field, value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(normalize(value)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func splitEnvelope(envelope string) (keyID, payload string, err error) {
+	parts := strings.SplitN(envelope, ":", 3)
+	if len(parts) != 3 || parts[0] != envelopeVersion || parts[1] == "" {
+		return "", "", ErrMalformedEnvelope
+	}
+	return parts[1], parts[2], nil
+}
+
+func normalize(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
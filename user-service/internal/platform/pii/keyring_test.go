@@ -0,0 +1,134 @@
+package pii
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	k, err := NewKeyring(map[string][]byte{"k1": testKey(1)}, "k1", testKey(9))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+
+	a, err := k.Encrypt(FieldUserEmail, "alice@example.com")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	b, _ := k.Encrypt(FieldUserEmail, "alice@example.com")
+	if a == b {
+		t.Error("two encryptions of the same value produced identical ciphertext")
+	}
+	if strings.Contains(a, "alice") {
+		t.Errorf("ciphertext leaks plaintext: %s", a)
+	}
+	if id, _ := KeyID(a); id != "k1" {
+		t.Errorf("KeyID = %q, want k1", id)
+	}
+
+	got, err := k.Decrypt(FieldUserEmail, a)
+	if err != nil || got != "alice@example.com" {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+
+	if _, err := k.Decrypt("users.phone", a); err == nil {
+		t.Error("ciphertext for users.email decrypted as users.phone")
+	}
+}
+
+func TestRotationKeepsOldKeysReadable(t *testing.T) {
+	old, _ := NewKeyring(map[string][]byte{"k1": testKey(1)}, "k1", testKey(9))
+	envelope, _ := old.Encrypt(FieldUserEmail, "bob@example.com")
+
+	rotated, err := NewKeyring(map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k2", testKey(9))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if got, err := rotated.Decrypt(FieldUserEmail, envelope); err != nil || got != "bob@example.com" {
+		t.Errorf("Decrypt with rotated keyring = %q, %v", got, err)
+	}
+	fresh, _ := rotated.Encrypt(FieldUserEmail, "bob@example.com")
+	if id, _ := KeyID(fresh); id != "k2" {
+		t.Errorf("new ciphertext uses key %q, want k2", id)
+	}
+	if rotated.BlindIndex(FieldUserEmail, "bob@example.com") != old.BlindIndex(FieldUserEmail, "bob@example.com") {
+		t.Error("blind index changed when only the encryption key rotated")
+	}
+
+	retired, _ := NewKeyring(map[string][]byte{"k2": testKey(2)}, "k2", testKey(9))
+	if _, err := retired.Decrypt(FieldUserEmail, envelope); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("want ErrUnknownKey once k1 is removed, got %v", err)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	k, _ := NewKeyring(map[string][]byte{"k1": testKey(1)}, "k1", testKey(9))
+
+	if k.BlindIndex(FieldUserEmail, " Alice@Example.com ") != k.BlindIndex(FieldUserEmail, "alice@example.com") {
+		t.Error("blind index is not normalized")
+	}
+	if k.BlindIndex(FieldUserEmail, "alice@example.com") == k.BlindIndex(FieldUserEmail, "bob@example.com") {
+		t.Error("different values share a blind index")
+	}
+	if k.BlindIndex(FieldUserEmail, "x") == k.BlindIndex("users.phone", "x") {
+		t.Error("blind index is not separated by field")
+	}
+
+	other, _ := NewKeyring(map[string][]byte{"k1": testKey(1)}, "k1", testKey(8))
+	if k.BlindIndex(FieldUserEmail, "alice@example.com") == other.BlindIndex(FieldUserEmail, "alice@example.com") {
+		t.Error("blind index does not depend on the index key")
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	enc := base64.StdEncoding.EncodeToString
+	k, err := ParseKeyring("k1:"+enc(testKey(1))+", k2:"+enc(testKey(2)), "k2", enc(testKey(9)))
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	if k.ActiveKeyID() != "k2" {
+		t.Errorf("ActiveKeyID = %q", k.ActiveKeyID())
+	}
+
+	invalid := []struct {
+		name, keys, active, index string
+	}{
+		{"no keys", "", "k1", enc(testKey(9))},
+		{"missing active", "k1:" + enc(testKey(1)), "k2", enc(testKey(9))},
+		{"short key", "k1:" + enc([]byte("short")), "k1", enc(testKey(9))},
+		{"short index key", "k1:" + enc(testKey(1)), "k1", enc([]byte("short"))},
+		{"bad base64", "k1:!!!", "k1", enc(testKey(9))},
+	}
+	for _, tt := range invalid {
+		if _, err := ParseKeyring(tt.keys, tt.active, tt.index); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	// A key pasted without its id must not end up in the error (and logs).
+	bare := enc(testKey(7))
+	_, err = ParseKeyring("k1:"+enc(testKey(1))+","+bare, "k1", enc(testKey(9)))
+	if err == nil || strings.Contains(err.Error(), bare) {
+		t.Errorf("bare key entry: err = %v", err)
+	}
+}
+
+func TestMaskEmail(t *testing.T) {
+	cases := map[string]string{
+		"alice@example.com": "a***@example.com",
+		"not-an-email":      "***",
+		"@example.com":      "***",
+	}
+	for in, want := range cases {
+		if got := MaskEmail(in); got != want {
+			t.Errorf("MaskEmail(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
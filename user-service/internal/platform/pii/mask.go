@@ -0,0 +1,13 @@
+package pii
+
+import "strings"
+
+// MaskEmail keeps just enough of an address to correlate log lines:
+// "alice@example.com" becomes "a***@example.com".
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	return local[:1] + "***@" + domain
+}
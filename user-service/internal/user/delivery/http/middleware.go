@@ -3,6 +3,7 @@ package http
 import (
 	"errors"
 	"log"
+	"math"
 	"net/http"
 	"strings"
 
@@ -10,6 +11,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 
+	"github.com/virhanali/filmnesia/contracts"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/database"
 	"github.com/virhanali/filmnesia/user-service/internal/user/domain"
 )
 
@@ -18,6 +21,8 @@ const (
 	AuthUsernameKey    = "authUsername"
 	AuthUserRoleKey    = "authUserRole"
 	AuthTokenClaimsKey = "authTokenClaims"
+
+	ReadYourWritesHeader = "X-Read-Your-Writes"
 )
 
 var (
@@ -27,12 +32,31 @@ var (
 	ErrTokenParsing      = errors.New("failed to parse token")
 )
 
-func AuthMiddleware(jwtSecretKey string) gin.HandlerFunc {
+// AuthMiddleware authenticates requests with an access token signed with
+// jwtSecretKey. With identity set it first accepts the identity headers
+// the gateway signed; headers that do not verify are rejected rather than
+// ignored. Requests authenticated by headers carry no token claims.
+func AuthMiddleware(jwtSecretKey string, identity *contracts.IdentityVerifier) gin.HandlerFunc {
 	if jwtSecretKey == "" {
 		log.Fatal("FATAL: JWT_SECRET_KEY cannot be empty for AuthMiddleware")
 	}
 
 	return func(c *gin.Context) {
+		if identity != nil {
+			id, err := identity.Verify(c.Request.Header)
+			if err == nil {
+				c.Set(AuthUserIDKey, id.UserID)
+				c.Set(AuthUsernameKey, id.Username)
+				c.Set(AuthUserRoleKey, id.Role)
+				c.Next()
+				return
+			}
+			if !errors.Is(err, contracts.ErrNoIdentity) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrMissingAuthHeader.Error()})
@@ -96,3 +120,49 @@ func AuthMiddleware(jwtSecretKey string) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// ReadYourWritesMiddleware pins the request's reads to the primary database
+// when the client asks for it via the X-Read-Your-Writes header, when it
+// returns a recent last-write marker, or when the authenticated user wrote
+// through this replica recently enough that replicas may still be behind.
+// It must run after AuthMiddleware to see the user ID.
+//
+// Requests that may write get a fresh marker, as a cookie and a header,
+// before they run: the service has several replicas and the client's next
+// request may reach another one. A marker for a write that then failed
+// only pins reads to the primary for the window.
+func ReadYourWritesMiddleware(tracker *database.WriteTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pin := strings.EqualFold(c.GetHeader(ReadYourWritesHeader), "true") ||
+			tracker.MarkerRecent(c.GetHeader(database.LastWriteHeader))
+
+		if !pin {
+			if marker, err := c.Cookie(database.LastWriteCookie); err == nil && tracker.MarkerRecent(marker) {
+				pin = true
+			}
+		}
+		if !pin {
+			if value, exists := c.Get(AuthUserIDKey); exists {
+				if userID, ok := value.(uuid.UUID); ok && tracker.WroteRecently(userID) {
+					pin = true
+				}
+			}
+		}
+
+		if pin {
+			c.Request = c.Request.WithContext(database.WithPrimary(c.Request.Context()))
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			marker := tracker.LastWriteMarker()
+			maxAge := int(math.Ceil(tracker.Window().Seconds()))
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(database.LastWriteCookie, marker, maxAge, "/api/v1", "", false, true)
+			c.Header(database.LastWriteHeader, marker)
+		}
+
+		c.Next()
+	}
+}
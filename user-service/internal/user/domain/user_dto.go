@@ -11,12 +11,14 @@ type RegisterUserRequest struct {
 	Username string `json:"username" binding:"required,alphanum,min=3,max=30"`
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=6,max=72"`
+	Locale   string `json:"locale,omitempty" binding:"omitempty,oneof=id en"`
 }
 
 type UpdateUserRequest struct {
 	Username *string `json:"username,omitempty" binding:"omitempty,alphanum,min=3,max=30"`
 	Email    *string `json:"email,omitempty" binding:"omitempty,email"`
 	Password *string `json:"password,omitempty" binding:"omitempty,min=6,max=72"`
+	Locale   *string `json:"locale,omitempty" binding:"omitempty,oneof=id en"`
 }
 
 type LoginUserRequest struct {
@@ -40,6 +42,7 @@ type UserResponse struct {
 	Username  string    `json:"username"`
 	Email     string    `json:"email"`
 	Role      string    `json:"role"`
+	Locale    string    `json:"locale,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -50,6 +53,7 @@ func (u *User) ToUserResponse() *UserResponse {
 		Username:  u.Username,
 		Email:     u.Email,
 		Role:      u.Role,
+		Locale:    u.Locale,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
 	}
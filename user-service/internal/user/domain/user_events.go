@@ -0,0 +1,25 @@
+package domain
+
+import "github.com/virhanali/filmnesia/contracts"
+
+// User events are defined in the shared contracts module so that producers
+// and consumers agree on one schema; these aliases keep the domain package
+// the place use cases import them from.
+
+const (
+	EventUserRegistered   = contracts.EventUserRegistered
+	EventUserUpdated      = contracts.EventUserUpdated
+	EventUserEmailChanged = contracts.EventUserEmailChanged
+	EventUserRoleChanged  = contracts.EventUserRoleChanged
+	EventUserDeleted      = contracts.EventUserDeleted
+	EventUserLoggedIn     = contracts.EventUserLoggedIn
+)
+
+type (
+	UserRegisteredEvent   = contracts.UserRegisteredEvent
+	UserUpdatedEvent      = contracts.UserUpdatedEvent
+	UserEmailChangedEvent = contracts.UserEmailChangedEvent
+	UserRoleChangedEvent  = contracts.UserRoleChangedEvent
+	UserDeletedEvent      = contracts.UserDeletedEvent
+	UserLoggedInEvent     = contracts.UserLoggedInEvent
+)
@@ -0,0 +1,361 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/cache"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/database"
+	"github.com/virhanali/filmnesia/user-service/internal/user/domain"
+	"golang.org/x/sync/singleflight"
+)
+
+// cachedUser is the cache encoding of a lookup result; Found=false is a
+// negative entry for a lookup that matched nothing. Entries may be written
+// to the shared tier, so they carry no email or password hash: those live
+// in privateUser, in the in-process tier only.
+type cachedUser struct {
+	Found     bool      `json:"found"`
+	ID        uuid.UUID `json:"id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	Locale    string    `json:"locale,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+type privateUser struct {
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash"`
+}
+
+type CacheOptions struct {
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	// FreshWindow is how long after a user is written or invalidated
+	// misses for them are refilled from the primary rather than a replica,
+	// normally DB_READ_YOUR_WRITES_WINDOW. Zero refills every miss from the
+	// primary.
+	FreshWindow time.Duration
+}
+
+type CacheStats struct {
+	Hits         int64 `json:"hits"`
+	NegativeHits int64 `json:"negative_hits"`
+	Misses       int64 `json:"misses"`
+	Errors       int64 `json:"errors"`
+}
+
+// CachedUserRepository is a read-through cache in front of another
+// UserRepository. Lookups for the same key are collapsed with singleflight,
+// misses are cached for NegativeTTL, and writes invalidate every key that
+// could resolve to the written user. Lookups on a context pinned to the
+// primary (database.WithPrimary) bypass the cache entirely. Misses are
+// refilled from a replica, except for users written or invalidated within
+// FreshWindow and for lookups that matched nothing, which a lagging replica
+// could answer with a row the invalidation just dropped. Email addresses
+// and password hashes, including email-keyed entries, never leave the
+// in-process tier.
+type CachedUserRepository struct {
+	inner   UserRepository
+	cache   cache.Cache
+	local   cache.Cache
+	opts    CacheOptions
+	group   singleflight.Group
+	changed *database.WriteTracker
+
+	hits         atomic.Int64
+	negativeHits atomic.Int64
+	misses       atomic.Int64
+	errors       atomic.Int64
+}
+
+func NewCachedUserRepository(inner UserRepository, c cache.Cache, opts CacheOptions) *CachedUserRepository {
+	r := &CachedUserRepository{inner: inner, cache: c, local: cache.LocalOnly(c), opts: opts}
+	if opts.FreshWindow > 0 {
+		r.changed = database.NewWriteTracker(opts.FreshWindow)
+	}
+	return r
+}
+
+func idKey(id uuid.UUID) string          { return "user:id:" + id.String() }
+func usernameKey(username string) string { return "user:username:" + username }
+func privateKey(id uuid.UUID) string     { return "user:private:" + id.String() }
+
+// emailKey normalises email as the blind index does, so that lookups that
+// differ in case share the entry that writes invalidate.
+func emailKey(email string) string {
+	return "user:email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func (r *CachedUserRepository) Create(ctx context.Context, user *domain.User) (*domain.User, error) {
+	created, err := r.inner.Create(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	// Drop negative entries left by the pre-registration uniqueness checks.
+	r.invalidate(ctx, created.ID, []string{created.Email}, []string{created.Username})
+	return created, nil
+}
+
+func (r *CachedUserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return r.lookup(ctx, r.local, emailKey(email), func(ctx context.Context) (*domain.User, error) {
+		return r.inner.GetByEmail(ctx, email)
+	})
+}
+
+func (r *CachedUserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return r.lookup(ctx, r.cache, usernameKey(username), func(ctx context.Context) (*domain.User, error) {
+		return r.inner.GetByUsername(ctx, username)
+	})
+}
+
+func (r *CachedUserRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	return r.lookup(ctx, r.cache, idKey(id), func(ctx context.Context) (*domain.User, error) {
+		return r.inner.GetByID(ctx, id)
+	})
+}
+
+func (r *CachedUserRepository) Update(ctx context.Context, user *domain.User) (*domain.User, error) {
+	previous, err := r.inner.GetByID(database.WithPrimary(ctx), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := r.inner.Update(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	emails, usernames := []string{updated.Email}, []string{updated.Username}
+	if previous != nil {
+		emails = append(emails, previous.Email)
+		usernames = append(usernames, previous.Username)
+	}
+	r.invalidate(ctx, updated.ID, emails, usernames)
+	return updated, nil
+}
+
+func (r *CachedUserRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	previous, err := r.inner.GetByID(database.WithPrimary(ctx), id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.inner.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		r.invalidate(ctx, id, []string{previous.Email}, []string{previous.Username})
+	} else {
+		r.invalidate(ctx, id, nil, nil)
+	}
+	return nil
+}
+
+// InvalidateUser drops every cached entry for the user. It is called for
+// user.updated and user.deleted events so that other instances' local
+// caches do not serve stale profiles.
+func (r *CachedUserRepository) InvalidateUser(ctx context.Context, id uuid.UUID, emails, usernames []string) error {
+	if r.changed != nil && id != uuid.Nil {
+		r.changed.RecordWrite(id)
+	}
+	return r.drop(ctx, id, emails, usernames)
+}
+
+func (r *CachedUserRepository) drop(ctx context.Context, id uuid.UUID, emails, usernames []string) error {
+	shared, local := userKeys(id, emails, usernames)
+	if err := r.local.Delete(ctx, local...); err != nil {
+		return err
+	}
+	return r.cache.Delete(ctx, shared...)
+}
+
+func (r *CachedUserRepository) Stats() CacheStats {
+	return CacheStats{
+		Hits:         r.hits.Load(),
+		NegativeHits: r.negativeHits.Load(),
+		Misses:       r.misses.Load(),
+		Errors:       r.errors.Load(),
+	}
+}
+
+// lookup reads key from c, which is the in-process tier for keys that
+// would otherwise put an email address in the shared tier.
+func (r *CachedUserRepository) lookup(ctx context.Context, c cache.Cache, key string, load func(context.Context) (*domain.User, error)) (*domain.User, error) {
+	if database.UsePrimary(ctx) {
+		return load(ctx)
+	}
+
+	raw, err := c.Get(ctx, key)
+	switch {
+	case err == nil:
+		var entry cachedUser
+		if err := json.Unmarshal(raw, &entry); err == nil {
+			if !entry.Found {
+				r.negativeHits.Add(1)
+				return nil, nil
+			}
+			if private, ok := r.private(ctx, entry.ID); ok {
+				r.hits.Add(1)
+				return entry.toUser(private), nil
+			}
+			// Profile found in the shared tier but this process has no
+			// email or password hash for it: reload rather than return a
+			// partial user.
+		} else {
+			log.Printf("WARNING: Discarding undecodable cache entry %s", key)
+		}
+	case !errors.Is(err, cache.ErrMiss):
+		r.errors.Add(1)
+		log.Printf("WARNING: User cache read failed for %s: %v", key, err)
+	}
+	r.misses.Add(1)
+
+	// The shared load must not be cancelled just because the first caller
+	// gave up; statement_timeout still bounds the query.
+	result, err, _ := r.group.Do(key, func() (interface{}, error) {
+		loadCtx := context.WithoutCancel(ctx)
+		if r.changed == nil {
+			loadCtx = database.WithPrimary(loadCtx)
+		}
+		user, err := load(loadCtx)
+		if err != nil {
+			return nil, err
+		}
+		fromReplica := !database.UsePrimary(loadCtx)
+		if fromReplica && r.mayBeStale(user) {
+			loadCtx = database.WithPrimary(loadCtx)
+			if user, err = load(loadCtx); err != nil {
+				return nil, err
+			}
+			fromReplica = false
+		}
+		r.store(loadCtx, c, key, user)
+		// An invalidation that landed between the load and the store would
+		// leave the replica's row cached; drop it again.
+		if fromReplica && r.mayBeStale(user) {
+			if err := r.drop(loadCtx, user.ID, []string{user.Email}, []string{user.Username}); err != nil {
+				r.errors.Add(1)
+				log.Printf("WARNING: User cache invalidation failed for ID %s: %v", user.ID, err)
+			}
+		}
+		return user, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user, _ := result.(*domain.User)
+	if user == nil {
+		return nil, nil
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// mayBeStale reports whether a replica's answer must be checked on the
+// primary: it found nothing, which may be a user the replica has not seen
+// yet, or it is a user that changed within FreshWindow.
+func (r *CachedUserRepository) mayBeStale(user *domain.User) bool {
+	return user == nil || r.changed.WroteRecently(user.ID)
+}
+
+func (r *CachedUserRepository) private(ctx context.Context, id uuid.UUID) (privateUser, bool) {
+	var private privateUser
+	raw, err := r.local.Get(ctx, privateKey(id))
+	if err != nil {
+		return private, false
+	}
+	return private, json.Unmarshal(raw, &private) == nil
+}
+
+func (r *CachedUserRepository) store(ctx context.Context, c cache.Cache, key string, user *domain.User) {
+	if user == nil {
+		r.set(ctx, c, key, cachedUser{Found: false}, r.opts.NegativeTTL)
+		return
+	}
+	if !r.set(ctx, r.local, privateKey(user.ID), privateUser{Email: user.Email, PasswordHash: user.PasswordHash}, r.opts.TTL) {
+		return
+	}
+	entry := newCachedUser(user)
+	r.set(ctx, r.cache, idKey(user.ID), entry, r.opts.TTL)
+	r.set(ctx, r.cache, usernameKey(user.Username), entry, r.opts.TTL)
+	r.set(ctx, r.local, emailKey(user.Email), entry, r.opts.TTL)
+}
+
+func (r *CachedUserRepository) set(ctx context.Context, c cache.Cache, key string, entry any, ttl time.Duration) bool {
+	if ttl <= 0 {
+		return false
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return false
+	}
+	if err := c.Set(ctx, key, raw, ttl); err != nil {
+		r.errors.Add(1)
+		log.Printf("WARNING: User cache write failed for %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+func (r *CachedUserRepository) invalidate(ctx context.Context, id uuid.UUID, emails, usernames []string) {
+	if err := r.InvalidateUser(ctx, id, emails, usernames); err != nil {
+		r.errors.Add(1)
+		log.Printf("WARNING: User cache invalidation failed for ID %s: %v", id, err)
+	}
+}
+
+// userKeys returns the keys that can resolve to a user, split by tier.
+// Email-keyed and private entries are only ever in the local tier, so
+// deleting them never sends an address to the shared one.
+func userKeys(id uuid.UUID, emails, usernames []string) (shared, local []string) {
+	if id != uuid.Nil {
+		shared = append(shared, idKey(id))
+		local = append(local, privateKey(id))
+	}
+	for _, email := range emails {
+		if email != "" {
+			local = append(local, emailKey(email))
+		}
+	}
+	for _, username := range usernames {
+		if username != "" {
+			shared = append(shared, usernameKey(username))
+		}
+	}
+	return shared, local
+}
+
+func newCachedUser(u *domain.User) cachedUser {
+	return cachedUser{
+		Found:     true,
+		ID:        u.ID,
+		Username:  u.Username,
+		Role:      u.Role,
+		Locale:    u.Locale,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+func (c cachedUser) toUser(private privateUser) *domain.User {
+	return &domain.User{
+		ID:           c.ID,
+		Username:     c.Username,
+		Email:        private.Email,
+		PasswordHash: private.PasswordHash,
+		Role:         c.Role,
+		Locale:       c.Locale,
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+	}
+}
@@ -0,0 +1,330 @@
+package repository_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/cache"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/database"
+	"github.com/virhanali/filmnesia/user-service/internal/user/domain"
+	"github.com/virhanali/filmnesia/user-service/internal/user/repository"
+	"github.com/virhanali/filmnesia/user-service/internal/user/repository/repositorytest"
+)
+
+var testCacheOptions = repository.CacheOptions{TTL: time.Minute, NegativeTTL: time.Minute}
+
+// countingRepository counts lookups that reach the backing store and can
+// hold them open to exercise singleflight.
+type countingRepository struct {
+	repository.UserRepository
+	lookups atomic.Int64
+	gate    chan struct{}
+}
+
+func (r *countingRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	r.lookups.Add(1)
+	if r.gate != nil {
+		<-r.gate
+	}
+	return r.UserRepository.GetByID(ctx, id)
+}
+
+func (r *countingRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	r.lookups.Add(1)
+	return r.UserRepository.GetByUsername(ctx, username)
+}
+
+func TestCachedUserRepositoryContract(t *testing.T) {
+	t.Run("LRU", func(t *testing.T) {
+		repositorytest.RunUserRepositoryContract(t, func(t *testing.T) repository.UserRepository {
+			return repository.NewCachedUserRepository(repository.NewInMemoryUserRepository(), cache.NewLRU(100), testCacheOptions)
+		})
+	})
+	t.Run("RedisTiered", func(t *testing.T) {
+		repositorytest.RunUserRepositoryContract(t, func(t *testing.T) repository.UserRepository {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { client.Close() })
+			tiered := cache.NewTiered(cache.NewLRU(100), cache.NewRedis(client, "test:"), time.Second)
+			return repository.NewCachedUserRepository(repository.NewInMemoryUserRepository(), tiered, testCacheOptions)
+		})
+	})
+}
+
+func TestCachedUserRepositoryServesRepeatLookupsFromCache(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingRepository{UserRepository: repository.NewInMemoryUserRepository()}
+	repo := repository.NewCachedUserRepository(inner, cache.NewLRU(100), testCacheOptions)
+
+	created, err := repo.Create(ctx, &domain.User{Username: "alice", Email: "alice@example.com", PasswordHash: "h"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		got, err := repo.GetByID(ctx, created.ID)
+		if err != nil || got == nil || got.PasswordHash != "h" {
+			t.Fatalf("GetByID = %+v, %v", got, err)
+		}
+	}
+	// The first lookup also populates the username key.
+	if _, err := repo.GetByUsername(ctx, "alice"); err != nil {
+		t.Fatalf("GetByUsername: %v", err)
+	}
+	if n := inner.lookups.Load(); n != 1 {
+		t.Errorf("backing store hit %d times, want 1", n)
+	}
+
+	stats := repo.Stats()
+	if stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("stats = %+v, want 3 hits and 1 miss", stats)
+	}
+
+	if _, err := repo.GetByID(database.WithPrimary(ctx), created.ID); err != nil {
+		t.Fatalf("GetByID on primary: %v", err)
+	}
+	if n := inner.lookups.Load(); n != 2 {
+		t.Errorf("primary-pinned lookup did not bypass the cache (%d lookups)", n)
+	}
+}
+
+func TestCachedUserRepositoryCachesMisses(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingRepository{UserRepository: repository.NewInMemoryUserRepository()}
+	repo := repository.NewCachedUserRepository(inner, cache.NewLRU(100), testCacheOptions)
+
+	for i := 0; i < 3; i++ {
+		if got, err := repo.GetByUsername(ctx, "ghost"); got != nil || err != nil {
+			t.Fatalf("GetByUsername = %+v, %v", got, err)
+		}
+	}
+	if n := inner.lookups.Load(); n != 1 {
+		t.Errorf("backing store hit %d times for a cached miss, want 1", n)
+	}
+	if repo.Stats().NegativeHits != 2 {
+		t.Errorf("stats = %+v, want 2 negative hits", repo.Stats())
+	}
+
+	if _, err := repo.Create(ctx, &domain.User{Username: "ghost", Email: "ghost@example.com"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got, err := repo.GetByUsername(ctx, "ghost"); err != nil || got == nil {
+		t.Errorf("negative entry survived Create: %+v, %v", got, err)
+	}
+}
+
+func TestCachedUserRepositoryCollapsesConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	backing := repository.NewInMemoryUserRepository()
+	created, err := backing.Create(ctx, &domain.User{Username: "alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	inner := &countingRepository{UserRepository: backing, gate: make(chan struct{})}
+	repo := repository.NewCachedUserRepository(inner, cache.NewLRU(100), testCacheOptions)
+
+	const callers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := repo.GetByID(ctx, created.ID); err != nil || got == nil {
+				t.Errorf("GetByID = %+v, %v", got, err)
+			}
+		}()
+	}
+
+	// Let every caller reach singleflight before releasing the load.
+	time.Sleep(50 * time.Millisecond)
+	close(inner.gate)
+	wg.Wait()
+
+	if n := inner.lookups.Load(); n != 1 {
+		t.Errorf("%d concurrent misses reached the backing store %d times, want 1", callers, n)
+	}
+}
+
+func TestCachedUserRepositoryInvalidatesOnUpdateAndEvent(t *testing.T) {
+	ctx := context.Background()
+	backing := repository.NewInMemoryUserRepository()
+	repo := repository.NewCachedUserRepository(backing, cache.NewLRU(100), testCacheOptions)
+
+	created, err := repo.Create(ctx, &domain.User{Username: "alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	repo.GetByEmail(ctx, "alice@example.com")
+
+	changed := *created
+	changed.Email = "alicia@example.com"
+	if _, err := repo.Update(ctx, &changed); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, _ := repo.GetByEmail(ctx, "alice@example.com"); got != nil {
+		t.Errorf("old email still served from cache: %+v", got)
+	}
+	if got, _ := repo.GetByID(ctx, created.ID); got == nil || got.Email != "alicia@example.com" {
+		t.Errorf("GetByID after update = %+v", got)
+	}
+
+	// Simulate a write made by another instance, then the user.updated event.
+	renamed := changed
+	renamed.Username = "alicia"
+	if _, err := backing.Update(ctx, &renamed); err != nil {
+		t.Fatalf("backing Update: %v", err)
+	}
+	if got, _ := repo.GetByID(ctx, created.ID); got.Username != "alice" {
+		t.Fatalf("expected stale cached username before invalidation, got %q", got.Username)
+	}
+	if err := repo.InvalidateUser(ctx, created.ID, []string{renamed.Email}, []string{"alice", "alicia"}); err != nil {
+		t.Fatalf("InvalidateUser: %v", err)
+	}
+	if got, _ := repo.GetByID(ctx, created.ID); got.Username != "alicia" {
+		t.Errorf("GetByID after invalidation = %q, want alicia", got.Username)
+	}
+}
+
+func TestCachedUserRepositoryKeepsEmailAndPasswordHashOutOfSharedTier(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	shared := cache.NewRedis(client, "test:")
+
+	inner := &countingRepository{UserRepository: repository.NewInMemoryUserRepository()}
+	first := repository.NewCachedUserRepository(inner, cache.NewTiered(cache.NewLRU(100), shared, time.Minute), testCacheOptions)
+	created, err := first.Create(ctx, &domain.User{Username: "alice", Email: "alice@example.com", PasswordHash: "secret-hash"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got, err := first.GetByUsername(ctx, "alice"); err != nil || got == nil || got.PasswordHash != "secret-hash" {
+		t.Fatalf("GetByUsername = %+v, %v", got, err)
+	}
+	if got, err := first.GetByEmail(ctx, "alice@example.com"); err != nil || got == nil || got.Email != "alice@example.com" {
+		t.Fatalf("GetByEmail = %+v, %v", got, err)
+	}
+	if got, err := first.GetByEmail(ctx, "nobody@example.com"); err != nil || got != nil {
+		t.Fatalf("GetByEmail(nobody) = %+v, %v", got, err)
+	}
+
+	if len(mr.Keys()) == 0 {
+		t.Fatal("nothing was written to the shared tier")
+	}
+	for _, key := range mr.Keys() {
+		value, _ := mr.Get(key)
+		for _, secret := range []string{"secret-hash", "alice@example.com", "nobody@example.com"} {
+			if strings.Contains(key, secret) || strings.Contains(value, secret) {
+				t.Errorf("shared tier entry %s = %s contains %q", key, value, secret)
+			}
+		}
+	}
+
+	// Another instance sharing Redis has the profile but not the email or
+	// hash, so it reloads instead of returning a partial user.
+	second := repository.NewCachedUserRepository(inner, cache.NewTiered(cache.NewLRU(100), shared, time.Minute), testCacheOptions)
+	before := inner.lookups.Load()
+	if got, err := second.GetByID(ctx, created.ID); err != nil || got == nil || got.PasswordHash != "secret-hash" || got.Email != "alice@example.com" {
+		t.Fatalf("GetByID on second instance = %+v, %v", got, err)
+	}
+	if n := inner.lookups.Load() - before; n != 1 {
+		t.Errorf("second instance reached the backing store %d times, want 1", n)
+	}
+}
+
+// routingRepository records which lookups were pinned to the primary.
+type routingRepository struct {
+	repository.UserRepository
+	primary, replica atomic.Int64
+}
+
+func (r *routingRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	r.count(ctx)
+	return r.UserRepository.GetByID(ctx, id)
+}
+
+func (r *routingRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	r.count(ctx)
+	return r.UserRepository.GetByUsername(ctx, username)
+}
+
+func (r *routingRepository) count(ctx context.Context) {
+	if database.UsePrimary(ctx) {
+		r.primary.Add(1)
+	} else {
+		r.replica.Add(1)
+	}
+}
+
+func TestCachedUserRepositoryRefillsFromReplicaOutsideFreshWindow(t *testing.T) {
+	ctx := context.Background()
+	backing := repository.NewInMemoryUserRepository()
+	created, err := backing.Create(ctx, &domain.User{Username: "alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	inner := &routingRepository{UserRepository: backing}
+	opts := testCacheOptions
+	opts.FreshWindow = time.Minute
+	repo := repository.NewCachedUserRepository(inner, cache.NewLRU(100), opts)
+
+	if got, err := repo.GetByID(ctx, created.ID); err != nil || got == nil {
+		t.Fatalf("GetByID = %+v, %v", got, err)
+	}
+	if p, r := inner.primary.Load(), inner.replica.Load(); p != 0 || r != 1 {
+		t.Errorf("cold read: %d primary, %d replica lookups; want the replica only", p, r)
+	}
+
+	// After an invalidation the replica may still hold the old row.
+	if err := repo.InvalidateUser(ctx, created.ID, nil, []string{"alice"}); err != nil {
+		t.Fatalf("InvalidateUser: %v", err)
+	}
+	if got, err := repo.GetByID(ctx, created.ID); err != nil || got == nil {
+		t.Fatalf("GetByID after invalidation = %+v, %v", got, err)
+	}
+	if p := inner.primary.Load(); p != 1 {
+		t.Errorf("refill after an invalidation read the primary %d times, want 1", p)
+	}
+
+	// A replica that has not seen a user yet must not cache its absence.
+	if got, err := repo.GetByUsername(ctx, "ghost"); err != nil || got != nil {
+		t.Fatalf("GetByUsername(ghost) = %+v, %v", got, err)
+	}
+	if p := inner.primary.Load(); p != 2 {
+		t.Errorf("miss not confirmed on the primary (%d primary lookups)", p)
+	}
+}
+
+func TestCachedUserRepositoryInvalidatesMixedCaseEmailLookups(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewCachedUserRepository(repository.NewInMemoryUserRepository(), cache.NewLRU(100), testCacheOptions)
+
+	// A miss before registration must not outlive it.
+	if got, err := repo.GetByEmail(ctx, " Alice@Example.com"); err != nil || got != nil {
+		t.Fatalf("GetByEmail before Create = %+v, %v", got, err)
+	}
+	created, err := repo.Create(ctx, &domain.User{Username: "alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got, err := repo.GetByEmail(ctx, " Alice@Example.com"); err != nil || got == nil || got.Username != "alice" {
+		t.Fatalf("GetByEmail after Create = %+v, %v", got, err)
+	}
+
+	changed := *created
+	changed.Username = "alicia"
+	if _, err := repo.Update(ctx, &changed); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, err := repo.GetByEmail(ctx, "ALICE@example.COM"); err != nil || got == nil || got.Username != "alicia" {
+		t.Errorf("GetByEmail after Update = %+v, %v", got, err)
+	}
+}
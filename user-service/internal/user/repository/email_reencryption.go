@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/pii"
+)
+
+// EmailReencryptor moves users.email onto the active PII key. It encrypts
+// legacy plaintext rows (the 000002 migration path) and re-encrypts rows
+// written under a retired key. Batches lock rows with SKIP LOCKED, so
+// several runs can safely overlap with each other and with live traffic.
+type EmailReencryptor struct {
+	db        *pgxpool.Pool
+	keys      *pii.Keyring
+	batchSize int
+}
+
+func NewEmailReencryptor(db *pgxpool.Pool, keys *pii.Keyring, batchSize int) *EmailReencryptor {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	return &EmailReencryptor{db: db, keys: keys, batchSize: batchSize}
+}
+
+// ErrEmailCollisions is returned by Run when legacy plaintext emails
+// differ from other users' only in case or surrounding space. They would
+// share a blind index, which is unique, and stop the backfill partway.
+var ErrEmailCollisions = errors.New("emails that differ only in case must be resolved before the backfill")
+
+// EmailCollision is a group of users whose emails share a blind index.
+// It names the users, not the address.
+type EmailCollision struct {
+	BlindIndex string
+	UserIDs    []uuid.UUID
+}
+
+type emailRow struct {
+	id        uuid.UUID
+	plain     *string
+	encrypted *string
+}
+
+// FindEmailCollisions returns the legacy plaintext rows whose blind index
+// another user, encrypted or not, already has or would get.
+func (e *EmailReencryptor) FindEmailCollisions(ctx context.Context) ([]EmailCollision, error) {
+	const selectPlain = `SELECT id, email FROM users WHERE email_bidx IS NULL AND email IS NOT NULL ORDER BY id;`
+	const selectIndexed = `SELECT id, email_bidx FROM users WHERE email_bidx = ANY($1) ORDER BY id;`
+
+	groups := make(map[string][]uuid.UUID)
+	var order []string
+	add := func(bidx string, id uuid.UUID) {
+		if _, ok := groups[bidx]; !ok {
+			order = append(order, bidx)
+		}
+		groups[bidx] = append(groups[bidx], id)
+	}
+
+	rows, err := e.db.Query(ctx, selectPlain)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		add(e.keys.BlindIndex(pii.FieldUserEmail, email), id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(order) == 0 {
+		return nil, nil
+	}
+
+	rows, err = e.db.Query(ctx, selectIndexed, order)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var bidx string
+		if err := rows.Scan(&id, &bidx); err != nil {
+			return nil, err
+		}
+		add(bidx, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var collisions []EmailCollision
+	for _, bidx := range order {
+		if ids := groups[bidx]; len(ids) > 1 {
+			collisions = append(collisions, EmailCollision{BlindIndex: bidx, UserIDs: ids})
+		}
+	}
+	return collisions, nil
+}
+
+// Run processes batches until no row needs work and returns how many rows
+// were rewritten. It rewrites nothing while FindEmailCollisions reports
+// any, returning ErrEmailCollisions.
+func (e *EmailReencryptor) Run(ctx context.Context) (int, error) {
+	collisions, err := e.FindEmailCollisions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(collisions) > 0 {
+		return 0, fmt.Errorf("%w: %d groups of users", ErrEmailCollisions, len(collisions))
+	}
+
+	const selectPending = `
+		SELECT id, email, email_encrypted FROM users
+		WHERE email_encrypted IS NULL OR email_key_id IS DISTINCT FROM $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED;
+	`
+	const update = `
+		UPDATE users SET email = NULL, email_encrypted = $2, email_key_id = $3, email_bidx = $4
+		WHERE id = $1;
+	`
+	return e.runBatches(ctx, "re-encrypt", func(ctx context.Context, tx pgx.Tx) ([]emailRow, error) {
+		return queryEmailRows(ctx, tx, selectPending, e.keys.ActiveKeyID(), e.batchSize)
+	}, func(ctx context.Context, tx pgx.Tx, row emailRow, email string) error {
+		encrypted, err := e.keys.Encrypt(pii.FieldUserEmail, email)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, update, row.id, encrypted, e.keys.ActiveKeyID(), e.keys.BlindIndex(pii.FieldUserEmail, email))
+		return err
+	})
+}
+
+// Decrypt restores plaintext emails and clears the encrypted columns. It is
+// only meant for rolling back migration 000002.
+func (e *EmailReencryptor) Decrypt(ctx context.Context) (int, error) {
+	const selectEncrypted = `
+		SELECT id, email, email_encrypted FROM users
+		WHERE email_encrypted IS NOT NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED;
+	`
+	const update = `
+		UPDATE users SET email = $2, email_encrypted = NULL, email_key_id = NULL, email_bidx = NULL
+		WHERE id = $1;
+	`
+	return e.runBatches(ctx, "decrypt", func(ctx context.Context, tx pgx.Tx) ([]emailRow, error) {
+		return queryEmailRows(ctx, tx, selectEncrypted, e.batchSize)
+	}, func(ctx context.Context, tx pgx.Tx, row emailRow, email string) error {
+		_, err := tx.Exec(ctx, update, row.id, email)
+		return err
+	})
+}
+
+func (e *EmailReencryptor) runBatches(
+	ctx context.Context,
+	action string,
+	selectBatch func(context.Context, pgx.Tx) ([]emailRow, error),
+	rewrite func(context.Context, pgx.Tx, emailRow, string) error,
+) (int, error) {
+	total := 0
+	for {
+		n, err := e.runBatch(ctx, selectBatch, rewrite)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n == 0 {
+			return total, nil
+		}
+		log.Printf("INFO: Email %s: %d rows in batch, %d total", action, n, total)
+	}
+}
+
+func (e *EmailReencryptor) runBatch(
+	ctx context.Context,
+	selectBatch func(context.Context, pgx.Tx) ([]emailRow, error),
+	rewrite func(context.Context, pgx.Tx, emailRow, string) error,
+) (int, error) {
+	tx, err := e.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := selectBatch(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, row := range rows {
+		email, err := e.plaintext(row)
+		if err != nil {
+			return 0, err
+		}
+		if err := rewrite(ctx, tx, row, email); err != nil {
+			return 0, fmt.Errorf("user %s: %w", row.id, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
+
+func (e *EmailReencryptor) plaintext(row emailRow) (string, error) {
+	if row.encrypted != nil {
+		email, err := e.keys.Decrypt(pii.FieldUserEmail, *row.encrypted)
+		if err != nil {
+			return "", fmt.Errorf("user %s: %w", row.id, err)
+		}
+		return email, nil
+	}
+	if row.plain != nil {
+		return *row.plain, nil
+	}
+	return "", fmt.Errorf("user %s has neither a plaintext nor an encrypted email", row.id)
+}
+
+func queryEmailRows(ctx context.Context, tx pgx.Tx, query string, args ...any) ([]emailRow, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []emailRow
+	for rows.Next() {
+		var row emailRow
+		if err := rows.Scan(&row.id, &row.plain, &row.encrypted); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
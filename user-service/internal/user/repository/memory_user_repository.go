@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/user-service/internal/user/domain"
+)
+
+type memoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]domain.User
+}
+
+// NewInMemoryUserRepository returns a concurrency-safe UserRepository backed
+// by a map. It enforces the same uniqueness and not-found semantics as the
+// Postgres implementation and is meant for tests and local development.
+func NewInMemoryUserRepository() UserRepository {
+	return &memoryUserRepository{users: make(map[uuid.UUID]domain.User)}
+}
+
+func (r *memoryUserRepository) Create(ctx context.Context, user *domain.User) (*domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user.ID == uuid.Nil {
+		user.ID = uuid.New()
+	}
+	if _, exists := r.users[user.ID]; exists {
+		return nil, fmt.Errorf("%w: id %s", ErrUserConflict, user.ID)
+	}
+	if err := r.checkUniqueLocked(user); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	if user.Role == "" {
+		user.Role = "user"
+	}
+
+	r.users[user.ID] = *user
+	return user, nil
+}
+
+func (r *memoryUserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	email = strings.TrimSpace(email)
+	return r.find(ctx, func(u *domain.User) bool { return strings.EqualFold(u.Email, email) })
+}
+
+func (r *memoryUserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return r.find(ctx, func(u *domain.User) bool { return u.Username == username })
+}
+
+func (r *memoryUserRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (r *memoryUserRepository) Update(ctx context.Context, user *domain.User) (*domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.users[user.ID]
+	if !ok {
+		return nil, fmt.Errorf("%w: id %s", ErrUserNotFound, user.ID)
+	}
+	if err := r.checkUniqueLocked(user); err != nil {
+		return nil, err
+	}
+
+	user.CreatedAt = existing.CreatedAt
+	user.UpdatedAt = time.Now()
+	r.users[user.ID] = *user
+	return user, nil
+}
+
+func (r *memoryUserRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, id)
+	return nil
+}
+
+func (r *memoryUserRepository) find(ctx context.Context, match func(*domain.User) bool) (*domain.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryUserRepository) checkUniqueLocked(user *domain.User) error {
+	for id, other := range r.users {
+		if id == user.ID {
+			continue
+		}
+		if other.Username == user.Username {
+			return fmt.Errorf("%w: username %q", ErrUserConflict, user.Username)
+		}
+		if strings.EqualFold(other.Email, user.Email) {
+			return fmt.Errorf("%w: email %q", ErrUserConflict, user.Email)
+		}
+	}
+	return nil
+}
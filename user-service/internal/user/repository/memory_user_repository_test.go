@@ -0,0 +1,14 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/virhanali/filmnesia/user-service/internal/user/repository"
+	"github.com/virhanali/filmnesia/user-service/internal/user/repository/repositorytest"
+)
+
+func TestInMemoryUserRepositoryContract(t *testing.T) {
+	repositorytest.RunUserRepositoryContract(t, func(t *testing.T) repository.UserRepository {
+		return repository.NewInMemoryUserRepository()
+	})
+}
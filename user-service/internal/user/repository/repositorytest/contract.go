@@ -0,0 +1,257 @@
+// Package repositorytest holds the behavioural contract every
+// repository.UserRepository implementation must satisfy.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/user-service/internal/user/domain"
+	"github.com/virhanali/filmnesia/user-service/internal/user/repository"
+)
+
+// Factory returns an empty repository. It is called once per subtest.
+type Factory func(t *testing.T) repository.UserRepository
+
+// Postgres stores timestamps with microsecond precision.
+const timestampTolerance = time.Millisecond
+
+func RunUserRepositoryContract(t *testing.T, newRepo Factory) {
+	t.Run("CreateAssignsIDRoleAndTimestamps", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		before := time.Now().Add(-time.Second)
+		created, err := repo.Create(ctx, newUser("alice"))
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if created.ID == uuid.Nil {
+			t.Fatal("Create did not assign an ID")
+		}
+		if created.Role != "user" {
+			t.Errorf("Role = %q, want default %q", created.Role, "user")
+		}
+		if created.CreatedAt.Before(before) || created.UpdatedAt.Before(before) {
+			t.Errorf("timestamps not set: created_at=%v updated_at=%v", created.CreatedAt, created.UpdatedAt)
+		}
+		if !sameInstant(created.CreatedAt, created.UpdatedAt) {
+			t.Errorf("created_at %v and updated_at %v differ on create", created.CreatedAt, created.UpdatedAt)
+		}
+	})
+
+	t.Run("LookupsFindCreatedUser", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		created := mustCreate(t, repo, newUser("bob"))
+
+		lookups := map[string]func() (*domain.User, error){
+			"GetByID":    func() (*domain.User, error) { return repo.GetByID(ctx, created.ID) },
+			"GetByEmail": func() (*domain.User, error) { return repo.GetByEmail(ctx, created.Email) },
+			"GetByEmail ignoring case": func() (*domain.User, error) {
+				return repo.GetByEmail(ctx, strings.ToUpper(created.Email))
+			},
+			"GetByUsername": func() (*domain.User, error) { return repo.GetByUsername(ctx, created.Username) },
+		}
+		for name, lookup := range lookups {
+			got, err := lookup()
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got == nil {
+				t.Fatalf("%s returned nil for an existing user", name)
+			}
+			assertSameUser(t, name, created, got)
+		}
+	})
+
+	t.Run("LookupsReturnNilNilWhenMissing", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		mustCreate(t, repo, newUser("carol"))
+
+		lookups := map[string]func() (*domain.User, error){
+			"GetByID":       func() (*domain.User, error) { return repo.GetByID(ctx, uuid.New()) },
+			"GetByEmail":    func() (*domain.User, error) { return repo.GetByEmail(ctx, "nobody@example.com") },
+			"GetByUsername": func() (*domain.User, error) { return repo.GetByUsername(ctx, "nobody") },
+		}
+		for name, lookup := range lookups {
+			got, err := lookup()
+			if err != nil {
+				t.Errorf("%s: want nil error for a missing user, got %v", name, err)
+			}
+			if got != nil {
+				t.Errorf("%s: want nil user, got %+v", name, got)
+			}
+		}
+	})
+
+	t.Run("CreateRejectsDuplicateUsernameAndEmail", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		mustCreate(t, repo, newUser("dave"))
+
+		dupUsername := newUser("dave")
+		dupUsername.Email = "other-dave@example.com"
+		if _, err := repo.Create(ctx, dupUsername); !errors.Is(err, repository.ErrUserConflict) {
+			t.Errorf("duplicate username: want ErrUserConflict, got %v", err)
+		}
+
+		dupEmail := newUser("dave2")
+		dupEmail.Email = "dave@example.com"
+		if _, err := repo.Create(ctx, dupEmail); !errors.Is(err, repository.ErrUserConflict) {
+			t.Errorf("duplicate email: want ErrUserConflict, got %v", err)
+		}
+
+		dupEmail.Email = "Dave@Example.com"
+		if _, err := repo.Create(ctx, dupEmail); !errors.Is(err, repository.ErrUserConflict) {
+			t.Errorf("email differing only in case: want ErrUserConflict, got %v", err)
+		}
+	})
+
+	t.Run("UpdatePersistsChangesAndBumpsUpdatedAt", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		created := mustCreate(t, repo, newUser("erin"))
+		createdAt, updatedAt := created.CreatedAt, created.UpdatedAt
+
+		time.Sleep(5 * time.Millisecond)
+		changed := *created
+		changed.Username = "erin2"
+		changed.Email = "erin2@example.com"
+		changed.Role = "admin"
+		updated, err := repo.Update(ctx, &changed)
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if !sameInstant(updated.CreatedAt, createdAt) {
+			t.Errorf("created_at changed on update: %v -> %v", createdAt, updated.CreatedAt)
+		}
+		if !updated.UpdatedAt.After(updatedAt) {
+			t.Errorf("updated_at not bumped: %v -> %v", updatedAt, updated.UpdatedAt)
+		}
+
+		got, err := repo.GetByID(ctx, created.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetByID after update: user=%v err=%v", got, err)
+		}
+		assertSameUser(t, "GetByID after update", updated, got)
+
+		if old, _ := repo.GetByUsername(ctx, "erin"); old != nil {
+			t.Errorf("old username still resolves to %+v", old)
+		}
+	})
+
+	t.Run("UpdateRejectsTakenUsername", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		mustCreate(t, repo, newUser("frank"))
+		grace := mustCreate(t, repo, newUser("grace"))
+
+		changed := *grace
+		changed.Username = "frank"
+		if _, err := repo.Update(ctx, &changed); !errors.Is(err, repository.ErrUserConflict) {
+			t.Errorf("want ErrUserConflict, got %v", err)
+		}
+	})
+
+	t.Run("UpdateUnknownUserFails", func(t *testing.T) {
+		repo := newRepo(t)
+		ghost := newUser("ghost")
+		ghost.ID = uuid.New()
+		if _, err := repo.Update(context.Background(), ghost); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Errorf("want ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DeleteRemovesUserAndIsIdempotent", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		created := mustCreate(t, repo, newUser("heidi"))
+
+		if err := repo.Delete(ctx, created.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if got, err := repo.GetByID(ctx, created.ID); got != nil || err != nil {
+			t.Errorf("GetByID after delete: user=%+v err=%v", got, err)
+		}
+		if err := repo.Delete(ctx, created.ID); err != nil {
+			t.Errorf("second Delete: %v", err)
+		}
+	})
+
+	t.Run("ConcurrentCreatesKeepUsernamesUnique", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		const workers = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				u := newUser("ivan")
+				u.Email = fmt.Sprintf("ivan%d@example.com", i)
+				_, err := repo.Create(ctx, u)
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		succeeded := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, repository.ErrUserConflict):
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("%d concurrent creates with the same username succeeded, want 1", succeeded)
+		}
+	})
+}
+
+func newUser(username string) *domain.User {
+	return &domain.User{
+		Username:     username,
+		Email:        username + "@example.com",
+		PasswordHash: "hash-" + username,
+	}
+}
+
+func mustCreate(t *testing.T, repo repository.UserRepository, user *domain.User) *domain.User {
+	t.Helper()
+	created, err := repo.Create(context.Background(), user)
+	if err != nil {
+		t.Fatalf("Create(%s): %v", user.Username, err)
+	}
+	copied := *created
+	return &copied
+}
+
+func assertSameUser(t *testing.T, label string, want, got *domain.User) {
+	t.Helper()
+	if got.ID != want.ID || got.Username != want.Username || got.Email != want.Email ||
+		got.PasswordHash != want.PasswordHash || got.Role != want.Role || got.Locale != want.Locale {
+		t.Errorf("%s: got %+v, want %+v", label, got, want)
+	}
+	if !sameInstant(got.CreatedAt, want.CreatedAt) || !sameInstant(got.UpdatedAt, want.UpdatedAt) {
+		t.Errorf("%s: timestamps got (%v, %v), want (%v, %v)", label, got.CreatedAt, got.UpdatedAt, want.CreatedAt, want.UpdatedAt)
+	}
+}
+
+func sameInstant(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d < timestampTolerance && d > -timestampTolerance
+}
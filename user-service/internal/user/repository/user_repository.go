@@ -2,14 +2,32 @@ package repository
 
 import (
 	"context"
-	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/database"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/pii"
 	"github.com/virhanali/filmnesia/user-service/internal/user/domain"
 )
 
+var (
+	// ErrUserConflict is returned by Create and Update when the username,
+	// email or ID is already taken by another user.
+	ErrUserConflict = errors.New("user conflicts with an existing user")
+	// ErrUserNotFound is returned by Update when no user has the given ID.
+	// Lookups report a missing user as (nil, nil) instead.
+	ErrUserNotFound = errors.New("user not found")
+)
+
+const pgUniqueViolation = "23505"
+
+// UserRepository persists users. GetBy* return (nil, nil) when nothing
+// matches; Delete of an unknown ID is not an error.
 type UserRepository interface {
 	Create(ctx context.Context, user *domain.User) (*domain.User, error)
 	GetByEmail(ctx context.Context, email string) (*domain.User, error)
@@ -19,18 +37,42 @@ type UserRepository interface {
 	Delete(ctx context.Context, id uuid.UUID) error
 }
 
+const (
+	stmtGetUserByEmail    = "user_get_by_email"
+	stmtGetUserByUsername = "user_get_by_username"
+	stmtGetUserByID       = "user_get_by_id"
+
+	selectUserColumns = `SELECT id, username, email, email_encrypted, password_hash, role, locale, created_at, updated_at FROM users`
+)
+
+// PreparedStatements are the hot lookup queries prepared on every pooled
+// connection. Pass them to database.NewPostgresPool. Email lookups go
+// through the blind index; the plaintext fallback only matches rows the
+// encryption backfill has not reached yet, and ignores case as the blind
+// index does.
+var PreparedStatements = map[string]string{
+	stmtGetUserByEmail:    selectUserColumns + ` WHERE email_bidx = $1 OR (email_bidx IS NULL AND lower(email) = lower($2))`,
+	stmtGetUserByUsername: selectUserColumns + ` WHERE username = $1`,
+	stmtGetUserByID:       selectUserColumns + ` WHERE id = $1`,
+}
+
 type pgUserRepository struct {
-	db *sql.DB
+	db   *database.Cluster
+	keys *pii.Keyring
 }
 
-func NewPostgresUserRepository(db *sql.DB) UserRepository {
-	return &pgUserRepository{db: db}
+// NewPostgresUserRepository writes to the cluster primary and serves GetBy*
+// lookups from a healthy replica unless the context asks for the primary
+// (see database.WithPrimary). Emails are stored encrypted with keys and
+// looked up by their blind index; plaintext never reaches the table.
+func NewPostgresUserRepository(db *database.Cluster, keys *pii.Keyring) UserRepository {
+	return &pgUserRepository{db: db, keys: keys}
 }
 
 func (r *pgUserRepository) Create(ctx context.Context, user *domain.User) (*domain.User, error) {
 	query := `
-		INSERT INTO users (username, email, password_hash, role, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (username, email_encrypted, email_key_id, email_bidx, password_hash, role, locale, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id, created_at, updated_at;
 	`
 
@@ -41,173 +83,152 @@ func (r *pgUserRepository) Create(ctx context.Context, user *domain.User) (*doma
 		user.Role = "user"
 	}
 
-	err := r.db.QueryRowContext(ctx, query,
+	encryptedEmail, err := r.keys.Encrypt(pii.FieldUserEmail, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.db.Primary().QueryRow(ctx, query,
 		user.Username,
-		user.Email,
+		encryptedEmail,
+		r.keys.ActiveKeyID(),
+		r.keys.BlindIndex(pii.FieldUserEmail, user.Email),
 		user.PasswordHash,
 		user.Role,
+		nullIfEmpty(user.Locale),
 		user.CreatedAt,
 		user.UpdatedAt,
 	).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
 		log.Printf("Error creating user in DB: %v. Query: %s", err, query)
-		return nil, err
+		return nil, translateWriteError(err, user)
 	}
 
+	r.db.Writes().RecordWrite(user.ID)
 	log.Printf("User created successfully with ID: %s", user.ID.String())
 	return user, nil
 }
 
-func scanUser(rowScanner interface{ Scan(...interface{}) error }) (*domain.User, error) {
+// scanUser reads the selectUserColumns projection, decrypting the email or
+// falling back to the legacy plaintext column for rows not yet backfilled.
+func (r *pgUserRepository) scanUser(row pgx.Row) (*domain.User, error) {
 	var user domain.User
-	var idBytes []byte
-
-	err := rowScanner.Scan(
-		&idBytes,
+	var plainEmail, encryptedEmail, locale *string
+	err := row.Scan(
+		&user.ID,
 		&user.Username,
-		&user.Email,
+		&plainEmail,
+		&encryptedEmail,
 		&user.PasswordHash,
 		&user.Role,
+		&locale,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		log.Printf("Error scanning user row: %v", err)
 		return nil, err
 	}
 
-	parsedID, err := uuid.FromBytes(idBytes)
-	if err != nil {
-		parsedID, err = uuid.Parse(string(idBytes))
+	if locale != nil {
+		user.Locale = *locale
+	}
+
+	switch {
+	case encryptedEmail != nil:
+		user.Email, err = r.keys.Decrypt(pii.FieldUserEmail, *encryptedEmail)
 		if err != nil {
-			log.Printf("Error parsing UUID from DB: %v", err)
+			return nil, fmt.Errorf("user %s: %w", user.ID, err)
 		}
+	case plainEmail != nil:
+		user.Email = *plainEmail
 	}
-	user.ID = parsedID
-
 	return &user, nil
 }
 
-func (r *pgUserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, role, created_at, updated_at
-		FROM users
-		WHERE email = $1;
-	`
-	row := r.db.QueryRowContext(ctx, query, email)
-
-	var user domain.User
-	err := row.Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+// queryUser runs a prepared lookup on the reader pool and retries on the
+// primary if a replica fails with anything other than "no rows".
+func (r *pgUserRepository) queryUser(ctx context.Context, stmt string, args ...any) (*domain.User, error) {
+	pool := r.db.Reader(ctx)
+	user, err := r.scanUser(pool.QueryRow(ctx, stmt, args...))
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) && !r.db.IsPrimary(pool) && ctx.Err() == nil {
+		r.db.ReportFailure(pool, err)
+		user, err = r.scanUser(r.db.Primary().QueryRow(ctx, stmt, args...))
+	}
+	return user, err
+}
 
+func (r *pgUserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	user, err := r.queryUser(ctx, stmtGetUserByEmail, r.keys.BlindIndex(pii.FieldUserEmail, email), email)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("No user found with email: %s", email)
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("No user found with email: %s", pii.MaskEmail(email))
 			return nil, nil
 		}
-		log.Printf("Error getting user by email from DB: %v. Email: %s", err, email)
+		log.Printf("Error getting user by email from DB: %v. Email: %s", err, pii.MaskEmail(email))
 		return nil, err
 	}
-	return &user, nil
+	return user, nil
 }
 
 func (r *pgUserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, role, created_at, updated_at
-		FROM users
-		WHERE username = $1;
-	`
-	row := r.db.QueryRowContext(ctx, query, username)
-	var user domain.User
-	err := row.Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	user, err := r.queryUser(ctx, stmtGetUserByUsername, username)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, pgx.ErrNoRows) {
 			log.Printf("No user found with username: %s", username)
 			return nil, nil
 		}
 		log.Printf("Error getting user by username from DB: %v. Username: %s", err, username)
 		return nil, err
 	}
-	return &user, nil
+	return user, nil
 }
 
 func (r *pgUserRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
-	query := `
-		SELECT id, username, email, password_hash, role, created_at, updated_at
-		FROM users
-		WHERE id = $1;
-	`
-	row := r.db.QueryRowContext(ctx, query, id)
-	var user domain.User
-	err := row.Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	user, err := r.queryUser(ctx, stmtGetUserByID, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, pgx.ErrNoRows) {
 			log.Printf("No user found with ID: %s", id.String())
 			return nil, nil
 		}
 		log.Printf("Error getting user by ID from DB: %v. ID: %s", err, id.String())
 		return nil, err
 	}
-	return &user, nil
+	return user, nil
 }
 
 func (r *pgUserRepository) Update(ctx context.Context, user *domain.User) (*domain.User, error) {
 	query := `
 		UPDATE users
-		SET username = $2, email = $3, password_hash = $4, role = $5, updated_at = $6
+		SET username = $2, email = NULL, email_encrypted = $3, email_key_id = $4, email_bidx = $5,
+			password_hash = $6, role = $7, locale = $8, updated_at = $9
 		WHERE id = $1
-		RETURNING id, username, email, password_hash, role, created_at, updated_at;
+		RETURNING id, username, email, email_encrypted, password_hash, role, locale, created_at, updated_at;
 	`
+	encryptedEmail, err := r.keys.Encrypt(pii.FieldUserEmail, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
 	user.UpdatedAt = time.Now()
-	err := r.db.QueryRowContext(ctx, query,
+	updated, err := r.scanUser(r.db.Primary().QueryRow(ctx, query,
 		user.ID,
 		user.Username,
-		user.Email,
+		encryptedEmail,
+		r.keys.ActiveKeyID(),
+		r.keys.BlindIndex(pii.FieldUserEmail, user.Email),
 		user.PasswordHash,
 		user.Role,
+		nullIfEmpty(user.Locale),
 		user.UpdatedAt,
-	).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	))
 	if err != nil {
 		log.Printf("Error updating user in DB: %v. Query: %s", err, query)
-		return nil, err
+		return nil, translateWriteError(err, user)
 	}
-	log.Printf("User updated successfully with ID: %s", user.ID.String())
-	return user, nil
+	r.db.Writes().RecordWrite(updated.ID)
+	log.Printf("User updated successfully with ID: %s", updated.ID.String())
+	return updated, nil
 }
 
 func (r *pgUserRepository) Delete(ctx context.Context, id uuid.UUID) error {
@@ -215,11 +236,30 @@ func (r *pgUserRepository) Delete(ctx context.Context, id uuid.UUID) error {
 		DELETE FROM users
 		WHERE id = $1;
 	`
-	_, err := r.db.ExecContext(ctx, query, id)
+	_, err := r.db.Primary().Exec(ctx, query, id)
 	if err != nil {
 		log.Printf("Error deleting user from DB: %v. Query: %s", err, query)
 		return err
 	}
+	r.db.Writes().RecordWrite(id)
 	log.Printf("User deleted successfully with ID: %s", id.String())
 	return nil
 }
+
+func translateWriteError(err error, user *domain.User) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: id %s", ErrUserNotFound, user.ID)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		return fmt.Errorf("%w: %s", ErrUserConflict, pgErr.ConstraintName)
+	}
+	return err
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
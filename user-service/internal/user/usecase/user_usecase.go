@@ -10,8 +10,10 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/virhanali/filmnesia/user-service/internal/config"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/database"
 	"github.com/virhanali/filmnesia/user-service/internal/platform/hash"
 	"github.com/virhanali/filmnesia/user-service/internal/platform/messagebroker"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/pii"
 	"github.com/virhanali/filmnesia/user-service/internal/user/domain"
 	"github.com/virhanali/filmnesia/user-service/internal/user/repository"
 
@@ -39,10 +41,10 @@ type UserUsecase interface {
 type userUsecase struct {
 	userRepo  repository.UserRepository
 	appConfig config.Config
-	publisher *messagebroker.RabbitMQPublisher
+	publisher messagebroker.EventPublisher
 }
 
-func NewUserUsecase(repo repository.UserRepository, appConfig config.Config, publisher *messagebroker.RabbitMQPublisher) UserUsecase {
+func NewUserUsecase(repo repository.UserRepository, appConfig config.Config, publisher messagebroker.EventPublisher) UserUsecase {
 	return &userUsecase{
 		userRepo:  repo,
 		appConfig: appConfig,
@@ -51,6 +53,8 @@ func NewUserUsecase(repo repository.UserRepository, appConfig config.Config, pub
 }
 
 func (uc *userUsecase) Register(ctx context.Context, req domain.RegisterUserRequest) (*domain.UserResponse, error) {
+	// Uniqueness checks must not be answered by a lagging replica.
+	ctx = database.WithPrimary(ctx)
 	req.Username = strings.TrimSpace(req.Username)
 	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
 
@@ -87,6 +91,7 @@ func (uc *userUsecase) Register(ctx context.Context, req domain.RegisterUserRequ
 		Email:        req.Email,
 		PasswordHash: hashedPassword,
 		Role:         "user",
+		Locale:       req.Locale,
 	}
 
 	createdUser, err := uc.userRepo.Create(ctx, newUser)
@@ -95,24 +100,13 @@ func (uc *userUsecase) Register(ctx context.Context, req domain.RegisterUserRequ
 		return nil, err
 	}
 
-	if uc.publisher != nil {
-		event := domain.UserRegisteredEvent{
-			UserID:       createdUser.ID,
-			Email:        createdUser.Email,
-			Username:     createdUser.Username,
-			RegisteredAt: time.Now(),
-		}
-
-		exchangeName := "user_events"
-		routingKey := "user.registered"
-
-		err := uc.publisher.PublishUserRegisteredEvent(ctx, exchangeName, routingKey, event)
-		if err != nil {
-			log.Printf("Error publishing user registered event: %v", err)
-		} else {
-			log.Printf("User registered event published successfully.")
-		}
-	}
+	uc.publish(ctx, domain.UserRegisteredEvent{
+		UserID:       createdUser.ID,
+		Email:        createdUser.Email,
+		Username:     createdUser.Username,
+		RegisteredAt: time.Now(),
+		Locale:       createdUser.Locale,
+	})
 
 	return createdUser.ToUserResponse(), nil
 }
@@ -154,6 +148,7 @@ func (uc *userUsecase) GetByUsername(ctx context.Context, username string) (*dom
 }
 
 func (uc *userUsecase) UpdateUser(ctx context.Context, id uuid.UUID, req domain.UpdateUserRequest) (*domain.UserResponse, error) {
+	ctx = database.WithPrimary(ctx)
 	user, err := uc.userRepo.GetByID(ctx, id)
 	if err != nil {
 		log.Printf("Error getting user by ID: %v", err)
@@ -162,6 +157,7 @@ func (uc *userUsecase) UpdateUser(ctx context.Context, id uuid.UUID, req domain.
 	if user == nil {
 		return nil, ErrUserNotFound
 	}
+	previousUsername, previousEmail := user.Username, user.Email
 
 	if req.Username != nil {
 		newUserName := strings.TrimSpace(*req.Username)
@@ -195,16 +191,41 @@ func (uc *userUsecase) UpdateUser(ctx context.Context, id uuid.UUID, req domain.
 		user.Email = newUserEmail
 	}
 
+	if req.Locale != nil {
+		user.Locale = *req.Locale
+	}
+
 	user.UpdatedAt = time.Now()
 	updatedUser, err := uc.userRepo.Update(ctx, user)
 	if err != nil {
 		log.Printf("Error updating user: %v", err)
 		return nil, err
 	}
+
+	uc.publish(ctx, domain.UserUpdatedEvent{
+		UserID:           updatedUser.ID,
+		Username:         updatedUser.Username,
+		Email:            updatedUser.Email,
+		PreviousUsername: previousUsername,
+		PreviousEmail:    previousEmail,
+		UpdatedAt:        updatedUser.UpdatedAt,
+		Locale:           updatedUser.Locale,
+	})
+	if updatedUser.Email != previousEmail {
+		uc.publish(ctx, domain.UserEmailChangedEvent{
+			UserID:    updatedUser.ID,
+			Username:  updatedUser.Username,
+			OldEmail:  previousEmail,
+			NewEmail:  updatedUser.Email,
+			ChangedAt: updatedUser.UpdatedAt,
+		})
+	}
+
 	return updatedUser.ToUserResponse(), nil
 }
 
 func (uc *userUsecase) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	ctx = database.WithPrimary(ctx)
 	userExisting, err := uc.userRepo.GetByID(ctx, id)
 	if err != nil {
 		log.Printf("Error getting user by ID: %v", err)
@@ -217,6 +238,13 @@ func (uc *userUsecase) DeleteUser(ctx context.Context, id uuid.UUID) error {
 		log.Printf("Error deleting user: %v", err)
 		return err
 	}
+
+	uc.publish(ctx, domain.UserDeletedEvent{
+		UserID:    userExisting.ID,
+		Username:  userExisting.Username,
+		Email:     userExisting.Email,
+		DeletedAt: time.Now(),
+	})
 	return nil
 }
 
@@ -225,6 +253,10 @@ func (uc *userUsecase) Login(ctx context.Context, req domain.LoginUserRequest) (
 		return nil, ErrInvalidInput
 	}
 
+	// A user logging in right after registering must be found even if the
+	// replicas have not replayed the insert yet.
+	ctx = database.WithPrimary(ctx)
+
 	var user *domain.User
 	var err error
 	found := false
@@ -233,7 +265,7 @@ func (uc *userUsecase) Login(ctx context.Context, req domain.LoginUserRequest) (
 		email := strings.TrimSpace(strings.ToLower(*req.Email))
 		user, err = uc.userRepo.GetByEmail(ctx, email)
 		if err != nil {
-			log.Printf("Error when GetByEmail for login (email: %s): %v", email, err)
+			log.Printf("Error when GetByEmail for login (email: %s): %v", pii.MaskEmail(email), err)
 			return nil, err
 		}
 		if user != nil {
@@ -285,6 +317,12 @@ func (uc *userUsecase) Login(ctx context.Context, req domain.LoginUserRequest) (
 		return nil, fmt.Errorf("failed to create token: %w", err)
 	}
 
+	uc.publish(ctx, domain.UserLoggedInEvent{
+		UserID:     user.ID,
+		Username:   user.Username,
+		LoggedInAt: time.Now(),
+	})
+
 	userResponse := user.ToUserResponse()
 	loginResponse := &domain.LoginUserResponse{
 		AccessToken: tokenString,
@@ -293,3 +331,14 @@ func (uc *userUsecase) Login(ctx context.Context, req domain.LoginUserRequest) (
 
 	return loginResponse, nil
 }
+
+// publish sends event if a publisher is configured. Events are best-effort:
+// a broker failure is logged but never fails the user-facing operation.
+func (uc *userUsecase) publish(ctx context.Context, event messagebroker.Event) {
+	if uc.publisher == nil {
+		return
+	}
+	if err := uc.publisher.Publish(ctx, event); err != nil {
+		log.Printf("Error publishing %s event: %v", event.RoutingKey(), err)
+	}
+}
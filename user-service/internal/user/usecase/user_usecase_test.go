@@ -0,0 +1,256 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/virhanali/filmnesia/user-service/internal/config"
+	"github.com/virhanali/filmnesia/user-service/internal/platform/messagebroker"
+	"github.com/virhanali/filmnesia/user-service/internal/user/domain"
+	"github.com/virhanali/filmnesia/user-service/internal/user/repository"
+)
+
+const testJWTSecret = "test-secret"
+
+func newTestUsecase(t *testing.T) (UserUsecase, repository.UserRepository, *messagebroker.InMemoryPublisher) {
+	t.Helper()
+	repo := repository.NewInMemoryUserRepository()
+	publisher := messagebroker.NewInMemoryPublisher()
+	cfg := config.Config{JWTSecretKey: testJWTSecret, JWTExpirationHours: 1}
+	return NewUserUsecase(repo, cfg, publisher), repo, publisher
+}
+
+func strPtr(s string) *string { return &s }
+
+func mustRegister(t *testing.T, uc UserUsecase, username, email, password string) *domain.UserResponse {
+	t.Helper()
+	resp, err := uc.Register(context.Background(), domain.RegisterUserRequest{
+		Username: username,
+		Email:    email,
+		Password: password,
+	})
+	if err != nil {
+		t.Fatalf("Register(%s): %v", username, err)
+	}
+	return resp
+}
+
+func TestRegister(t *testing.T) {
+	uc, repo, _ := newTestUsecase(t)
+	ctx := context.Background()
+
+	resp := mustRegister(t, uc, "  alice ", " Alice@Example.COM ", "password1")
+	if resp.Username != "alice" || resp.Email != "alice@example.com" || resp.Role != "user" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	stored, err := repo.GetByID(ctx, resp.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("user not persisted: user=%v err=%v", stored, err)
+	}
+	if stored.PasswordHash == "" || stored.PasswordHash == "password1" {
+		t.Errorf("password not hashed: %q", stored.PasswordHash)
+	}
+
+	tests := []struct {
+		name string
+		req  domain.RegisterUserRequest
+		want error
+	}{
+		{"duplicate username", domain.RegisterUserRequest{Username: "alice", Email: "other@example.com", Password: "password1"}, ErrUsernameExists},
+		{"duplicate email", domain.RegisterUserRequest{Username: "alice2", Email: "ALICE@example.com", Password: "password1"}, ErrEmailExists},
+		{"blank username", domain.RegisterUserRequest{Username: "   ", Email: "x@example.com", Password: "password1"}, ErrInvalidInput},
+		{"missing password", domain.RegisterUserRequest{Username: "bob", Email: "bob@example.com"}, ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := uc.Register(ctx, tt.req); !errors.Is(err, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	uc, _, _ := newTestUsecase(t)
+	ctx := context.Background()
+	registered := mustRegister(t, uc, "alice", "alice@example.com", "password1")
+
+	t.Run("by email", func(t *testing.T) {
+		resp, err := uc.Login(ctx, domain.LoginUserRequest{Email: strPtr("ALICE@example.com"), Password: "password1"})
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		if resp.User.ID != registered.ID {
+			t.Errorf("logged in as %s, want %s", resp.User.ID, registered.ID)
+		}
+
+		claims := &domain.AppClaims{}
+		_, err = jwt.ParseWithClaims(resp.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+			return []byte(testJWTSecret), nil
+		})
+		if err != nil {
+			t.Fatalf("token does not verify: %v", err)
+		}
+		if claims.Subject != registered.ID.String() || claims.Username != "alice" || claims.Role != "user" {
+			t.Errorf("unexpected claims: %+v", claims)
+		}
+	})
+
+	t.Run("by username", func(t *testing.T) {
+		if _, err := uc.Login(ctx, domain.LoginUserRequest{Username: strPtr("alice"), Password: "password1"}); err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+	})
+
+	tests := []struct {
+		name string
+		req  domain.LoginUserRequest
+		want error
+	}{
+		{"wrong password", domain.LoginUserRequest{Email: strPtr("alice@example.com"), Password: "wrong-pass"}, ErrInvalidCredentials},
+		{"unknown user", domain.LoginUserRequest{Username: strPtr("nobody"), Password: "password1"}, ErrInvalidCredentials},
+		{"no identifier", domain.LoginUserRequest{Password: "password1"}, ErrInvalidInput},
+		{"no password", domain.LoginUserRequest{Username: strPtr("alice")}, ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := uc.Login(ctx, tt.req); !errors.Is(err, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	uc, _, _ := newTestUsecase(t)
+	ctx := context.Background()
+	alice := mustRegister(t, uc, "alice", "alice@example.com", "password1")
+	mustRegister(t, uc, "bob", "bob@example.com", "password1")
+
+	t.Run("changes username and email", func(t *testing.T) {
+		resp, err := uc.UpdateUser(ctx, alice.ID, domain.UpdateUserRequest{
+			Username: strPtr(" alicia "),
+			Email:    strPtr("Alicia@Example.com"),
+		})
+		if err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		if resp.Username != "alicia" || resp.Email != "alicia@example.com" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+		if !resp.UpdatedAt.After(alice.UpdatedAt) {
+			t.Errorf("updated_at not bumped: %v -> %v", alice.UpdatedAt, resp.UpdatedAt)
+		}
+
+		got, err := uc.GetByUsername(ctx, "alicia")
+		if err != nil || got.ID != alice.ID {
+			t.Errorf("GetByUsername after update: %+v, %v", got, err)
+		}
+	})
+
+	t.Run("keeping own username is allowed", func(t *testing.T) {
+		if _, err := uc.UpdateUser(ctx, alice.ID, domain.UpdateUserRequest{Username: strPtr("alicia"), Locale: strPtr("en")}); err != nil {
+			t.Errorf("UpdateUser: %v", err)
+		}
+	})
+
+	tests := []struct {
+		name string
+		id   uuid.UUID
+		req  domain.UpdateUserRequest
+		want error
+	}{
+		{"username taken", alice.ID, domain.UpdateUserRequest{Username: strPtr("bob")}, ErrUsernameExists},
+		{"email taken", alice.ID, domain.UpdateUserRequest{Email: strPtr("BOB@example.com")}, ErrEmailExists},
+		{"blank username", alice.ID, domain.UpdateUserRequest{Username: strPtr("  ")}, ErrInvalidInput},
+		{"unknown user", uuid.New(), domain.UpdateUserRequest{Username: strPtr("carol")}, ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := uc.UpdateUser(ctx, tt.id, tt.req); !errors.Is(err, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	uc, _, _ := newTestUsecase(t)
+	ctx := context.Background()
+	alice := mustRegister(t, uc, "alice", "alice@example.com", "password1")
+
+	if err := uc.DeleteUser(ctx, alice.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := uc.GetUserByID(ctx, alice.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUserByID after delete: want ErrUserNotFound, got %v", err)
+	}
+	if err := uc.DeleteUser(ctx, alice.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("second DeleteUser: want ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestUsecaseEmitsUserEvents(t *testing.T) {
+	uc, _, publisher := newTestUsecase(t)
+	ctx := context.Background()
+
+	alice := mustRegister(t, uc, "alice", "alice@example.com", "password1")
+	if _, err := uc.Login(ctx, domain.LoginUserRequest{Username: strPtr("alice"), Password: "password1"}); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := uc.Login(ctx, domain.LoginUserRequest{Username: strPtr("alice"), Password: "wrong-pass"}); err == nil {
+		t.Fatal("Login with a wrong password succeeded")
+	}
+	if _, err := uc.UpdateUser(ctx, alice.ID, domain.UpdateUserRequest{Username: strPtr("alicia"), Locale: strPtr("en")}); err != nil {
+		t.Fatalf("UpdateUser(username): %v", err)
+	}
+	if _, err := uc.UpdateUser(ctx, alice.ID, domain.UpdateUserRequest{Email: strPtr("alicia@example.com")}); err != nil {
+		t.Fatalf("UpdateUser(email): %v", err)
+	}
+	if err := uc.DeleteUser(ctx, alice.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	want := []string{
+		domain.EventUserRegistered,
+		domain.EventUserLoggedIn,
+		domain.EventUserUpdated,
+		domain.EventUserUpdated,
+		domain.EventUserEmailChanged,
+		domain.EventUserDeleted,
+	}
+	got := publisher.RoutingKeys()
+	if len(got) != len(want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("published %v, want %v", got, want)
+		}
+	}
+
+	events := publisher.Events()
+	emailChanged := events[4].(domain.UserEmailChangedEvent)
+	if emailChanged.OldEmail != "alice@example.com" || emailChanged.NewEmail != "alicia@example.com" {
+		t.Errorf("unexpected email_changed payload: %+v", emailChanged)
+	}
+	updated := events[2].(domain.UserUpdatedEvent)
+	if updated.PreviousUsername != "alice" || updated.Username != "alicia" || updated.Locale != "en" {
+		t.Errorf("unexpected updated payload: %+v", updated)
+	}
+}
+
+func TestPublishFailureDoesNotFailRegister(t *testing.T) {
+	uc, _, publisher := newTestUsecase(t)
+	publisher.FailWith(errors.New("broker down"))
+
+	if _, err := uc.Register(context.Background(), domain.RegisterUserRequest{
+		Username: "alice", Email: "alice@example.com", Password: "password1",
+	}); err != nil {
+		t.Errorf("Register failed because of the broker: %v", err)
+	}
+}
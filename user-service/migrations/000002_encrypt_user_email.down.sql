@@ -0,0 +1,7 @@
+-- Run the backfill job with -decrypt first, otherwise encrypted emails are lost.
+DROP INDEX IF EXISTS idx_users_email_key_id;
+DROP INDEX IF EXISTS users_email_bidx_key;
+ALTER TABLE users DROP COLUMN IF EXISTS email_bidx;
+ALTER TABLE users DROP COLUMN IF EXISTS email_key_id;
+ALTER TABLE users DROP COLUMN IF EXISTS email_encrypted;
+ALTER TABLE users ALTER COLUMN email SET NOT NULL;
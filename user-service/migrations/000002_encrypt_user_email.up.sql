@@ -0,0 +1,10 @@
+-- Email is now encrypted by the application (AES-GCM, see internal/platform/pii).
+-- Rows written before this migration keep their plaintext email until the
+-- backfill job (go run ./cmd/pii-reencrypt) encrypts them and clears it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_encrypted TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_key_id VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_bidx VARCHAR(64);
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_bidx_key ON users(email_bidx);
+CREATE INDEX IF NOT EXISTS idx_users_email_key_id ON users(email_key_id);
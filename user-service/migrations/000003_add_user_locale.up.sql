@@ -0,0 +1,3 @@
+-- The user's preferred notification language (BCP 47, e.g. 'id' or 'en').
+-- NULL means the user never chose one; consumers then use their default.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(16);
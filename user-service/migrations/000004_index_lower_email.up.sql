@@ -0,0 +1,3 @@
+-- Lookups of rows the email backfill has not reached yet compare
+-- lower(email), as the blind index ignores case.
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email)) WHERE email IS NOT NULL;